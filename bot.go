@@ -0,0 +1,1074 @@
+package durcov
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/message"
+)
+
+// Regex patterns to match valid commands.
+var commandPattern = `(?P<command>^(?i)(CASES|DEATHS|DOSES|VACCINATED|TESTS|POSITIVITY|HOSPITALIZED|ICU|VENTILATORS))` // Case insensitive match on a command, e.g. 'CASES' or 'DEATHS'. Must be at the start of string
+var countryCodePattern = `(?P<countryCode>(?i)((TOTAL)|[A-Z]{2}(-[A-Z0-9]+){0,2}|[A-Z][A-Z0-9]{2,19})$)`               // Case insensitive match on either 'TOTAL', a two letter sequence from [A-Z], a region code like US-CA or a group code like EUROPE. Must be at the end of string.
+var validBodyPattern = regexp.MustCompile(commandPattern + `\s+` + countryCodePattern)                                 // Matches command and code delimited by 1+n whitespace
+
+const (
+	// maxCommandLength is the longest message matched against the command grammar
+	maxCommandLength = 40
+	// maxMessageLength is the longest message answered. Longer than a command to leave room for free-form questions.
+	maxMessageLength = 160
+	// intentsRefreshAfter is how long the countries known to the intent parser are kept before they're read again
+	intentsRefreshAfter = time.Hour
+)
+
+// Bot represents a message consuming and message producing conversational bot
+type Bot struct {
+	view DataView
+	// staleAfter is how old data can get before responses carry a notice. Zero disables the notice.
+	staleAfter time.Duration
+	observer   ResponseObserver
+	// sessions remembers what each sender last asked so follow-ups can be answered. Optional.
+	sessions SessionStore
+
+	intentsMu      sync.Mutex
+	intents        *intentParser
+	intentsBuiltAt time.Time
+
+	now func() time.Time
+}
+
+// ResponseObserver is told the command and outcome of every response, e.g. to count them.
+// Command is "cases", "deaths", "doses", "vaccinated", "tests", "positivity", "hospitalized", "icu", "ventilators",
+// "reset" or "unknown".
+type ResponseObserver func(command string, outcome string)
+
+// NewBot returns a bot answering from the view.
+// Responses carry a notice once the data is older than staleAfter. Zero disables the notice.
+func NewBot(view DataView, staleAfter time.Duration) *Bot {
+	return &Bot{view: view, staleAfter: staleAfter, now: time.Now}
+}
+
+// SetObserver sets the function told about every response. Optional.
+func (b *Bot) SetObserver(observer ResponseObserver) {
+	b.observer = observer
+}
+
+// SetSessions sets the store remembering each sender's conversation. Optional.
+// Without it every message is answered on its own and RESET isn't understood.
+func (b *Bot) SetSessions(sessions SessionStore) {
+	b.sessions = sessions
+}
+
+type botError struct {
+	err      error
+	message  string
+	context  []interface{}
+	category string
+}
+
+// Outcomes of a response. Every outcome other than OutcomeOK is a category of bot error.
+const (
+	OutcomeOK               = "ok"
+	OutcomeTooLong          = "too_long"
+	OutcomeUnmatched        = "unmatched"
+	OutcomeConfused         = "confused"
+	OutcomeUnavailable      = "unavailable"
+	OutcomeNoCountry        = "no_country"
+	OutcomeNoRegion         = "no_region"
+	OutcomeNoGroup          = "no_group"
+	OutcomeBadDate          = "bad_date"
+	OutcomeNoHistory        = "no_history"
+	OutcomeNotPublished     = "not_published"
+	OutcomeUnsupportedPlace = "unsupported_place"
+)
+
+func (b *Bot) handleBotError(command string, botErr *botError) string {
+	err := botErr.err
+	responseMsg := botErr.message
+	category := botErr.category
+
+	contextMsg := ""
+	for i, info := range botErr.context {
+		infoMsg := fmt.Sprintf("%d) %v\n", i+1, info)
+		contextMsg = contextMsg + infoMsg
+	}
+	logMsg := fmt.Sprintf("\nError: %v\nContext: %s", err, contextMsg)
+	log.Println(logMsg)
+
+	switch err.(type) {
+	case *NoCountryMatchedError:
+		responseMsg = "Sorry, that code doesn't match any countries I know."
+		category = OutcomeNoCountry
+	case *NoRegionMatchedError:
+		responseMsg = "Sorry, that code doesn't match any regions I know."
+		category = OutcomeNoRegion
+	case *NoGroupMatchedError:
+		responseMsg = "Sorry, that doesn't match any groups of countries I know."
+		category = OutcomeNoGroup
+	case *NoSnapshotError:
+		responseMsg = "Sorry, I don't have any figures for that yet."
+		category = OutcomeNoHistory
+	}
+
+	b.observe(command, category)
+	return responseMsg
+}
+
+type requestType int
+
+const (
+	_Cases requestType = iota + 1
+	_Deaths
+	_Doses
+	_Vaccinated
+	_Tests
+	_Positivity
+	_Hospitalized
+	_ICU
+	_Ventilators
+)
+
+// commandRequestTypes maps the commands in the grammar to what they ask for
+var commandRequestTypes = map[string]requestType{
+	"CASES":        _Cases,
+	"DEATHS":       _Deaths,
+	"DOSES":        _Doses,
+	"VACCINATED":   _Vaccinated,
+	"TESTS":        _Tests,
+	"POSITIVITY":   _Positivity,
+	"HOSPITALIZED": _Hospitalized,
+	"ICU":          _ICU,
+	"VENTILATORS":  _Ventilators,
+}
+
+func (rt requestType) String() string {
+	switch rt {
+	case _Cases:
+		return "cases"
+	case _Deaths:
+		return "deaths"
+	case _Doses:
+		return "doses"
+	case _Vaccinated:
+		return "vaccinated"
+	case _Tests:
+		return "tests"
+	case _Positivity:
+		return "positivity"
+	case _Hospitalized:
+		return "hospitalized"
+	case _ICU:
+		return "icu"
+	case _Ventilators:
+		return "ventilators"
+	}
+	return "unknown"
+}
+
+// datum returns the datapoint the request type asks for
+func (rt requestType) datum() Datum {
+	switch rt {
+	case _Cases:
+		return Active
+	case _Deaths:
+		return Deaths
+	case _Doses:
+		return Doses
+	case _Vaccinated:
+		return Vaccinated
+	case _Tests:
+		return Tests
+	case _Positivity:
+		return Positivity
+	case _Hospitalized:
+		return Hospitalized
+	case _ICU:
+		return ICU
+	case _Ventilators:
+		return Ventilators
+	}
+	return 0
+}
+
+// isCaseCount reports whether the request is answered from the case counts rather than vaccination, testing or
+// hospital occupancy figures
+func (rt requestType) isCaseCount() bool {
+	return rt == _Cases || rt == _Deaths
+}
+
+type parsedRequest struct {
+	Type requestType
+	Code string
+}
+
+func (p *parsedRequest) command() *BotCommand {
+	datum := p.Type.datum()
+	if datum == 0 {
+		return nil
+	}
+	return &BotCommand{datum, p.Code}
+}
+
+func (b *Bot) observe(command string, outcome string) {
+	if b.observer != nil {
+		b.observer(command, outcome)
+	}
+}
+
+// Respond answers a request message, e.g. "CASES SG". Errors are answered with a message explaining what went wrong.
+// The message is answered on its own. Use RespondTo for follow-ups.
+func (b *Bot) Respond(requestMessage string) string {
+	response, _ := b.RespondTo("", requestMessage)
+	return response
+}
+
+// RespondTo answers a request message from the sender. When sessions are set, follow-ups like "and deaths?" or "what about IN"
+// are answered using the sender's last command and RESET forgets it. An empty sender is answered without a session.
+// Messages can end by saying when they're about, e.g. "CASES IN ON 2020-11-01" or "DEATHS SG LAST WEEK".
+// Also returns the command answered, with any follow-up filled in. Nil when the message wasn't answered or was about the past.
+func (b *Bot) RespondTo(sender string, requestMessage string) (string, *BotCommand) {
+	response, notice, answered := b.RespondWithNotice(sender, requestMessage)
+	return response + notice, answered
+}
+
+// RespondWithNotice answers like RespondTo, returning the notice added to the response separately, e.g. StaleNotice.
+// The notice is empty when there's nothing to add.
+func (b *Bot) RespondWithNotice(sender string, requestMessage string) (string, string, *BotCommand) {
+	command := "unknown"
+	trimmedMsg, botErr := b.trimRequest(requestMessage)
+	if botErr != nil {
+		return b.handleBotError(command, botErr), "", nil
+	}
+
+	conversing := b.sessions != nil && sender != ""
+	if conversing && strings.EqualFold(trimmedMsg, "RESET") {
+		return b.reset(sender), "", nil
+	}
+
+	query, when, botErr := b.splitDate(trimmedMsg)
+	if botErr != nil {
+		return b.handleBotError(command, botErr), "", nil
+	}
+
+	// Commands keep their exact meaning. Anything else may be a follow-up or a free-form question.
+	parsedReq, unmatched := b.matchRequest(query)
+	botErr = unmatched
+	if botErr == nil && isGroupCode(parsedReq.Code) {
+		parsedReq = b.preferNamedPlace(query, parsedReq)
+	}
+	if botErr != nil && conversing {
+		parsedReq, botErr = b.matchFollowUp(sender, query, unmatched, when != nil)
+	}
+	if botErr != nil && botErr == unmatched {
+		parsedReq, botErr = b.matchIntent(sender, query, unmatched)
+	}
+	if botErr != nil {
+		return b.handleBotError(command, botErr), "", nil
+	}
+	command = parsedReq.Type.String()
+
+	var response string
+	if when == nil {
+		response, botErr = b.generateResponse(parsedReq)
+	} else {
+		response, botErr = b.generateDatedResponse(parsedReq, when)
+	}
+	if botErr != nil {
+		return b.handleBotError(command, botErr), "", nil
+	}
+
+	answered := parsedReq.command()
+	if conversing {
+		err := b.sessions.Remember(sender, &Conversation{Datum: answered.Datum, Code: answered.Code})
+		if err != nil {
+			log.Printf("Unable to remember conversation: %v", err)
+		}
+	}
+	b.observe(command, OutcomeOK)
+	if when != nil {
+		// Figures from the past aren't out of date
+		return response, "", nil
+	}
+	return response, b.staleNotice(), answered
+}
+
+// splitDate cuts the date qualifier from the end of the message. The returned range is nil when the message doesn't say when.
+func (b *Bot) splitDate(trimmedRequestMessage string) (string, *dateRange, *botError) {
+	query, when, err := splitDateQualifier(trimmedRequestMessage, b.now().UTC())
+	if err != nil {
+		requestLog := fmt.Sprintf("Unreadable Date: %s", trimmedRequestMessage)
+		botErr := &botError{
+			err,
+			"Sorry, I couldn't use that date. Try e.g. CASES SG ON 2020-11-01 or DEATHS SG LAST WEEK.",
+			[]interface{}{requestLog},
+			OutcomeBadDate,
+		}
+		return "", nil, botErr
+	}
+	return query, when, nil
+}
+
+// reset forgets the sender's conversation
+func (b *Bot) reset(sender string) string {
+	err := b.sessions.Forget(sender)
+	if err != nil {
+		botErr := &botError{err, "Sorry, I couldn't forget our conversation. Please try again.", []interface{}{"Error: Reset"}, OutcomeUnavailable}
+		return b.handleBotError("reset", botErr)
+	}
+	b.observe("reset", OutcomeOK)
+	return "Okay, I've forgotten our conversation. Send e.g. CASES SG to start again."
+}
+
+func (b *Bot) trimRequest(reqMsg string) (string, *botError) {
+	trimmedReqMsg := strings.Trim(reqMsg, " ")
+	if len(trimmedReqMsg) > maxMessageLength {
+		requestLog := fmt.Sprintf("Message Too Long: %s", reqMsg)
+		failedMessageCtxt := []interface{}{requestLog}
+		botErr := &botError{
+			errors.New("Request message too long"),
+			"Sorry, that message is too long for me.",
+			failedMessageCtxt,
+			OutcomeTooLong,
+		}
+		return "", botErr
+	}
+	return trimmedReqMsg, nil
+}
+
+func (b *Bot) matchRequest(trimmedRequestMessage string) (*parsedRequest, *botError) {
+	matches, botErr := b.subMatchRequest(trimmedRequestMessage)
+	if botErr != nil {
+		return nil, botErr
+	}
+
+	command, code := b.extractSubExp(matches)
+
+	if requestType, ok := commandRequestTypes[command]; ok {
+		return &parsedRequest{requestType, code}, nil
+	}
+
+	unhandledCommandLog := fmt.Sprintf("Unhandled command: %s", command)
+	unhandledCodeLog := fmt.Sprintf("Code in request: %v", code)
+	failedMessageCtxt := []interface{}{
+		unhandledCommandLog,
+		unhandledCodeLog,
+	}
+	botErr = &botError{
+		errors.New("Unhandled command"),
+		"Oops, I've got myself confused :(",
+		failedMessageCtxt,
+		OutcomeConfused,
+	}
+	return nil, botErr
+}
+
+func (b *Bot) subMatchRequest(reqMsg string) ([]string, *botError) {
+	var matches []string
+	if len(reqMsg) <= maxCommandLength {
+		matches = validBodyPattern.FindStringSubmatch(reqMsg)
+	}
+	if matches == nil {
+		requestLog := fmt.Sprintf("Unmatchable Message: %s", reqMsg)
+		failedMessageCtxt := []interface{}{requestLog}
+		botErr := &botError{
+			errors.New("Unmatched request message"),
+			"Sorry, I'm not sure how to respond to that.",
+			failedMessageCtxt,
+			OutcomeUnmatched,
+		}
+		return nil, botErr
+	}
+	return matches, nil
+}
+
+func (b *Bot) extractSubExp(matches []string) (command string, code string) {
+	commandIdx := validBodyPattern.SubexpIndex("command")
+	codeIdx := validBodyPattern.SubexpIndex("countryCode")
+
+	command = matches[commandIdx]
+	command = strings.ToUpper(command)
+	code = matches[codeIdx]
+	code = strings.ToUpper(code)
+	return command, code
+}
+
+// followUpFillers are words dropped from the start of a follow-up, e.g. "what about" in "what about deaths?".
+// "IN" isn't one because it's India's code.
+var followUpFillers = map[string]bool{"AND": true, "WHAT": true, "WHATS": true, "ABOUT": true, "HOW": true, "FOR": true, "THE": true}
+
+var followUpPunctuation = strings.NewReplacer("?", " ", "!", " ", ".", " ", ",", " ", "'", "")
+
+var followUpCodePattern = regexp.MustCompile(`^(TOTAL|[A-Z]{2}(-[A-Z0-9]+){0,2})$`)
+
+// matchFollowUp answers a message naming only a datapoint or only a code (e.g. "and deaths?" or "what about IN")
+// by filling in the rest from the sender's conversation. A message that only said when (e.g. "and yesterday?") is dated
+// and repeats the last command. Returns the original error for anything else.
+func (b *Bot) matchFollowUp(sender string, trimmedRequestMessage string, unmatched *botError, dated bool) (*parsedRequest, *botError) {
+	words := strings.Fields(followUpPunctuation.Replace(trimmedRequestMessage))
+	fields := strings.Fields(strings.ToUpper(strings.Join(words, " ")))
+	filled := false
+	for len(fields) > 0 && followUpFillers[fields[0]] {
+		fields = fields[1:]
+		filled = true
+	}
+	if len(fields) > 1 || (len(fields) == 0 && !dated) {
+		return nil, unmatched
+	}
+
+	var requestType requestType
+	var code string
+	if len(fields) == 1 {
+		switch {
+		case commandRequestTypes[fields[0]] != 0:
+			requestType = commandRequestTypes[fields[0]]
+		case followUpCodePattern.MatchString(fields[0]):
+			// Replies like "no" or "ok" would otherwise be taken for codes. A lone code has to be asked about
+			// (e.g. "what about in") or typed in capitals.
+			if !filled && words[len(words)-1] != fields[0] {
+				return nil, unmatched
+			}
+			code = fields[0]
+		default:
+			return nil, unmatched
+		}
+	}
+
+	conversation, err := b.sessions.Conversation(sender)
+	if err != nil {
+		log.Printf("Unable to look up conversation: %v", err)
+		return nil, unmatched
+	}
+	if conversation == nil {
+		// A lone code (e.g. "HI") is more likely not a follow-up at all
+		if code != "" && !filled {
+			return nil, unmatched
+		}
+		requestLog := fmt.Sprintf("Follow-up without a conversation: %s", trimmedRequestMessage)
+		botErr := &botError{
+			errors.New("No conversation to follow up"),
+			"Sorry, I don't remember what we were talking about. Try e.g. CASES SG.",
+			[]interface{}{requestLog},
+			OutcomeUnmatched,
+		}
+		return nil, botErr
+	}
+
+	if requestType == 0 {
+		requestType = _Cases
+		for _, rt := range commandRequestTypes {
+			if rt.datum() == conversation.Datum {
+				requestType = rt
+			}
+		}
+	}
+	if code == "" {
+		code = conversation.Code
+	}
+	return &parsedRequest{requestType, code}, nil
+}
+
+// matchIntent answers free-form questions, e.g. "how many people died in brazil". Questions naming no place are about
+// the country in the sender's conversation when there is one and the world otherwise. Returns the original error when
+// the question isn't understood.
+func (b *Bot) matchIntent(sender string, trimmedRequestMessage string, unmatched *botError) (*parsedRequest, *botError) {
+	parser, err := b.intentParser()
+	if err != nil {
+		log.Printf("Unable to load countries for free-form questions: %v", err)
+		return nil, unmatched
+	}
+	parsedReq := parser.parse(trimmedRequestMessage)
+	if parsedReq == nil {
+		return nil, unmatched
+	}
+	if parsedReq.Code != "" {
+		return parsedReq, nil
+	}
+
+	parsedReq.Code = "TOTAL"
+	if b.sessions != nil && sender != "" {
+		conversation, err := b.sessions.Conversation(sender)
+		if err != nil {
+			log.Printf("Unable to look up conversation: %v", err)
+		} else if conversation != nil {
+			parsedReq.Code = conversation.Code
+		}
+	}
+	return parsedReq, nil
+}
+
+// preferNamedPlace reads a command whose code is shaped like a group's as a free-form question when that names a place,
+// e.g. CASES FRANCE or CASES WORLDWIDE. Otherwise the command is kept and the group is looked up when answering.
+func (b *Bot) preferNamedPlace(trimmedRequestMessage string, parsedReq *parsedRequest) *parsedRequest {
+	parser, err := b.intentParser()
+	if err != nil {
+		log.Printf("Unable to load countries for free-form questions: %v", err)
+		return parsedReq
+	}
+	named := parser.parse(trimmedRequestMessage)
+	if named == nil || named.Code == "" {
+		return parsedReq
+	}
+	return named
+}
+
+// intentParser returns the parser for free-form questions. It's rebuilt from the view's countries, regions and groups every intentsRefreshAfter.
+func (b *Bot) intentParser() (*intentParser, error) {
+	b.intentsMu.Lock()
+	defer b.intentsMu.Unlock()
+
+	if b.intents != nil && time.Since(b.intentsBuiltAt) < intentsRefreshAfter {
+		return b.intents, nil
+	}
+	countries, err := b.view.Countries()
+	if err != nil {
+		// A stale parser still knows every country it knew before
+		if b.intents != nil {
+			return b.intents, nil
+		}
+		return nil, err
+	}
+	// Questions about countries can be answered without regions or groups
+	regions, err := b.view.Regions("")
+	if err != nil {
+		log.Printf("Unable to load regions for free-form questions: %v", err)
+		regions = nil
+	}
+	groups, err := b.view.Groups()
+	if err != nil {
+		log.Printf("Unable to load groups for free-form questions: %v", err)
+		groups = nil
+	}
+	b.intents = newIntentParser(countries, regions, groups)
+	b.intentsBuiltAt = time.Now()
+	return b.intents, nil
+}
+
+func (b *Bot) generateResponse(parsedReq *parsedRequest) (string, *botError) {
+	switch parsedReq.Type {
+	case _Cases:
+		return b.generateCasesResponse(parsedReq.Code)
+	case _Deaths:
+		return b.generateDeathsResponse(parsedReq.Code)
+	case _Doses, _Vaccinated:
+		return b.generateVaccinationResponse(parsedReq.Code, parsedReq.Type)
+	case _Tests, _Positivity:
+		return b.generateTestingResponse(parsedReq.Code, parsedReq.Type)
+	case _Hospitalized, _ICU, _Ventilators:
+		return b.generateHospitalResponse(parsedReq.Code, parsedReq.Type)
+	}
+
+	requestTypeLog := fmt.Sprintf("Parsed Request Type: %v", parsedReq.Type)
+	requestCodeLog := fmt.Sprintf("Parsed Request Code: %s", parsedReq.Code)
+	failedMessageCtxt := []interface{}{
+		requestTypeLog,
+		requestCodeLog,
+	}
+	botErr := &botError{
+		errors.New("Unexpected Request Type"),
+		"Oops, I've got myself confused :(",
+		failedMessageCtxt,
+		OutcomeConfused,
+	}
+	return "", botErr
+}
+
+// generateDatedResponse answers with the figures on a day, or how they changed between two days.
+// The days of the snapshots used are always named since they may not be the days asked for.
+func (b *Bot) generateDatedResponse(parsedReq *parsedRequest, when *dateRange) (string, *botError) {
+	if !parsedReq.Type.isCaseCount() {
+		dataset := "vaccination and testing"
+		if parsedReq.Type.datum().isHospitalFigure() {
+			dataset = "hospital"
+		}
+		requestLog := fmt.Sprintf("Dated %s request. Code=%s", parsedReq.Type, parsedReq.Code)
+		botErr := &botError{
+			fmt.Errorf("No history of %s figures", dataset),
+			fmt.Sprintf("Sorry, I only have the latest %s figures.", dataset),
+			[]interface{}{requestLog},
+			OutcomeNoHistory,
+		}
+		return "", botErr
+	}
+	subject := "Active Cases"
+	if parsedReq.Type == _Deaths {
+		subject = "Deaths"
+	}
+	if parsedReq.Code == "TOTAL" {
+		subject = "Total " + subject
+	}
+
+	name, to, botErr := b.statsAt(parsedReq, when.to)
+	if botErr == nil {
+		botErr = checkSnapshotGap(parsedReq, to, when.to)
+	}
+	if botErr != nil {
+		return "", botErr
+	}
+	if name != "" {
+		subject = fmt.Sprintf("[%s] %s %s", parsedReq.Code, name, subject)
+	}
+	value := func(stats *StatsSnapshot) int64 {
+		if parsedReq.Type == _Deaths {
+			return stats.Deaths
+		}
+		return stats.Active()
+	}
+
+	if !when.isRange() {
+		message := fmt.Sprintf("%s on %s: %s", subject, formatDay(to.CollectedAt), FormatNumber(value(to)))
+		if !sameDay(to.CollectedAt, when.to) {
+			message = fmt.Sprintf("%s\n(I don't have data for %s. This is the nearest day I have.)", message, formatDay(when.to))
+		}
+		return message, nil
+	}
+
+	_, from, botErr := b.statsAt(parsedReq, when.from)
+	if botErr == nil {
+		botErr = checkSnapshotGap(parsedReq, from, when.from)
+	}
+	if botErr != nil {
+		return "", botErr
+	}
+	if from.CollectedAt.Equal(to.CollectedAt) {
+		requestLog := fmt.Sprintf("Single snapshot between %s and %s. Code=%s", when.from, when.to, parsedReq.Code)
+		botErr := &botError{
+			errors.New("Not enough history"),
+			fmt.Sprintf("Sorry, I don't have enough history for that. The nearest day I have is %s.", formatDay(to.CollectedAt)),
+			[]interface{}{requestLog},
+			OutcomeNoHistory,
+		}
+		return "", botErr
+	}
+	change := value(to) - value(from)
+	sign := "+"
+	if change < 0 {
+		sign = "-"
+		change = -change
+	}
+	message := fmt.Sprintf("%s went from %s on %s to %s on %s (%s%s)", subject,
+		FormatNumber(value(from)), formatDay(from.CollectedAt), FormatNumber(value(to)), formatDay(to.CollectedAt), sign, FormatNumber(change))
+	return message, nil
+}
+
+// maxSnapshotGap is the furthest the nearest snapshot can be from the day asked about to be given for it
+const maxSnapshotGap = 7 * 24 * time.Hour
+
+// checkSnapshotGap refuses a snapshot collected too far from the time asked about to stand in for it
+func checkSnapshotGap(parsedReq *parsedRequest, stats *StatsSnapshot, at time.Time) *botError {
+	gap := stats.CollectedAt.Sub(at)
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap <= maxSnapshotGap {
+		return nil
+	}
+	requestLog := fmt.Sprintf("Nearest snapshot to %s is %s. Code=%s", at, stats.CollectedAt, parsedReq.Code)
+	return &botError{
+		errors.New("No snapshot near the date"),
+		fmt.Sprintf("Sorry, I don't have data near %s. The nearest day I have is %s.", formatDay(at), formatDay(stats.CollectedAt)),
+		[]interface{}{requestLog},
+		OutcomeNoHistory,
+	}
+}
+
+// statsAt returns the request's statistics as they were at the given time. Name is empty for global statistics.
+func (b *Bot) statsAt(parsedReq *parsedRequest, at time.Time) (string, *StatsSnapshot, *botError) {
+	if parsedReq.Code == "TOTAL" {
+		stats, err := b.view.GlobalStatsAt(at)
+		if err != nil {
+			logMessage := fmt.Sprintf("Error: Global Stats At %s", at)
+			return "", nil, &botError{err, "Sorry, I don't have the results right now.", []interface{}{logMessage}, OutcomeUnavailable}
+		}
+		return "", stats, nil
+	}
+	if IsRegionCode(parsedReq.Code) {
+		info, stats, err := b.view.RegionStatsAt(parsedReq.Code, at)
+		if err != nil {
+			logMessage := fmt.Sprintf("Error: Region Stats At %s. Code=%s", at, parsedReq.Code)
+			return "", nil, &botError{err, "Sorry, I don't have the results right now.", []interface{}{logMessage}, OutcomeUnavailable}
+		}
+		return info.Name, stats, nil
+	}
+	if isGroupCode(parsedReq.Code) {
+		return b.groupStatsAt(parsedReq.Code, at)
+	}
+	info, stats, err := b.view.CountryStatsAt(parsedReq.Code, at)
+	if _, ok := err.(*NoCountryMatchedError); ok {
+		name, stats, botErr := b.groupStatsAt(parsedReq.Code, at)
+		if botErr == nil {
+			return name, stats, nil
+		}
+		if _, ok := botErr.err.(*NoGroupMatchedError); !ok {
+			return "", nil, botErr
+		}
+	}
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Country Stats At %s. Code=%s", at, parsedReq.Code)
+		return "", nil, &botError{err, "Sorry, I don't have the results right now.", []interface{}{logMessage}, OutcomeUnavailable}
+	}
+	return info.Name, stats, nil
+}
+
+func (b *Bot) groupStatsAt(code string, at time.Time) (string, *StatsSnapshot, *botError) {
+	group, stats, err := b.view.GroupStatsAt(code, at)
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Group Stats At %s. Code=%s", at, code)
+		return "", nil, &botError{err, "Sorry, I don't have the results right now.", []interface{}{logMessage}, OutcomeUnavailable}
+	}
+	return group.Name, stats, nil
+}
+
+// isGroupCode reports whether the code can only be a group's, e.g. EUROPE. Two letter group codes like EU
+// are only tried once no country has the code.
+func isGroupCode(code string) bool {
+	return len(code) > 2 && code != "TOTAL" && !IsRegionCode(code)
+}
+
+// formatDay formats the day the way replies name them, e.g. 4 Dec 2020
+func formatDay(t time.Time) string {
+	return t.UTC().Format("2 Jan 2006")
+}
+
+func (b *Bot) generateCasesResponse(code string) (string, *botError) {
+	if code == "TOTAL" {
+		return b.generateGlobalActiveMessage()
+	}
+	if IsRegionCode(code) {
+		return b.generateRegionMessage(code, _Cases)
+	}
+	if isGroupCode(code) {
+		return b.generateGroupMessage(code, _Cases)
+	}
+	message, botErr := b.generateCountryActiveMessage(code)
+	return b.orGroupMessage(code, _Cases, message, botErr)
+}
+
+func (b *Bot) generateGlobalActiveMessage() (string, *botError) {
+	activeCount, err := b.view.LatestGlobalView(Active)
+	if err != nil {
+		logMessage := "Error: Global Active"
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+
+	message := fmt.Sprintf("Total Active Cases: %s", FormatNumber(activeCount))
+	return message, nil
+}
+
+func (b *Bot) generateCountryActiveMessage(code string) (string, *botError) {
+	countryName, activeCount, err := b.view.LatestCountryView(code, Active)
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Country Active. Code=%s", code)
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+
+	message := fmt.Sprintf("[%s] %s Active Cases: %s", code, countryName, FormatNumber(activeCount))
+	return message, nil
+}
+
+func (b *Bot) generateDeathsResponse(code string) (string, *botError) {
+	if code == "TOTAL" {
+		return b.generateGlobalDeathsMessage()
+	}
+	if IsRegionCode(code) {
+		return b.generateRegionMessage(code, _Deaths)
+	}
+	if isGroupCode(code) {
+		return b.generateGroupMessage(code, _Deaths)
+	}
+	message, botErr := b.generateCountryDeathsMessage(code)
+	return b.orGroupMessage(code, _Deaths, message, botErr)
+}
+
+func (b *Bot) generateGlobalDeathsMessage() (string, *botError) {
+	deathCount, err := b.view.LatestGlobalView(Deaths)
+	if err != nil {
+		logMessage := "Error: Global Deaths"
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+
+	message := fmt.Sprintf("Total Deaths: %s", FormatNumber(deathCount))
+	return message, nil
+}
+
+func (b *Bot) generateCountryDeathsMessage(code string) (string, *botError) {
+	countryName, deathCount, err := b.view.LatestCountryView(code, Deaths)
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Country Deaths. Code=%s", code)
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+
+	message := fmt.Sprintf("[%s] %s Deaths: %s", code, countryName, FormatNumber(deathCount))
+	return message, nil
+}
+
+func (b *Bot) generateRegionMessage(code string, requestType requestType) (string, *botError) {
+	info, stats, err := b.view.LatestRegionStats(code)
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Region %s. Code=%s", requestType, code)
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+
+	return formatPlaceMessage(code, info.Name, requestType, stats), nil
+}
+
+func (b *Bot) generateGroupMessage(code string, requestType requestType) (string, *botError) {
+	group, stats, err := b.view.LatestGroupStats(code)
+	if err != nil {
+		logMessage := fmt.Sprintf("Error: Group %s. Code=%s", requestType, code)
+		failedMessageCtxt := []interface{}{logMessage}
+		botErr := &botError{err, "Sorry, I don't have the results right now.", failedMessageCtxt, OutcomeUnavailable}
+		return "", botErr
+	}
+	return formatPlaceMessage(code, group.Name, requestType, stats), nil
+}
+
+// orGroupMessage answers for the group with the code when no country has it, e.g. EU.
+// The country's error is kept when no group has the code either.
+func (b *Bot) orGroupMessage(code string, requestType requestType, message string, botErr *botError) (string, *botError) {
+	if botErr == nil {
+		return message, nil
+	}
+	if _, ok := botErr.err.(*NoCountryMatchedError); !ok {
+		return "", botErr
+	}
+	groupMessage, groupErr := b.generateGroupMessage(code, requestType)
+	if groupErr != nil {
+		if _, ok := groupErr.err.(*NoGroupMatchedError); ok {
+			return "", botErr
+		}
+		return "", groupErr
+	}
+	return groupMessage, nil
+}
+
+// formatPlaceMessage formats the latest figure for a region or group the way country figures are formatted
+func formatPlaceMessage(code string, name string, requestType requestType, stats *StatsSnapshot) string {
+	if requestType == _Deaths {
+		return fmt.Sprintf("[%s] %s Deaths: %s", code, name, FormatNumber(stats.Deaths))
+	}
+	return fmt.Sprintf("[%s] %s Active Cases: %s", code, name, FormatNumber(stats.Active()))
+}
+
+func (b *Bot) generateVaccinationResponse(code string, requestType requestType) (string, *botError) {
+	if botErr := noRegionFigures(code, requestType, "vaccination"); botErr != nil {
+		return "", botErr
+	}
+	var name string
+	var figures *VaccinationSnapshot
+	var err error
+	switch {
+	case code == "TOTAL":
+		figures, err = b.view.LatestGlobalVaccinations()
+	case isGroupCode(code):
+		name, figures, err = b.groupVaccinations(code)
+	default:
+		var info *CountryInfo
+		info, figures, err = b.view.LatestCountryVaccinations(code)
+		if info != nil {
+			name = info.Name
+		}
+		if _, ok := err.(*NoCountryMatchedError); ok {
+			// Two letter group codes like EU are only tried once no country has the code
+			groupName, groupFigures, groupErr := b.groupVaccinations(code)
+			if _, ok := groupErr.(*NoGroupMatchedError); !ok {
+				name, figures, err = groupName, groupFigures, groupErr
+			}
+		}
+	}
+	if err != nil {
+		return "", figuresError(err, code, requestType, "vaccination")
+	}
+
+	subject, value := "Vaccine Doses", FormatNumber(figures.Doses)
+	if requestType == _Vaccinated {
+		subject, value = "People Vaccinated", FormatNumber(figures.PeopleVaccinated)
+	}
+	return formatFiguresMessage(code, name, subject, value, figures.CollectedAt), nil
+}
+
+func (b *Bot) groupVaccinations(code string) (string, *VaccinationSnapshot, error) {
+	group, figures, err := b.view.LatestGroupVaccinations(code)
+	if err != nil {
+		return "", nil, err
+	}
+	return group.Name, figures, nil
+}
+
+func (b *Bot) generateTestingResponse(code string, requestType requestType) (string, *botError) {
+	if botErr := noRegionFigures(code, requestType, "testing"); botErr != nil {
+		return "", botErr
+	}
+	var name string
+	var figures *TestingSnapshot
+	var err error
+	switch {
+	case code == "TOTAL":
+		requestLog := fmt.Sprintf("Global %s request", requestType)
+		return "", &botError{errors.New("No global testing figures"), "Sorry, I only have testing figures for countries and groups.", []interface{}{requestLog}, OutcomeUnsupportedPlace}
+	case isGroupCode(code):
+		name, figures, err = b.groupTests(code)
+	default:
+		var info *CountryInfo
+		info, figures, err = b.view.LatestCountryTests(code)
+		if info != nil {
+			name = info.Name
+		}
+		if _, ok := err.(*NoCountryMatchedError); ok {
+			// Two letter group codes like EU are only tried once no country has the code
+			groupName, groupFigures, groupErr := b.groupTests(code)
+			if _, ok := groupErr.(*NoGroupMatchedError); !ok {
+				name, figures, err = groupName, groupFigures, groupErr
+			}
+		}
+	}
+	if err != nil {
+		return "", figuresError(err, code, requestType, "testing")
+	}
+
+	subject, value := "Tests", FormatNumber(figures.Tests)
+	if requestType == _Positivity {
+		subject, value = "Test Positivity", fmt.Sprintf("%.1f%%", figures.PositiveRate*100)
+	}
+	return formatFiguresMessage(code, name, subject, value, figures.CollectedAt), nil
+}
+
+func (b *Bot) groupTests(code string) (string, *TestingSnapshot, error) {
+	group, figures, err := b.view.LatestGroupTests(code)
+	if err != nil {
+		return "", nil, err
+	}
+	return group.Name, figures, nil
+}
+
+// hospitalSubjects name hospital occupancy figures in replies, and hospitalFigureNames when they aren't available
+var (
+	hospitalSubjects = map[requestType]string{
+		_Hospitalized: "Hospitalized",
+		_ICU:          "ICU Patients",
+		_Ventilators:  "Patients on Ventilators",
+	}
+	hospitalFigureNames = map[requestType]string{
+		_Hospitalized: "hospitalization",
+		_ICU:          "ICU",
+		_Ventilators:  "ventilator",
+	}
+)
+
+// generateHospitalResponse answers with a country's or region's hospital occupancy figures. Places publish different
+// figures, so a figure that isn't published is said to be unavailable rather than answered as zero.
+func (b *Bot) generateHospitalResponse(code string, requestType requestType) (string, *botError) {
+	if code == "TOTAL" || isGroupCode(code) {
+		requestLog := fmt.Sprintf("%s request for %s", requestType, code)
+		message := "Sorry, I only have hospital figures for countries and regions."
+		return "", &botError{errors.New("No hospital figures for the world or groups"), message, []interface{}{requestLog}, OutcomeUnsupportedPlace}
+	}
+	name, figures, err := b.view.LatestHospitalOccupancy(code)
+	if err != nil {
+		return "", figuresError(err, code, requestType, "hospital")
+	}
+
+	figure := figures.Figure(requestType.datum())
+	if figure == nil {
+		requestLog := fmt.Sprintf("%s not published. Code=%s", requestType, code)
+		message := fmt.Sprintf("Sorry, %s figures aren't available for [%s] %s.", hospitalFigureNames[requestType], code, name)
+		return "", &botError{fmt.Errorf("No %s figures published for %s", requestType, code), message, []interface{}{requestLog}, OutcomeNotPublished}
+	}
+	return formatFiguresMessage(code, name, hospitalSubjects[requestType], FormatNumber(figure.Patients), figure.CollectedAt), nil
+}
+
+// noRegionFigures refuses vaccination and testing requests for regions since the figures are only read for countries.
+// Groups add up their members' figures.
+func noRegionFigures(code string, requestType requestType, dataset string) *botError {
+	if !IsRegionCode(code) {
+		return nil
+	}
+	requestLog := fmt.Sprintf("%s request for %s", requestType, code)
+	message := fmt.Sprintf("Sorry, I only have %s figures for countries and groups.", dataset)
+	return &botError{errors.New("No figures for regions"), message, []interface{}{requestLog}, OutcomeUnsupportedPlace}
+}
+
+// figuresError explains when a country has no vaccination, testing or hospital figures rather than saying no figures were ever stored
+func figuresError(err error, code string, requestType requestType, dataset string) *botError {
+	logMessage := fmt.Sprintf("Error: %s. Code=%s", requestType, code)
+	if _, ok := err.(*NoSnapshotError); ok {
+		// Wrapped so the reply names the dataset
+		err = fmt.Errorf("No %s figures: %v", dataset, err)
+		return &botError{err, fmt.Sprintf("Sorry, I don't have %s figures for %s yet.", dataset, code), []interface{}{logMessage}, OutcomeNoHistory}
+	}
+	return &botError{err, "Sorry, I don't have the results right now.", []interface{}{logMessage}, OutcomeUnavailable}
+}
+
+// formatFiguresMessage formats vaccination, testing and hospital figures, naming the day they're for since they're published
+// less often than case counts. Name is empty for the world's figures.
+func formatFiguresMessage(code string, name string, subject string, value string, collectedAt time.Time) string {
+	if name == "" {
+		return fmt.Sprintf("Total %s: %s (as of %s)", subject, value, formatDay(collectedAt))
+	}
+	return fmt.Sprintf("[%s] %s %s: %s (as of %s)", code, name, subject, value, formatDay(collectedAt))
+}
+
+// StaleNotice is added to responses when the data hasn't been updated within the threshold, with the day it was
+// last updated
+const StaleNotice = "\n(Heads up: this data was last updated %s and may be out of date.)"
+
+// staleNotice tells the user when the data behind a response hasn't been updated within the threshold.
+// Returns an empty notice when the data is up to date.
+func (b *Bot) staleNotice() string {
+	if b.staleAfter <= 0 {
+		return ""
+	}
+	stats, err := b.view.LatestGlobalStats()
+	if err != nil {
+		log.Printf("Unable to check data freshness: %v", err)
+		return ""
+	}
+	if b.now().Sub(stats.CollectedAt) <= b.staleAfter {
+		return ""
+	}
+	return fmt.Sprintf(StaleNotice, formatDay(stats.CollectedAt))
+}
+
+// FormatNumber formats the number the way the bot does, e.g. 1,822
+func FormatNumber(n int64) string {
+	p := message.NewPrinter(message.MatchLanguage("en"))
+	return p.Sprintf("%d", n)
+}
+
+// BotCommand represents a command the bot understands
+type BotCommand struct {
+	// Datum is the datapoint asked for. Active for CASES, Deaths for DEATHS and the datapoint of the same name for
+	// DOSES, VACCINATED, TESTS, POSITIVITY, HOSPITALIZED, ICU and VENTILATORS.
+	Datum Datum
+	// Code is a two letter country code, a region code (e.g. US-CA), a group code (e.g. EUROPE) or TOTAL
+	Code string
+}
+
+// MatchCommand parses a request message in the command grammar (e.g. "CASES SG") without answering it.
+// Returns nil when the message isn't a command. Free-form questions and follow-ups aren't parsed.
+func MatchCommand(requestMessage string) *BotCommand {
+	b := &Bot{}
+	trimmedMsg, botErr := b.trimRequest(requestMessage)
+	if botErr != nil {
+		return nil
+	}
+	parsedReq, botErr := b.matchRequest(trimmedMsg)
+	if botErr != nil {
+		return nil
+	}
+	return parsedReq.command()
+}
@@ -0,0 +1,367 @@
+package durcov
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBotMatchRequest(t *testing.T) {
+	tests := []struct {
+		input                 string
+		expectedParsedRequest *parsedRequest
+		expectError           bool
+	}{
+		{
+			"CASES TOTAL",
+			&parsedRequest{
+				_Cases,
+				"TOTAL",
+			},
+			false,
+		},
+		{
+			"CASES in",
+			&parsedRequest{
+				_Cases,
+				"IN",
+			},
+			false,
+		},
+		{
+			"deaths TOTAL",
+			&parsedRequest{
+				_Deaths,
+				"TOTAL",
+			},
+			false,
+		},
+		{
+			"DEATHS uS",
+			&parsedRequest{
+				_Deaths,
+				"US",
+			},
+			false,
+		},
+		{
+			"cases       AL",
+			&parsedRequest{
+				_Cases,
+				"AL",
+			},
+			false,
+		},
+		{
+			"DEATHS    TOTAL",
+			&parsedRequest{
+				_Deaths,
+				"TOTAL",
+			},
+			false,
+		},
+		{
+			"asiodjoai",
+			nil,
+			true,
+		},
+		{
+			"sfsf sadoija asfdijas",
+			nil,
+			true,
+		},
+		{
+			"Cases total cases total",
+			nil,
+			true,
+		},
+		{
+			"Case IN",
+			nil,
+			true,
+		},
+		{
+			"DEATHS europe",
+			&parsedRequest{
+				_Deaths,
+				"EUROPE",
+			},
+			false,
+		},
+		{
+			"DEATHS EUROPE-1",
+			nil,
+			true,
+		},
+		{
+			"Cases I",
+			nil,
+			true,
+		},
+	}
+
+	testBot := Bot{}
+
+	for _, test := range tests {
+		parsedReq, botErr := testBot.matchRequest(test.input)
+		if botErr != nil {
+			if !test.expectError {
+				t.Fatalf("Didn't Expect error. Input: %s, Error: %v", test.input, botErr.err)
+			}
+		} else if test.expectError {
+			t.Fatalf("Expected error. Input: %s, Parsed Request: %+v", test.input, parsedReq)
+		} else {
+			if parsedReq.Code != test.expectedParsedRequest.Code {
+				t.Fatalf("Parsed Request Code Error. Expected=%s Got=%s", test.expectedParsedRequest.Code, parsedReq.Code)
+			}
+
+			if parsedReq.Type != test.expectedParsedRequest.Type {
+				t.Fatalf("Parsed Request Type error. Expected=%d Got=%d", test.expectedParsedRequest.Type, parsedReq.Type)
+			}
+		}
+	}
+}
+
+func TestBotResponseGeneration(t *testing.T) {
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	pool, err := GetPgxPool(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	dataStore := &CovidDataStore{}
+	dataStore.SetDBConnection(pool)
+
+	exampleData, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dataStore.StoreData(exampleData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataView := &CovidBotView{}
+	dataView.SetDBConnection(pool)
+
+	testBot := NewBot(dataView, 0)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"CASES TOTAL",
+			"Total Active Cases: 9,000,000",
+		},
+		{
+			"CASES AF",
+			"[AF] Afghanistan Active Cases: 8,132",
+		},
+		{
+			"DEATHS TOTAL",
+			"Total Deaths: 500,000",
+		},
+		{
+			"DEATHS SG",
+			"[SG] Singapore Deaths: 1,822",
+		},
+		{
+			"abcdefghijklmnopqrstuvwxyz",
+			"Sorry, I'm not sure how to respond to that.",
+		},
+		{
+			"DEATH SG",
+			"Sorry, I'm not sure how to respond to that.",
+		},
+		{
+			"Could you please tell me how many people in total have died from the coronavirus in Singapore since the pandemic began in early 2020? I would really like to know the latest numbers.",
+			"Sorry, that message is too long for me.",
+		},
+		{
+			"cases IN",
+			"Sorry, that code doesn't match any countries I know.",
+		},
+	}
+
+	for _, test := range tests {
+		response := testBot.Respond(test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Expected=%s Got=%s", test.expected, response)
+		}
+	}
+}
+
+func TestBotStaleNotice(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(ExampleTestDataAt(time.Date(2020, 12, 4, 3, 49, 29, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBot := NewBot(memoryStore, 24*time.Hour)
+	expected := "[SG] Singapore Deaths: 1,822\n(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)"
+	if response := testBot.Respond("DEATHS SG"); response != expected {
+		t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+	}
+
+	testBot.staleAfter = 0
+	expected = "[SG] Singapore Deaths: 1,822"
+	if response := testBot.Respond("DEATHS SG"); response != expected {
+		t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+	}
+
+	expected = "Sorry, that code doesn't match any countries I know."
+	testBot.staleAfter = 24 * time.Hour
+	if response := testBot.Respond("DEATHS IN"); response != expected {
+		t.Errorf("Errors should not carry a stale notice. Expected=%s Got=%s", expected, response)
+	}
+}
+
+func TestMatchCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *BotCommand
+	}{
+		{"cases SG", &BotCommand{Active, "SG"}},
+		{" DEATHS total ", &BotCommand{Deaths, "TOTAL"}},
+		{"cases europe", &BotCommand{Active, "EUROPE"}},
+		{"DEATHS EUROPE-1", nil},
+		{"CASES                                                TOTAL", nil},
+	}
+
+	for _, test := range tests {
+		command := MatchCommand(test.input)
+		if test.expected == nil {
+			if command != nil {
+				t.Errorf("Expected no command. Input: %s Got=%+v", test.input, command)
+			}
+		} else if command == nil || *command != *test.expected {
+			t.Errorf("Command mismatch. Input: %s Expected=%+v Got=%+v", test.input, test.expected, command)
+		}
+	}
+}
+
+func TestBotFollowUps(t *testing.T) {
+	newConversingBot := func(t *testing.T) (*Bot, *MemorySessionStore) {
+		memoryStore := NewMemoryStore()
+		data, err := ExampleTestData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = memoryStore.StoreData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions := NewMemorySessionStore(time.Hour)
+		testBot := NewBot(memoryStore, 0)
+		testBot.SetSessions(sessions)
+		return testBot, sessions
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Fills in follow-ups from the last command": func(t *testing.T) {
+			testBot, _ := newConversingBot(t)
+			conversation := []struct {
+				input    string
+				expected string
+				command  *BotCommand
+			}{
+				{"CASES SG", "[SG] Singapore Active Cases: 8,132", &BotCommand{Active, "SG"}},
+				{"and deaths?", "[SG] Singapore Deaths: 1,822", &BotCommand{Deaths, "SG"}},
+				{"What about AF?", "[AF] Afghanistan Deaths: 1,822", &BotCommand{Deaths, "AF"}},
+				{"how about the total", "Total Deaths: 500,000", &BotCommand{Deaths, "TOTAL"}},
+				{"cases", "Total Active Cases: 9,000,000", &BotCommand{Active, "TOTAL"}},
+				{"and the weather?", "Sorry, I'm not sure how to respond to that.", nil},
+			}
+			for _, message := range conversation {
+				response, command := testBot.RespondTo("telegram:1", message.input)
+				if response != message.expected {
+					t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", message.input, message.expected, response)
+				}
+				if (command == nil) != (message.command == nil) || (command != nil && *command != *message.command) {
+					t.Errorf("Command mismatch. Input: %s Expected=%+v Got=%+v", message.input, message.command, command)
+				}
+			}
+		},
+		"Doesn't take short words for codes": func(t *testing.T) {
+			testBot, _ := newConversingBot(t)
+			testBot.RespondTo("telegram:1", "DEATHS SG")
+			for _, input := range []string{"no", "so", "ok", "hi", "is", "Ok!"} {
+				response, command := testBot.RespondTo("telegram:1", input)
+				if command != nil || response != "Sorry, I'm not sure how to respond to that." {
+					t.Errorf("Response mismatch. Input: %s Got=%s %+v", input, response, command)
+				}
+			}
+			if response, _ := testBot.RespondTo("telegram:1", "AF"); response != "[AF] Afghanistan Deaths: 1,822" {
+				t.Errorf("A lone code in capitals should be a follow-up. Got=%s", response)
+			}
+			if response, _ := testBot.RespondTo("telegram:1", "what about af"); response != "[AF] Afghanistan Deaths: 1,822" {
+				t.Errorf("An asked about code should be a follow-up. Got=%s", response)
+			}
+		},
+		"Keeps the last answered command": func(t *testing.T) {
+			testBot, sessions := newConversingBot(t)
+			testBot.RespondTo("telegram:1", "DEATHS SG")
+			testBot.RespondTo("telegram:1", "what about IN")
+			conversation, _ := sessions.Conversation("telegram:1")
+			if conversation == nil || conversation.Code != "SG" {
+				t.Errorf("Unanswered follow-ups should not change the conversation. Got=%+v", conversation)
+			}
+		},
+		"Keeps senders apart": func(t *testing.T) {
+			testBot, _ := newConversingBot(t)
+			testBot.RespondTo("telegram:1", "DEATHS SG")
+			expected := "Sorry, I don't remember what we were talking about. Try e.g. CASES SG."
+			if response, _ := testBot.RespondTo("telegram:2", "and cases?"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+			expected = "Sorry, I'm not sure how to respond to that."
+			if response, _ := testBot.RespondTo("telegram:2", "SG"); response != expected {
+				t.Errorf("A lone code without a conversation should be unmatched. Expected=%s Got=%s", expected, response)
+			}
+		},
+		"Forgets expired conversations": func(t *testing.T) {
+			testBot, sessions := newConversingBot(t)
+			now := time.Now()
+			sessions.now = func() time.Time { return now }
+			testBot.RespondTo("telegram:1", "DEATHS SG")
+
+			now = now.Add(time.Hour)
+			expected := "Sorry, I don't remember what we were talking about. Try e.g. CASES SG."
+			if response, _ := testBot.RespondTo("telegram:1", "and cases?"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+		},
+		"Resets the conversation": func(t *testing.T) {
+			testBot, _ := newConversingBot(t)
+			testBot.RespondTo("telegram:1", "DEATHS SG")
+			expected := "Okay, I've forgotten our conversation. Send e.g. CASES SG to start again."
+			if response, _ := testBot.RespondTo("telegram:1", "reset"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+			expected = "Sorry, I don't remember what we were talking about. Try e.g. CASES SG."
+			if response, _ := testBot.RespondTo("telegram:1", "and cases?"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+		},
+		"Answers without a session when there's no sender": func(t *testing.T) {
+			testBot, _ := newConversingBot(t)
+			testBot.Respond("DEATHS SG")
+			// Read as a free-form question about the world
+			expected := "Total Active Cases: 9,000,000"
+			if response := testBot.Respond("and cases?"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+			expected = "Sorry, I'm not sure how to respond to that."
+			if response := testBot.Respond("RESET"); response != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const usage = `Usage:
+  durcov [flags]                     Start a REPL answering bot commands
+  durcov [flags] ask [-json] QUERY   Answer a single bot command, e.g. durcov ask "cases IN"
+
+Flags:
+`
+
+const replHelp = `Send CASES or DEATHS followed by a two letter country code or TOTAL, e.g. CASES SG.
+Regions have codes too, e.g. CASES US-CA for California or US-CA-037 for Los Angeles county.
+So do groups of countries, e.g. CASES EU, CASES ASEAN or CASES EUROPE.
+DOSES, VACCINATED, TESTS and POSITIVITY give a country's latest vaccination and testing figures, e.g. DOSES SG.
+HOSPITALIZED, ICU and VENTILATORS give the latest hospital figures where they're published, e.g. ICU US-CA.
+Or ask a question, e.g. how many people died in brazil?
+End with a date to ask about the past, e.g. DEATHS SG ON 2020-11-01, YESTERDAY or LAST WEEK.
+Follow-ups like "and deaths?" or "what about IN" use your last command. RESET forgets it.
+Type EXIT or press Ctrl-D to quit.`
+
+// replSender is who the REPL's messages are from. The REPL is a single conversation.
+const replSender = "cli"
+
+type options struct {
+	backend      string
+	dbURL        string
+	sqlite       string
+	fixture      string
+	regions      string
+	vaccinations string
+	hospitals    string
+	staleAfter   time.Duration
+	sessionTTL   time.Duration
+	verbose      bool
+}
+
+// answer represents a bot response as printed by ask -json
+type answer struct {
+	Query   string `json:"query"`
+	Reply   string `json:"reply"`
+	Command string `json:"command"`
+	Outcome string `json:"outcome"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the CLI and returns its exit code: 0 on success, 1 when a query isn't answered and 2 for usage errors.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("durcov", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	opts := &options{}
+	flags.StringVar(&opts.backend, "backend", "", "Data backend: postgres, sqlite or memory. Defaults to sqlite when a SQLite file is set, postgres when a database URL is set and memory otherwise")
+	flags.StringVar(&opts.dbURL, "db", os.Getenv("DATABASE_URL"), "Postgres URL for the postgres backend")
+	flags.StringVar(&opts.sqlite, "sqlite", "", "SQLite file for the sqlite backend, with the tables of the Postgres schema (group members comma separated)")
+	flags.StringVar(&opts.fixture, "fixture", "", "JSON file in the covid API's format to load into the memory backend. Defaults to the example test data")
+	flags.StringVar(&opts.regions, "regions", "", "CSV file in the JHU CSSE daily report format with regions to add to the memory backend's data")
+	flags.StringVar(&opts.vaccinations, "vaccinations", "", "CSV file in the Our World in Data format with vaccination and testing figures to add to the memory backend's data")
+	flags.StringVar(&opts.hospitals, "hospitals", "", "CSV file with hospitalized, icu and ventilators counts by country or region code to add to the memory backend's data")
+	flags.DurationVar(&opts.staleAfter, "stale-after", 24*time.Hour, "How old data can get before replies carry a notice. Zero disables the notice")
+	flags.DurationVar(&opts.sessionTTL, "session-ttl", 30*time.Minute, "How long the REPL remembers the last command for follow-ups")
+	flags.BoolVar(&opts.verbose, "verbose", false, "Log bot errors to stderr")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	log.SetOutput(ioutil.Discard)
+	if opts.verbose {
+		log.SetOutput(stderr)
+	}
+
+	view, closeView, err := openView(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer closeView()
+	bot := durcov.NewBot(view, opts.staleAfter)
+
+	switch flags.Arg(0) {
+	case "":
+		bot.SetSessions(durcov.NewMemorySessionStore(opts.sessionTTL))
+		err = repl(bot, stdin, stdout)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	case "ask":
+		return ask(bot, flags.Args()[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+}
+
+// openView returns the view for the configured backend and a function to release it.
+// The sqlite backend loads the file into memory since the bot's queries are written for Postgres.
+func openView(opts *options) (durcov.DataView, func(), error) {
+	backend := opts.backend
+	if backend == "" {
+		backend = "memory"
+		if opts.sqlite != "" {
+			backend = "sqlite"
+		} else if opts.dbURL != "" {
+			backend = "postgres"
+		}
+	}
+
+	switch backend {
+	case "postgres":
+		if opts.dbURL == "" {
+			return nil, nil, errors.New("The postgres backend needs a database URL. Set -db or DATABASE_URL")
+		}
+		pgxpool, err := durcov.GetPgxPool(opts.dbURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		view := &durcov.CovidBotView{}
+		view.SetDBConnection(pgxpool)
+		return view, pgxpool.Close, nil
+	case "sqlite":
+		if opts.sqlite == "" {
+			return nil, nil, errors.New("The sqlite backend needs a database file. Set -sqlite")
+		}
+		// Read only so a mistyped path isn't created as an empty database
+		db, err := sql.Open("sqlite3", "file:"+opts.sqlite+"?mode=ro")
+		if err != nil {
+			return nil, nil, err
+		}
+		defer db.Close()
+		memoryStore, err := durcov.LoadMemoryStore(db)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to load %s: %v", opts.sqlite, err)
+		}
+		return memoryStore, func() {}, nil
+	case "memory":
+		data, err := loadFixture(opts.fixture)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = loadRegions(data, opts.regions)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = loadVaccinationsAndTests(data, opts.vaccinations)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = loadHospitalOccupancy(data, opts.hospitals)
+		if err != nil {
+			return nil, nil, err
+		}
+		memoryStore := durcov.NewMemoryStore()
+		err = memoryStore.StoreData(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return memoryStore, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown backend %q. Expected postgres, sqlite or memory", backend)
+}
+
+// loadFixture reads data saved from the covid API. The example test data is used when no path is given.
+func loadFixture(path string) (*durcov.Data, error) {
+	if path == "" {
+		return durcov.ExampleTestData()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := durcov.ReadData(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read fixture %s: %v", path, err)
+	}
+	return data, data.Validate()
+}
+
+// loadRegions adds the regions in a saved JHU CSSE daily report to the data. Nothing is added when no path is given.
+func loadRegions(data *durcov.Data, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = data.ReadRegions(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read regions %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadVaccinationsAndTests adds the figures in a saved OWID report to the data. Nothing is added when no path is given.
+func loadVaccinationsAndTests(data *durcov.Data, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = data.ReadVaccinationsAndTests(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read vaccinations and tests %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadHospitalOccupancy adds the figures in a saved hospital occupancy report to the data. Nothing is added when no path is given.
+func loadHospitalOccupancy(data *durcov.Data, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = data.ReadHospitalOccupancy(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read hospital occupancy %s: %v", path, err)
+	}
+	return nil
+}
+
+// respond answers the query from the sender, noting the command and outcome the bot reports for it.
+// An empty sender is answered without a conversation.
+func respond(bot *durcov.Bot, sender string, query string) *answer {
+	answered := &answer{Query: query}
+	bot.SetObserver(func(command string, outcome string) {
+		answered.Command = command
+		answered.Outcome = outcome
+	})
+	answered.Reply, _ = bot.RespondTo(sender, query)
+	return answered
+}
+
+// ask answers a single query. Exits with 1 when the bot couldn't answer it so scripted checks can fail on it.
+func ask(bot *durcov.Bot, args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ask", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "Print the reply, command and outcome as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	query := strings.Join(flags.Args(), " ")
+	if query == "" {
+		fmt.Fprintln(stderr, `Nothing to ask. e.g. durcov ask "cases IN"`)
+		return 2
+	}
+
+	answered := respond(bot, "", query)
+	if *asJSON {
+		err = json.NewEncoder(stdout).Encode(answered)
+	} else {
+		_, err = fmt.Fprintln(stdout, answered.Reply)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if answered.Outcome != durcov.OutcomeOK {
+		return 1
+	}
+	return 0
+}
+
+// repl answers every line read until EXIT or the end of input.
+func repl(bot *durcov.Bot, stdin io.Reader, stdout io.Writer) error {
+	fmt.Fprintln(stdout, replHelp)
+	scanner := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprint(stdout, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(stdout)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch strings.ToUpper(line) {
+		case "":
+			continue
+		case "EXIT", "QUIT":
+			return nil
+		case "HELP":
+			fmt.Fprintln(stdout, replHelp)
+			continue
+		}
+		fmt.Fprintln(stdout, respond(bot, replSender, line).Reply)
+	}
+}
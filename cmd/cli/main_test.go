@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const fixtureJSON = `{
+	"Global": {"TotalConfirmed": 64520350, "TotalDeaths": 1493624, "TotalRecovered": 41488406},
+	"Countries": [
+		{"Country": "Albania", "CountryCode": "AL", "Slug": "albania", "TotalConfirmed": 39719, "TotalDeaths": 839, "TotalRecovered": 19912, "Date": "2020-12-04T03:49:29Z"}
+	],
+	"Date": "2020-12-04T03:49:29Z"
+}`
+
+// sqliteFixture lays out the Postgres schema in SQLite with a few rows
+const sqliteFixture = `
+CREATE TABLE covid_stats (id TEXT PRIMARY KEY, name TEXT, slug TEXT, confirmed INT, deaths INT, recovered INT, collected_at TIMESTAMP);
+CREATE TABLE covid_stats_history (id TEXT, confirmed INT, deaths INT, recovered INT, collected_at TIMESTAMP, PRIMARY KEY (id, collected_at));
+CREATE TABLE covid_regions (id TEXT PRIMARY KEY, parent TEXT NOT NULL, level INT NOT NULL, name TEXT, confirmed INT, deaths INT, recovered INT, collected_at TIMESTAMP);
+CREATE TABLE country_groups (code TEXT PRIMARY KEY, name TEXT NOT NULL, members TEXT NOT NULL);
+CREATE TABLE covid_vaccinations (id TEXT PRIMARY KEY, doses BIGINT NOT NULL, people_vaccinated BIGINT NOT NULL, collected_at TIMESTAMP NOT NULL);
+CREATE TABLE covid_testing (id TEXT PRIMARY KEY, tests BIGINT NOT NULL, positive_rate DOUBLE PRECISION NOT NULL, collected_at TIMESTAMP NOT NULL);
+CREATE TABLE covid_hospital_occupancy (id TEXT NOT NULL, datum TEXT NOT NULL, patients BIGINT NOT NULL, collected_at TIMESTAMP NOT NULL, PRIMARY KEY (id, datum));
+INSERT INTO covid_stats VALUES ('GLOBAL', '', '', 64520350, 1493624, 41488406, '2020-12-04 03:49:29');
+INSERT INTO covid_stats VALUES ('AL', 'Albania', 'albania', 39719, 839, 19912, '2020-12-04 03:49:29');
+INSERT INTO covid_stats_history VALUES ('AL', 39000, 830, 19000, '2020-12-03 03:49:29');
+INSERT INTO covid_stats_history VALUES ('AL', 39719, 839, 19912, '2020-12-04 03:49:29');
+INSERT INTO country_groups VALUES ('BALKANS', 'Balkans', 'AL');
+`
+
+func runCLI(stdin string, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	// The example data is old so stale notices are turned off unless a test asks for them
+	args = append([]string{"-backend", "memory", "-stale-after", "0", "-db", ""}, args...)
+	code := run(args, strings.NewReader(stdin), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAsk(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Prints the reply": func(t *testing.T) {
+			code, stdout, _ := runCLI("", "ask", "deaths", "SG")
+			if code != 0 || stdout != "[SG] Singapore Deaths: 1,822\n" {
+				t.Errorf("Output mismatch. Code=%d Stdout=%q", code, stdout)
+			}
+		},
+		"Prints JSON": func(t *testing.T) {
+			code, stdout, _ := runCLI("", "ask", "-json", "cases TOTAL")
+			answered := &answer{}
+			err := json.Unmarshal([]byte(stdout), answered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := &answer{"cases TOTAL", "Total Active Cases: 9,000,000", "cases", durcov.OutcomeOK}
+			if code != 0 || *answered != *expected {
+				t.Errorf("Answer mismatch. Code=%d Expected=%+v Got=%+v", code, expected, answered)
+			}
+		},
+		"Fails queries the bot can't answer": func(t *testing.T) {
+			code, stdout, _ := runCLI("", "ask", "-json", "cases IN")
+			answered := &answer{}
+			json.Unmarshal([]byte(stdout), answered)
+			if code != 1 || answered.Outcome != durcov.OutcomeNoCountry {
+				t.Errorf("Answer mismatch. Code=%d Got=%+v", code, answered)
+			}
+		},
+		"Requires a query": func(t *testing.T) {
+			if code, _, _ := runCLI("", "ask"); code != 2 {
+				t.Errorf("Exit code mismatch. Expected=%d Got=%d", 2, code)
+			}
+		},
+		"Adds stale notices": func(t *testing.T) {
+			_, stdout, _ := runCLI("", "-stale-after", "24h", "ask", "deaths SG")
+			if !strings.Contains(stdout, "may be out of date") {
+				t.Errorf("Expected a stale notice. Got=%q", stdout)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	code, stdout, _ := runCLI("cases total\n\nDEATHS SG\nexit\nCASES AF\n")
+	if code != 0 {
+		t.Fatalf("Exit code mismatch. Expected=%d Got=%d", 0, code)
+	}
+	if !strings.Contains(stdout, "> Total Active Cases: 9,000,000\n") || !strings.Contains(stdout, "> [SG] Singapore Deaths: 1,822\n") {
+		t.Errorf("Expected both replies. Got=%q", stdout)
+	}
+	if strings.Contains(stdout, "Afghanistan") {
+		t.Errorf("Expected the REPL to stop at exit. Got=%q", stdout)
+	}
+
+	code, stdout, _ = runCLI("deaths SG\nand cases?\nreset\nand cases?\n")
+	if !strings.Contains(stdout, "> [SG] Singapore Active Cases: 8,132\n") || !strings.Contains(stdout, "> Sorry, I don't remember what we were talking about.") {
+		t.Errorf("Expected follow-ups until the conversation was reset. Got=%q", stdout)
+	}
+
+	code, stdout, _ = runCLI("deaths total")
+	if code != 0 || !strings.Contains(stdout, "Total Deaths: 500,000") {
+		t.Errorf("Expected the REPL to answer until the end of input. Code=%d Got=%q", code, stdout)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Loads fixtures into memory": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "summary.json")
+			err := ioutil.WriteFile(path, []byte(fixtureJSON), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, stdout, _ := runCLI("", "-fixture", path, "ask", "DEATHS AL")
+			if code != 0 || stdout != "[AL] Albania Deaths: 839\n" {
+				t.Errorf("Output mismatch. Code=%d Stdout=%q", code, stdout)
+			}
+		},
+		"Loads regions into memory": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "report.csv")
+			report := "FIPS,Admin2,Province_State,Country_Region,Last_Update,Confirmed,Deaths,Recovered\n" +
+				",,Kabul,Afghanistan,2020-12-04 05:27:50,17000,650,13000\n"
+			err := ioutil.WriteFile(path, []byte(report), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, stdout, _ := runCLI("", "-regions", path, "ask", "DEATHS AF-KABUL")
+			if code != 0 || stdout != "[AF-KABUL] Kabul Deaths: 650\n" {
+				t.Errorf("Output mismatch. Code=%d Stdout=%q", code, stdout)
+			}
+		},
+		"Loads vaccinations and tests into memory": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "owid.csv")
+			report := "iso_code,location,date,total_vaccinations,people_vaccinated,total_tests,positive_rate\n" +
+				"SGP,Singapore,2021-03-01,500000,400000,7000000,0.001\n"
+			err := ioutil.WriteFile(path, []byte(report), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, stdout, _ := runCLI("", "-vaccinations", path, "ask", "DOSES SG")
+			if code != 0 || stdout != "[SG] Singapore Vaccine Doses: 500,000 (as of 1 Mar 2021)\n" {
+				t.Errorf("Output mismatch. Code=%d Stdout=%q", code, stdout)
+			}
+		},
+		"Loads hospital occupancy into memory": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hospitals.csv")
+			report := "code,date,hospitalized,icu,ventilators\nSG,2021-03-01,120,15,\n"
+			err := ioutil.WriteFile(path, []byte(report), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, stdout, _ := runCLI("", "-hospitals", path, "ask", "ICU SG")
+			if code != 0 || stdout != "[SG] Singapore ICU Patients: 15 (as of 1 Mar 2021)\n" {
+				t.Errorf("Output mismatch. Code=%d Stdout=%q", code, stdout)
+			}
+		},
+		"Loads a SQLite database into memory": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "durcov.db")
+			db, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(sqliteFixture)
+			db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, stdout, _ := runCLI("DEATHS AL\nDEATHS AL 3 Dec 2020\nDEATHS BALKANS\n", "-backend", "sqlite", "-sqlite", path)
+			for _, reply := range []string{"> [AL] Albania Deaths: 839\n", "830", "Balkans"} {
+				if code != 0 || !strings.Contains(stdout, reply) {
+					t.Errorf("Expected %q. Code=%d Stdout=%q", reply, code, stdout)
+				}
+			}
+		},
+		"Needs a file for sqlite": func(t *testing.T) {
+			code, _, stderr := runCLI("", "-backend", "sqlite", "ask", "DEATHS SG")
+			if code != 2 || !strings.Contains(stderr, "-sqlite") {
+				t.Errorf("Expected a usage error. Code=%d Stderr=%q", code, stderr)
+			}
+		},
+		"Rejects unknown backends": func(t *testing.T) {
+			code, _, stderr := runCLI("", "-backend", "mongodb", "ask", "DEATHS SG")
+			if code != 2 || !strings.Contains(stderr, "Unknown backend") {
+				t.Errorf("Expected a usage error. Code=%d Stderr=%q", code, stderr)
+			}
+		},
+		"Needs a database URL for postgres": func(t *testing.T) {
+			code, _, stderr := runCLI("", "-backend", "postgres", "ask", "DEATHS SG")
+			if code != 2 || !strings.Contains(stderr, "database URL") {
+				t.Errorf("Expected a usage error. Code=%d Stderr=%q", code, stderr)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
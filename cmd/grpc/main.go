@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/TuhinNair/durcov"
+	"github.com/TuhinNair/durcov/durcovpb"
+)
+
+type config struct {
+	port  string
+	dbURL string
+}
+
+func loadConfig() *config {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = os.Getenv("PORT")
+	}
+	port = ":" + port
+	dbURL := os.Getenv("DATABASE_URL")
+
+	return &config{port: port, dbURL: dbURL}
+}
+
+func main() {
+	config := loadConfig()
+	pgxpool, err := durcov.GetPgxPool(config.dbURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pgxpool.Close()
+
+	dataview := &durcov.CovidBotView{}
+	dataview.SetDBConnection(pgxpool)
+
+	listener, err := net.Listen("tcp", config.port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	updateListener := &durcov.CovidUpdateListener{}
+	updateListener.SetDBConnection(pgxpool)
+	updates := newUpdateHub()
+	go func() {
+		err := updates.run(context.Background(), updateListener, durcov.DefaultListenRetry)
+		log.Printf("Stopped watching for updates: %v", err)
+	}()
+
+	grpcServer := grpc.NewServer()
+	durcovpb.RegisterDurCovServer(grpcServer, &DurCovServer{view: dataview, updates: updates})
+
+	log.Printf("Starting grpc server on port= %v", config.port)
+	err = grpcServer.Serve(listener)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/TuhinNair/durcov"
+	"github.com/TuhinNair/durcov/durcovpb"
+)
+
+// DurCovServer represents a grpc server for covid data backed by a data view
+type DurCovServer struct {
+	durcovpb.UnimplementedDurCovServer
+	view    durcov.DataView
+	updates *updateHub
+}
+
+// updateHub tells every watching stream when new data may have been stored
+type updateHub struct {
+	mu       sync.Mutex
+	watchers map[chan struct{}]struct{}
+	closed   bool
+}
+
+func newUpdateHub() *updateHub {
+	return &updateHub{watchers: map[chan struct{}]struct{}{}}
+}
+
+// run notifies watchers of every update received from the listener until ctx is done or listening fails for good,
+// then closes the hub.
+func (h *updateHub) run(ctx context.Context, listener durcov.UpdateListener, retry durcov.ListenRetry) error {
+	defer h.close()
+	return durcov.ListenWithRetry(ctx, listener, retry, h.notify)
+}
+
+// notify wakes every watcher without blocking. A watcher already due to check for new data needn't be woken twice.
+func (h *updateHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for watcher := range h.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe registers a new watcher. Returns false once the hub is closed.
+func (h *updateHub) subscribe() (chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	watcher := make(chan struct{}, 1)
+	h.watchers[watcher] = struct{}{}
+	return watcher, true
+}
+
+func (h *updateHub) unsubscribe(watcher chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[watcher]; ok {
+		delete(h.watchers, watcher)
+		close(watcher)
+	}
+}
+
+// close ends every watcher and refuses new ones
+func (h *updateHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for watcher := range h.watchers {
+		delete(h.watchers, watcher)
+		close(watcher)
+	}
+}
+
+// GetLatest returns the latest global statistics and statistics for the requested countries.
+func (s *DurCovServer) GetLatest(ctx context.Context, req *durcovpb.GetLatestRequest) (*durcovpb.Data, error) {
+	data, err := s.latestData(req.GetCountryCodes())
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return data, nil
+}
+
+// GetHistory returns every stored snapshot for a country (or global) between two times.
+func (s *DurCovServer) GetHistory(ctx context.Context, req *durcovpb.GetHistoryRequest) (*durcovpb.GetHistoryResponse, error) {
+	from := time.Time{}
+	if req.GetFrom() != nil {
+		parsed, err := ptypes.Timestamp(req.GetFrom())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid from: %v", err)
+		}
+		from = parsed
+	}
+	to := time.Now()
+	if req.GetTo() != nil {
+		parsed, err := ptypes.Timestamp(req.GetTo())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid to: %v", err)
+		}
+		to = parsed
+	}
+	if to.Before(from) {
+		return nil, status.Error(codes.InvalidArgument, "to must not be before from")
+	}
+
+	var history []*durcov.StatsSnapshot
+	var err error
+	code := strings.ToUpper(req.GetCountryCode())
+	if code == "" || code == "GLOBAL" {
+		history, err = s.view.GlobalHistory(from, to)
+	} else {
+		history, err = s.view.CountryHistory(code, from, to)
+	}
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	res := &durcovpb.GetHistoryResponse{}
+	for _, stats := range history {
+		res.Statistics = append(res.Statistics, toStatistics(stats))
+	}
+	return res, nil
+}
+
+// ListCountries returns every country with latest statistics.
+func (s *DurCovServer) ListCountries(ctx context.Context, req *durcovpb.ListCountriesRequest) (*durcovpb.ListCountriesResponse, error) {
+	countries, err := s.view.Countries()
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	res := &durcovpb.ListCountriesResponse{}
+	for _, info := range countries {
+		res.Countries = append(res.Countries, &durcovpb.Country{Name: info.Name, Slug: info.Slug, Code: info.Code})
+	}
+	return res, nil
+}
+
+// WatchUpdates sends the latest data on subscription and again every time a new snapshot is stored.
+// Updates are pushed by the server's update listener. The stream ends with Unavailable once it stops listening.
+func (s *DurCovServer) WatchUpdates(req *durcovpb.WatchUpdatesRequest, stream durcovpb.DurCov_WatchUpdatesServer) error {
+	changed, ok := s.updates.subscribe()
+	if !ok {
+		return status.Error(codes.Unavailable, "Not watching for updates")
+	}
+	defer s.updates.unsubscribe(changed)
+
+	var lastCollectedAt time.Time
+	for {
+		globalStats, err := s.view.LatestGlobalStats()
+		if err != nil {
+			log.Printf("Unable to check for updates: %v", err)
+		} else if !globalStats.CollectedAt.Equal(lastCollectedAt) {
+			// Listening again after a dropped connection notifies without new data
+			data, err := s.latestData(req.GetCountryCodes())
+			if err != nil {
+				return toStatusError(err)
+			}
+			err = stream.Send(data)
+			if err != nil {
+				return err
+			}
+			lastCollectedAt = globalStats.CollectedAt
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case _, ok := <-changed:
+			if !ok {
+				return status.Error(codes.Unavailable, "Stopped watching for updates")
+			}
+		}
+	}
+}
+
+func (s *DurCovServer) latestData(countryCodes []string) (*durcovpb.Data, error) {
+	globalStats, err := s.view.LatestGlobalStats()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(countryCodes) == 0 {
+		countries, err := s.view.Countries()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range countries {
+			countryCodes = append(countryCodes, info.Code)
+		}
+	}
+
+	data := &durcovpb.Data{Global: &durcovpb.Global{Stats: toStatistics(globalStats)}}
+	for _, code := range countryCodes {
+		info, stats, err := s.view.LatestCountryStats(strings.ToUpper(code))
+		if err != nil {
+			return nil, err
+		}
+		country := &durcovpb.Country{Name: info.Name, Slug: info.Slug, Code: info.Code, Stats: toStatistics(stats)}
+		data.Countries = append(data.Countries, country)
+	}
+	return data, nil
+}
+
+func toStatistics(stats *durcov.StatsSnapshot) *durcovpb.Statistics {
+	date, err := ptypes.TimestampProto(stats.CollectedAt)
+	if err != nil {
+		log.Printf("Unable to convert collection time %v: %v", stats.CollectedAt, err)
+	}
+	return &durcovpb.Statistics{
+		TotalConfirmed: stats.Confirmed,
+		TotalDeaths:    stats.Deaths,
+		TotalRecovered: stats.Recovered,
+		Date:           date,
+	}
+}
+
+func toStatusError(err error) error {
+	if _, ok := err.(*durcov.NoCountryMatchedError); ok {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	log.Printf("grpc request failed: %v", err)
+	return status.Error(codes.Internal, "Unable to fetch data")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/TuhinNair/durcov"
+	"github.com/TuhinNair/durcov/durcovpb"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	exitVal := m.Run()
+	os.Exit(exitVal)
+}
+
+// testListenRetry listens again quickly and gives up after a few attempts
+var testListenRetry = durcov.ListenRetry{MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Attempts: 3}
+
+// droppingListener drops its first connection straight away, like a dropped LISTEN connection, then listens on the store
+type droppingListener struct {
+	store   *durcov.MemoryStore
+	dropped int32
+}
+
+func (l *droppingListener) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	if atomic.CompareAndSwapInt32(&l.dropped, 0, 1) {
+		updates := make(chan time.Time)
+		close(updates)
+		return updates, nil
+	}
+	return l.store.ListenForUpdates(ctx)
+}
+
+// failingListener can never listen
+type failingListener struct{}
+
+func (l *failingListener) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	return nil, errors.New("connection refused")
+}
+
+func startTestServer(t *testing.T, view durcov.DataView, updateListener durcov.UpdateListener) durcovpb.DurCovClient {
+	updates := newUpdateHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go updates.run(ctx, updateListener, testListenRetry)
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	durcovpb.RegisterDurCovServer(grpcServer, &DurCovServer{view: view, updates: updates})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return durcovpb.NewDurCovClient(conn)
+}
+
+func TestDurCovServer(t *testing.T) {
+	memoryStore := exampleStore(t)
+	client := startTestServer(t, memoryStore, memoryStore)
+	ctx := context.Background()
+
+	tests := map[string]func(t *testing.T){
+		"GetLatest returns global and requested countries": func(t *testing.T) {
+			data, err := client.GetLatest(ctx, &durcovpb.GetLatestRequest{CountryCodes: []string{"sg"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.GetGlobal().GetStats().GetTotalDeaths() != 500000 {
+				t.Errorf("global deaths mismatch. Expected=%d Got=%d", 500000, data.GetGlobal().GetStats().GetTotalDeaths())
+			}
+			if len(data.GetCountries()) != 1 || data.GetCountries()[0].GetName() != "Singapore" {
+				t.Errorf("countries mismatch. Got=%v", data.GetCountries())
+			}
+		},
+		"GetLatest returns every country by default": func(t *testing.T) {
+			data, err := client.GetLatest(ctx, &durcovpb.GetLatestRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data.GetCountries()) != 2 {
+				t.Errorf("countries length mismatch. Expected=%d Got=%d", 2, len(data.GetCountries()))
+			}
+		},
+		"GetLatest unmatched country is not found": func(t *testing.T) {
+			_, err := client.GetLatest(ctx, &durcovpb.GetLatestRequest{CountryCodes: []string{"--"}})
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("Unexpected error code. Expected=%v Got=%v", codes.NotFound, status.Code(err))
+			}
+		},
+		"ListCountries returns countries by name": func(t *testing.T) {
+			res, err := client.ListCountries(ctx, &durcovpb.ListCountriesRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.GetCountries()) != 2 || res.GetCountries()[0].GetCode() != "AF" {
+				t.Errorf("countries mismatch. Got=%v", res.GetCountries())
+			}
+		},
+		"GetHistory returns country history": func(t *testing.T) {
+			res, err := client.GetHistory(ctx, &durcovpb.GetHistoryRequest{CountryCode: "AF"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.GetStatistics()) != 1 || res.GetStatistics()[0].GetTotalConfirmed() != 46980 {
+				t.Errorf("history mismatch. Got=%v", res.GetStatistics())
+			}
+		},
+		"GetHistory rejects inverted ranges": func(t *testing.T) {
+			from, _ := ptypes.TimestampProto(time.Now())
+			to, _ := ptypes.TimestampProto(time.Now().Add(-time.Hour))
+			_, err := client.GetHistory(ctx, &durcovpb.GetHistoryRequest{From: from, To: to})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Unexpected error code. Expected=%v Got=%v", codes.InvalidArgument, status.Code(err))
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestDurCovServerWatchUpdates(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Pushes newly stored data": func(t *testing.T) {
+			memoryStore := exampleStore(t)
+			testWatchUpdates(t, memoryStore, startTestServer(t, memoryStore, memoryStore))
+		},
+		"Keeps pushing after the listener drops": func(t *testing.T) {
+			memoryStore := exampleStore(t)
+			testWatchUpdates(t, memoryStore, startTestServer(t, memoryStore, &droppingListener{store: memoryStore}))
+		},
+		"Ends streams once it can't listen": func(t *testing.T) {
+			client := startTestServer(t, exampleStore(t), &failingListener{})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			stream, err := client.WatchUpdates(ctx, &durcovpb.WatchUpdatesRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for err == nil {
+				_, err = stream.Recv()
+			}
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("Unexpected error code. Expected=%v Got=%v", codes.Unavailable, status.Code(err))
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func exampleStore(t *testing.T) *durcov.MemoryStore {
+	memoryStore := durcov.NewMemoryStore()
+	exampleData, err := durcov.ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(exampleData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memoryStore
+}
+
+// testWatchUpdates checks the stream sends the latest data, then the data stored after it
+func testWatchUpdates(t *testing.T, memoryStore *durcov.MemoryStore, client durcovpb.DurCovClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchUpdates(ctx, &durcovpb.WatchUpdatesRequest{CountryCodes: []string{"AF"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	laterTime := time.Date(2020, 12, 5, 0, 0, 0, 0, time.UTC)
+	err = memoryStore.StoreData(durcov.ExampleTestDataAt(laterTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstDate, _ := ptypes.Timestamp(first.GetCountries()[0].GetStats().GetDate())
+	secondDate, _ := ptypes.Timestamp(second.GetCountries()[0].GetStats().GetDate())
+	if !secondDate.After(firstDate) || !secondDate.Equal(laterTime) {
+		t.Errorf("update date mismatch. Expected=%v Got=%v", laterTime, secondDate)
+	}
+}
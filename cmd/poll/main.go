@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx"
 
@@ -14,6 +15,14 @@ func main() {
 
 	dbURL := os.Getenv("DATABASE_URL")
 	covidEndpoint := os.Getenv("COVID_API_ENDPOINT")
+	// Optional. A JHU CSSE daily report CSV with state, province and county figures.
+	regionalEndpoint := os.Getenv("REGIONAL_DATA_ENDPOINT")
+	// Optional. An Our World in Data CSV with vaccination and testing figures.
+	vaccinationEndpoint := os.Getenv("VACCINATION_DATA_ENDPOINT")
+	// Optional. A CSV with hospitalized, ICU and ventilator counts by country or region code.
+	hospitalEndpoint := os.Getenv("HOSPITAL_DATA_ENDPOINT")
+	pushgatewayURL := os.Getenv("PROMETHEUS_PUSHGATEWAY_URL")
+	metricsTextfile := os.Getenv("METRICS_TEXTFILE")
 
 	pgxpool, err := durcov.GetPgxPool(dbURL)
 	if err != nil {
@@ -21,28 +30,100 @@ func main() {
 	}
 	defer pgxpool.Close()
 
-	err = fetchAndStoreData(pgxpool, covidEndpoint)
+	err = fetchAndStoreData(pgxpool, covidEndpoint, regionalEndpoint, vaccinationEndpoint, hospitalEndpoint)
+	outputMetrics(pushgatewayURL, metricsTextfile)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func fetchAndStoreData(pgxpool *pgx.ConnPool, covidEndpoint string) error {
-	data, err := fetchData(covidEndpoint)
+// fetchAndStoreData fetches the case counts along with any regional, vaccination, testing and hospital figures and stores
+// them. Those figures are optional: when they can't be added the failure is counted and logged, and storing the rest keeps
+// the ones stored last time.
+func fetchAndStoreData(pgxpool *pgx.ConnPool, covidEndpoint string, regionalEndpoint string, vaccinationEndpoint string, hospitalEndpoint string) error {
+	data, err := fetchData(covidEndpoint, hospitalEndpoint)
+	if hospitalErr, ok := err.(*durcov.HospitalReportError); ok {
+		hospitalFailures.Inc()
+		log.Print(hospitalErr)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	err = data.Validate()
+	if err != nil {
+		validationFailures.Inc()
+		return err
+	}
+	if regionalEndpoint != "" {
+		err = addRegions(data, regionalEndpoint)
+		if err != nil {
+			regionalFailures.Inc()
+			log.Printf("Unable to add regional data: %v", err)
+		}
+	}
+	if vaccinationEndpoint != "" {
+		addVaccinationsAndTests(data, vaccinationEndpoint)
+	}
+	err = storeData(pgxpool, data)
+	if err != nil {
+		return err
+	}
+	rowsStored.Set(float64(data.RowCount()))
+	lastSuccess.SetToCurrentTime()
+	return nil
+}
+
+// fetchData fetches the data from the covid API, adding hospital occupancy figures when an endpoint is given.
+// The data is returned along with a *durcov.HospitalReportError when only the figures couldn't be added.
+func fetchData(covidEndpoint string, hospitalEndpoint string) (*durcov.Data, error) {
+	start := time.Now()
+	defer func() {
+		fetchDuration.Set(time.Since(start).Seconds())
+	}()
+
+	covidAPI := &durcov.CovidAPI{}
+	covidAPI.UseURL(covidEndpoint)
+	var dataSource durcov.DataSource = covidAPI
+	if hospitalEndpoint != "" {
+		hospitalSource := &durcov.HospitalSource{Source: covidAPI}
+		err := hospitalSource.UseURL(hospitalEndpoint)
+		if err != nil {
+			hospitalFailures.Inc()
+			log.Printf("Unable to add hospital occupancy figures: %v", err)
+		} else {
+			dataSource = hospitalSource
+		}
+	}
+	return dataSource.FetchData()
+}
+
+func addRegions(data *durcov.Data, regionalEndpoint string) error {
+	report := durcov.JHUReport{}
+	err := report.UseURL(regionalEndpoint)
 	if err != nil {
 		return err
 	}
-	return storeData(pgxpool, data)
+	return report.AddRegions(data)
 }
 
-func fetchData(covidEndpoint string) (*durcov.Data, error) {
-	dataSource := durcov.CovidAPI{}
-	dataSource.UseURL(covidEndpoint)
-	data, err := dataSource.FetchData()
+// addVaccinationsAndTests fetches the vaccination and testing report and adds its figures to the data. It's timed and
+// counted on its own, and failures are only logged since the case counts are stored without the figures.
+func addVaccinationsAndTests(data *durcov.Data, vaccinationEndpoint string) {
+	start := time.Now()
+	defer func() {
+		vaccinationFetchDuration.Set(time.Since(start).Seconds())
+	}()
+
+	report := durcov.OWIDReport{}
+	err := report.UseURL(vaccinationEndpoint)
+	if err == nil {
+		err = report.AddVaccinationsAndTests(data)
+	}
 	if err != nil {
-		return nil, err
+		vaccinationFailures.Inc()
+		log.Printf("Unable to add vaccination and testing data: %v", err)
 	}
-	return data, nil
 }
 
 func storeData(pgxpool *pgx.ConnPool, data *durcov.Data) error {
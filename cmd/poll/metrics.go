@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pollRegistry holds the metrics of a single poll. The poller exits after one run so they're pushed or written out rather than scraped.
+var pollRegistry = prometheus.NewRegistry()
+
+var (
+	fetchDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "durcov_poll_fetch_duration_seconds",
+		Help: "Time taken to fetch and decode data from the covid API.",
+	})
+
+	vaccinationFetchDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "durcov_poll_vaccination_fetch_duration_seconds",
+		Help: "Time taken to fetch and read the vaccination and testing report.",
+	})
+
+	rowsStored = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "durcov_poll_rows_stored",
+		Help: "Rows stored by the last poll.",
+	})
+
+	validationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_poll_validation_failures_total",
+		Help: "Fetched data rejected by validation.",
+	})
+
+	regionalFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_poll_regional_failures_total",
+		Help: "Regional reports that couldn't be fetched or were rejected. Country data is still stored.",
+	})
+
+	vaccinationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_poll_vaccination_failures_total",
+		Help: "Vaccination and testing reports that couldn't be fetched or were rejected. Country data is still stored.",
+	})
+
+	hospitalFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_poll_hospital_failures_total",
+		Help: "Hospital occupancy reports that couldn't be fetched or were rejected. Country data is still stored.",
+	})
+
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "durcov_poll_last_success_timestamp_seconds",
+		Help: "Unix time of the last poll that stored data.",
+	})
+)
+
+func init() {
+	pollRegistry.MustRegister(fetchDuration, vaccinationFetchDuration, rowsStored, validationFailures, regionalFailures, vaccinationFailures, hospitalFailures, lastSuccess)
+}
+
+// outputMetrics pushes the poll metrics to a pushgateway and/or writes them to a textfile for node_exporter.
+// Either destination is skipped when not configured.
+func outputMetrics(pushgatewayURL string, textfilePath string) {
+	if pushgatewayURL != "" {
+		err := push.New(pushgatewayURL, "durcov_poll").Gatherer(pollRegistry).Push()
+		if err != nil {
+			log.Printf("Unable to push metrics: %v", err)
+		}
+	}
+	if textfilePath != "" {
+		err := prometheus.WriteToTextfile(textfilePath, pollRegistry)
+		if err != nil {
+			log.Printf("Unable to write metrics textfile: %v", err)
+		}
+	}
+}
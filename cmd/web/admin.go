@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// Admin represents the operator views. Every view requires basic auth.
+type Admin struct {
+	username     string
+	password     string
+	statuses     durcov.DeliveryStatusStore
+	suppressions durcov.SuppressionList
+	// view lists the groups managed through groups
+	view   durcov.DataView
+	groups durcov.GroupStore
+}
+
+type deliveryReport struct {
+	Since       time.Time      `json:"since"`
+	Total       int            `json:"total"`
+	ByStatus    map[string]int `json:"byStatus"`
+	FailureRate float64        `json:"failureRate"`
+}
+
+type messageStatusReport struct {
+	MessageSid string         `json:"messageSid"`
+	Statuses   []statusReport `json:"statuses"`
+}
+
+type statusReport struct {
+	Status     string    `json:"status"`
+	InboundSid string    `json:"inboundSid,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+type suppressionReport struct {
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type groupReport struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	BuiltIn bool     `json:"builtIn"`
+}
+
+// authenticate rejects requests without the admin credentials.
+func (a *Admin) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+		validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+		if !ok || !validUsername || !validPassword {
+			log.Println("Admin not authenticated")
+			w.Header().Set("WWW-Authenticate", `Basic realm="durcov admin"`)
+			http.Error(w, http.StatusText(401), 401)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleDeliveries reports the delivery statuses of outbound messages.
+// With a sid query parameter it lists every status reported for that message,
+// otherwise it summarises messages with a status reported within the since duration (default 24h).
+func (a *Admin) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	if sid := r.URL.Query().Get("sid"); sid != "" {
+		a.writeMessageStatuses(w, sid)
+		return
+	}
+
+	window := 24 * time.Hour
+	if since := r.URL.Query().Get("since"); since != "" {
+		parsed, err := time.ParseDuration(since)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid since duration: %s", since)
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+		window = parsed
+	}
+
+	from := time.Now().UTC().Add(-window)
+	stats, err := a.statuses.DeliveryStats(from)
+	if err != nil {
+		log.Printf("Unable to summarise delivery statuses: %v", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	writeAdminReport(w, &deliveryReport{from, stats.Total, stats.ByStatus, stats.FailureRate()})
+}
+
+func (a *Admin) writeMessageStatuses(w http.ResponseWriter, sid string) {
+	updates, err := a.statuses.MessageStatuses(sid)
+	if err != nil {
+		log.Printf("Unable to load statuses of message %s: %v", sid, err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if len(updates) == 0 {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	report := &messageStatusReport{MessageSid: sid, Statuses: []statusReport{}}
+	for _, update := range updates {
+		report.Statuses = append(report.Statuses, statusReport{update.Status, update.InboundSid, update.ErrorCode, update.RecordedAt})
+	}
+	writeAdminReport(w, report)
+}
+
+// handleBlocklist manages senders blocked for abuse.
+// GET lists every suppressed address (blocked or opted out), POST blocks the address form value
+// with an optional note and DELETE unblocks the address query parameter.
+// Unblocking doesn't undo an opt-out. Only the sender can do that by opting back in.
+func (a *Admin) handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		suppressions, err := a.suppressions.Suppressions()
+		if err != nil {
+			log.Printf("Unable to list suppressed addresses: %v", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		report := []suppressionReport{}
+		for _, suppression := range suppressions {
+			report = append(report, suppressionReport{suppression.Address, suppression.Reason, suppression.Note, suppression.CreatedAt})
+		}
+		writeAdminReport(w, report)
+	case "POST":
+		address := durcov.NormalizeAddress(r.PostFormValue("address"))
+		if address == "" {
+			log.Println("Request Error: Missing `address` in blocklist request.")
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+		err := a.suppressions.Suppress(address, durcov.SuppressedBlocked, r.PostFormValue("note"))
+		if err != nil {
+			log.Printf("Unable to block %s: %v", address, err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		log.Printf("Blocked %s", address)
+		w.WriteHeader(204)
+	case "DELETE":
+		address := durcov.NormalizeAddress(r.URL.Query().Get("address"))
+		if address == "" {
+			log.Println("Request Error: Missing `address` in blocklist request.")
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+		err := a.suppressions.Unsuppress(address, durcov.SuppressedBlocked)
+		if err != nil {
+			log.Printf("Unable to unblock %s: %v", address, err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		log.Printf("Unblocked %s", address)
+		w.WriteHeader(204)
+	default:
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
+
+// handleGroups manages the groups of countries the bot totals, e.g. CASES EUROPE.
+// GET lists every group (built in and custom), POST saves the custom group in the code, name and members form values
+// (members are comma separated country codes) and DELETE removes the custom group in the code query parameter.
+// The bot answers commands for a saved group straight away. Free-form questions find it once the bot next reloads the
+// places it knows, within an hour.
+func (a *Admin) handleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		groups, err := a.view.Groups()
+		if err != nil {
+			log.Printf("Unable to list groups: %v", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		report := []groupReport{}
+		for _, group := range groups {
+			report = append(report, groupReport{group.Code, group.Name, group.Members, group.BuiltIn})
+		}
+		writeAdminReport(w, report)
+	case "POST":
+		group := &durcov.GroupInfo{
+			Code:    r.PostFormValue("code"),
+			Name:    r.PostFormValue("name"),
+			Members: strings.Split(r.PostFormValue("members"), ","),
+		}
+		err := group.Validate()
+		if err != nil {
+			log.Printf("Request Error: %v", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		err = a.groups.SaveGroup(group)
+		if err != nil {
+			log.Printf("Unable to save group %s: %v", group.Code, err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		log.Printf("Saved group %s", group.Code)
+		w.WriteHeader(204)
+	case "DELETE":
+		code := strings.ToUpper(r.URL.Query().Get("code"))
+		if code == "" {
+			log.Println("Request Error: Missing `code` in groups request.")
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+		err := a.groups.DeleteGroup(code)
+		if _, ok := err.(*durcov.NoGroupMatchedError); ok {
+			http.Error(w, http.StatusText(404), 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Unable to delete group %s: %v", code, err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		log.Printf("Deleted group %s", code)
+		w.WriteHeader(204)
+	default:
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
+
+func writeAdminReport(w http.ResponseWriter, report interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Printf("Unable to write admin report: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func newTestAdmin() (*Admin, *durcov.MemoryDeliveryStatusStore) {
+	statuses := durcov.NewMemoryDeliveryStatusStore()
+	now := time.Now().UTC()
+	statuses.RecordStatus(&durcov.StatusUpdate{MessageSid: "SM1", InboundSid: "SM0", Status: "sent", RecordedAt: now.Add(-time.Minute)})
+	statuses.RecordStatus(&durcov.StatusUpdate{MessageSid: "SM1", InboundSid: "SM0", Status: "delivered", RecordedAt: now})
+	statuses.RecordStatus(&durcov.StatusUpdate{MessageSid: "SM2", Status: "failed", ErrorCode: "30006", RecordedAt: now})
+	statuses.RecordStatus(&durcov.StatusUpdate{MessageSid: "SM3", Status: "failed", RecordedAt: now.Add(-48 * time.Hour)})
+	memoryStore := durcov.NewMemoryStore()
+	return &Admin{"admin", "secret", statuses, durcov.NewMemorySuppressionList(), memoryStore, memoryStore}, statuses
+}
+
+func TestAdminDeliveries(t *testing.T) {
+	admin, _ := newTestAdmin()
+	handler := admin.authenticate(admin.handleDeliveries)
+
+	tests := map[string]func(t *testing.T){
+		"Requires credentials": func(t *testing.T) {
+			for _, credentials := range [][2]string{{"", ""}, {"admin", "wrong"}, {"root", "secret"}} {
+				req := httptest.NewRequest("GET", "/admin/deliveries", nil)
+				if credentials[0] != "" {
+					req.SetBasicAuth(credentials[0], credentials[1])
+				}
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				if rec.Code != 401 {
+					t.Errorf("Status mismatch for %v. Expected=%d Got=%d", credentials, 401, rec.Code)
+				}
+			}
+		},
+		"Summarises recent deliveries": func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/deliveries", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			report := &deliveryReport{}
+			err := json.NewDecoder(rec.Body).Decode(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Total != 2 || report.ByStatus["delivered"] != 1 || report.ByStatus["failed"] != 1 {
+				t.Errorf("Report mismatch. Got=%+v", report)
+			}
+			if report.FailureRate != 0.5 {
+				t.Errorf("Failure rate mismatch. Expected=%f Got=%f", 0.5, report.FailureRate)
+			}
+		},
+		"Honours the since window": func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/deliveries?since=72h", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			report := &deliveryReport{}
+			json.NewDecoder(rec.Body).Decode(report)
+			if report.Total != 3 {
+				t.Errorf("Total mismatch. Expected=%d Got=%d", 3, report.Total)
+			}
+		},
+		"Rejects invalid windows": func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/deliveries?since=forever", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != 400 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 400, rec.Code)
+			}
+		},
+		"Lists transitions of a message": func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/deliveries?sid=SM1", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			report := &messageStatusReport{}
+			err := json.NewDecoder(rec.Body).Decode(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Statuses) != 2 || report.Statuses[0].Status != "sent" || report.Statuses[1].Status != "delivered" || report.Statuses[1].InboundSid != "SM0" {
+				t.Errorf("Report mismatch. Got=%+v", report)
+			}
+		},
+		"Unknown messages are not found": func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/deliveries?sid=SMmissing", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != 404 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 404, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestAdminBlocklist(t *testing.T) {
+	admin, _ := newTestAdmin()
+	handler := admin.authenticate(admin.handleBlocklist)
+	admin.suppressions.Suppress("+15005550001", durcov.SuppressedOptOut, "STOP")
+
+	request := func(method string, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := request("POST", "/admin/blocklist", url.Values{"address": {"whatsapp:+15005550006"}, "note": {"spam"}})
+	if rec.Code != 204 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 204, rec.Code)
+	}
+	blocked, _ := admin.suppressions.IsSuppressed("+15005550006", durcov.SuppressedBlocked)
+	if !blocked {
+		t.Fatal("Expected address to be blocked")
+	}
+
+	rec = request("GET", "/admin/blocklist", nil)
+	report := []suppressionReport{}
+	err := json.NewDecoder(rec.Body).Decode(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("Suppression count mismatch. Expected=%d Got=%d", 2, len(report))
+	}
+
+	rec = request("DELETE", "/admin/blocklist?address=%2B15005550006", nil)
+	if rec.Code != 204 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 204, rec.Code)
+	}
+	blocked, _ = admin.suppressions.IsSuppressed("+15005550006")
+	if blocked {
+		t.Error("Expected address to be unblocked")
+	}
+
+	rec = request("DELETE", "/admin/blocklist?address=%2B15005550001", nil)
+	optedOut, _ := admin.suppressions.IsSuppressed("+15005550001", durcov.SuppressedOptOut)
+	if !optedOut {
+		t.Error("Unblocking should not undo an opt-out")
+	}
+
+	rec = request("POST", "/admin/blocklist", url.Values{})
+	if rec.Code != 400 {
+		t.Errorf("Status mismatch. Expected=%d Got=%d", 400, rec.Code)
+	}
+}
+
+func TestAdminGroups(t *testing.T) {
+	admin, _ := newTestAdmin()
+	handler := admin.authenticate(admin.handleGroups)
+
+	request := func(method string, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	listGroups := func() map[string]groupReport {
+		rec := request("GET", "/admin/groups", nil)
+		report := []groupReport{}
+		err := json.NewDecoder(rec.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups := map[string]groupReport{}
+		for _, group := range report {
+			groups[group.Code] = group
+		}
+		return groups
+	}
+
+	rec := request("POST", "/admin/groups", url.Values{"code": {"nordics"}, "name": {"Nordic countries"}, "members": {"dk, fi,IS,no,se"}})
+	if rec.Code != 204 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 204, rec.Code)
+	}
+	groups := listGroups()
+	nordics, ok := groups["NORDICS"]
+	if !ok || nordics.BuiltIn || strings.Join(nordics.Members, " ") != "DK FI IS NO SE" {
+		t.Errorf("Group mismatch. Got=%+v", nordics)
+	}
+	if europe := groups["EUROPE"]; !europe.BuiltIn {
+		t.Errorf("Expected the built in groups to be listed. Got=%+v", europe)
+	}
+
+	for name, form := range map[string]url.Values{
+		"Built in code":       {"code": {"EUROPE"}, "name": {"Europe"}, "members": {"FR"}},
+		"Country sized code":  {"code": {"FR"}, "name": {"France"}, "members": {"FR"}},
+		"Missing members":     {"code": {"EMPTY"}, "name": {"Empty"}},
+		"Invalid member code": {"code": {"ODD"}, "name": {"Odd"}, "members": {"FRANCE"}},
+	} {
+		rec = request("POST", "/admin/groups", form)
+		if rec.Code != 400 {
+			t.Errorf("%s: Status mismatch. Expected=%d Got=%d", name, 400, rec.Code)
+		}
+	}
+
+	rec = request("DELETE", "/admin/groups?code=nordics", nil)
+	if rec.Code != 204 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 204, rec.Code)
+	}
+	if _, ok := listGroups()["NORDICS"]; ok {
+		t.Error("Expected the group to be deleted")
+	}
+	rec = request("DELETE", "/admin/groups?code=EUROPE", nil)
+	if rec.Code != 404 {
+		t.Errorf("Status mismatch. Expected=%d Got=%d", 404, rec.Code)
+	}
+}
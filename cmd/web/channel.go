@@ -0,0 +1,284 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// Channel represents a messaging platform the bot can be reached on.
+// Supporting a new platform means implementing Channel. The bot itself doesn't change.
+type Channel interface {
+	// Name identifies the channel in logs and metrics
+	Name() string
+	Capabilities() Capabilities
+	// ValidateRequest checks the webhook request was sent by the platform
+	ValidateRequest(r *http.Request) error
+	// ParseRequest reads the inbound message from a validated webhook request.
+	// Returns an *IgnoredRequestError for requests that are acknowledged without an answer.
+	ParseRequest(r *http.Request) (*InboundMessage, error)
+	// FormatReply adapts a bot reply to what the channel can display
+	FormatReply(reply string) string
+	// Reply answers an inbound message, either in the webhook response or with a separate send.
+	// A reply without text acknowledges the webhook without answering.
+	// Nothing must be written to w when an error is returned.
+	Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error
+	// Send delivers a message that isn't an answer to a webhook, e.g. digests or alerts.
+	Send(to string, text string) error
+}
+
+// DeferringChannel is implemented by channels that can acknowledge a message and answer it later.
+// Platforms that give up on slow webhooks are acknowledged once an answer takes longer than DeferAfter.
+type DeferringChannel interface {
+	Channel
+	DeferAfter() time.Duration
+	// Defer acknowledges the message in the webhook response. Nothing must be written to w when an error is returned.
+	Defer(w http.ResponseWriter, msg *InboundMessage) error
+	// FollowUp answers a deferred message
+	FollowUp(msg *InboundMessage, reply *Reply) error
+}
+
+// Capabilities represents what a channel can deliver
+type Capabilities struct {
+	Media    bool
+	Markdown bool
+	// MaxLength is the most characters a single message can hold. Zero means no limit.
+	MaxLength int
+}
+
+// InboundMessage represents a message sent to the bot on any channel
+type InboundMessage struct {
+	// ID is the platform's id for the message. Optional.
+	ID   string
+	From string
+	To   string
+	Text string
+	// Platform holds channel specific details needed to reply, e.g. a telegram callback query. Optional.
+	Platform interface{}
+}
+
+// Reply represents the answer to an inbound message
+type Reply struct {
+	Text string
+	// Compliance marks replies carriers require (e.g. opt-out confirmations).
+	// They're sent right away, even to recipients who have opted out.
+	Compliance bool
+	// FromBot marks replies answered by the bot rather than a screen
+	FromBot bool
+	// Command is the command the bot answered, with any follow-up filled in from the conversation
+	Command *durcov.BotCommand
+	// Notice is the notice the bot added at the end of the text, e.g. when the data is out of date. Optional.
+	Notice string
+}
+
+// IgnoredRequestError when a webhook request has nothing to answer, e.g. a telegram update the bot doesn't handle.
+// The request is acknowledged anyway, since platforms retry requests that fail.
+type IgnoredRequestError struct {
+	reason string
+}
+
+func (i *IgnoredRequestError) Error() string {
+	return i.reason
+}
+
+// unavailableReply answers deferred messages that couldn't be answered
+const unavailableReply = "Sorry, I don't have the results right now."
+
+// screen can answer a message before it reaches the bot, e.g. for opt-out keywords or rate limits.
+// Returns nil when the message should go on to the bot.
+type screen func(msg *InboundMessage) (*Reply, error)
+
+// ChannelHandler represents a channel's webhook, answered by the bot
+type ChannelHandler struct {
+	channel Channel
+	bot     *durcov.Bot
+	screens []screen
+	// followUps tracks deferred replies still being answered
+	followUps sync.WaitGroup
+}
+
+func newChannelHandler(channel Channel, bot *durcov.Bot, screens ...screen) *ChannelHandler {
+	return &ChannelHandler{channel: channel, bot: bot, screens: screens}
+}
+
+func (h *ChannelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	err := h.channel.ValidateRequest(r)
+	if err != nil {
+		log.Printf("%s request not authenticated: %v", h.channel.Name(), err)
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	msg, err := h.channel.ParseRequest(r)
+	if ignored, ok := err.(*IgnoredRequestError); ok {
+		log.Printf("Ignoring %s request: %v", h.channel.Name(), ignored)
+		w.WriteHeader(200)
+		return
+	}
+	if err != nil {
+		log.Printf("Malformed %s request: %v", h.channel.Name(), err)
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	deferring, ok := h.channel.(DeferringChannel)
+	if !ok {
+		reply, err := h.answer(msg)
+		h.reply(w, msg, reply, err)
+		return
+	}
+
+	answered := make(chan *answer, 1)
+	go func() {
+		reply, err := h.answer(msg)
+		answered <- &answer{reply, err}
+	}()
+	select {
+	case answer := <-answered:
+		h.reply(w, msg, answer.reply, answer.err)
+		return
+	case <-time.After(deferring.DeferAfter()):
+	}
+
+	err = deferring.Defer(w, msg)
+	if err != nil {
+		log.Printf("Unable to defer %s reply: %v", h.channel.Name(), err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	h.followUps.Add(1)
+	go func() {
+		defer h.followUps.Done()
+		answer := <-answered
+		reply := answer.reply
+		if answer.err != nil {
+			log.Printf("Unable to screen %s message: %v", h.channel.Name(), answer.err)
+			// The user is waiting on the deferred reply so they're told something went wrong
+			reply = &Reply{Text: fitReply(h.channel, unavailableReply)}
+		}
+		err := deferring.FollowUp(msg, reply)
+		if err != nil {
+			log.Printf("Unable to follow up on %s: %v", h.channel.Name(), err)
+		}
+	}()
+}
+
+// wait blocks until every deferred reply has been followed up
+func (h *ChannelHandler) wait() {
+	h.followUps.Wait()
+}
+
+type answer struct {
+	reply *Reply
+	err   error
+}
+
+// reply answers the webhook with the reply, or an error status when the message couldn't be answered.
+func (h *ChannelHandler) reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply, err error) {
+	if err != nil {
+		log.Printf("Unable to screen %s message: %v", h.channel.Name(), err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	err = h.channel.Reply(w, msg, reply)
+	if err != nil {
+		log.Printf("Unable to respond on %s: %v", h.channel.Name(), err)
+		http.Error(w, http.StatusText(500), 500)
+	}
+}
+
+// answer runs the message past the screens and then the bot. The reply is formatted for the channel.
+func (h *ChannelHandler) answer(msg *InboundMessage) (*Reply, error) {
+	var reply *Reply
+	for _, screen := range h.screens {
+		screened, err := screen(msg)
+		if err != nil {
+			return nil, err
+		}
+		if screened != nil {
+			reply = screened
+			break
+		}
+	}
+	if reply == nil {
+		// Senders are only unique within a channel
+		text, notice, command := h.bot.RespondWithNotice(h.channel.Name()+":"+msg.From, msg.Text)
+		reply = &Reply{Text: text + notice, FromBot: true, Command: command, Notice: strings.TrimSpace(notice)}
+	}
+	reply.Text = fitReply(h.channel, reply.Text)
+	return reply, nil
+}
+
+// fitReply formats the reply for the channel and cuts it down to the channel's max length.
+func fitReply(channel Channel, reply string) string {
+	if reply == "" {
+		return ""
+	}
+	reply = channel.FormatReply(reply)
+	maxLength := channel.Capabilities().MaxLength
+	if maxLength <= 0 || utf8.RuneCountInString(reply) <= maxLength {
+		return reply
+	}
+	const ellipsis = "..."
+	return string([]rune(reply)[:maxLength-len(ellipsis)]) + ellipsis
+}
+
+// throttleScreen limits how often each sender on the named channel is answered. Senders are keyed by the given function.
+// Messages are let through if the limit can't be checked.
+func throttleScreen(channel string, limiter durcov.RateLimiter, key func(msg *InboundMessage) string) screen {
+	return func(msg *InboundMessage) (*Reply, error) {
+		decision, err := limiter.Allow(key(msg))
+		if err != nil {
+			log.Printf("Unable to check rate limit: %v", err)
+			return nil, nil
+		}
+		if decision.Allowed {
+			return nil, nil
+		}
+
+		throttledMessages.WithLabelValues(channel).Inc()
+		if decision.FirstRefusal {
+			throttledSenders.WithLabelValues(channel).Inc()
+			return &Reply{Text: slowDownReply}, nil
+		}
+		return &Reply{}, nil
+	}
+}
+
+// latestStats returns the latest stats for a country, region or group code, or worldwide for TOTAL, with the name of the place they're for.
+func latestStats(view durcov.DataView, code string) (string, *durcov.StatsSnapshot, error) {
+	if code == "TOTAL" {
+		stats, err := view.LatestGlobalStats()
+		return "Global", stats, err
+	}
+	if durcov.IsRegionCode(code) {
+		info, stats, err := view.LatestRegionStats(code)
+		if err != nil {
+			return "", nil, err
+		}
+		return info.Name, stats, nil
+	}
+	info, stats, err := view.LatestCountryStats(code)
+	if _, ok := err.(*durcov.NoCountryMatchedError); ok {
+		group, groupStats, groupErr := view.LatestGroupStats(code)
+		if groupErr == nil {
+			return group.Name, groupStats, nil
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return info.Name, stats, nil
+}
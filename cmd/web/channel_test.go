@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// fakeChannel represents a channel that reads the message from the request body and records replies
+type fakeChannel struct {
+	capabilities Capabilities
+	invalid      bool
+	replyErr     error
+	replies      []*Reply
+	sent         []string
+}
+
+func (f *fakeChannel) Name() string               { return "fake" }
+func (f *fakeChannel) Capabilities() Capabilities { return f.capabilities }
+
+func (f *fakeChannel) ValidateRequest(r *http.Request) error {
+	if f.invalid {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+func (f *fakeChannel) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(body) == 0 {
+		return nil, errors.New("empty message")
+	}
+	return &InboundMessage{From: "tester", To: "bot", Text: string(body)}, nil
+}
+
+func (f *fakeChannel) FormatReply(reply string) string {
+	return strings.ToUpper(reply)
+}
+
+func (f *fakeChannel) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	if f.replyErr != nil {
+		return f.replyErr
+	}
+	f.replies = append(f.replies, reply)
+	w.WriteHeader(200)
+	return nil
+}
+
+func (f *fakeChannel) Send(to string, text string) error {
+	f.sent = append(f.sent, text)
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	exitVal := m.Run()
+	os.Exit(exitVal)
+}
+
+// newTestView returns a memory store holding the example data, used by the tests of every endpoint
+func newTestView(t *testing.T) *durcov.MemoryStore {
+	memoryStore := durcov.NewMemoryStore()
+	exampleData, err := durcov.ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Singapore's vaccination and hospital figures are only given in replies' text
+	err = exampleData.ReadVaccinationsAndTests(strings.NewReader("iso_code,location,date,total_vaccinations,people_vaccinated\nSGP,Singapore,2021-02-28,500000,320000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exampleData.ReadHospitalOccupancy(strings.NewReader("code,date,hospitalized,icu,ventilators\nSG,2021-02-28,120,15,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(exampleData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memoryStore
+}
+
+func newTestBot(t *testing.T) *durcov.Bot {
+	return durcov.NewBot(newTestView(t), 0)
+}
+
+func TestChannelHandler(t *testing.T) {
+	post := func(handler *ChannelHandler, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/fake", strings.NewReader(body)))
+		return rec
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Answers with the formatted bot reply": func(t *testing.T) {
+			channel := &fakeChannel{}
+			rec := post(newChannelHandler(channel, newTestBot(t)), "DEATHS SG")
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			if len(channel.replies) != 1 || channel.replies[0].Text != "[SG] SINGAPORE DEATHS: 1,822" {
+				t.Errorf("Reply mismatch. Got=%+v", channel.replies)
+			}
+		},
+		"Cuts replies to the max length": func(t *testing.T) {
+			channel := &fakeChannel{capabilities: Capabilities{MaxLength: 10}}
+			post(newChannelHandler(channel, newTestBot(t)), "DEATHS SG")
+			if len(channel.replies) != 1 || channel.replies[0].Text != "[SG] SI..." {
+				t.Errorf("Reply mismatch. Got=%+v", channel.replies)
+			}
+		},
+		"Screens answer before the bot": func(t *testing.T) {
+			channel := &fakeChannel{}
+			screened := func(msg *InboundMessage) (*Reply, error) {
+				if msg.Text == "HELLO" {
+					return &Reply{Text: "hi"}, nil
+				}
+				return nil, nil
+			}
+			handler := newChannelHandler(channel, newTestBot(t), screened)
+			post(handler, "HELLO")
+			post(handler, "DEATHS SG")
+			if len(channel.replies) != 2 || channel.replies[0].Text != "HI" || channel.replies[1].Text != "[SG] SINGAPORE DEATHS: 1,822" {
+				t.Errorf("Reply mismatch. Got=%+v", channel.replies)
+			}
+		},
+		"Answers follow-ups in the sender's conversation": func(t *testing.T) {
+			channel := &fakeChannel{}
+			bot := newTestBot(t)
+			bot.SetSessions(durcov.NewMemorySessionStore(time.Hour))
+			handler := newChannelHandler(channel, bot)
+			post(handler, "CASES SG")
+			post(handler, "and deaths?")
+			if len(channel.replies) != 2 || channel.replies[1].Text != "[SG] SINGAPORE DEATHS: 1,822" {
+				t.Fatalf("Reply mismatch. Got=%+v", channel.replies)
+			}
+			if command := channel.replies[1].Command; command == nil || *command != (durcov.BotCommand{Datum: durcov.Deaths, Code: "SG"}) {
+				t.Errorf("Command mismatch. Got=%+v", command)
+			}
+		},
+		"Acknowledges screened messages without a reply": func(t *testing.T) {
+			channel := &fakeChannel{}
+			silenced := func(msg *InboundMessage) (*Reply, error) { return &Reply{}, nil }
+			post(newChannelHandler(channel, newTestBot(t), silenced), "DEATHS SG")
+			if len(channel.replies) != 1 || channel.replies[0].Text != "" {
+				t.Errorf("Reply mismatch. Got=%+v", channel.replies)
+			}
+		},
+		"Rejects unauthenticated requests": func(t *testing.T) {
+			channel := &fakeChannel{invalid: true}
+			rec := post(newChannelHandler(channel, newTestBot(t)), "DEATHS SG")
+			if rec.Code != 401 || len(channel.replies) != 0 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+		},
+		"Rejects malformed requests": func(t *testing.T) {
+			rec := post(newChannelHandler(&fakeChannel{}, newTestBot(t)), "")
+			if rec.Code != 400 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 400, rec.Code)
+			}
+		},
+		"Reports failed replies": func(t *testing.T) {
+			channel := &fakeChannel{replyErr: errors.New("platform down")}
+			rec := post(newChannelHandler(channel, newTestBot(t)), "DEATHS SG")
+			if rec.Code != 500 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 500, rec.Code)
+			}
+		},
+		"Only accepts POST": func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newChannelHandler(&fakeChannel{}, newTestBot(t)).ServeHTTP(rec, httptest.NewRequest("GET", "/fake", nil))
+			if rec.Code != 405 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 405, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
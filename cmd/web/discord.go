@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	discordAPIBase = "https://discord.com/api/v8"
+	// discordDeferAfter leaves time to acknowledge an interaction within discord's 3 second limit
+	discordDeferAfter = 2 * time.Second
+	// discordTokenLifetime is how long an interaction token can be used to follow up
+	discordTokenLifetime = 15 * time.Minute
+	discordEmbedColor    = 0xC0392B
+)
+
+// Interaction types, interaction response types and flags used by the interactions endpoint
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+
+	discordPong                     = 1
+	discordChannelMessageWithSource = 4
+	discordDeferredChannelMessage   = 5
+
+	discordStringOption = 3
+	discordEphemeral    = 64
+)
+
+// discordCommands are the slash commands registered for the bot. Each maps to the bot command of the same name.
+var discordCommands = []*discordCommand{
+	{
+		Name:        "cases",
+		Description: "Active cases in a country or worldwide",
+		Options:     []*discordCommandOption{discordCodeOption},
+	},
+	{
+		Name:        "deaths",
+		Description: "Deaths in a country or worldwide",
+		Options:     []*discordCommandOption{discordCodeOption},
+	},
+}
+
+var discordCodeOption = &discordCommandOption{
+	Type:        discordStringOption,
+	Name:        "code",
+	Description: "Country code (e.g. SG), region code (e.g. US-CA) or TOTAL for worldwide",
+	Required:    true,
+}
+
+// discordDatumLabels names the datapoint each command reports, shown as an embed field
+var discordDatumLabels = map[durcov.Datum]string{
+	durcov.Active: "Active Cases",
+	durcov.Deaths: "Deaths",
+}
+
+// DiscordChannel represents a discord application answering slash commands on its interactions endpoint
+type DiscordChannel struct {
+	publicKey     ed25519.PublicKey
+	applicationID string
+	botToken      string
+	// view is used to show the requested datapoints as embeds
+	view       durcov.DataView
+	apiBase    string
+	client     *http.Client
+	deferAfter time.Duration
+}
+
+// newDiscordChannel returns a channel verifying interactions with the application's hex encoded public key
+func newDiscordChannel(publicKey string, applicationID string, botToken string, view durcov.DataView) (*DiscordChannel, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid discord public key. Expected a hex encoded ed25519 key")
+	}
+	return &DiscordChannel{
+		publicKey:     key,
+		applicationID: applicationID,
+		botToken:      botToken,
+		view:          view,
+		apiBase:       discordAPIBase,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		deferAfter:    discordDeferAfter,
+	}, nil
+}
+
+type discordInteraction struct {
+	ID            string              `json:"id"`
+	ApplicationID string              `json:"application_id"`
+	Type          int                 `json:"type"`
+	Token         string              `json:"token"`
+	ChannelID     string              `json:"channel_id"`
+	Data          *discordCommandData `json:"data"`
+	// Member is set for interactions in a server, User for interactions in a DM
+	Member *discordMember `json:"member"`
+	User   *discordUser   `json:"user"`
+}
+
+type discordCommandData struct {
+	Name    string                `json:"name"`
+	Options []*discordOptionValue `json:"options"`
+}
+
+type discordOptionValue struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type discordMember struct {
+	User *discordUser `json:"user"`
+}
+
+type discordUser struct {
+	ID string `json:"id"`
+}
+
+type discordCommand struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Options     []*discordCommandOption `json:"options,omitempty"`
+}
+
+type discordCommandOption struct {
+	Type        int    `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+type discordResponse struct {
+	Type int                 `json:"type"`
+	Data *discordMessageData `json:"data,omitempty"`
+}
+
+type discordMessageData struct {
+	Content string          `json:"content,omitempty"`
+	Embeds  []*discordEmbed `json:"embeds,omitempty"`
+	Flags   int             `json:"flags,omitempty"`
+}
+
+type discordEmbed struct {
+	Title     string               `json:"title"`
+	Color     int                  `json:"color"`
+	Fields    []*discordEmbedField `json:"fields"`
+	Timestamp string               `json:"timestamp,omitempty"`
+	Footer    *discordEmbedFooter  `json:"footer,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// discordDetails holds what's needed to answer an interaction
+type discordDetails struct {
+	ping bool
+	// token authorizes the follow up to a deferred interaction
+	token string
+}
+
+func (dc *DiscordChannel) Name() string {
+	return "discord"
+}
+
+// Capabilities describes discord messages. Replies are embeds so markdown isn't needed.
+func (dc *DiscordChannel) Capabilities() Capabilities {
+	return Capabilities{Media: true, Markdown: false, MaxLength: 2000}
+}
+
+// ValidateRequest checks the ed25519 signature discord sends with every interaction.
+func (dc *DiscordChannel) ValidateRequest(r *http.Request) error {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("Invalid discord signature")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	// The body is parsed after validation
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signed := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if !ed25519.Verify(dc.publicKey, signed, signature) {
+		return errors.New("Discord signature mismatch")
+	}
+	return nil
+}
+
+// ParseRequest reads a ping or slash command. A command's text is the bot command it maps to, e.g. "cases SG".
+func (dc *DiscordChannel) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	interaction := &discordInteraction{}
+	err := json.NewDecoder(r.Body).Decode(interaction)
+	if err != nil {
+		return nil, err
+	}
+	switch interaction.Type {
+	case discordPing:
+		return &InboundMessage{ID: interaction.ID, Platform: &discordDetails{ping: true}}, nil
+	case discordApplicationCommand:
+		if interaction.Data == nil {
+			return nil, errors.New("Discord command without data")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported discord interaction type %d", interaction.Type)
+	}
+
+	text := interaction.Data.Name
+	for _, option := range interaction.Data.Options {
+		if option.Name == "code" {
+			text = fmt.Sprintf("%s %v", text, option.Value)
+		}
+	}
+	from := ""
+	if interaction.Member != nil && interaction.Member.User != nil {
+		from = interaction.Member.User.ID
+	} else if interaction.User != nil {
+		from = interaction.User.ID
+	}
+	return &InboundMessage{
+		ID:       interaction.ID,
+		From:     from,
+		To:       interaction.ChannelID,
+		Text:     text,
+		Platform: &discordDetails{token: interaction.Token},
+	}, nil
+}
+
+func (dc *DiscordChannel) FormatReply(reply string) string {
+	return reply
+}
+
+// Reply answers the interaction in the webhook response.
+func (dc *DiscordChannel) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	response := &discordResponse{Type: discordPong}
+	if details, ok := msg.Platform.(*discordDetails); !ok || !details.ping {
+		response = &discordResponse{Type: discordChannelMessageWithSource, Data: dc.messageData(msg, reply)}
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	return err
+}
+
+// messageData renders the reply. Replies from the bot are shown as an embed with the country name, code and requested datapoint.
+func (dc *DiscordChannel) messageData(msg *InboundMessage, reply *Reply) *discordMessageData {
+	if reply.Text == "" {
+		// Discord shows an error for interactions that aren't answered. The only replies without text are for throttled users.
+		return &discordMessageData{Content: slowDownReply, Flags: discordEphemeral}
+	}
+	request := reply.Command
+	if !reply.FromBot || request == nil {
+		return &discordMessageData{Content: reply.Text}
+	}
+	label, ok := discordDatumLabels[request.Datum]
+	if !ok {
+		// Vaccination, testing and hospital figures are only given in the reply
+		return &discordMessageData{Content: reply.Text}
+	}
+	name, stats, err := latestStats(dc.view, request.Code)
+	if err != nil {
+		// The reply tells the user why there's nothing to show
+		return &discordMessageData{Content: reply.Text}
+	}
+
+	title := name
+	if request.Code != "TOTAL" {
+		title = fmt.Sprintf("%s (%s)", name, request.Code)
+	}
+	embed := &discordEmbed{
+		Title: title,
+		Color: discordEmbedColor,
+		Fields: []*discordEmbedField{
+			{Name: label, Value: durcov.FormatNumber(datumValue(stats, request.Datum)), Inline: true},
+		},
+		Timestamp: stats.CollectedAt.UTC().Format(time.RFC3339),
+		Footer:    &discordEmbedFooter{"Data collected"},
+	}
+	data := &discordMessageData{Embeds: []*discordEmbed{embed}}
+	// Keeps notices the bot adds to replies, e.g. when the data is out of date
+	data.Content = reply.Notice
+	return data
+}
+
+func (dc *DiscordChannel) DeferAfter() time.Duration {
+	return dc.deferAfter
+}
+
+// Defer acknowledges the interaction. Discord shows the bot as thinking until the follow up.
+func (dc *DiscordChannel) Defer(w http.ResponseWriter, msg *InboundMessage) error {
+	body, err := json.Marshal(&discordResponse{Type: discordDeferredChannelMessage})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	return err
+}
+
+// FollowUp replaces the deferred response with the reply. Interaction tokens are valid for 15 minutes.
+func (dc *DiscordChannel) FollowUp(msg *InboundMessage, reply *Reply) error {
+	details, ok := msg.Platform.(*discordDetails)
+	if !ok || details.token == "" {
+		return errors.New("Discord message without an interaction token")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), discordTokenLifetime)
+	defer cancel()
+	path := fmt.Sprintf("/webhooks/%s/%s/messages/@original", dc.applicationID, details.token)
+	return dc.call(ctx, "PATCH", path, dc.messageData(msg, reply), false)
+}
+
+// Send posts a message to the discord channel with the given id.
+func (dc *DiscordChannel) Send(to string, text string) error {
+	data := &discordMessageData{Content: fitReply(dc, text)}
+	return dc.call(context.Background(), "POST", "/channels/"+to+"/messages", data, true)
+}
+
+// registerCommands registers the bot's slash commands, replacing any registered before.
+// Commands registered to a guild are available right away. Global commands can take up to an hour to appear.
+func (dc *DiscordChannel) registerCommands(guildID string) error {
+	path := "/applications/" + dc.applicationID + "/commands"
+	if guildID != "" {
+		path = "/applications/" + dc.applicationID + "/guilds/" + guildID + "/commands"
+	}
+	return dc.call(context.Background(), "PUT", path, discordCommands, true)
+}
+
+// call sends a request to the discord API. Requests other than interaction follow ups are authorized with the bot token.
+func (dc *DiscordChannel) call(ctx context.Context, method string, path string, params interface{}, authorize bool) error {
+	if authorize && dc.botToken == "" {
+		return errors.New("No discord bot token configured")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, dc.apiBase+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorize {
+		req.Header.Set("Authorization", "Bot "+dc.botToken)
+	}
+
+	resp, err := dc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Discord %s %s failed with status %d: %s", method, path, resp.StatusCode, message)
+	}
+	return nil
+}
+
+// discordScreen answers pings before they reach the bot
+func discordScreen(msg *InboundMessage) (*Reply, error) {
+	if details, ok := msg.Platform.(*discordDetails); ok && details.ping {
+		return &Reply{}, nil
+	}
+	return nil, nil
+}
+
+// handler returns the channel's interactions endpoint
+func (dc *DiscordChannel) handler(bot *durcov.Bot, limiter durcov.RateLimiter) *ChannelHandler {
+	screens := []screen{discordScreen}
+	if limiter != nil {
+		screens = append(screens, throttleScreen(dc.Name(), limiter, func(msg *InboundMessage) string {
+			return "discord:" + msg.From
+		}))
+	}
+	return newChannelHandler(dc, bot, screens...)
+}
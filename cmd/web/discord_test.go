@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// fakeDiscordAPI represents the discord API. It records every request.
+type fakeDiscordAPI struct {
+	mu       sync.Mutex
+	requests []*discordAPIRequest
+}
+
+type discordAPIRequest struct {
+	method        string
+	path          string
+	authorization string
+	body          string
+}
+
+func (f *fakeDiscordAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, &discordAPIRequest{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{}`))
+}
+
+func (f *fakeDiscordAPI) received() []*discordAPIRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*discordAPIRequest{}, f.requests...)
+}
+
+// slowView holds country lookups until released so replies can be deferred
+type slowView struct {
+	durcov.DataView
+	release chan struct{}
+}
+
+func (s *slowView) LatestCountryView(countryCode string, datapoint durcov.Datum) (string, int64, error) {
+	<-s.release
+	return s.DataView.LatestCountryView(countryCode, datapoint)
+}
+
+func newTestDiscord(t *testing.T, view durcov.DataView) (*DiscordChannel, ed25519.PrivateKey, *fakeDiscordAPI) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeDiscordAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	discord, err := newDiscordChannel(hex.EncodeToString(publicKey), "app1", "bot-token", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discord.apiBase = server.URL
+	return discord, privateKey, api
+}
+
+func newSignedDiscordRequest(key ed25519.PrivateKey, body string) *http.Request {
+	timestamp := "1600000000"
+	req := httptest.NewRequest("POST", "/discord", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body))))
+	return req
+}
+
+func commandInteraction(name string, code string) string {
+	return `{"id": "i1", "type": 2, "token": "tok1", "channel_id": "c1", "member": {"user": {"id": "u1"}}, "data": {"name": "` + name + `", "options": [{"name": "code", "type": 3, "value": "` + code + `"}]}}`
+}
+
+func decodeDiscordResponse(t *testing.T, rec *httptest.ResponseRecorder) *discordResponse {
+	t.Helper()
+	response := &discordResponse{}
+	err := json.NewDecoder(rec.Body).Decode(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestDiscordInteractions(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers pings": func(t *testing.T) {
+			view := newTestView(t)
+			discord, key, _ := newTestDiscord(t, view)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, 0), nil).ServeHTTP(rec, newSignedDiscordRequest(key, `{"id": "i0", "type": 1}`))
+			if response := decodeDiscordResponse(t, rec); response.Type != discordPong || response.Data != nil {
+				t.Errorf("Response mismatch. Got=%+v", response)
+			}
+		},
+		"Answers commands with an embed": func(t *testing.T) {
+			view := newTestView(t)
+			discord, key, _ := newTestDiscord(t, view)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, 0), nil).ServeHTTP(rec, newSignedDiscordRequest(key, commandInteraction("deaths", "sg")))
+
+			response := decodeDiscordResponse(t, rec)
+			if response.Type != discordChannelMessageWithSource || response.Data == nil || len(response.Data.Embeds) != 1 {
+				t.Fatalf("Response mismatch. Got=%+v", response)
+			}
+			embed := response.Data.Embeds[0]
+			if embed.Title != "Singapore (SG)" {
+				t.Errorf("Title mismatch. Expected=%s Got=%s", "Singapore (SG)", embed.Title)
+			}
+			if len(embed.Fields) != 1 || embed.Fields[0].Name != "Deaths" || embed.Fields[0].Value != "1,822" {
+				t.Errorf("Fields mismatch. Got=%+v", embed.Fields)
+			}
+		},
+		"Keeps the stale notice with the embed": func(t *testing.T) {
+			view := newTestView(t)
+			discord, key, _ := newTestDiscord(t, view)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, time.Hour), nil).ServeHTTP(rec, newSignedDiscordRequest(key, commandInteraction("deaths", "sg")))
+
+			response := decodeDiscordResponse(t, rec)
+			expected := "(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)"
+			if response.Data == nil || len(response.Data.Embeds) != 1 || response.Data.Content != expected {
+				t.Errorf("Response mismatch. Expected=%s Got=%+v", expected, response.Data)
+			}
+		},
+		"Answers vaccination figures as text": func(t *testing.T) {
+			view := newTestView(t)
+			discord, key, _ := newTestDiscord(t, view)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, 0), nil).ServeHTTP(rec, newSignedDiscordRequest(key, commandInteraction("doses", "SG")))
+
+			response := decodeDiscordResponse(t, rec)
+			if response.Data == nil || response.Data.Content != "[SG] Singapore Vaccine Doses: 500,000 (as of 28 Feb 2021)" || len(response.Data.Embeds) != 0 {
+				t.Errorf("Response mismatch. Got=%+v", response.Data)
+			}
+		},
+		"Answers errors as text": func(t *testing.T) {
+			view := newTestView(t)
+			discord, key, _ := newTestDiscord(t, view)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, 0), nil).ServeHTTP(rec, newSignedDiscordRequest(key, commandInteraction("cases", "ZZ")))
+
+			response := decodeDiscordResponse(t, rec)
+			if response.Data == nil || response.Data.Content != "Sorry, that code doesn't match any countries I know." || len(response.Data.Embeds) != 0 {
+				t.Errorf("Response mismatch. Got=%+v", response.Data)
+			}
+		},
+		"Defers slow answers and follows up": func(t *testing.T) {
+			view := &slowView{newTestView(t), make(chan struct{})}
+			discord, key, api := newTestDiscord(t, view)
+			discord.deferAfter = 10 * time.Millisecond
+
+			rec := httptest.NewRecorder()
+			handler := discord.handler(durcov.NewBot(view, 0), nil)
+			handler.ServeHTTP(rec, newSignedDiscordRequest(key, commandInteraction("deaths", "SG")))
+			if response := decodeDiscordResponse(t, rec); response.Type != discordDeferredChannelMessage {
+				t.Fatalf("Response type mismatch. Expected=%d Got=%d", discordDeferredChannelMessage, response.Type)
+			}
+
+			close(view.release)
+			handler.wait()
+			received := api.received()
+			if len(received) != 1 {
+				t.Fatalf("Follow up count mismatch. Expected=%d Got=%d", 1, len(received))
+			}
+			if received[0].method != "PATCH" || received[0].path != "/webhooks/app1/tok1/messages/@original" || received[0].authorization != "" {
+				t.Errorf("Follow up mismatch. Got=%+v", received[0])
+			}
+			if !strings.Contains(received[0].body, `"title":"Singapore (SG)"`) {
+				t.Errorf("Follow up body mismatch. Got=%s", received[0].body)
+			}
+		},
+		"Rejects forged signatures": func(t *testing.T) {
+			view := newTestView(t)
+			discord, _, _ := newTestDiscord(t, view)
+			_, forger, _ := ed25519.GenerateKey(rand.Reader)
+			rec := httptest.NewRecorder()
+			discord.handler(durcov.NewBot(view, 0), nil).ServeHTTP(rec, newSignedDiscordRequest(forger, commandInteraction("deaths", "SG")))
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestDiscordRegisterCommands(t *testing.T) {
+	discord, _, api := newTestDiscord(t, newTestView(t))
+	err := discord.registerCommands("guild1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := api.received()
+	if len(received) != 1 {
+		t.Fatalf("Request count mismatch. Expected=%d Got=%d", 1, len(received))
+	}
+	if received[0].method != "PUT" || received[0].path != "/applications/app1/guilds/guild1/commands" || received[0].authorization != "Bot bot-token" {
+		t.Errorf("Request mismatch. Got=%+v", received[0])
+	}
+	commands := []*discordCommand{}
+	json.Unmarshal([]byte(received[0].body), &commands)
+	if len(commands) != 2 || commands[0].Name != "cases" || commands[1].Name != "deaths" || !commands[0].Options[0].Required {
+		t.Errorf("Commands mismatch. Got=%s", received[0].body)
+	}
+}
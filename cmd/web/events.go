@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// keepAliveInterval is kept below the 55 second idle timeout of the Heroku router
+const keepAliveInterval = 30 * time.Second
+
+// EventFeed represents a live feed of per-country change events for connected clients
+type EventFeed struct {
+	view    durcov.DataView
+	retry   durcov.ListenRetry
+	mu      sync.Mutex
+	latest  map[string]*durcov.StatsSnapshot
+	clients map[*eventClient]struct{}
+	closed  bool
+}
+
+type eventClient struct {
+	codes  map[string]bool
+	events chan *changeEvent
+}
+
+type changeEvent struct {
+	Code        string          `json:"code"`
+	Name        string          `json:"name"`
+	CollectedAt time.Time       `json:"collectedAt"`
+	Statistics  eventStatistics `json:"statistics"`
+	Changes     eventStatistics `json:"changes"`
+}
+
+type eventStatistics struct {
+	Confirmed int64 `json:"confirmed"`
+	Deaths    int64 `json:"deaths"`
+	Recovered int64 `json:"recovered"`
+	Active    int64 `json:"active"`
+}
+
+func newEventFeed(view durcov.DataView) *EventFeed {
+	return &EventFeed{
+		view:    view,
+		retry:   durcov.DefaultListenRetry,
+		latest:  map[string]*durcov.StatsSnapshot{},
+		clients: map[*eventClient]struct{}{},
+	}
+}
+
+// run publishes change events for every update received from the listener until ctx is done, listening again when
+// the listener stops. Changes missed while it wasn't listening are published once it's listening again.
+// Returns an error once it can't listen again.
+func (f *EventFeed) run(ctx context.Context, listener durcov.UpdateListener) error {
+	loaded := false
+	return durcov.ListenWithRetry(ctx, listener, f.retry, func() {
+		changes, err := f.collectChanges()
+		if err != nil {
+			log.Printf("Unable to collect changes: %v", err)
+			return
+		}
+		// The first data loaded is only what later changes are compared with
+		if !loaded {
+			loaded = true
+			return
+		}
+		f.broadcast(changes)
+	})
+}
+
+// collectChanges compares the latest data in the view with the data seen on the previous call.
+func (f *EventFeed) collectChanges() ([]*changeEvent, error) {
+	current := map[string]*durcov.StatsSnapshot{}
+	names := map[string]string{}
+
+	globalStats, err := f.view.LatestGlobalStats()
+	if err != nil {
+		return nil, err
+	}
+	current["GLOBAL"] = globalStats
+	names["GLOBAL"] = "Global"
+
+	countries, err := f.view.Countries()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range countries {
+		_, stats, err := f.view.LatestCountryStats(info.Code)
+		if err != nil {
+			return nil, err
+		}
+		current[info.Code] = stats
+		names[info.Code] = info.Name
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changes := []*changeEvent{}
+	for code, stats := range current {
+		previous, ok := f.latest[code]
+		if !ok {
+			previous = &durcov.StatsSnapshot{}
+		}
+		if previous.Confirmed == stats.Confirmed && previous.Deaths == stats.Deaths && previous.Recovered == stats.Recovered {
+			continue
+		}
+		change := &changeEvent{
+			Code:        code,
+			Name:        names[code],
+			CollectedAt: stats.CollectedAt,
+			Statistics:  eventStatistics{stats.Confirmed, stats.Deaths, stats.Recovered, stats.Active()},
+			Changes: eventStatistics{
+				stats.Confirmed - previous.Confirmed,
+				stats.Deaths - previous.Deaths,
+				stats.Recovered - previous.Recovered,
+				stats.Active() - previous.Active(),
+			},
+		}
+		changes = append(changes, change)
+	}
+	f.latest = current
+	return changes, nil
+}
+
+func (f *EventFeed) broadcast(changes []*changeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for client := range f.clients {
+		for _, change := range changes {
+			if len(client.codes) > 0 && !client.codes[change.Code] {
+				continue
+			}
+			if !client.send(change) {
+				log.Println("Dropping slow event feed client")
+				delete(f.clients, client)
+				close(client.events)
+				break
+			}
+		}
+	}
+}
+
+// send queues the change without blocking. Returns false if the client's queue is full.
+func (c *eventClient) send(change *changeEvent) bool {
+	select {
+	case c.events <- change:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribe registers a new client. Returns false once the feed is closed.
+func (f *EventFeed) subscribe(codes map[string]bool) (*eventClient, bool) {
+	client := &eventClient{codes, make(chan *changeEvent, 256)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, false
+	}
+	f.clients[client] = struct{}{}
+	return client, true
+}
+
+func (f *EventFeed) unsubscribe(client *eventClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.clients[client]; ok {
+		delete(f.clients, client)
+		close(client.events)
+	}
+}
+
+// close ends every connected client's stream and refuses new clients.
+func (f *EventFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for client := range f.clients {
+		delete(f.clients, client)
+		close(client.events)
+	}
+}
+
+func (f *EventFeed) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("Streaming unsupported by response writer")
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	codes := map[string]bool{}
+	if countries := r.URL.Query().Get("countries"); countries != "" {
+		for _, code := range strings.Split(countries, ",") {
+			codes[strings.ToUpper(strings.TrimSpace(code))] = true
+		}
+	}
+
+	client, ok := f.subscribe(codes)
+	if !ok {
+		log.Println("Event feed closed")
+		http.Error(w, http.StatusText(503), 503)
+		return
+	}
+	defer f.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		case change, ok := <-client.events:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(change)
+			if err != nil {
+				log.Printf("Unable to encode change event: %v", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: change\ndata: %s\n\n", payload)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+var updatedJSON = []byte(`{
+	"Global":{
+	   "TotalConfirmed":10000100,
+	   "TotalDeaths":500010,
+	   "TotalRecovered":500000
+	},
+	"Countries":[
+	   {
+		  "Country":"Afghanistan",
+		  "CountryCode":"AF",
+		  "Slug":"afghanistan",
+		  "TotalConfirmed":47080,
+		  "TotalDeaths":1832,
+		  "TotalRecovered":37026,
+		  "Date":"2020-12-05T03:49:29Z"
+	   },
+	   {
+		  "Country":"Singapore",
+		  "CountryCode":"SG",
+		  "Slug":"singapore",
+		  "TotalConfirmed":46980,
+		  "TotalDeaths":1822,
+		  "TotalRecovered":37026,
+		  "Date":"2020-12-05T03:49:29Z"
+	   }
+	],
+	"Date":"2020-12-05T03:49:29Z"
+ }`)
+
+// droppedListener drops its first connection once dropped is closed, then listens on the store
+type droppedListener struct {
+	store   *durcov.MemoryStore
+	dropped chan time.Time
+	mu      sync.Mutex
+	calls   int
+}
+
+func (l *droppedListener) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	l.mu.Lock()
+	l.calls++
+	first := l.calls == 1
+	l.mu.Unlock()
+	if first {
+		return l.dropped, nil
+	}
+	return l.store.ListenForUpdates(ctx)
+}
+
+// failingListener can never listen
+type failingListener struct{}
+
+func (l *failingListener) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestEventFeed(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Publishes changes": func(t *testing.T) {
+			memoryStore := newTestView(t)
+			testEventFeed(t, memoryStore, memoryStore, nil)
+		},
+		"Publishes changes after the listener drops": func(t *testing.T) {
+			memoryStore := newTestView(t)
+			listener := &droppedListener{store: memoryStore, dropped: make(chan time.Time)}
+			testEventFeed(t, memoryStore, listener, func() { close(listener.dropped) })
+		},
+		"Stops once it can't listen": func(t *testing.T) {
+			eventFeed := newEventFeed(newTestView(t))
+			eventFeed.retry = durcov.ListenRetry{MinDelay: time.Millisecond, MaxDelay: time.Millisecond, Attempts: 3}
+			err := eventFeed.run(context.Background(), &failingListener{})
+			if err == nil {
+				t.Error("Expected error once the feed can't listen")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+// testEventFeed checks a client receives the change once the updated data is stored. drop is called, when set,
+// before the data is stored.
+func testEventFeed(t *testing.T, memoryStore *durcov.MemoryStore, listener durcov.UpdateListener, drop func()) {
+	eventFeed := newEventFeed(memoryStore)
+	eventFeed.retry = durcov.ListenRetry{MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Attempts: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go eventFeed.run(ctx, listener)
+
+	server := httptest.NewServer(http.HandlerFunc(eventFeed.handleEvents))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?countries=af,sg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type mismatch. Expected=%s Got=%s", "text/event-stream", res.Header.Get("Content-Type"))
+	}
+
+	// Wait for the feed to be listening before storing new data
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		eventFeed.mu.Lock()
+		ready := len(eventFeed.latest) > 0 && len(eventFeed.clients) > 0
+		eventFeed.mu.Unlock()
+		if ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Event feed not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if drop != nil {
+		drop()
+	}
+
+	var updatedData durcov.Data
+	err = json.Unmarshal(updatedJSON, &updatedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(&updatedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(res.Body)
+	var event string
+	var data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	if event != "change" {
+		t.Errorf("Event mismatch. Expected=%s Got=%s", "change", event)
+	}
+	var change changeEvent
+	err = json.Unmarshal([]byte(data), &change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Code != "AF" {
+		t.Fatalf("Only AF changed among subscribed countries. Got=%s", change.Code)
+	}
+	expectedChanges := eventStatistics{Confirmed: 100, Deaths: 10, Recovered: 0, Active: 90}
+	if change.Changes != expectedChanges {
+		t.Errorf("Changes mismatch. Expected=%+v Got=%+v", expectedChanges, change.Changes)
+	}
+	if change.Statistics.Confirmed != 47080 {
+		t.Errorf("Confirmed mismatch. Expected=%d Got=%d", 47080, change.Statistics.Confirmed)
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// GraphQLServer represents a graphql endpoint backed by a data view
+type GraphQLServer struct {
+	schema graphql.Schema
+	view   durcov.DataView
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// globalSource stands in for the global "country" while resolving graphql fields
+type globalSource struct{}
+
+type statisticsEdge struct {
+	Cursor string
+	Node   *durcov.StatsSnapshot
+}
+
+type pageInfo struct {
+	HasNextPage bool
+	EndCursor   string
+}
+
+type statisticsConnection struct {
+	Edges      []*statisticsEdge
+	PageInfo   *pageInfo
+	TotalCount int
+}
+
+const cursorPrefix = "snapshot:"
+
+// newGraphQLServer builds the graphql schema and returns a server resolving it through the given view.
+func newGraphQLServer(view durcov.DataView) (*GraphQLServer, error) {
+	gs := &GraphQLServer{view: view}
+	schema, err := gs.buildSchema()
+	if err != nil {
+		return nil, err
+	}
+	gs.schema = schema
+	return gs, nil
+}
+
+func (gs *GraphQLServer) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if err != nil {
+				log.Printf("Malformed graphql variables: %v", err)
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+		}
+	case "POST":
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Printf("Malformed graphql request: %v", err)
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+	default:
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		log.Println("Malformed graphql request: missing query")
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	result := gs.execute(&req)
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(result)
+	if err != nil {
+		log.Printf("Unable to write graphql response: %v", err)
+	}
+}
+
+func (gs *GraphQLServer) execute(req *graphQLRequest) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Schema:         gs.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+	})
+}
+
+func (gs *GraphQLServer) buildSchema() (graphql.Schema, error) {
+	statisticsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Statistics",
+		Fields: graphql.Fields{
+			"confirmed": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.StatsSnapshot).Confirmed, nil
+				},
+			},
+			"deaths": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.StatsSnapshot).Deaths, nil
+				},
+			},
+			"recovered": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.StatsSnapshot).Recovered, nil
+				},
+			},
+			"active": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.StatsSnapshot).Active(), nil
+				},
+			},
+			"collectedAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.StatsSnapshot).CollectedAt, nil
+				},
+			},
+		},
+	})
+
+	// Doses and tests are floats since they outgrow graphql's 32 bit Int
+	vaccinationsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Vaccinations",
+		Fields: graphql.Fields{
+			"doses": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Float),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.VaccinationSnapshot).Doses, nil
+				},
+			},
+			"peopleVaccinated": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Float),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.VaccinationSnapshot).PeopleVaccinated, nil
+				},
+			},
+			"collectedAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.VaccinationSnapshot).CollectedAt, nil
+				},
+			},
+		},
+	})
+
+	testingType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Testing",
+		Fields: graphql.Fields{
+			"tests": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Float),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.TestingSnapshot).Tests, nil
+				},
+			},
+			"positiveRate": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Float),
+				Description: "The share of recent tests that were positive, from 0 to 1.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.TestingSnapshot).PositiveRate, nil
+				},
+			},
+			"collectedAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.TestingSnapshot).CollectedAt, nil
+				},
+			},
+		},
+	})
+
+	occupancyType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Occupancy",
+		Fields: graphql.Fields{
+			"patients": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.OccupancyFigure).Patients, nil
+				},
+			},
+			"collectedAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.OccupancyFigure).CollectedAt, nil
+				},
+			},
+		},
+	})
+
+	// Each figure is null when the country doesn't publish it rather than zero
+	occupancyField := func(datum durcov.Datum, description string) *graphql.Field {
+		return &graphql.Field{
+			Type:        occupancyType,
+			Description: description,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				figure := p.Source.(*durcov.HospitalSnapshot).Figure(datum)
+				if figure == nil {
+					return nil, nil
+				}
+				return figure, nil
+			},
+		}
+	}
+	hospitalsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Hospitals",
+		Fields: graphql.Fields{
+			"hospitalized": occupancyField(durcov.Hospitalized, "Covid patients in hospital. Null when not published."),
+			"icu":          occupancyField(durcov.ICU, "Covid patients in intensive care. Null when not published."),
+			"ventilators":  occupancyField(durcov.Ventilators, "Covid patients on a ventilator. Null when not published."),
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "StatisticsEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*statisticsEdge).Cursor, nil
+				},
+			},
+			"node": &graphql.Field{
+				Type: graphql.NewNonNull(statisticsType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*statisticsEdge).Node, nil
+				},
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*pageInfo).HasNextPage, nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cursor := p.Source.(*pageInfo).EndCursor
+					if cursor == "" {
+						return nil, nil
+					}
+					return cursor, nil
+				},
+			},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "StatisticsConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*statisticsConnection).Edges, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*statisticsConnection).PageInfo, nil
+				},
+			},
+			"totalCount": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*statisticsConnection).TotalCount, nil
+				},
+			},
+		},
+	})
+
+	historyArgs := graphql.FieldConfigArgument{
+		"from":  &graphql.ArgumentConfig{Type: graphql.DateTime},
+		"to":    &graphql.ArgumentConfig{Type: graphql.DateTime},
+		"first": &graphql.ArgumentConfig{Type: graphql.Int},
+		"after": &graphql.ArgumentConfig{Type: graphql.String},
+	}
+
+	countryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Country",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.CountryInfo).Name, nil
+				},
+			},
+			"slug": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.CountryInfo).Slug, nil
+				},
+			},
+			"code": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.CountryInfo).Code, nil
+				},
+			},
+			"statistics": &graphql.Field{
+				Type: statisticsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, stats, err := gs.view.LatestCountryStats(p.Source.(*durcov.CountryInfo).Code)
+					return stats, err
+				},
+			},
+			"history": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: historyArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					code := p.Source.(*durcov.CountryInfo).Code
+					return gs.resolveHistory(p.Args, func(from time.Time, to time.Time) ([]*durcov.StatsSnapshot, error) {
+						return gs.view.CountryHistory(code, from, to)
+					})
+				},
+			},
+			"vaccinations": &graphql.Field{
+				Type:        vaccinationsType,
+				Description: "The latest vaccination figures. Null when none are published for the country.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, figures, err := gs.view.LatestCountryVaccinations(p.Source.(*durcov.CountryInfo).Code)
+					if _, ok := err.(*durcov.NoSnapshotError); ok {
+						return nil, nil
+					}
+					return figures, err
+				},
+			},
+			"testing": &graphql.Field{
+				Type:        testingType,
+				Description: "The latest testing figures. Null when none are published for the country.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, figures, err := gs.view.LatestCountryTests(p.Source.(*durcov.CountryInfo).Code)
+					if _, ok := err.(*durcov.NoSnapshotError); ok {
+						return nil, nil
+					}
+					return figures, err
+				},
+			},
+			"hospitals": &graphql.Field{
+				Type:        graphql.NewNonNull(hospitalsType),
+				Description: "The latest hospital occupancy figures the country publishes.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, figures, err := gs.view.LatestHospitalOccupancy(p.Source.(*durcov.CountryInfo).Code)
+					return figures, err
+				},
+			},
+		},
+	})
+
+	groupType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Group",
+		Fields: graphql.Fields{
+			"code": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.GroupInfo).Code, nil
+				},
+			},
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.GroupInfo).Name, nil
+				},
+			},
+			"members": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.GroupInfo).Members, nil
+				},
+			},
+			"builtIn": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*durcov.GroupInfo).BuiltIn, nil
+				},
+			},
+			"statistics": &graphql.Field{
+				Type:        statisticsType,
+				Description: "The members' latest statistics added up. Null when none of the members have statistics.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, stats, err := gs.view.LatestGroupStats(p.Source.(*durcov.GroupInfo).Code)
+					if _, ok := err.(*durcov.NoSnapshotError); ok {
+						return nil, nil
+					}
+					return stats, err
+				},
+			},
+		},
+	})
+
+	globalType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Global",
+		Fields: graphql.Fields{
+			"statistics": &graphql.Field{
+				Type: statisticsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return gs.view.LatestGlobalStats()
+				},
+			},
+			"history": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: historyArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return gs.resolveHistory(p.Args, gs.view.GlobalHistory)
+				},
+			},
+			"vaccinations": &graphql.Field{
+				Type:        vaccinationsType,
+				Description: "The world's latest vaccination figures. Null when none are published.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					figures, err := gs.view.LatestGlobalVaccinations()
+					if _, ok := err.(*durcov.NoSnapshotError); ok {
+						return nil, nil
+					}
+					return figures, err
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"global": &graphql.Field{
+				Type: graphql.NewNonNull(globalType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return &globalSource{}, nil
+				},
+			},
+			"countries": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(countryType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return gs.view.Countries()
+				},
+			},
+			"groups": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(groupType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return gs.view.Groups()
+				},
+			},
+			"group": &graphql.Field{
+				Type: groupType,
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					group, err := gs.findGroup(strings.ToUpper(p.Args["code"].(string)))
+					if group == nil {
+						return nil, err
+					}
+					return group, nil
+				},
+			},
+			"country": &graphql.Field{
+				Type: countryType,
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					code := strings.ToUpper(p.Args["code"].(string))
+					info, _, err := gs.view.LatestCountryStats(code)
+					if _, ok := err.(*durcov.NoCountryMatchedError); ok {
+						return nil, nil
+					}
+					return info, err
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+// findGroup returns the group with the code from every group, or nil when there isn't one
+func (gs *GraphQLServer) findGroup(code string) (*durcov.GroupInfo, error) {
+	groups, err := gs.view.Groups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.Code == code {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+func (gs *GraphQLServer) resolveHistory(args map[string]interface{}, history func(from time.Time, to time.Time) ([]*durcov.StatsSnapshot, error)) (*statisticsConnection, error) {
+	from := time.Time{}
+	if arg, ok := args["from"].(time.Time); ok {
+		from = arg
+	}
+	to := time.Now()
+	if arg, ok := args["to"].(time.Time); ok {
+		to = arg
+	}
+	if to.Before(from) {
+		return nil, errors.New("history `to` must not be before `from`")
+	}
+
+	snapshots, err := history(from, to)
+	if err != nil {
+		return nil, err
+	}
+	totalCount := len(snapshots)
+
+	if after, ok := args["after"].(string); ok {
+		afterTime, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		remaining := []*durcov.StatsSnapshot{}
+		for _, snapshot := range snapshots {
+			if snapshot.CollectedAt.After(afterTime) {
+				remaining = append(remaining, snapshot)
+			}
+		}
+		snapshots = remaining
+	}
+
+	hasNextPage := false
+	if first, ok := args["first"].(int); ok {
+		if first < 0 {
+			return nil, errors.New("history `first` must not be negative")
+		}
+		if first < len(snapshots) {
+			snapshots = snapshots[:first]
+			hasNextPage = true
+		}
+	}
+
+	connection := &statisticsConnection{
+		Edges:      []*statisticsEdge{},
+		PageInfo:   &pageInfo{HasNextPage: hasNextPage},
+		TotalCount: totalCount,
+	}
+	for _, snapshot := range snapshots {
+		edge := &statisticsEdge{encodeCursor(snapshot.CollectedAt), snapshot}
+		connection.Edges = append(connection.Edges, edge)
+		connection.PageInfo.EndCursor = edge.Cursor
+	}
+	return connection, nil
+}
+
+func encodeCursor(collectedAt time.Time) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + collectedAt.UTC().Format(time.RFC3339Nano)))
+}
+
+func decodeCursor(cursor string) (time.Time, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return time.Time{}, errors.New("Invalid history cursor")
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimPrefix(string(raw), cursorPrefix))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func TestGraphQL(t *testing.T) {
+	graphQLServer, err := newGraphQLServer(newTestView(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			`{ global { statistics { deaths active } } }`,
+			`{"data":{"global":{"statistics":{"active":9000000,"deaths":500000}}}}`,
+		},
+		{
+			`{ countries { code name } }`,
+			`{"data":{"countries":[{"code":"AF","name":"Afghanistan"},{"code":"SG","name":"Singapore"}]}}`,
+		},
+		{
+			`{ country(code: "sg") { name slug statistics { deaths collectedAt } } }`,
+			`{"data":{"country":{"name":"Singapore","slug":"singapore","statistics":{"collectedAt":"2020-12-04T03:49:29Z","deaths":1822}}}}`,
+		},
+		{
+			`{ country(code: "IN") { name } }`,
+			`{"data":{"country":null}}`,
+		},
+		{
+			`{ country(code: "AF") { history(from: "2020-12-01T00:00:00Z", to: "2020-12-05T00:00:00Z") { totalCount edges { node { active } } pageInfo { hasNextPage } } } }`,
+			`{"data":{"country":{"history":{"edges":[{"node":{"active":8132}}],"pageInfo":{"hasNextPage":false},"totalCount":1}}}}`,
+		},
+		{
+			`{ global { history(to: "2020-12-01T00:00:00Z") { totalCount } } }`,
+			`{"data":{"global":{"history":{"totalCount":0}}}}`,
+		},
+		{
+			`{ group(code: "asean") { name builtIn statistics { deaths } } }`,
+			`{"data":{"group":{"builtIn":true,"name":"ASEAN","statistics":{"deaths":1822}}}}`,
+		},
+		{
+			`{ group(code: "OCEANIA") { name statistics { deaths } } }`,
+			`{"data":{"group":{"name":"Oceania","statistics":null}}}`,
+		},
+		{
+			`{ group(code: "NARNIA") { name } }`,
+			`{"data":{"group":null}}`,
+		},
+		{
+			`{ country(code: "SG") { vaccinations { doses } testing { tests } } global { vaccinations { doses } } }`,
+			`{"data":{"country":{"testing":null,"vaccinations":{"doses":500000}},"global":{"vaccinations":null}}}`,
+		},
+		{
+			`{ country(code: "SG") { hospitals { hospitalized { patients collectedAt } icu { patients } ventilators { patients } } } }`,
+			`{"data":{"country":{"hospitals":{"hospitalized":{"collectedAt":"2021-02-28T00:00:00Z","patients":120},"icu":{"patients":15},"ventilators":null}}}}`,
+		},
+		{
+			`{ country(code: "AF") { hospitals { hospitalized { patients } } } }`,
+			`{"data":{"country":{"hospitals":{"hospitalized":null}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		body, err := json.Marshal(&graphQLRequest{Query: test.query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+		rec := httptest.NewRecorder()
+		graphQLServer.handleGraphQL(rec, req)
+
+		if rec.Code != 200 {
+			t.Fatalf("Status mismatch. Query: %s Expected=%d Got=%d", test.query, 200, rec.Code)
+		}
+		got := strings.TrimSpace(rec.Body.String())
+		if got != test.expected {
+			t.Errorf("Response mismatch. Query: %s\nExpected=%s\nGot=%s", test.query, test.expected, got)
+		}
+	}
+}
+
+func TestGraphQLHistoryPagination(t *testing.T) {
+	memoryStore := durcov.NewMemoryStore()
+	firstDay, err := time.Parse(time.RFC3339, "2020-12-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day := 0; day < 3; day++ {
+		err = memoryStore.StoreData(durcov.ExampleTestDataAt(firstDay.AddDate(0, 0, day)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graphQLServer, err := newGraphQLServer(memoryStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `query History($after: String) { country(code: "SG") { history(first: 2, after: $after) { totalCount edges { node { collectedAt } } pageInfo { hasNextPage endCursor } } } }`
+
+	var page struct {
+		Data struct {
+			Country struct {
+				History struct {
+					TotalCount int
+					Edges      []struct {
+						Node struct {
+							CollectedAt time.Time
+						}
+					}
+					PageInfo struct {
+						HasNextPage bool
+						EndCursor   string
+					}
+				}
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(query), nil)
+	rec := httptest.NewRecorder()
+	graphQLServer.handleGraphQL(rec, req)
+	err = json.Unmarshal(rec.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := page.Data.Country.History
+	if history.TotalCount != 3 || len(history.Edges) != 2 || !history.PageInfo.HasNextPage {
+		t.Fatalf("First page mismatch. Got=%s", rec.Body.String())
+	}
+
+	variables := `{"after":"` + history.PageInfo.EndCursor + `"}`
+	req = httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(query)+"&variables="+url.QueryEscape(variables), nil)
+	rec = httptest.NewRecorder()
+	graphQLServer.handleGraphQL(rec, req)
+	err = json.Unmarshal(rec.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history = page.Data.Country.History
+	if len(history.Edges) != 1 || history.PageInfo.HasNextPage {
+		t.Fatalf("Second page mismatch. Got=%s", rec.Body.String())
+	}
+	if !history.Edges[0].Node.CollectedAt.Equal(firstDay.AddDate(0, 0, 2)) {
+		t.Errorf("Second page node mismatch. Expected=%v Got=%v", firstDay.AddDate(0, 0, 2), history.Edges[0].Node.CollectedAt)
+	}
+}
+
+func TestGraphQLMethodNotAllowed(t *testing.T) {
+	graphQLServer, err := newGraphQLServer(durcov.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("DELETE", "/graphql", nil)
+	rec := httptest.NewRecorder()
+	graphQLServer.handleGraphQL(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status mismatch. Expected=%d Got=%d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// HealthChecker represents liveness and readiness checks for the web server
+type HealthChecker struct {
+	ping func() error
+	view durcov.DataView
+	// staleAfter is how old data can get before the server isn't ready. Zero disables the check, as it does the bot's notice.
+	staleAfter time.Duration
+}
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (hc *HealthChecker) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, 200, &healthReport{Status: "ok"})
+}
+
+func (hc *HealthChecker) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report, ready := hc.checkReadiness()
+	status := 200
+	if !ready {
+		status = 503
+	}
+	writeHealthReport(w, status, report)
+}
+
+// checkReadiness runs every readiness check. Later checks still run when earlier ones fail so the report is complete.
+func (hc *HealthChecker) checkReadiness() (*healthReport, bool) {
+	report := &healthReport{Status: "ok", Checks: map[string]string{}}
+	ready := true
+	fail := func(check string, err error) {
+		log.Printf("Readiness check %s failed: %v", check, err)
+		report.Checks[check] = err.Error()
+		report.Status = "unavailable"
+		ready = false
+	}
+
+	err := hc.ping()
+	if err != nil {
+		fail("database", err)
+	} else {
+		report.Checks["database"] = "ok"
+	}
+
+	countries, err := hc.view.Countries()
+	if err != nil {
+		fail("data", err)
+	} else if len(countries) == 0 {
+		fail("data", fmt.Errorf("No country data stored"))
+	} else {
+		report.Checks["data"] = "ok"
+	}
+
+	if hc.staleAfter <= 0 {
+		report.Checks["freshness"] = "disabled"
+		return report, ready
+	}
+	stats, err := hc.view.LatestGlobalStats()
+	if err != nil {
+		fail("freshness", err)
+	} else if age := time.Since(stats.CollectedAt); age > hc.staleAfter {
+		fail("freshness", fmt.Errorf("Global data collected %s ago, older than %s", age.Round(time.Second), hc.staleAfter))
+	} else {
+		report.Checks["freshness"] = "ok"
+	}
+
+	return report, ready
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report *healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Printf("Unable to write health report: %v", err)
+	}
+}
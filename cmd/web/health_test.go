@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func TestHealthChecker(t *testing.T) {
+	freshStore := durcov.NewMemoryStore()
+	err := freshStore.StoreData(durcov.ExampleTestDataAt(time.Now().Add(-time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleStore := durcov.NewMemoryStore()
+	err = staleStore.StoreData(durcov.ExampleTestDataAt(time.Now().Add(-48 * time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingOK := func() error { return nil }
+	pingFailed := func() error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name           string
+		checker        *HealthChecker
+		expectedStatus int
+		failedCheck    string
+	}{
+		{"Fresh data is ready", &HealthChecker{pingOK, freshStore, 24 * time.Hour}, 200, ""},
+		{"Stale data is not ready", &HealthChecker{pingOK, staleStore, 24 * time.Hour}, 503, "freshness"},
+		{"Stale data is ready without a threshold", &HealthChecker{pingOK, staleStore, 0}, 200, ""},
+		{"Unreachable database is not ready", &HealthChecker{pingFailed, freshStore, 24 * time.Hour}, 503, "database"},
+		{"Empty data is not ready", &HealthChecker{pingOK, durcov.NewMemoryStore(), 24 * time.Hour}, 503, "data"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		test.checker.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != test.expectedStatus {
+			t.Errorf("%s: Status mismatch. Expected=%d Got=%d", test.name, test.expectedStatus, rec.Code)
+		}
+
+		var report healthReport
+		err := json.Unmarshal(rec.Body.Bytes(), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.failedCheck != "" && report.Checks[test.failedCheck] == "ok" {
+			t.Errorf("%s: Expected %s check to fail. Got=%+v", test.name, test.failedCheck, report)
+		}
+
+		rec = httptest.NewRecorder()
+		test.checker.handleHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+		if rec.Code != 200 {
+			t.Errorf("%s: Liveness should not depend on readiness. Got=%d", test.name, rec.Code)
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/TuhinNair/durcov"
 
 	"github.com/kevinburke/twilio-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type config struct {
@@ -15,7 +24,34 @@ type config struct {
 	twilioSID         string
 	twilioAuthToken   string
 	twilioWebhookHost string
+	twilioMode        responseMode
+	twilioSenders     map[twilioTransport]string
+	telegramToken     string
+	telegramMode      string
+	telegramSecret    string
+	telegramWebhook   string
+	slackSecret       string
+	slackToken        string
+	slackDigests      []*slackDigest
+	slackDigestAt     time.Duration
+	discordPublicKey  string
+	discordAppID      string
+	discordToken      string
+	discordGuildID    string
+	webChat           bool
+	outboundQueue     string
+	outboundWorkers   int
+	deliveryStatuses  string
+	suppressions      string
+	rateLimitStore    string
+	rateLimit         durcov.RateLimit
+	sessionStore      string
+	sessionTTL        time.Duration
+	adminUsername     string
+	adminPassword     string
 	dbURL             string
+	staleAfter        time.Duration
+	timeouts          *serverTimeouts
 }
 
 func loadConfig() *config {
@@ -24,9 +60,162 @@ func loadConfig() *config {
 	twilioSID := os.Getenv("TWILIO_SID")
 	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN")
 	twilioWebhookHost := os.Getenv("TWILIO_WEBHOOK_HOST")
+	twilioMode, err := parseResponseMode(os.Getenv("TWILIO_RESPONSE_MODE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Numbers to send messages that aren't replies from (e.g. digests). Optional.
+	twilioSenders := map[twilioTransport]string{}
+	if number := os.Getenv("TWILIO_WHATSAPP_NUMBER"); number != "" {
+		twilioSenders[whatsappTransport] = "whatsapp:" + strings.TrimPrefix(number, "whatsapp:")
+	}
+	if number := os.Getenv("TWILIO_SMS_NUMBER"); number != "" {
+		twilioSenders[smsTransport] = number
+	}
+	telegramToken := os.Getenv("TELEGRAM_BOT_TOKEN")
+	telegramMode := os.Getenv("TELEGRAM_MODE")
+	if telegramMode == "" {
+		telegramMode = "webhook"
+	}
+	if telegramMode != "webhook" && telegramMode != "polling" {
+		log.Fatalf("Unknown TELEGRAM_MODE %q. Expected webhook or polling", telegramMode)
+	}
+	telegramSecret := os.Getenv("TELEGRAM_SECRET_TOKEN")
+	telegramWebhook := os.Getenv("TELEGRAM_WEBHOOK_URL")
+	slackSecret := os.Getenv("SLACK_SIGNING_SECRET")
+	slackToken := os.Getenv("SLACK_BOT_TOKEN")
+	slackDigests, err := parseSlackDigests(os.Getenv("SLACK_DIGESTS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Digests are posted daily at SLACK_DIGEST_TIME (UTC)
+	slackDigestAt := 9 * time.Hour
+	if at := os.Getenv("SLACK_DIGEST_TIME"); at != "" {
+		parsed, err := time.Parse("15:04", at)
+		if err != nil {
+			log.Fatalf("Invalid SLACK_DIGEST_TIME %q. Expected HH:MM", at)
+		}
+		slackDigestAt = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+	discordPublicKey := os.Getenv("DISCORD_PUBLIC_KEY")
+	discordAppID := os.Getenv("DISCORD_APPLICATION_ID")
+	discordToken := os.Getenv("DISCORD_BOT_TOKEN")
+	discordGuildID := os.Getenv("DISCORD_GUILD_ID")
+	// The web chat is open to anyone who can load the page so it's only served when turned on
+	webChat := false
+	switch os.Getenv("WEB_CHAT") {
+	case "on":
+		webChat = true
+	case "", "off":
+	default:
+		log.Fatalf("Unknown WEB_CHAT %q. Expected on or off", os.Getenv("WEB_CHAT"))
+	}
+	outboundQueue := os.Getenv("OUTBOUND_QUEUE")
+	if outboundQueue == "" {
+		outboundQueue = "postgres"
+	}
+	outboundWorkers := 4
+	if workers := os.Getenv("OUTBOUND_WORKERS"); workers != "" {
+		outboundWorkers, err = strconv.Atoi(workers)
+		if err != nil || outboundWorkers < 1 {
+			log.Fatalf("Invalid OUTBOUND_WORKERS: %s", workers)
+		}
+	}
+	deliveryStatuses := os.Getenv("DELIVERY_STATUS_STORE")
+	if deliveryStatuses == "" {
+		deliveryStatuses = "postgres"
+	}
+	suppressions := os.Getenv("SUPPRESSION_STORE")
+	if suppressions == "" {
+		suppressions = "postgres"
+	}
+	rateLimitStore := os.Getenv("RATE_LIMIT_STORE")
+	if rateLimitStore == "" {
+		rateLimitStore = "memory"
+	}
+	// Each sender can send a burst of messages and then one every interval
+	rateLimitBurst := 5
+	if burst := os.Getenv("RATE_LIMIT_BURST"); burst != "" {
+		rateLimitBurst, err = strconv.Atoi(burst)
+		if err != nil || rateLimitBurst < 1 {
+			log.Fatalf("Invalid RATE_LIMIT_BURST: %s", burst)
+		}
+	}
+	rateLimitInterval := durationEnv("RATE_LIMIT_INTERVAL", 12*time.Second)
+	if rateLimitInterval <= 0 {
+		log.Fatalf("Invalid RATE_LIMIT_INTERVAL: %v", rateLimitInterval)
+	}
+	rateLimit := durcov.RateLimit{Rate: 1 / rateLimitInterval.Seconds(), Burst: rateLimitBurst}
+	sessionStore := os.Getenv("SESSION_STORE")
+	if sessionStore == "" {
+		sessionStore = "memory"
+	}
+	// How long the bot remembers a sender's last command for follow-ups
+	sessionTTL := durationEnv("SESSION_TTL", 30*time.Minute)
+	if sessionTTL <= 0 {
+		log.Fatalf("Invalid SESSION_TTL: %v", sessionTTL)
+	}
+	adminUsername := os.Getenv("ADMIN_USERNAME")
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
 	dbURL := os.Getenv("DATABASE_URL")
 
-	return &config{port, twilioSID, twilioAuthToken, twilioWebhookHost, dbURL}
+	// How old data can get before replies carry a notice and the server isn't ready. Zero disables both.
+	staleAfter := durationEnv("STALE_DATA_THRESHOLD", 24*time.Hour)
+	timeouts := &serverTimeouts{
+		read:  durationEnv("HTTP_READ_TIMEOUT", 10*time.Second),
+		write: durationEnv("HTTP_WRITE_TIMEOUT", 20*time.Second),
+		idle:  durationEnv("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		// Heroku sends SIGKILL 30 seconds after SIGTERM
+		shutdown: durationEnv("SHUTDOWN_TIMEOUT", 25*time.Second),
+	}
+
+	return &config{
+		port:              port,
+		twilioSID:         twilioSID,
+		twilioAuthToken:   twilioAuthToken,
+		twilioWebhookHost: twilioWebhookHost,
+		twilioMode:        twilioMode,
+		twilioSenders:     twilioSenders,
+		telegramToken:     telegramToken,
+		telegramMode:      telegramMode,
+		telegramSecret:    telegramSecret,
+		telegramWebhook:   telegramWebhook,
+		slackSecret:       slackSecret,
+		slackToken:        slackToken,
+		slackDigests:      slackDigests,
+		slackDigestAt:     slackDigestAt,
+		discordPublicKey:  discordPublicKey,
+		discordAppID:      discordAppID,
+		discordToken:      discordToken,
+		discordGuildID:    discordGuildID,
+		webChat:           webChat,
+		outboundQueue:     outboundQueue,
+		outboundWorkers:   outboundWorkers,
+		deliveryStatuses:  deliveryStatuses,
+		suppressions:      suppressions,
+		rateLimitStore:    rateLimitStore,
+		rateLimit:         rateLimit,
+		sessionStore:      sessionStore,
+		sessionTTL:        sessionTTL,
+		adminUsername:     adminUsername,
+		adminPassword:     adminPassword,
+		dbURL:             dbURL,
+		staleAfter:        staleAfter,
+		timeouts:          timeouts,
+	}
+}
+
+// durationEnv parses the named environment variable as a duration, falling back to the default when unset.
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return parsed
 }
 
 func main() {
@@ -37,21 +226,281 @@ func main() {
 	}
 	defer pgxpool.Close()
 
-	dataview := &durcov.CovidBotView{}
-	dataview.SetDBConnection(pgxpool)
-	bot := &Bot{dataview}
+	covidBotView := &durcov.CovidBotView{}
+	covidBotView.SetDBConnection(pgxpool)
+	dataview := &instrumentedView{covidBotView}
+	bot := durcov.NewBot(dataview, config.staleAfter)
+	bot.SetObserver(observeBotResponse)
+
+	err = registerFreshnessMetric(prometheus.DefaultRegisterer, covidBotView)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Opt-outs can't be turned off. Carriers require them to be honoured.
+	var suppressionList durcov.SuppressionList
+	switch config.suppressions {
+	case "postgres":
+		covidSuppressionList := &durcov.CovidSuppressionList{}
+		covidSuppressionList.SetDBConnection(pgxpool)
+		suppressionList = covidSuppressionList
+	case "memory":
+		suppressionList = durcov.NewMemorySuppressionList()
+	default:
+		log.Fatalf("Unknown SUPPRESSION_STORE %q. Expected postgres or memory", config.suppressions)
+	}
+
+	var rateLimiter durcov.RateLimiter
+	switch config.rateLimitStore {
+	case "postgres":
+		covidRateLimiter := durcov.NewCovidRateLimiter(config.rateLimit)
+		covidRateLimiter.SetDBConnection(pgxpool)
+		rateLimiter = covidRateLimiter
+	case "memory":
+		rateLimiter = durcov.NewMemoryRateLimiter(config.rateLimit)
+	case "off":
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q. Expected memory, postgres or off", config.rateLimitStore)
+	}
+
+	switch config.sessionStore {
+	case "postgres":
+		covidSessionStore := durcov.NewCovidSessionStore(config.sessionTTL)
+		covidSessionStore.SetDBConnection(pgxpool)
+		bot.SetSessions(covidSessionStore)
+		sessionsCtx, stopSessions := context.WithCancel(context.Background())
+		defer stopSessions()
+		go deleteExpiredSessions(sessionsCtx, covidSessionStore, config.sessionTTL)
+	case "memory":
+		bot.SetSessions(durcov.NewMemorySessionStore(config.sessionTTL))
+	case "off":
+	default:
+		log.Fatalf("Unknown SESSION_STORE %q. Expected memory, postgres or off", config.sessionStore)
+	}
 
 	twilioClient := twilio.NewClient(config.twilioSID, config.twilioAuthToken, nil)
 	twilioValidator := &twilioValidator{config.twilioWebhookHost, config.twilioAuthToken}
-	twilioBot := TwilioBot{twilioClient, twilioValidator, bot}
 
+	var outbox *Outbox
+	switch config.outboundQueue {
+	case "postgres":
+		messageQueue := &durcov.CovidMessageQueue{}
+		messageQueue.SetDBConnection(pgxpool)
+		outbox = newOutbox(messageQueue, twilioClient, config.outboundWorkers)
+	case "memory":
+		outbox = newOutbox(durcov.NewMemoryMessageQueue(), twilioClient, config.outboundWorkers)
+	case "off":
+	default:
+		log.Fatalf("Unknown OUTBOUND_QUEUE %q. Expected postgres, memory or off", config.outboundQueue)
+	}
+	if outbox != nil {
+		outbox.suppressions = suppressionList
+	}
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxDone := make(chan struct{})
+	go func() {
+		defer close(outboxDone)
+		if outbox != nil {
+			outbox.run(outboxCtx)
+		}
+	}()
+
+	var statusStore durcov.DeliveryStatusStore
+	switch config.deliveryStatuses {
+	case "postgres":
+		covidStatusStore := &durcov.CovidDeliveryStatusStore{}
+		covidStatusStore.SetDBConnection(pgxpool)
+		statusStore = covidStatusStore
+	case "memory":
+		statusStore = durcov.NewMemoryDeliveryStatusStore()
+	case "off":
+	default:
+		log.Fatalf("Unknown DELIVERY_STATUS_STORE %q. Expected postgres, memory or off", config.deliveryStatuses)
+	}
+
+	twilioBot := &TwilioBot{twilioClient, twilioValidator, bot, config.twilioMode, outbox, statusStore, suppressionList, rateLimiter, config.twilioSenders}
+	channelHandlers := []*ChannelHandler{
+		twilioBot.handler(whatsappTransport),
+		twilioBot.handler(smsTransport),
+	}
+
+	// Telegram is enabled once a bot token is configured. Polling mode is meant for local development.
+	var telegramPolling *ChannelHandler
+	var telegram *TelegramChannel
+	if config.telegramToken != "" {
+		telegram = newTelegramChannel(config.telegramToken, config.telegramSecret)
+		telegramHandler := telegram.handler(bot, rateLimiter)
+		switch config.telegramMode {
+		case "webhook":
+			if config.telegramSecret == "" {
+				log.Fatal("TELEGRAM_SECRET_TOKEN must be set in telegram webhook mode")
+			}
+			if config.telegramWebhook != "" {
+				err = telegram.setWebhook(config.telegramWebhook)
+				if err != nil {
+					log.Fatalf("Unable to set telegram webhook: %v", err)
+				}
+			}
+			channelHandlers = append(channelHandlers, telegramHandler)
+		case "polling":
+			telegramPolling = telegramHandler
+		}
+	}
+	pollingCtx, stopPolling := context.WithCancel(context.Background())
+	pollingDone := make(chan struct{})
+	go func() {
+		defer close(pollingDone)
+		if telegramPolling != nil {
+			err := telegram.poll(pollingCtx, telegramPolling)
+			if err != nil && err != context.Canceled {
+				log.Printf("Telegram polling stopped: %v", err)
+			}
+		}
+	}()
+
+	// Slack is enabled once a signing secret is configured. Replies to mentions and digests also need a bot token.
+	// Digests are posted by every process they're configured on so SLACK_DIGESTS should only be set on one.
+	digestsCtx, stopDigests := context.WithCancel(context.Background())
+	defer stopDigests()
+	if config.slackSecret != "" {
+		slack := newSlackChannel(config.slackSecret, config.slackToken, dataview)
+		channelHandlers = append(channelHandlers, slack.handler(bot, rateLimiter))
+		if len(config.slackDigests) > 0 {
+			if config.slackToken == "" {
+				log.Fatal("SLACK_BOT_TOKEN must be set to post slack digests")
+			}
+			go slack.runDigests(digestsCtx, config.slackDigests, config.slackDigestAt)
+		}
+	}
+
+	// Discord is enabled once the application's public key is configured.
+	// Slash commands are registered at startup when a bot token is set, to DISCORD_GUILD_ID if given.
+	if config.discordPublicKey != "" {
+		if config.discordAppID == "" {
+			log.Fatal("DISCORD_APPLICATION_ID must be set to answer discord interactions")
+		}
+		discord, err := newDiscordChannel(config.discordPublicKey, config.discordAppID, config.discordToken, dataview)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if config.discordToken != "" {
+			err = discord.registerCommands(config.discordGuildID)
+			if err != nil {
+				log.Fatalf("Unable to register discord commands: %v", err)
+			}
+		}
+		channelHandlers = append(channelHandlers, discord.handler(bot, rateLimiter))
+	}
+
+	graphQLServer, err := newGraphQLServer(dataview)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	updateListener := &durcov.CovidUpdateListener{}
+	updateListener.SetDBConnection(pgxpool)
+	eventFeed := newEventFeed(dataview)
+	feedCtx, stopFeed := context.WithCancel(context.Background())
+	defer stopFeed()
+	go func() {
+		err := eventFeed.run(feedCtx, updateListener)
+		if err != nil && feedCtx.Err() == nil {
+			// Connected clients would otherwise wait for changes that never come
+			log.Printf("Event feed stopped: %v", err)
+			eventFeed.close()
+		}
+	}()
+
+	healthChecker := &HealthChecker{
+		ping: func() error {
+			var one int
+			return pgxpool.QueryRow("SELECT 1;").Scan(&one)
+		},
+		view:       covidBotView,
+		staleAfter: config.staleAfter,
+	}
+
+	timeouts := config.timeouts
 	mux := http.NewServeMux()
-	mux.HandleFunc("/whatsapp", twilioBot.handleWhatsapp)
+	// Every channel's webhook is served at /<channel name>. Webhooks are served without a write timeout since the
+	// platforms retry on a 503 and the message would be answered twice.
+	for _, handler := range channelHandlers {
+		mux.Handle("/"+handler.channel.Name(), handler)
+	}
+	webChat := newWebChat()
+	if config.webChat {
+		webChatHandler := webChat.handler(bot, rateLimiter)
+		mux.Handle("/webchat", withWriteTimeout(webChat.handlePage, timeouts))
+		mux.Handle("/webchat/messages", withWriteTimeout(webChatHandler.ServeHTTP, timeouts))
+		// Sockets are long lived (and hijacked) so they're served without a write timeout, like the event feed
+		mux.HandleFunc("/webchat/ws", webChat.handleSocket(webChatHandler))
+	}
+	mux.Handle("/graphql", withWriteTimeout(graphQLServer.handleGraphQL, timeouts))
+	if statusStore != nil {
+		mux.HandleFunc("/twilio/status", twilioBot.handleStatus)
+	}
+	mux.HandleFunc("/events", eventFeed.handleEvents)
+	mux.Handle("/metrics", withWriteTimeout(promhttp.Handler().ServeHTTP, timeouts))
+	mux.Handle("/healthz", withWriteTimeout(healthChecker.handleHealthz, timeouts))
+	mux.Handle("/readyz", withWriteTimeout(healthChecker.handleReadyz, timeouts))
+	// Admin views are only served once credentials are configured
+	if config.adminPassword != "" {
+		groupStore := &durcov.CovidGroupStore{}
+		groupStore.SetDBConnection(pgxpool)
+		admin := &Admin{config.adminUsername, config.adminPassword, statusStore, suppressionList, dataview, groupStore}
+		mux.Handle("/admin/blocklist", withWriteTimeout(admin.authenticate(admin.handleBlocklist), timeouts))
+		mux.Handle("/admin/groups", withWriteTimeout(admin.authenticate(admin.handleGroups), timeouts))
+		if statusStore != nil {
+			mux.Handle("/admin/deliveries", withWriteTimeout(admin.authenticate(admin.handleDeliveries), timeouts))
+		}
+	}
 
-	log.Printf("Starting server on port= %v", config.port)
-	err = http.ListenAndServe(config.port, mux)
+	server := newServer(config.port, mux, timeouts)
+	// Event streams never finish on their own so they're closed as soon as shutdown starts
+	server.RegisterOnShutdown(eventFeed.close)
+	server.RegisterOnShutdown(webChat.close)
+
+	listener, err := net.Listen("tcp", config.port)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	log.Printf("Starting server on port= %v", config.port)
+	err = serveUntilSignal(server, listener, signals, timeouts.shutdown)
+	if err != nil {
+		log.Printf("Server did not shut down cleanly: %v", err)
+	}
+
+	// Outbox workers finish the send they're on. With the Postgres queue anything still queued is sent after the
+	// restart. The memory queue loses it.
+	// Deferred replies are still followed up since their users are waiting on them.
+	// The deferred stopFeed and pgxpool.Close only run once they're done.
+	stopPolling()
+	stopOutbox()
+	for _, handler := range channelHandlers {
+		handler.wait()
+	}
+	<-pollingDone
+	<-outboxDone
+	log.Println("Server shut down")
+}
+
+// deleteExpiredSessions deletes expired conversations every interval until ctx is done
+func deleteExpiredSessions(ctx context.Context, sessions *durcov.CovidSessionStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := sessions.DeleteExpired()
+			if err != nil {
+				log.Printf("Unable to delete expired conversations: %v", err)
+			}
+		}
+	}
 }
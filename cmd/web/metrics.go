@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TuhinNair/durcov"
+)
+
+var (
+	botRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_bot_requests_total",
+		Help: "Bot requests by command and outcome. Outcome is either ok or the bot error category.",
+	}, []string{"command", "outcome"})
+
+	botErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_bot_errors_total",
+		Help: "Bot errors by category.",
+	}, []string{"category"})
+
+	dataViewQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "durcov_dataview_query_duration_seconds",
+		Help:    "Latency of data view queries by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+
+	twilioSendFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_twilio_send_failures_total",
+		Help: "Outbound twilio messages that failed to send.",
+	})
+
+	twilioMessageStatuses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_twilio_message_status_total",
+		Help: "Delivery statuses reported by twilio for outbound messages, by status.",
+	}, []string{"status"})
+
+	screenedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_channel_screened_messages_total",
+		Help: "Inbound messages handled before the bot by channel and outcome: opt_out, opt_in, opted_out or blocked.",
+	}, []string{"channel", "outcome"})
+
+	throttledMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_channel_throttled_messages_total",
+		Help: "Inbound messages left unanswered because the sender went over the rate limit, by channel.",
+	}, []string{"channel"})
+
+	throttledSenders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "durcov_channel_throttled_senders_total",
+		Help: "Times a sender went over the rate limit and was asked to slow down, by channel. Counted once per window.",
+	}, []string{"channel"})
+
+	outboxSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_outbox_suppressed_total",
+		Help: "Queued outbound messages dropped because the recipient opted out or was blocked.",
+	})
+
+	outboxDeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "durcov_outbox_dead_letters_total",
+		Help: "Queued outbound messages given up on after permanent failures or too many attempts.",
+	})
+)
+
+// observeBotResponse counts a bot response by command and outcome. Outcomes other than ok are also counted as bot errors.
+func observeBotResponse(command string, outcome string) {
+	botRequests.WithLabelValues(command, outcome).Inc()
+	if outcome != durcov.OutcomeOK {
+		botErrors.WithLabelValues(outcome).Inc()
+	}
+}
+
+// registerFreshnessMetric exposes the age of the latest global data, computed on every scrape.
+func registerFreshnessMetric(registerer prometheus.Registerer, view durcov.DataView) error {
+	return registerer.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "durcov_data_age_seconds",
+		Help: "Seconds since the latest global data was collected.",
+	}, func() float64 {
+		stats, err := view.LatestGlobalStats()
+		if err != nil {
+			log.Printf("Unable to compute data age: %v", err)
+			return -1
+		}
+		return time.Since(stats.CollectedAt).Seconds()
+	}))
+}
+
+// instrumentedView represents a data view recording the latency of every query
+type instrumentedView struct {
+	durcov.DataView
+}
+
+func observeQuery(method string, start time.Time) {
+	dataViewQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (v *instrumentedView) LatestGlobalView(datapoint durcov.Datum) (int64, error) {
+	defer observeQuery("LatestGlobalView", time.Now())
+	return v.DataView.LatestGlobalView(datapoint)
+}
+
+func (v *instrumentedView) LatestCountryView(countryCode string, datapoint durcov.Datum) (string, int64, error) {
+	defer observeQuery("LatestCountryView", time.Now())
+	return v.DataView.LatestCountryView(countryCode, datapoint)
+}
+
+func (v *instrumentedView) Countries() ([]*durcov.CountryInfo, error) {
+	defer observeQuery("Countries", time.Now())
+	return v.DataView.Countries()
+}
+
+func (v *instrumentedView) LatestGlobalStats() (*durcov.StatsSnapshot, error) {
+	defer observeQuery("LatestGlobalStats", time.Now())
+	return v.DataView.LatestGlobalStats()
+}
+
+func (v *instrumentedView) LatestCountryStats(countryCode string) (*durcov.CountryInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("LatestCountryStats", time.Now())
+	return v.DataView.LatestCountryStats(countryCode)
+}
+
+func (v *instrumentedView) GlobalHistory(from time.Time, to time.Time) ([]*durcov.StatsSnapshot, error) {
+	defer observeQuery("GlobalHistory", time.Now())
+	return v.DataView.GlobalHistory(from, to)
+}
+
+func (v *instrumentedView) CountryHistory(countryCode string, from time.Time, to time.Time) ([]*durcov.StatsSnapshot, error) {
+	defer observeQuery("CountryHistory", time.Now())
+	return v.DataView.CountryHistory(countryCode, from, to)
+}
+
+func (v *instrumentedView) GlobalStatsAt(at time.Time) (*durcov.StatsSnapshot, error) {
+	defer observeQuery("GlobalStatsAt", time.Now())
+	return v.DataView.GlobalStatsAt(at)
+}
+
+func (v *instrumentedView) CountryStatsAt(countryCode string, at time.Time) (*durcov.CountryInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("CountryStatsAt", time.Now())
+	return v.DataView.CountryStatsAt(countryCode, at)
+}
+
+func (v *instrumentedView) Regions(parentCode string) ([]*durcov.RegionInfo, error) {
+	defer observeQuery("Regions", time.Now())
+	return v.DataView.Regions(parentCode)
+}
+
+func (v *instrumentedView) LatestRegionStats(regionCode string) (*durcov.RegionInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("LatestRegionStats", time.Now())
+	return v.DataView.LatestRegionStats(regionCode)
+}
+
+func (v *instrumentedView) RegionStatsAt(regionCode string, at time.Time) (*durcov.RegionInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("RegionStatsAt", time.Now())
+	return v.DataView.RegionStatsAt(regionCode, at)
+}
+
+func (v *instrumentedView) Groups() ([]*durcov.GroupInfo, error) {
+	defer observeQuery("Groups", time.Now())
+	return v.DataView.Groups()
+}
+
+func (v *instrumentedView) LatestGroupStats(groupCode string) (*durcov.GroupInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("LatestGroupStats", time.Now())
+	return v.DataView.LatestGroupStats(groupCode)
+}
+
+func (v *instrumentedView) GroupStatsAt(groupCode string, at time.Time) (*durcov.GroupInfo, *durcov.StatsSnapshot, error) {
+	defer observeQuery("GroupStatsAt", time.Now())
+	return v.DataView.GroupStatsAt(groupCode, at)
+}
+
+func (v *instrumentedView) LatestGlobalVaccinations() (*durcov.VaccinationSnapshot, error) {
+	defer observeQuery("LatestGlobalVaccinations", time.Now())
+	return v.DataView.LatestGlobalVaccinations()
+}
+
+func (v *instrumentedView) LatestCountryVaccinations(countryCode string) (*durcov.CountryInfo, *durcov.VaccinationSnapshot, error) {
+	defer observeQuery("LatestCountryVaccinations", time.Now())
+	return v.DataView.LatestCountryVaccinations(countryCode)
+}
+
+func (v *instrumentedView) LatestGroupVaccinations(groupCode string) (*durcov.GroupInfo, *durcov.VaccinationSnapshot, error) {
+	defer observeQuery("LatestGroupVaccinations", time.Now())
+	return v.DataView.LatestGroupVaccinations(groupCode)
+}
+
+func (v *instrumentedView) LatestCountryTests(countryCode string) (*durcov.CountryInfo, *durcov.TestingSnapshot, error) {
+	defer observeQuery("LatestCountryTests", time.Now())
+	return v.DataView.LatestCountryTests(countryCode)
+}
+
+func (v *instrumentedView) LatestGroupTests(groupCode string) (*durcov.GroupInfo, *durcov.TestingSnapshot, error) {
+	defer observeQuery("LatestGroupTests", time.Now())
+	return v.DataView.LatestGroupTests(groupCode)
+}
+
+func (v *instrumentedView) LatestHospitalOccupancy(code string) (string, *durcov.HospitalSnapshot, error) {
+	defer observeQuery("LatestHospitalOccupancy", time.Now())
+	return v.DataView.LatestHospitalOccupancy(code)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func TestBotMetrics(t *testing.T) {
+	memoryStore := newTestView(t)
+	testBot := durcov.NewBot(&instrumentedView{memoryStore}, 0)
+	testBot.SetObserver(observeBotResponse)
+
+	tests := []struct {
+		input   string
+		command string
+		outcome string
+	}{
+		{"CASES TOTAL", "cases", durcov.OutcomeOK},
+		{"DEATHS SG", "deaths", durcov.OutcomeOK},
+		{"DEATHS IN", "deaths", durcov.OutcomeNoCountry},
+		{"abcdefghijklmnopqrstuvwxyz", "unknown", durcov.OutcomeUnmatched},
+		{"CASES" + strings.Repeat(" ", 160) + "TOTAL", "unknown", durcov.OutcomeTooLong},
+	}
+
+	for _, test := range tests {
+		requests := botRequests.WithLabelValues(test.command, test.outcome)
+		before := testutil.ToFloat64(requests)
+		testBot.Respond(test.input)
+		if got := testutil.ToFloat64(requests) - before; got != 1 {
+			t.Errorf("Request count mismatch. Input: %s Expected=%d Got=%v", test.input, 1, got)
+		}
+	}
+
+	if testutil.ToFloat64(botErrors.WithLabelValues(durcov.OutcomeNoCountry)) < 1 {
+		t.Error("Expected no_country bot error to be counted")
+	}
+	if testutil.CollectAndCount(dataViewQueryDuration) == 0 {
+		t.Error("Expected data view query latency to be observed")
+	}
+
+	registry := prometheus.NewRegistry()
+	err := registerFreshnessMetric(registry, memoryStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "durcov_data_age_seconds" {
+		t.Fatalf("Expected only the data age metric to be registered. Got=%v", families)
+	}
+	if age := families[0].GetMetric()[0].GetGauge().GetValue(); age <= 0 {
+		t.Errorf("Expected a positive data age. Got=%v", age)
+	}
+}
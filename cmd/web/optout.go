@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// Keywords carriers require us to honour. Matched against the whole (trimmed, case insensitive) message.
+var (
+	optOutKeywords = map[string]bool{"STOP": true, "STOPALL": true, "UNSUBSCRIBE": true, "CANCEL": true, "END": true, "QUIT": true}
+	optInKeywords  = map[string]bool{"START": true, "YES": true, "UNSTOP": true}
+)
+
+const (
+	optOutReply   = "You've been unsubscribed and won't get any more messages from me. Reply START to resubscribe."
+	optInReply    = "You've been resubscribed. Try CASES TOTAL to get started."
+	slowDownReply = "You're sending messages faster than I can keep up. Please wait a minute before trying again."
+)
+
+// optOutScreen applies opt-out keywords and the sender's suppression state before the bot sees the message.
+// Blocked senders and senders who opted out get no reply at all.
+func optOutScreen(suppressions durcov.SuppressionList, transport twilioTransport) screen {
+	return func(msg *InboundMessage) (*Reply, error) {
+		blocked, err := suppressions.IsSuppressed(msg.From, durcov.SuppressedBlocked)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			screenedMessages.WithLabelValues(transport.String(), "blocked").Inc()
+			return &Reply{}, nil
+		}
+
+		keyword := strings.ToUpper(strings.TrimSpace(msg.Text))
+		if optOutKeywords[keyword] {
+			screenedMessages.WithLabelValues(transport.String(), "opt_out").Inc()
+			err = suppressions.Suppress(msg.From, durcov.SuppressedOptOut, keyword)
+			return transport.complianceReply(optOutReply), err
+		}
+		if optInKeywords[keyword] {
+			screenedMessages.WithLabelValues(transport.String(), "opt_in").Inc()
+			err = suppressions.Unsuppress(msg.From, durcov.SuppressedOptOut)
+			return transport.complianceReply(optInReply), err
+		}
+
+		optedOut, err := suppressions.IsSuppressed(msg.From, durcov.SuppressedOptOut)
+		if err != nil {
+			return nil, err
+		}
+		if optedOut {
+			screenedMessages.WithLabelValues(transport.String(), "opted_out").Inc()
+			return &Reply{}, nil
+		}
+		return nil, nil
+	}
+}
+
+// complianceReply returns the confirmation for an opt-out or opt-in keyword.
+// Twilio already confirms SMS keywords itself (and rejects anything we send after a STOP) so SMS gets no reply.
+func (t twilioTransport) complianceReply(reply string) *Reply {
+	if t == smsTransport {
+		return &Reply{}
+	}
+	return &Reply{Text: reply, Compliance: true}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"github.com/kevinburke/twilio-go"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// maxRetryBackoff caps the exponential backoff between delivery attempts
+const maxRetryBackoff = 10 * time.Minute
+
+// Outbox represents a worker pool delivering queued replies through twilio
+type Outbox struct {
+	queue        durcov.MessageQueue
+	client       *twilio.Client
+	workers      int
+	maxAttempts  int
+	backoff      time.Duration
+	lease        time.Duration
+	pollInterval time.Duration
+	wake         chan struct{}
+	// suppressions holds recipients that must not be messaged. Every message is delivered when nil.
+	suppressions durcov.SuppressionList
+}
+
+func newOutbox(queue durcov.MessageQueue, client *twilio.Client, workers int) *Outbox {
+	return &Outbox{
+		queue:        queue,
+		client:       client,
+		workers:      workers,
+		maxAttempts:  5,
+		backoff:      5 * time.Second,
+		lease:        time.Minute,
+		pollInterval: 5 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues the response for delivery and wakes a worker.
+func (o *Outbox) enqueue(resp *twilioResponse) error {
+	msg := &durcov.OutboundMessage{To: resp.to, From: resp.from, Body: resp.responseBody, StatusCallback: resp.statusCallback}
+	err := o.queue.Enqueue(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run starts the workers and blocks until ctx is done and every worker finished its current delivery.
+// Messages still queued are picked up by the next run (for durable queues).
+func (o *Outbox) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (o *Outbox) work(ctx context.Context) {
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// drain delivers due messages one at a time until none are left or ctx is done.
+func (o *Outbox) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimed, err := o.queue.Claim(1, o.lease)
+		if err != nil {
+			log.Printf("Unable to claim outbound messages: %v", err)
+			return
+		}
+		if len(claimed) == 0 {
+			return
+		}
+		o.deliver(claimed[0])
+	}
+}
+
+func (o *Outbox) deliver(msg *durcov.OutboundMessage) {
+	if o.suppressions != nil {
+		suppressed, err := o.suppressions.IsSuppressed(msg.To)
+		if err != nil {
+			log.Printf("Unable to check suppression of message %d: %v", msg.ID, err)
+			// Nothing was sent so it isn't counted as an attempt
+			err = o.queue.Release(msg.ID, o.retryAfter(msg.Attempts+1))
+			if err != nil {
+				log.Printf("Unable to release message %d: %v", msg.ID, err)
+			}
+			return
+		}
+		if suppressed {
+			log.Printf("Dropping message %d to a suppressed recipient", msg.ID)
+			outboxSuppressed.Inc()
+			err = o.queue.Drop(msg.ID, "Recipient suppressed")
+			if err != nil {
+				log.Printf("Unable to drop message %d: %v", msg.ID, err)
+			}
+			return
+		}
+	}
+
+	sent, err := sendMessage(o.client, msg.From, msg.To, msg.Body, msg.StatusCallback)
+	if err == nil {
+		err = o.queue.MarkSent(msg.ID, sent.Sid)
+		if err != nil {
+			log.Printf("Unable to mark message %d sent: %v", msg.ID, err)
+		}
+		return
+	}
+
+	twilioSendFailures.Inc()
+	attempts := msg.Attempts + 1
+	if isPermanentSendError(err) || attempts >= o.maxAttempts {
+		log.Printf("Dead lettering message %d after %d attempts: %v", msg.ID, attempts, err)
+		outboxDeadLetters.Inc()
+		err = o.queue.DeadLetter(msg.ID, err.Error())
+		if err != nil {
+			log.Printf("Unable to dead letter message %d: %v", msg.ID, err)
+		}
+		return
+	}
+
+	retryAfter := o.retryAfter(attempts)
+	log.Printf("Unable to send message %d, retrying in %v: %v", msg.ID, retryAfter, err)
+	err = o.queue.MarkFailed(msg.ID, err.Error(), retryAfter)
+	if err != nil {
+		log.Printf("Unable to mark message %d failed: %v", msg.ID, err)
+	}
+}
+
+// retryAfter doubles the backoff with every attempt
+func (o *Outbox) retryAfter(attempts int) time.Duration {
+	retryAfter := o.backoff
+	for i := 1; i < attempts && retryAfter < maxRetryBackoff; i++ {
+		retryAfter *= 2
+	}
+	if retryAfter > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return retryAfter
+}
+
+// isPermanentSendError reports whether retrying won't help. Twilio rejects bad requests (e.g. invalid numbers) with a 4xx.
+func isPermanentSendError(err error) bool {
+	restErr, ok := err.(*rest.Error)
+	return ok && restErr.Status >= 400 && restErr.Status < 500 && restErr.Status != 429
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/twilio-go"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func newTestOutbox(t *testing.T, failures ...int) (*Outbox, *durcov.MemoryMessageQueue, *fakeTwilioAPI) {
+	api := &fakeTwilioAPI{failures: failures}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client := twilio.NewClient("AC123", testAuthToken, nil)
+	client.Base = server.URL
+
+	queue := durcov.NewMemoryMessageQueue()
+	outbox := newOutbox(queue, client, 2)
+	outbox.backoff = time.Millisecond
+	outbox.pollInterval = 5 * time.Millisecond
+	return outbox, queue, api
+}
+
+// waitForStatus polls the queue until the message reaches the status
+func waitForStatus(t *testing.T, queue *durcov.MemoryMessageQueue, id int64, status string) *durcov.OutboundMessage {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		msg, ok := queue.Message(id)
+		if ok && msg.Status == status {
+			return msg
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	msg, _ := queue.Message(id)
+	t.Fatalf("Message %d never reached status %s. Got=%+v", id, status, msg)
+	return nil
+}
+
+func TestOutboxRetriesTransientFailures(t *testing.T) {
+	outbox, queue, api := newTestOutbox(t, 500, 503)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	err := outbox.enqueue(&twilioResponse{to: "whatsapp:+15005550006", from: "whatsapp:+14155238886", responseBody: "Total Deaths: 500,000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := waitForStatus(t, queue, 1, durcov.MessageSent)
+	if msg.Attempts != 3 || msg.ProviderID != "SM123" {
+		t.Errorf("sent message mismatch. Got=%+v", msg)
+	}
+	attempts, err := queue.Attempts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 || attempts[0].Error == "" || attempts[2].Error != "" {
+		t.Errorf("attempts mismatch. Got=%+v", attempts)
+	}
+	if len(api.sent()) != 3 {
+		t.Errorf("send count mismatch. Expected=%d Got=%d", 3, len(api.sent()))
+	}
+}
+
+func TestOutboxDeadLettersPermanentFailures(t *testing.T) {
+	outbox, queue, api := newTestOutbox(t, 400)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	err := outbox.enqueue(&twilioResponse{to: "whatsapp:+1", from: "whatsapp:+14155238886", responseBody: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := waitForStatus(t, queue, 1, durcov.MessageDeadLettered)
+	if msg.Attempts != 1 || msg.LastError == "" {
+		t.Errorf("dead lettered message mismatch. Got=%+v", msg)
+	}
+	if len(api.sent()) != 1 {
+		t.Errorf("Permanent failures should not be retried. Sends=%d", len(api.sent()))
+	}
+}
+
+func TestOutboxDeadLettersAfterMaxAttempts(t *testing.T) {
+	outbox, queue, _ := newTestOutbox(t, 500, 500, 500)
+	outbox.maxAttempts = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	err := outbox.enqueue(&twilioResponse{to: "whatsapp:+15005550006", from: "whatsapp:+14155238886", responseBody: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := waitForStatus(t, queue, 1, durcov.MessageDeadLettered)
+	if msg.Attempts != 3 {
+		t.Errorf("attempts mismatch. Expected=%d Got=%d", 3, msg.Attempts)
+	}
+}
+
+func TestOutboxDropsSuppressedRecipients(t *testing.T) {
+	outbox, queue, api := newTestOutbox(t)
+	suppressions := durcov.NewMemorySuppressionList()
+	suppressions.Suppress("+15005550006", durcov.SuppressedOptOut, "STOP")
+	outbox.suppressions = suppressions
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	err := outbox.enqueue(&twilioResponse{to: "whatsapp:+15005550006", from: "whatsapp:+14155238886", responseBody: "Daily digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := waitForStatus(t, queue, 1, durcov.MessageDeadLettered)
+	if len(api.sent()) != 0 {
+		t.Errorf("Suppressed recipients should not be messaged. Sends=%d", len(api.sent()))
+	}
+	if msg.Attempts != 0 {
+		t.Errorf("attempts mismatch. Expected=%d Got=%d", 0, msg.Attempts)
+	}
+}
+
+func TestWebhookEnqueuesReplies(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+	queue := durcov.NewMemoryMessageQueue()
+	twilioBot.outbox = newOutbox(queue, twilioBot.client, 1)
+
+	rec := httptest.NewRecorder()
+	twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", whatsappForm("DEATHS SG")))
+	if rec.Code != 200 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+	}
+	if len(api.sent()) != 0 {
+		t.Error("Replies should be queued rather than sent by the webhook")
+	}
+	msg, ok := queue.Message(1)
+	if !ok || msg.Status != durcov.MessagePending || msg.Body != "[SG] Singapore Deaths: 1,822" {
+		t.Errorf("queued message mismatch. Got=%+v", msg)
+	}
+}
+
+func TestOutboxRetryBackoff(t *testing.T) {
+	outbox := newOutbox(durcov.NewMemoryMessageQueue(), nil, 1)
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{20, maxRetryBackoff},
+	}
+	for _, test := range tests {
+		if got := outbox.retryAfter(test.attempts); got != test.expected {
+			t.Errorf("Backoff mismatch. Attempts=%d Expected=%v Got=%v", test.attempts, test.expected, got)
+		}
+	}
+}
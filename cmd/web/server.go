@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type serverTimeouts struct {
+	read     time.Duration
+	write    time.Duration
+	idle     time.Duration
+	shutdown time.Duration
+}
+
+// newServer returns an http server with the given timeouts.
+// The write timeout is applied per handler (see withWriteTimeout) rather than on the server so the event stream isn't cut off.
+func newServer(addr string, handler http.Handler, timeouts *serverTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.read,
+		ReadTimeout:       timeouts.read,
+		IdleTimeout:       timeouts.idle,
+	}
+}
+
+// withWriteTimeout responds with a 503 if the handler hasn't finished within the write timeout.
+func withWriteTimeout(handler http.HandlerFunc, timeouts *serverTimeouts) http.Handler {
+	if timeouts.write <= 0 {
+		return handler
+	}
+	return http.TimeoutHandler(handler, timeouts.write, http.StatusText(503))
+}
+
+// serveUntilSignal serves on the listener until a signal is received, then stops accepting connections
+// and waits up to the shutdown timeout for in-flight requests to finish.
+// Returns nil once every in-flight request finished.
+func serveUntilSignal(server *http.Server, listener net.Listener, signals <-chan os.Signal, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %v. Shutting down, waiting up to %v for in-flight requests", sig, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+func TestServerDrainsInFlightRequestsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+		close(finished)
+	})
+
+	timeouts := &serverTimeouts{read: time.Second, write: time.Second, idle: time.Second, shutdown: 5 * time.Second}
+	server := newServer("", mux, timeouts)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveUntilSignal(server, listener, signals, timeouts.shutdown)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			responses <- response{"", err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		responses <- response{string(body), err}
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+
+	err = <-serveErr
+	if err != nil {
+		t.Fatalf("Expected a clean shutdown. Got=%v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown returned before the in-flight request finished")
+	}
+
+	res := <-responses
+	if res.err != nil {
+		t.Fatalf("In-flight request failed: %v", res.err)
+	}
+	if res.body != "done" {
+		t.Errorf("Response mismatch. Expected=%s Got=%s", "done", res.body)
+	}
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	if err == nil {
+		t.Error("Expected new requests to be refused after shutdown")
+	}
+}
+
+func TestServerClosesEventStreamsOnShutdown(t *testing.T) {
+	eventFeed := newEventFeed(durcov.NewMemoryStore())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", eventFeed.handleEvents)
+
+	timeouts := &serverTimeouts{read: time.Second, write: time.Second, idle: time.Second, shutdown: 5 * time.Second}
+	server := newServer("", mux, timeouts)
+	server.RegisterOnShutdown(eventFeed.close)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveUntilSignal(server, listener, signals, timeouts.shutdown)
+	}()
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Fatalf("Expected a clean shutdown. Got=%v", err)
+		}
+	case <-time.After(timeouts.shutdown):
+		t.Fatal("Open event stream blocked shutdown")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	handler := withWriteTimeout(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}, &serverTimeouts{write: 10 * time.Millisecond})
+
+	server := &http.Server{Handler: handler}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	res, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 {
+		t.Errorf("Status mismatch. Expected=%d Got=%d", 503, res.StatusCode)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	slackAPIBase = "https://slack.com/api"
+	// slackMaxRequestAge is how old a signed request can be before it's refused as a possible replay
+	slackMaxRequestAge = 5 * time.Minute
+	// slackMaxDigestCodes keeps digests within slack's limit of 50 blocks per message
+	slackMaxDigestCodes = 20
+)
+
+const slackHelp = "Try `/covid cases SG` or `/covid deaths total`. You can also mention me with a command, e.g. `@durcov cases IN`."
+
+// slackMention matches user mentions (e.g. "<@U012AB3CD>") so they can be stripped from app mentions
+var slackMention = regexp.MustCompile(`<@[A-Z0-9]+(\|[^>]*)?>`)
+
+// slackDigestCode matches the codes a digest can report on
+var slackDigestCode = regexp.MustCompile(`^([A-Z]{2}|TOTAL|[A-Z][A-Z0-9]{2,19})$`)
+
+// slackUnescape undoes the escaping slack applies to message text
+var slackUnescape = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+// slackEscape escapes the characters slack treats as control characters in mrkdwn
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackData lists the datapoints shown as fields under replies and in digests
+var slackData = []struct {
+	datum durcov.Datum
+	label string
+}{
+	{durcov.Confirmed, "Confirmed"},
+	{durcov.Deaths, "Deaths"},
+	{durcov.Recovered, "Recovered"},
+	{durcov.Active, "Active"},
+}
+
+// SlackChannel represents a slack app. Slash commands and app mentions are both served from the channel's webhook.
+type SlackChannel struct {
+	signingSecret string
+	botToken      string
+	// view is used to show every datapoint as Block Kit fields
+	view    durcov.DataView
+	apiBase string
+	client  *http.Client
+	now     func() time.Time
+}
+
+func newSlackChannel(signingSecret string, botToken string, view durcov.DataView) *SlackChannel {
+	return &SlackChannel{
+		signingSecret: signingSecret,
+		botToken:      botToken,
+		view:          view,
+		apiBase:       slackAPIBase,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		now:           time.Now,
+	}
+}
+
+// slackEnvelope represents an Events API request
+type slackEnvelope struct {
+	Type      string      `json:"type"`
+	Challenge string      `json:"challenge"`
+	EventID   string      `json:"event_id"`
+	Event     *slackEvent `json:"event"`
+}
+
+type slackEvent struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Fields   []*slackText `json:"fields,omitempty"`
+	Elements []*slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackMessage represents a message as posted with chat.postMessage or in a slash command response.
+// Text is the fallback shown in notifications.
+type slackMessage struct {
+	Channel      string        `json:"channel,omitempty"`
+	ThreadTS     string        `json:"thread_ts,omitempty"`
+	ResponseType string        `json:"response_type,omitempty"`
+	Text         string        `json:"text"`
+	Blocks       []*slackBlock `json:"blocks,omitempty"`
+}
+
+type slackAPIResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// slackDetails holds what's needed to reply to a slack request
+type slackDetails struct {
+	slashCommand bool
+	channelID    string
+	// threadTS is the thread app mentions are answered in
+	threadTS string
+	// challenge is set on the URL verification slack sends when the events URL is configured
+	challenge string
+	// acknowledgeOnly is set for events that mustn't be answered, e.g. retries of events already answered
+	acknowledgeOnly bool
+}
+
+func (sc *SlackChannel) Name() string {
+	return "slack"
+}
+
+// Capabilities describes slack messages. MaxLength is the most text a section block can hold.
+func (sc *SlackChannel) Capabilities() Capabilities {
+	return Capabilities{Media: true, Markdown: true, MaxLength: 3000}
+}
+
+// ValidateRequest checks the request signature slack computes with the app's signing secret.
+// Requests signed more than slackMaxRequestAge ago are refused so they can't be replayed.
+func (sc *SlackChannel) ValidateRequest(r *http.Request) error {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid slack request timestamp %q", timestamp)
+	}
+	age := sc.now().Sub(time.Unix(seconds, 0))
+	if math.Abs(age.Seconds()) > slackMaxRequestAge.Seconds() {
+		return fmt.Errorf("Slack request timestamp is %v old", age)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	// The body is parsed after validation
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if !hmac.Equal([]byte(r.Header.Get("X-Slack-Signature")), []byte(sc.signature(timestamp, body))) {
+		return errors.New("Slack signature mismatch")
+	}
+	return nil
+}
+
+// signature returns the v0 signature of a request body sent at the given timestamp
+func (sc *SlackChannel) signature(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(sc.signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseRequest reads a slash command (sent as a form) or an Events API request (sent as JSON).
+func (sc *SlackChannel) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		err := r.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+		details := &slackDetails{slashCommand: true, channelID: r.PostForm.Get("channel_id")}
+		return &InboundMessage{
+			ID:       r.PostForm.Get("trigger_id"),
+			From:     r.PostForm.Get("user_id"),
+			To:       details.channelID,
+			Text:     strings.TrimSpace(slackUnescape.Replace(r.PostForm.Get("text"))),
+			Platform: details,
+		}, nil
+	}
+
+	envelope := &slackEnvelope{}
+	err := json.NewDecoder(r.Body).Decode(envelope)
+	if err != nil {
+		return nil, err
+	}
+	switch envelope.Type {
+	case "url_verification":
+		return &InboundMessage{Platform: &slackDetails{challenge: envelope.Challenge}}, nil
+	case "event_callback":
+		if envelope.Event == nil {
+			return nil, errors.New("Slack event callback without an event")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported slack request type %q", envelope.Type)
+	}
+
+	event := envelope.Event
+	threadTS := event.ThreadTS
+	if threadTS == "" {
+		threadTS = event.TS
+	}
+	details := &slackDetails{channelID: event.Channel, threadTS: threadTS}
+	// Slack retries events that aren't acknowledged within 3 seconds. The first delivery is still being answered.
+	// Events from bots (including this one) are never answered so bots can't talk to each other in a loop.
+	if r.Header.Get("X-Slack-Retry-Num") != "" || event.Type != "app_mention" || event.BotID != "" {
+		details.acknowledgeOnly = true
+	}
+	text := slackMention.ReplaceAllString(event.Text, "")
+	return &InboundMessage{
+		ID:       envelope.EventID,
+		From:     event.User,
+		To:       event.Channel,
+		Text:     strings.TrimSpace(slackUnescape.Replace(text)),
+		Platform: details,
+	}, nil
+}
+
+func (sc *SlackChannel) FormatReply(reply string) string {
+	return slackEscape.Replace(reply)
+}
+
+// Reply answers slash commands in the response and app mentions in a thread.
+func (sc *SlackChannel) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	details, ok := msg.Platform.(*slackDetails)
+	if !ok {
+		return errors.New("Slack message without slack details")
+	}
+	if details.challenge != "" {
+		w.Header().Set("Content-Type", "text/plain")
+		_, err := w.Write([]byte(details.challenge))
+		return err
+	}
+	if reply.Text == "" {
+		w.WriteHeader(200)
+		return nil
+	}
+
+	message := &slackMessage{Text: reply.Text, Blocks: sc.replyBlocks(msg, reply)}
+	if details.slashCommand {
+		// Everyone in the channel sees the answer, not just the user who asked
+		message.ResponseType = "in_channel"
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(body)
+		return err
+	}
+
+	message.Channel = details.channelID
+	message.ThreadTS = details.threadTS
+	err := sc.post(context.Background(), "chat.postMessage", message)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(200)
+	return nil
+}
+
+// replyBlocks renders the reply as a section. Replies from the bot also get a field for every datapoint.
+func (sc *SlackChannel) replyBlocks(msg *InboundMessage, reply *Reply) []*slackBlock {
+	blocks := []*slackBlock{{Type: "section", Text: &slackText{"mrkdwn", reply.Text}}}
+	if !reply.FromBot {
+		return blocks
+	}
+	request := reply.Command
+	if request == nil || !caseDatum(request.Datum) {
+		// Vaccination, testing and hospital figures are only given in the reply
+		return blocks
+	}
+	_, stats, err := latestStats(sc.view, request.Code)
+	if err != nil {
+		// The bot has already told the user the data isn't available
+		return blocks
+	}
+	return append(blocks, slackStatsBlocks("", stats)...)
+}
+
+// slackStatsBlocks renders a field for every datapoint in the stats and notes when they were collected.
+func slackStatsBlocks(title string, stats *durcov.StatsSnapshot) []*slackBlock {
+	fields := []*slackText{}
+	for _, data := range slackData {
+		fields = append(fields, &slackText{"mrkdwn", fmt.Sprintf("*%s*\n%s", data.label, durcov.FormatNumber(datumValue(stats, data.datum)))})
+	}
+	section := &slackBlock{Type: "section", Fields: fields}
+	if title != "" {
+		section.Text = &slackText{"mrkdwn", "*" + slackEscape.Replace(title) + "*"}
+	}
+	collected := &slackBlock{Type: "context", Elements: []*slackText{{"mrkdwn", "Data collected " + stats.CollectedAt.Format("2 Jan 2006 15:04 MST")}}}
+	return []*slackBlock{section, collected}
+}
+
+// caseDatum reports whether the datapoint is one of the case counts held in a StatsSnapshot
+func caseDatum(datum durcov.Datum) bool {
+	switch datum {
+	case durcov.Confirmed, durcov.Deaths, durcov.Recovered, durcov.Active:
+		return true
+	}
+	return false
+}
+
+func datumValue(stats *durcov.StatsSnapshot, datum durcov.Datum) int64 {
+	switch datum {
+	case durcov.Confirmed:
+		return stats.Confirmed
+	case durcov.Deaths:
+		return stats.Deaths
+	case durcov.Recovered:
+		return stats.Recovered
+	case durcov.Active:
+		return stats.Active()
+	}
+	return 0
+}
+
+// Send posts a message to the slack channel with the given id.
+func (sc *SlackChannel) Send(to string, text string) error {
+	return sc.post(context.Background(), "chat.postMessage", &slackMessage{Channel: to, Text: fitReply(sc, text)})
+}
+
+// post invokes a Web API method with the bot token.
+func (sc *SlackChannel) post(ctx context.Context, method string, params interface{}) error {
+	if sc.botToken == "" {
+		return errors.New("No slack bot token configured")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sc.apiBase+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+sc.botToken)
+
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	apiResp := &slackAPIResponse{}
+	err = json.NewDecoder(resp.Body).Decode(apiResp)
+	if err != nil {
+		return fmt.Errorf("Unable to decode slack %s response (status %d): %v", method, resp.StatusCode, err)
+	}
+	if !apiResp.OK {
+		return fmt.Errorf("Slack %s failed: %s", method, apiResp.Error)
+	}
+	return nil
+}
+
+// slackDigest represents the latest stats for a set of codes, posted to a slack channel every day
+type slackDigest struct {
+	channel string
+	codes   []string
+}
+
+// parseSlackDigests reads digests in the form "C0123ABCD=SG,US,TOTAL;C0456EFGH=IN"
+func parseSlackDigests(spec string) ([]*slackDigest, error) {
+	digests := []*slackDigest{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid slack digest %q. Expected channel=CODE,CODE", entry)
+		}
+		digest := &slackDigest{channel: strings.TrimSpace(parts[0])}
+		for _, code := range strings.Split(parts[1], ",") {
+			code = strings.ToUpper(strings.TrimSpace(code))
+			if !slackDigestCode.MatchString(code) {
+				return nil, fmt.Errorf("Invalid code %q in slack digest for %s", code, digest.channel)
+			}
+			digest.codes = append(digest.codes, code)
+		}
+		if len(digest.codes) > slackMaxDigestCodes {
+			return nil, fmt.Errorf("Slack digest for %s has %d codes. At most %d are allowed", digest.channel, len(digest.codes), slackMaxDigestCodes)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// digestMessage renders the digest. Codes without data are listed as unavailable rather than failing the digest.
+func (sc *SlackChannel) digestMessage(digest *slackDigest) *slackMessage {
+	title := "COVID-19 digest for " + sc.now().UTC().Format("2 Jan 2006")
+	blocks := []*slackBlock{{Type: "header", Text: &slackText{"plain_text", title}}}
+	for _, code := range digest.codes {
+		name, stats, err := latestStats(sc.view, code)
+		if err != nil {
+			log.Printf("Unable to fetch %s for slack digest: %v", code, err)
+			blocks = append(blocks, &slackBlock{Type: "section", Text: &slackText{"mrkdwn", fmt.Sprintf("*%s*\nNo data available right now.", code)}})
+			continue
+		}
+		if code != "TOTAL" {
+			name = fmt.Sprintf("[%s] %s", code, name)
+		}
+		blocks = append(blocks, slackStatsBlocks(name, stats)...)
+	}
+	return &slackMessage{Channel: digest.channel, Text: title, Blocks: blocks}
+}
+
+// runDigests posts every digest each day at the given time after midnight UTC until ctx is done.
+func (sc *SlackChannel) runDigests(ctx context.Context, digests []*slackDigest, at time.Duration) {
+	for {
+		wait := nextDigestTime(sc.now(), at).Sub(sc.now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		for _, digest := range digests {
+			err := sc.post(ctx, "chat.postMessage", sc.digestMessage(digest))
+			if err != nil {
+				log.Printf("Unable to post slack digest to %s: %v", digest.channel, err)
+			}
+		}
+	}
+}
+
+// nextDigestTime returns the first time strictly after now that's the given duration past midnight UTC.
+func nextDigestTime(now time.Time, at time.Duration) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(at)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// slackScreen answers URL verifications and events that mustn't be answered, and offers help for empty commands.
+func slackScreen(msg *InboundMessage) (*Reply, error) {
+	details, ok := msg.Platform.(*slackDetails)
+	if ok && (details.challenge != "" || details.acknowledgeOnly) {
+		return &Reply{}, nil
+	}
+	switch strings.ToUpper(msg.Text) {
+	case "", "HELP":
+		return &Reply{Text: slackHelp}, nil
+	}
+	return nil, nil
+}
+
+// handler returns the channel's webhook, serving both slash commands and the Events API.
+func (sc *SlackChannel) handler(bot *durcov.Bot, limiter durcov.RateLimiter) *ChannelHandler {
+	screens := []screen{slackScreen}
+	if limiter != nil {
+		screens = append(screens, throttleScreen(sc.Name(), limiter, func(msg *InboundMessage) string {
+			return "slack:" + msg.From
+		}))
+	}
+	return newChannelHandler(sc, bot, screens...)
+}
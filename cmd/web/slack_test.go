@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	testSlackSecret = "slack-signing-secret"
+	testSlackToken  = "xoxb-test"
+)
+
+// fakeSlackAPI represents the slack Web API. It records every message posted.
+type fakeSlackAPI struct {
+	mu     sync.Mutex
+	posted []*slackMessage
+}
+
+func (f *fakeSlackAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+testSlackToken {
+		w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+		return
+	}
+	message := &slackMessage{}
+	json.NewDecoder(r.Body).Decode(message)
+	f.mu.Lock()
+	f.posted = append(f.posted, message)
+	f.mu.Unlock()
+	w.Write([]byte(`{"ok": true}`))
+}
+
+func (f *fakeSlackAPI) messages() []*slackMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*slackMessage{}, f.posted...)
+}
+
+func newTestSlack(t *testing.T) (*SlackChannel, *ChannelHandler, *fakeSlackAPI) {
+	api := &fakeSlackAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	view := newTestView(t)
+	slack := newSlackChannel(testSlackSecret, testSlackToken, view)
+	slack.apiBase = server.URL
+	return slack, slack.handler(durcov.NewBot(view, 0), nil), api
+}
+
+func newSignedSlackRequest(slack *SlackChannel, contentType string, body string, sentAt time.Time) *http.Request {
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	req := httptest.NewRequest("POST", "/slack", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", slack.signature(timestamp, []byte(body)))
+	return req
+}
+
+func slashCommand(text string) string {
+	return url.Values{
+		"command":    {"/covid"},
+		"text":       {text},
+		"user_id":    {"U111"},
+		"channel_id": {"C222"},
+	}.Encode()
+}
+
+func mentionEvent(text string) string {
+	return `{"type": "event_callback", "event_id": "Ev1", "event": {"type": "app_mention", "user": "U111", "text": "` + text + `", "channel": "C222", "ts": "1600000000.000100"}}`
+}
+
+// fieldTexts returns the text of every field in the blocks
+func fieldTexts(blocks []*slackBlock) []string {
+	texts := []string{}
+	for _, block := range blocks {
+		for _, field := range block.Fields {
+			texts = append(texts, field.Text)
+		}
+	}
+	return texts
+}
+
+func TestSlackSlashCommand(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers in the channel with a field for each datapoint": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/x-www-form-urlencoded", slashCommand("deaths sg"), time.Now()))
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+
+			message := &slackMessage{}
+			err := json.NewDecoder(rec.Body).Decode(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.ResponseType != "in_channel" || message.Text != "[SG] Singapore Deaths: 1,822" {
+				t.Errorf("Response mismatch. Got=%+v", message)
+			}
+			fields := fieldTexts(message.Blocks)
+			if len(fields) != len(slackData) || fields[1] != "*Deaths*\n1,822" {
+				t.Errorf("Fields mismatch. Got=%q", fields)
+			}
+		},
+		"Answers errors without fields": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/x-www-form-urlencoded", slashCommand("deaths zz"), time.Now()))
+
+			message := &slackMessage{}
+			json.NewDecoder(rec.Body).Decode(message)
+			if message.Text != "Sorry, that code doesn't match any countries I know." || len(fieldTexts(message.Blocks)) != 0 {
+				t.Errorf("Response mismatch. Got=%+v", message)
+			}
+		},
+		"Offers help for empty commands": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/x-www-form-urlencoded", slashCommand(""), time.Now()))
+
+			message := &slackMessage{}
+			json.NewDecoder(rec.Body).Decode(message)
+			if message.Text != slackHelp {
+				t.Errorf("Response mismatch. Expected=%q Got=%q", slackHelp, message.Text)
+			}
+		},
+		"Rejects forged signatures": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			req := newSignedSlackRequest(slack, "application/x-www-form-urlencoded", slashCommand("deaths sg"), time.Now())
+			req.Header.Set("X-Slack-Signature", "v0=forged")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+		},
+		"Rejects replayed requests": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/x-www-form-urlencoded", slashCommand("deaths sg"), time.Now().Add(-10*time.Minute)))
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestSlackEvents(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers mentions in a thread": func(t *testing.T) {
+			slack, handler, api := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/json", mentionEvent("<@U0BOT> deaths SG"), time.Now()))
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+
+			posted := api.messages()
+			if len(posted) != 1 {
+				t.Fatalf("Posted message count mismatch. Expected=%d Got=%d", 1, len(posted))
+			}
+			if posted[0].Channel != "C222" || posted[0].ThreadTS != "1600000000.000100" || posted[0].Text != "[SG] Singapore Deaths: 1,822" {
+				t.Errorf("Posted message mismatch. Got=%+v", posted[0])
+			}
+			if len(fieldTexts(posted[0].Blocks)) != len(slackData) {
+				t.Errorf("Fields mismatch. Got=%q", fieldTexts(posted[0].Blocks))
+			}
+		},
+		"Acknowledges retries without answering": func(t *testing.T) {
+			slack, handler, api := newTestSlack(t)
+			req := newSignedSlackRequest(slack, "application/json", mentionEvent("<@U0BOT> deaths SG"), time.Now())
+			req.Header.Set("X-Slack-Retry-Num", "1")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != 200 || len(api.messages()) != 0 {
+				t.Errorf("Expected retry to be acknowledged only. Status=%d Posted=%d", rec.Code, len(api.messages()))
+			}
+		},
+		"Answers URL verification": func(t *testing.T) {
+			slack, handler, _ := newTestSlack(t)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newSignedSlackRequest(slack, "application/json", `{"type": "url_verification", "challenge": "abc123"}`, time.Now()))
+			if rec.Code != 200 || rec.Body.String() != "abc123" {
+				t.Errorf("Challenge mismatch. Status=%d Body=%q", rec.Code, rec.Body.String())
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestSlackDigests(t *testing.T) {
+	t.Run("Parses digest configuration", func(t *testing.T) {
+		digests, err := parseSlackDigests("C1=sg, total, europe; C2=IN;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(digests) != 2 || digests[0].channel != "C1" || strings.Join(digests[0].codes, ",") != "SG,TOTAL,EUROPE" || digests[1].channel != "C2" {
+			t.Errorf("Digest mismatch. Got=%+v %+v", digests[0], digests[1])
+		}
+		for _, invalid := range []string{"C1", "=SG", "C1=US-CA", "C1=" + strings.Repeat("SG,", slackMaxDigestCodes) + "SG"} {
+			if _, err := parseSlackDigests(invalid); err == nil {
+				t.Errorf("Expected error for %q", invalid)
+			}
+		}
+	})
+
+	t.Run("Schedules the next digest", func(t *testing.T) {
+		at := 9 * time.Hour
+		before := time.Date(2020, 12, 7, 8, 0, 0, 0, time.UTC)
+		if next := nextDigestTime(before, at); !next.Equal(time.Date(2020, 12, 7, 9, 0, 0, 0, time.UTC)) {
+			t.Errorf("Next digest mismatch. Got=%v", next)
+		}
+		after := time.Date(2020, 12, 7, 9, 0, 0, 0, time.UTC)
+		if next := nextDigestTime(after, at); !next.Equal(time.Date(2020, 12, 8, 9, 0, 0, 0, time.UTC)) {
+			t.Errorf("Next digest mismatch. Got=%v", next)
+		}
+	})
+
+	t.Run("Posts every code with unavailable codes noted", func(t *testing.T) {
+		slack, _, api := newTestSlack(t)
+		fired := make(chan time.Time, 1)
+		slack.now = func() time.Time {
+			// The digest is due as soon as it's scheduled
+			select {
+			case now := <-fired:
+				return now
+			default:
+				return time.Date(2020, 12, 7, 9, 0, 0, 0, time.UTC)
+			}
+		}
+		fired <- time.Date(2020, 12, 7, 8, 59, 59, 999000000, time.UTC)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			slack.runDigests(ctx, []*slackDigest{{"C1", []string{"SG", "ZZ", "TOTAL"}}}, 9*time.Hour)
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(api.messages()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		posted := api.messages()
+		if len(posted) != 1 {
+			t.Fatalf("Posted message count mismatch. Expected=%d Got=%d", 1, len(posted))
+		}
+		if posted[0].Channel != "C1" || posted[0].Text != "COVID-19 digest for 7 Dec 2020" {
+			t.Errorf("Digest mismatch. Got=%+v", posted[0])
+		}
+		if fields := fieldTexts(posted[0].Blocks); len(fields) != 2*len(slackData) {
+			t.Errorf("Fields mismatch. Expected=%d Got=%d", 2*len(slackData), len(fields))
+		}
+		if !strings.Contains(posted[0].Blocks[3].Text.Text, "ZZ") {
+			t.Errorf("Expected ZZ to be noted as unavailable. Got=%+v", posted[0].Blocks[3].Text)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/TuhinNair/durcov"
+)
+
+// smsSegmentLength is the number of GSM-7 septets that fit in a single (unconcatenated) SMS segment
+const smsSegmentLength = 160
+
+// gsm7Basic is the GSM 03.38 basic character set. Each character takes one septet.
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension characters are sent with an escape so each takes two septets
+const gsm7Extension = "^{}\\[~]|€\f"
+
+// gsm7Substitutes replaces common characters outside GSM-7 that would otherwise force the whole message into UCS-2
+var gsm7Substitutes = strings.NewReplacer(
+	"‘", "'", "’", "'", "“", "\"", "”", "\"",
+	"–", "-", "—", "-", "…", "...", "\t", " ",
+)
+
+// smsMarkup matches the WhatsApp markdown markers (bold, italic, strikethrough and monospace)
+var smsMarkup = regexp.MustCompile("```|[*_~`]")
+
+// smsCondensers shorten replies that don't fit in a single segment. They're applied in order until the reply fits.
+var smsCondensers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(strings.Replace(regexp.QuoteMeta(durcov.StaleNotice), "%s", "(.+)", 1)), " (data from $1, may be outdated)"},
+	{regexp.MustCompile(`\s*\n\s*`), " "},
+	{regexp.MustCompile(`Active Cases`), "Active"},
+	{regexp.MustCompile(` \(data from .+, may be outdated\)`), " (may be outdated)"},
+}
+
+// formatSMS formats a bot reply for plain SMS. Markdown and characters outside GSM-7 (e.g. emoji) are removed
+// and the reply is condensed to fit in a single segment where possible, truncating as a last resort.
+func formatSMS(reply string) string {
+	reply = smsMarkup.ReplaceAllString(reply, "")
+	reply = toGSM7(reply)
+
+	for _, condenser := range smsCondensers {
+		if gsm7Length(reply) <= smsSegmentLength {
+			return reply
+		}
+		reply = condenser.pattern.ReplaceAllString(reply, condenser.replacement)
+	}
+	return truncateGSM7(reply, smsSegmentLength)
+}
+
+// toGSM7 substitutes or drops every character that can't be encoded in GSM-7.
+func toGSM7(text string) string {
+	text = gsm7Substitutes.Replace(text)
+	var encodable strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(gsm7Basic, r) || strings.ContainsRune(gsm7Extension, r) {
+			encodable.WriteRune(r)
+		}
+	}
+	// Dropping characters can leave doubled or trailing spaces behind
+	return strings.TrimSpace(strings.Join(strings.FieldsFunc(encodable.String(), func(r rune) bool { return r == ' ' }), " "))
+}
+
+// gsm7Length returns the number of septets needed to send the text. The text must only contain GSM-7 characters.
+func gsm7Length(text string) int {
+	length := 0
+	for _, r := range text {
+		length++
+		if strings.ContainsRune(gsm7Extension, r) {
+			length++
+		}
+	}
+	return length
+}
+
+// truncateGSM7 cuts the text to at most limit septets, marking the cut with an ellipsis.
+func truncateGSM7(text string, limit int) string {
+	if gsm7Length(text) <= limit {
+		return text
+	}
+	const ellipsis = "..."
+	truncated := []rune{}
+	length := len(ellipsis)
+	for _, r := range text {
+		size := gsm7Length(string(r))
+		if length+size > limit {
+			break
+		}
+		truncated = append(truncated, r)
+		length += size
+	}
+	return strings.TrimSpace(string(truncated)) + ellipsis
+}
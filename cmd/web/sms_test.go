@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatSMS(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"[SG] Singapore Deaths: 1,822",
+			"[SG] Singapore Deaths: 1,822",
+		},
+		{
+			"Oops, I've got myself confused :(",
+			"Oops, I've got myself confused :(",
+		},
+		{
+			"*Total Deaths:* _500,000_",
+			"Total Deaths: 500,000",
+		},
+		{
+			"Stay safe 😷 and wash your hands 🧼!",
+			"Stay safe and wash your hands !",
+		},
+		{
+			"Sorry, I’m not sure how to respond to that…",
+			"Sorry, I'm not sure how to respond to that...",
+		},
+		{
+			"[SG] Singapore Deaths: 1,822\n(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)",
+			"[SG] Singapore Deaths: 1,822\n(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)",
+		},
+		{
+			"[VC] Saint Vincent and the Grenadines Active Cases: 1,234,567\n(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)\nSorry, the rest of the countries will have to wait.",
+			"[VC] Saint Vincent and the Grenadines Active Cases: 1,234,567 (data from 4 Dec 2020, may be outdated)\nSorry, the rest of the countries will have to wait.",
+		},
+	}
+
+	for _, test := range tests {
+		formatted := formatSMS(test.input)
+		if formatted != test.expected {
+			t.Errorf("Format mismatch.\nExpected=%q\nGot=%q", test.expected, formatted)
+		}
+	}
+}
+
+func TestFormatSMSFitsOneSegment(t *testing.T) {
+	tests := map[string]string{
+		"Condenses before truncating": "[VC] Saint Vincent and the Grenadines Active Cases: 1,234,567\n(Heads up: this data was last updated 4 Dec 2020 and may be out of date.)\n" + strings.Repeat("Sorry, more. ", 5),
+		"Truncates as a last resort":  strings.Repeat("word ", 50),
+		"Counts extension characters": strings.Repeat("[x] ", 50),
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			formatted := formatSMS(input)
+			if length := gsm7Length(formatted); length > smsSegmentLength {
+				t.Errorf("Length mismatch. Expected<=%d Got=%d (%q)", smsSegmentLength, length, formatted)
+			}
+		})
+	}
+
+	if truncated := formatSMS(strings.Repeat("word ", 50)); !strings.HasSuffix(truncated, "...") {
+		t.Errorf("Truncated replies should end with an ellipsis. Got=%q", truncated)
+	}
+	if condensed := formatSMS("Sorry, " + strings.Repeat("the rest will have to wait. ", 6)); !strings.HasPrefix(condensed, "Sorry, the rest") {
+		t.Errorf("Condensed replies should keep their opening. Got=%q", condensed)
+	}
+	if length := gsm7Length("[]€"); length != 6 {
+		t.Errorf("GSM-7 length mismatch. Expected=%d Got=%d", 6, length)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	telegramAPIBase = "https://api.telegram.org"
+	// telegramPollTimeout is how long getUpdates waits for an update before returning empty
+	telegramPollTimeout = 30 * time.Second
+)
+
+const telegramWelcome = "Hi! Send CASES or DEATHS followed by a two letter country code (e.g. CASES SG), a region code (e.g. CASES US-CA) or TOTAL. Or tap one of the buttons below."
+
+// telegramKeyboard offers the common commands as buttons under every reply
+var telegramKeyboard = &telegramInlineKeyboard{
+	InlineKeyboard: [][]telegramButton{
+		{{"Cases total", "CASES TOTAL"}, {"Deaths total", "DEATHS TOTAL"}},
+	},
+}
+
+// TelegramChannel represents a telegram bot. Updates arrive either through a webhook or by long polling.
+type TelegramChannel struct {
+	token string
+	// secretToken is set on the webhook and checked on every update sent to it
+	secretToken string
+	apiBase     string
+	client      *http.Client
+}
+
+func newTelegramChannel(token string, secretToken string) *TelegramChannel {
+	return &TelegramChannel{
+		token:       token,
+		secretToken: secretToken,
+		apiBase:     telegramAPIBase,
+		// Long polls are held open for telegramPollTimeout
+		client: &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+	}
+}
+
+type telegramUpdate struct {
+	UpdateID      int64                  `json:"update_id"`
+	Message       *telegramMessage       `json:"message"`
+	CallbackQuery *telegramCallbackQuery `json:"callback_query"`
+}
+
+type telegramMessage struct {
+	MessageID int64        `json:"message_id"`
+	Chat      telegramChat `json:"chat"`
+	Text      string       `json:"text"`
+}
+
+type telegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type telegramCallbackQuery struct {
+	ID      string           `json:"id"`
+	Message *telegramMessage `json:"message"`
+	Data    string           `json:"data"`
+}
+
+type telegramInlineKeyboard struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type telegramAPIResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// telegramDetails holds what's needed to reply to a telegram update
+type telegramDetails struct {
+	// callbackQueryID is set when the update is a button press. The press has to be answered to stop the button's spinner.
+	callbackQueryID string
+}
+
+func (tc *TelegramChannel) Name() string {
+	return "telegram"
+}
+
+// Capabilities describes telegram messages as sent by the channel. Replies are plain text so brackets in them aren't parsed as markdown.
+func (tc *TelegramChannel) Capabilities() Capabilities {
+	return Capabilities{Media: true, Markdown: false, MaxLength: 4096}
+}
+
+// ValidateRequest checks the secret token telegram sends with every update.
+// Webhook updates are refused when no secret token is configured.
+func (tc *TelegramChannel) ValidateRequest(r *http.Request) error {
+	if tc.secretToken == "" {
+		return errors.New("No telegram secret token configured")
+	}
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(tc.secretToken)) != 1 {
+		return errors.New("Telegram secret token mismatch")
+	}
+	return nil
+}
+
+func (tc *TelegramChannel) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	update := &telegramUpdate{}
+	err := json.NewDecoder(r.Body).Decode(update)
+	if err != nil {
+		return nil, err
+	}
+	msg := tc.parseUpdate(update)
+	if msg == nil {
+		return nil, &IgnoredRequestError{fmt.Sprintf("Unsupported telegram update %d", update.UpdateID)}
+	}
+	return msg, nil
+}
+
+// parseUpdate reads the message from a message or button press. Returns nil for any other update.
+// Messages without text (e.g. stickers) are still answered so the user knows they weren't understood.
+func (tc *TelegramChannel) parseUpdate(update *telegramUpdate) *InboundMessage {
+	updateID := strconv.FormatInt(update.UpdateID, 10)
+	if query := update.CallbackQuery; query != nil && query.Message != nil {
+		chatID := strconv.FormatInt(query.Message.Chat.ID, 10)
+		return &InboundMessage{ID: updateID, From: chatID, Text: query.Data, Platform: &telegramDetails{query.ID}}
+	}
+	if update.Message != nil {
+		chatID := strconv.FormatInt(update.Message.Chat.ID, 10)
+		return &InboundMessage{ID: updateID, From: chatID, Text: normalizeTelegramCommand(update.Message.Text), Platform: &telegramDetails{}}
+	}
+	return nil
+}
+
+// normalizeTelegramCommand turns telegram style commands (e.g. "/cases@durcov_bot SG") into bot commands ("cases SG").
+func normalizeTelegramCommand(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return text
+	}
+	fields := strings.Fields(text[1:])
+	if len(fields) == 0 {
+		return text
+	}
+	if i := strings.Index(fields[0], "@"); i >= 0 {
+		fields[0] = fields[0][:i]
+	}
+	return strings.Join(fields, " ")
+}
+
+func (tc *TelegramChannel) FormatReply(reply string) string {
+	return reply
+}
+
+// Reply answers with a separate API call so webhook and polled updates are answered the same way.
+func (tc *TelegramChannel) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	err := tc.deliver(msg, reply)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(200)
+	return nil
+}
+
+func (tc *TelegramChannel) deliver(msg *InboundMessage, reply *Reply) error {
+	details, ok := msg.Platform.(*telegramDetails)
+	if ok && details.callbackQueryID != "" {
+		err := tc.call(context.Background(), "answerCallbackQuery", map[string]interface{}{"callback_query_id": details.callbackQueryID}, nil)
+		if err != nil {
+			// The button's spinner times out by itself so the reply is still worth sending
+			log.Printf("Unable to answer telegram callback query: %v", err)
+		}
+	}
+	if reply.Text == "" {
+		return nil
+	}
+	return tc.Send(msg.From, reply.Text)
+}
+
+// Send messages the chat with the given id. Every message offers the common commands as buttons.
+func (tc *TelegramChannel) Send(to string, text string) error {
+	chatID, err := strconv.ParseInt(to, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid telegram chat id %q", to)
+	}
+	return tc.call(context.Background(), "sendMessage", map[string]interface{}{
+		"chat_id":      chatID,
+		"text":         text,
+		"reply_markup": telegramKeyboard,
+	}, nil)
+}
+
+// setWebhook points telegram at the webhook URL. Telegram sends the secret token with every update.
+func (tc *TelegramChannel) setWebhook(url string) error {
+	return tc.call(context.Background(), "setWebhook", map[string]interface{}{
+		"url":             url,
+		"secret_token":    tc.secretToken,
+		"allowed_updates": []string{"message", "callback_query"},
+	}, nil)
+}
+
+// poll answers updates fetched by long polling until ctx is done. Meant for local development where
+// telegram can't reach a webhook. Any webhook set on the bot is removed because telegram doesn't allow both.
+func (tc *TelegramChannel) poll(ctx context.Context, handler *ChannelHandler) error {
+	err := tc.call(ctx, "deleteWebhook", map[string]interface{}{}, nil)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for ctx.Err() == nil {
+		updates := []*telegramUpdate{}
+		err := tc.call(ctx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message", "callback_query"},
+		}, &updates)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Unable to fetch telegram updates: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			// Confirms the update so it isn't fetched again
+			offset = update.UpdateID + 1
+			msg := tc.parseUpdate(update)
+			if msg == nil {
+				continue
+			}
+			reply, err := handler.answer(msg)
+			if err != nil {
+				log.Printf("Unable to screen telegram message: %v", err)
+				continue
+			}
+			err = tc.deliver(msg, reply)
+			if err != nil {
+				log.Printf("Unable to respond on telegram: %v", err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// call invokes a Bot API method. The result is decoded into result when it's not nil.
+func (tc *TelegramChannel) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tc.apiBase+"/bot"+tc.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return withoutURL(method, err)
+	}
+	defer resp.Body.Close()
+
+	apiResp := &telegramAPIResponse{}
+	err = json.NewDecoder(resp.Body).Decode(apiResp)
+	if err != nil {
+		return fmt.Errorf("Unable to decode telegram %s response (status %d): %v", method, resp.StatusCode, err)
+	}
+	if !apiResp.OK {
+		return fmt.Errorf("Telegram %s failed: %s", method, apiResp.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(apiResp.Result, result)
+}
+
+// withoutURL drops the request URL from the error since it contains the bot token, which would otherwise be logged
+func withoutURL(method string, err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("Telegram %s request failed: %v", method, urlErr.Err)
+	}
+	return err
+}
+
+// telegramStartScreen greets users starting a chat with the bot
+func telegramStartScreen(msg *InboundMessage) (*Reply, error) {
+	switch strings.ToUpper(msg.Text) {
+	case "START", "HELP":
+		return &Reply{Text: telegramWelcome}, nil
+	}
+	return nil, nil
+}
+
+// handler returns the channel's webhook. Also used to answer polled updates.
+func (tc *TelegramChannel) handler(bot *durcov.Bot, limiter durcov.RateLimiter) *ChannelHandler {
+	screens := []screen{}
+	if limiter != nil {
+		screens = append(screens, throttleScreen(tc.Name(), limiter, func(msg *InboundMessage) string {
+			return "telegram:" + msg.From
+		}))
+	}
+	screens = append(screens, telegramStartScreen)
+	return newChannelHandler(tc, bot, screens...)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTelegramToken  = "123:test-token"
+	testTelegramSecret = "test-secret"
+)
+
+// fakeTelegramAPI represents the Bot API. It records every call and hands out queued updates to getUpdates.
+type fakeTelegramAPI struct {
+	mu      sync.Mutex
+	calls   []telegramCall
+	updates []*telegramUpdate
+}
+
+type telegramCall struct {
+	method string
+	params map[string]interface{}
+}
+
+func (f *fakeTelegramAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + testTelegramToken + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"ok": false, "description": "Unauthorized"}`))
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+	params := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&params)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, telegramCall{method, params})
+	result := interface{}(true)
+	if method == "getUpdates" {
+		result = f.updates
+		f.updates = nil
+	}
+	f.mu.Unlock()
+
+	if method == "getUpdates" && result.([]*telegramUpdate) == nil {
+		// Stands in for the long poll so the poller doesn't spin
+		time.Sleep(10 * time.Millisecond)
+		result = []*telegramUpdate{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (f *fakeTelegramAPI) called(method string) []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := []telegramCall{}
+	for _, call := range f.calls {
+		if call.method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func newTestTelegram(t *testing.T) (*TelegramChannel, *ChannelHandler, *fakeTelegramAPI) {
+	api := &fakeTelegramAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	telegram := newTelegramChannel(testTelegramToken, testTelegramSecret)
+	telegram.apiBase = server.URL
+	return telegram, telegram.handler(newTestBot(t), nil), api
+}
+
+func postTelegramUpdate(handler *ChannelHandler, secret string, update string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/telegram", strings.NewReader(update))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTelegramWebhook(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers commands with buttons": func(t *testing.T) {
+			_, handler, api := newTestTelegram(t)
+			rec := postTelegramUpdate(handler, testTelegramSecret, `{"update_id": 1, "message": {"message_id": 7, "chat": {"id": 42}, "text": "/deaths@durcov_bot SG"}}`)
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			sent := api.called("sendMessage")
+			if len(sent) != 1 {
+				t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", 1, len(sent))
+			}
+			if sent[0].params["chat_id"] != float64(42) || sent[0].params["text"] != "[SG] Singapore Deaths: 1,822" {
+				t.Errorf("Sent message mismatch. Got=%v", sent[0].params)
+			}
+			keyboard, ok := sent[0].params["reply_markup"].(map[string]interface{})
+			if !ok || keyboard["inline_keyboard"] == nil {
+				t.Errorf("Expected inline keyboard. Got=%v", sent[0].params["reply_markup"])
+			}
+		},
+		"Answers button presses": func(t *testing.T) {
+			_, handler, api := newTestTelegram(t)
+			postTelegramUpdate(handler, testTelegramSecret, `{"update_id": 2, "callback_query": {"id": "cb1", "data": "DEATHS TOTAL", "message": {"message_id": 8, "chat": {"id": 42}}}}`)
+
+			answered := api.called("answerCallbackQuery")
+			if len(answered) != 1 || answered[0].params["callback_query_id"] != "cb1" {
+				t.Errorf("Callback query answer mismatch. Got=%v", answered)
+			}
+			sent := api.called("sendMessage")
+			if len(sent) != 1 || sent[0].params["text"] != "Total Deaths: 500,000" {
+				t.Errorf("Sent message mismatch. Got=%v", sent)
+			}
+		},
+		"Greets new chats": func(t *testing.T) {
+			_, handler, api := newTestTelegram(t)
+			postTelegramUpdate(handler, testTelegramSecret, `{"update_id": 3, "message": {"message_id": 9, "chat": {"id": 42}, "text": "/start"}}`)
+			sent := api.called("sendMessage")
+			if len(sent) != 1 || sent[0].params["text"] != telegramWelcome {
+				t.Errorf("Sent message mismatch. Got=%v", sent)
+			}
+		},
+		"Acknowledges unsupported updates": func(t *testing.T) {
+			_, handler, api := newTestTelegram(t)
+			rec := postTelegramUpdate(handler, testTelegramSecret, `{"update_id": 6, "edited_message": {"message_id": 7, "chat": {"id": 42}, "text": "CASES SG"}}`)
+			if rec.Code != 200 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("Expected an empty response. Got=%s", rec.Body.String())
+			}
+			if len(api.called("sendMessage")) != 0 {
+				t.Error("Unsupported updates should not be answered")
+			}
+		},
+		"Rejects updates without the secret token": func(t *testing.T) {
+			_, handler, api := newTestTelegram(t)
+			rec := postTelegramUpdate(handler, "forged", `{"update_id": 4, "message": {"message_id": 10, "chat": {"id": 42}, "text": "CASES TOTAL"}}`)
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+			if len(api.called("sendMessage")) != 0 {
+				t.Error("Unauthenticated updates should not be answered")
+			}
+		},
+		"Rejects updates when no secret token is configured": func(t *testing.T) {
+			telegram, handler, _ := newTestTelegram(t)
+			telegram.secretToken = ""
+			rec := postTelegramUpdate(handler, "", `{"update_id": 5, "message": {"message_id": 11, "chat": {"id": 42}, "text": "CASES TOTAL"}}`)
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestTelegramPolling(t *testing.T) {
+	telegram, handler, api := newTestTelegram(t)
+	api.updates = []*telegramUpdate{
+		{UpdateID: 10, Message: &telegramMessage{MessageID: 1, Chat: telegramChat{42}, Text: "DEATHS SG"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- telegram.poll(ctx, handler)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(api.called("getUpdates")) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected polling to stop with the context. Got=%v", err)
+	}
+
+	if len(api.called("deleteWebhook")) != 1 {
+		t.Error("Expected the webhook to be removed before polling")
+	}
+	sent := api.called("sendMessage")
+	if len(sent) != 1 || sent[0].params["text"] != "[SG] Singapore Deaths: 1,822" {
+		t.Errorf("Sent message mismatch. Got=%v", sent)
+	}
+	polls := api.called("getUpdates")
+	if polls[1].params["offset"] != float64(11) {
+		t.Errorf("Offset mismatch. Expected=%d Got=%v", 11, polls[1].params["offset"])
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	telegram, _, _ := newTestTelegram(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	telegram.apiBase = server.URL
+
+	err := telegram.call(context.Background(), "getUpdates", map[string]interface{}{}, nil)
+	if err == nil {
+		t.Fatal("Expected an error calling a closed server")
+	}
+	if strings.Contains(err.Error(), testTelegramToken) {
+		t.Errorf("Expected the error not to contain the token. Got=%v", err)
+	}
+}
+
+func TestNormalizeTelegramCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"CASES SG", "CASES SG"},
+		{"/cases SG", "cases SG"},
+		{"/deaths@durcov_bot   total", "deaths total"},
+		{"/start", "start"},
+		{"/", "/"},
+	}
+	for _, test := range tests {
+		if got := normalizeTelegramCommand(test.input); got != test.expected {
+			t.Errorf("Command mismatch. Input=%s Expected=%s Got=%s", test.input, test.expected, got)
+		}
+	}
+}
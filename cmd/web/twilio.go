@@ -1,18 +1,74 @@
 package main
 
 import (
+	"context"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/kevinburke/twilio-go"
+
+	"github.com/TuhinNair/durcov"
 )
 
-// TwilioBot represents a twilio client backed by a bot
+// TwilioBot represents a twilio account backed by a bot. It answers both WhatsApp and SMS.
 type TwilioBot struct {
 	client    *twilio.Client
 	validator *twilioValidator
-	bot       *Bot
+	bot       *durcov.Bot
+	mode      responseMode
+	// outbox queues REST replies for delivery by a worker pool. Replies are sent inline when nil.
+	outbox *Outbox
+	// statuses records delivery statuses reported to the status callback. Replies don't ask for callbacks when nil.
+	statuses durcov.DeliveryStatusStore
+	// suppressions holds senders who opted out or were blocked. Opt-out keywords aren't handled when nil.
+	suppressions durcov.SuppressionList
+	// limiter caps how often each sender is answered. Senders aren't limited when nil.
+	limiter durcov.RateLimiter
+	// senders are the numbers messages that aren't replies are sent from, by transport. Optional.
+	senders map[twilioTransport]string
+}
+
+// responseMode selects how replies are delivered to twilio
+type responseMode int
+
+const (
+	// restResponse sends replies with a separate call to the messages API. Needed for long-running or media replies.
+	restResponse responseMode = iota
+	// twimlResponse returns replies as TwiML in the body of the webhook response
+	twimlResponse
+)
+
+func parseResponseMode(mode string) (responseMode, error) {
+	switch mode {
+	case "", "rest":
+		return restResponse, nil
+	case "twiml":
+		return twimlResponse, nil
+	}
+	return restResponse, fmt.Errorf("Unknown twilio response mode %q. Expected rest or twiml", mode)
+}
+
+// twilioTransport identifies how messages reach twilio. Each transport is a separate channel.
+type twilioTransport int
+
+const (
+	whatsappTransport twilioTransport = iota
+	smsTransport
+)
+
+func (t twilioTransport) String() string {
+	switch t {
+	case whatsappTransport:
+		return "whatsapp"
+	case smsTransport:
+		return "sms"
+	}
+	return "unknown"
 }
 
 type twilioValidator struct {
@@ -28,61 +84,177 @@ type twilioRequest struct {
 	to          string
 	from        string
 	requestBody string
+	// messageSid identifies the inbound message. Optional.
+	messageSid string
 }
 
 type twilioResponse struct {
-	to           string
-	from         string
-	responseBody string
-}
-
-func (tr *twilioRequest) toResponse(responseBody string) *twilioResponse {
-	return &twilioResponse{to: tr.from, from: tr.to, responseBody: responseBody}
+	to             string
+	from           string
+	responseBody   string
+	statusCallback string
 }
 
 func (tr *twilioResponse) respond(twilioClient *twilio.Client) error {
-	_, err := twilioClient.Messages.SendMessage(tr.from, tr.to, tr.responseBody, nil)
+	_, err := sendMessage(twilioClient, tr.from, tr.to, tr.responseBody, tr.statusCallback)
 	return err
 }
 
-func (tb *TwilioBot) handleWhatsapp(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		log.Println("Method Not Allowed")
-		w.Header().Set("Allow", "POST")
-		http.Error(w, http.StatusText(405), 405)
-		return
+// sendMessage sends a message through the messages API, asking for delivery statuses when a callback is given.
+func sendMessage(twilioClient *twilio.Client, from string, to string, body string, statusCallback string) (*twilio.Message, error) {
+	data := url.Values{}
+	data.Set("Body", body)
+	data.Set("From", from)
+	data.Set("To", to)
+	if statusCallback != "" {
+		data.Set("StatusCallback", statusCallback)
 	}
+	return twilioClient.Messages.Create(context.Background(), data)
+}
 
-	err := tb.validator.validateRequest(r)
+type twiMLResponse struct {
+	XMLName xml.Name      `xml:"Response"`
+	Message *twiMLMessage `xml:"Message,omitempty"`
+}
+
+type twiMLMessage struct {
+	// Action is the URL twilio reports the reply's delivery statuses to
+	Action string `xml:"action,attr,omitempty"`
+	Method string `xml:"method,attr,omitempty"`
+	Body   string `xml:",chardata"`
+}
+
+// writeTwiML writes the response as TwiML for twilio to deliver as the reply.
+func (tr *twilioResponse) writeTwiML(w http.ResponseWriter) error {
+	response := &twiMLResponse{}
+	// An empty response tells twilio not to reply
+	if tr.responseBody != "" {
+		response.Message = &twiMLMessage{Body: tr.responseBody}
+		if tr.statusCallback != "" {
+			response.Message.Action = tr.statusCallback
+			response.Message.Method = "POST"
+		}
+	}
+	body, err := xml.Marshal(response)
 	if err != nil {
-		log.Printf("Twilio not authenticated: %v", err)
-		http.Error(w, http.StatusText(401), 401)
-		return
+		return err
 	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(200)
+	_, err = w.Write(append([]byte(xml.Header), body...))
+	return err
+}
 
-	twilioRequestData, err := tb.parseRequest(r)
-	if err != nil {
-		log.Printf("Malformed request: %v", err)
-		http.Error(w, http.StatusText(400), 400)
-		return
+// handler returns the webhook for the transport. Opt-outs are honoured before rate limits are applied.
+func (tb *TwilioBot) handler(transport twilioTransport) *ChannelHandler {
+	screens := []screen{}
+	if tb.suppressions != nil {
+		screens = append(screens, optOutScreen(tb.suppressions, transport))
+	}
+	if tb.limiter != nil {
+		// WhatsApp and SMS share a number's limit
+		screens = append(screens, throttleScreen(transport.String(), tb.limiter, func(msg *InboundMessage) string {
+			return durcov.NormalizeAddress(msg.From)
+		}))
+	}
+	return newChannelHandler(&twilioChannel{tb, transport}, tb.bot, screens...)
+}
+
+// twilioChannel represents messaging through twilio on a single transport
+type twilioChannel struct {
+	tb        *TwilioBot
+	transport twilioTransport
+}
+
+func (tc *twilioChannel) Name() string {
+	return tc.transport.String()
+}
+
+func (tc *twilioChannel) Capabilities() Capabilities {
+	if tc.transport == smsTransport {
+		return Capabilities{Media: false, Markdown: false, MaxLength: smsSegmentLength}
 	}
+	return Capabilities{Media: true, Markdown: true, MaxLength: 1600}
+}
+
+func (tc *twilioChannel) ValidateRequest(r *http.Request) error {
+	return tc.tb.validator.validateRequest(r)
+}
 
-	err = tb.respond(twilioRequestData)
+func (tc *twilioChannel) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	reqData, err := tc.tb.parseRequest(r)
 	if err != nil {
-		log.Printf("Unable to respond: %v", err)
-		http.Error(w, http.StatusText(500), 500)
-	} else {
-		w.WriteHeader(200)
+		return nil, err
+	}
+	return &InboundMessage{ID: reqData.messageSid, From: reqData.from, To: reqData.to, Text: reqData.requestBody}, nil
+}
+
+func (tc *twilioChannel) FormatReply(reply string) string {
+	if tc.transport == smsTransport {
+		return formatSMS(reply)
 	}
-	return
+	return reply
 }
 
-func (tb *TwilioBot) respond(reqData *twilioRequest) error {
-	reqMsg := reqData.requestBody
-	resMsg := tb.bot.respond(reqMsg)
+// Reply answers in the webhook response in TwiML mode. Otherwise the reply is queued in the outbox (if there is one)
+// or sent right away. Compliance replies are never queued because the outbox drops messages to opted-out senders.
+func (tc *twilioChannel) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	twilioResp := &twilioResponse{to: msg.From, from: msg.To, responseBody: reply.Text}
+	if reply.Text != "" {
+		twilioResp.statusCallback = tc.tb.statusCallbackURL(msg.ID)
+	}
 
-	twilioResp := reqData.toResponse(resMsg)
+	if tc.tb.mode == twimlResponse {
+		err := twilioResp.writeTwiML(w)
+		if err != nil {
+			// The response has already been (partly) written so there's nothing left to report the error on
+			log.Printf("Unable to write TwiML response: %v", err)
+		}
+		return nil
+	}
+
+	if reply.Text != "" {
+		err := tc.tb.deliver(twilioResp, !reply.Compliance)
+		if err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(200)
+	return nil
+}
+
+// Send delivers a message from the transport's sender number. Suppressed recipients are skipped.
+func (tc *twilioChannel) Send(to string, text string) error {
+	from, ok := tc.tb.senders[tc.transport]
+	if !ok {
+		return fmt.Errorf("No %s sender number configured", tc.transport)
+	}
+	if tc.transport == whatsappTransport && !strings.HasPrefix(to, "whatsapp:") {
+		to = "whatsapp:" + to
+	}
+	if tc.tb.suppressions != nil {
+		suppressed, err := tc.tb.suppressions.IsSuppressed(to)
+		if err != nil {
+			return err
+		}
+		if suppressed {
+			log.Printf("Not sending %s message to a suppressed recipient", tc.transport)
+			return nil
+		}
+	}
+	twilioResp := &twilioResponse{to: to, from: from, responseBody: fitReply(tc, text), statusCallback: tc.tb.statusCallbackURL("")}
+	return tc.tb.deliver(twilioResp, true)
+}
+
+// deliver queues the response in the outbox when allowed and there is one. Otherwise it's sent right away.
+func (tb *TwilioBot) deliver(twilioResp *twilioResponse, queue bool) error {
+	if queue && tb.outbox != nil {
+		return tb.outbox.enqueue(twilioResp)
+	}
 	err := twilioResp.respond(tb.client)
+	if err != nil {
+		twilioSendFailures.Inc()
+	}
 	return err
 }
 
@@ -95,7 +267,7 @@ func (tb *TwilioBot) parseRequest(r *http.Request) (*twilioRequest, error) {
 	to, ok := r.Form["To"]
 	if !ok {
 		log.Println("Request Error: Missing `To` key in request form data.")
-		return nil, err
+		return nil, errors.New("Request Error: Missing `To` key in request form data")
 	}
 	if len(to) == 0 {
 		log.Println("to is nil")
@@ -106,7 +278,7 @@ func (tb *TwilioBot) parseRequest(r *http.Request) (*twilioRequest, error) {
 	from, ok := r.Form["From"]
 	if !ok {
 		log.Println("Request Error: Missing `From` key in request form data.")
-		return nil, err
+		return nil, errors.New("Request Error: Missing `From` key in request form data")
 	}
 	if len(from) == 0 {
 		log.Println("No from number")
@@ -117,7 +289,7 @@ func (tb *TwilioBot) parseRequest(r *http.Request) (*twilioRequest, error) {
 	body, ok := r.Form["Body"]
 	if !ok {
 		log.Println("Request Error: Missing `Body` key in request form data.")
-		return nil, err
+		return nil, errors.New("Request Error: Missing `Body` key in request form data")
 	}
 	if len(body) == 0 {
 		log.Println("No message in body")
@@ -125,6 +297,57 @@ func (tb *TwilioBot) parseRequest(r *http.Request) (*twilioRequest, error) {
 	}
 	messageBody := body[0]
 
-	twilioReq := twilioRequest{toAddress, fromAddress, messageBody}
+	twilioReq := twilioRequest{toAddress, fromAddress, messageBody, r.Form.Get("MessageSid")}
 	return &twilioReq, nil
 }
+
+// statusCallbackURL returns the URL twilio reports the reply's delivery statuses to.
+// The inbound message sid is carried in the query so statuses can be linked to the request that prompted the reply.
+// Returns an empty string when statuses aren't recorded.
+func (tb *TwilioBot) statusCallbackURL(inboundSid string) string {
+	if tb.statuses == nil {
+		return ""
+	}
+	callback := tb.validator.host + "/twilio/status"
+	if inboundSid != "" {
+		callback += "?" + url.Values{"inbound": {inboundSid}}.Encode()
+	}
+	return callback
+}
+
+func (tb *TwilioBot) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	err := tb.validator.validateRequest(r)
+	if err != nil {
+		log.Printf("Twilio not authenticated: %v", err)
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	update := &durcov.StatusUpdate{
+		MessageSid: r.PostForm.Get("MessageSid"),
+		InboundSid: r.URL.Query().Get("inbound"),
+		Status:     r.PostForm.Get("MessageStatus"),
+		ErrorCode:  r.PostForm.Get("ErrorCode"),
+	}
+	if update.MessageSid == "" || update.Status == "" {
+		log.Println("Request Error: Missing `MessageSid` or `MessageStatus` in status callback.")
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	twilioMessageStatuses.WithLabelValues(update.Status).Inc()
+	err = tb.statuses.RecordStatus(update)
+	if err != nil {
+		log.Printf("Unable to record status of message %s: %v", update.MessageSid, err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kevinburke/twilio-go"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	testWebhookHost = "https://durcov.example.com"
+	testAuthToken   = "test-auth-token"
+)
+
+// newSignedTwilioRequest returns a webhook request signed the way twilio signs them
+func newSignedTwilioRequest(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", twilio.GetExpectedTwilioSignature(testWebhookHost, testAuthToken, path, form))
+	return req
+}
+
+// fakeTwilioAPI records messages sent through the twilio messages API.
+// Each call fails with the next of the queued failure statuses until there are none left.
+type fakeTwilioAPI struct {
+	mu       sync.Mutex
+	messages []url.Values
+	failures []int
+}
+
+func (f *fakeTwilioAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	f.messages = append(f.messages, r.PostForm)
+	status := 201
+	if len(f.failures) > 0 {
+		status = f.failures[0]
+		f.failures = f.failures[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != 201 {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"code": 21211, "message": "Request failed", "status": ` + strconv.Itoa(status) + `}`))
+		return
+	}
+	w.WriteHeader(201)
+	w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+}
+
+func (f *fakeTwilioAPI) sent() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]url.Values{}, f.messages...)
+}
+
+func newTestTwilioBot(t *testing.T, mode responseMode) (*TwilioBot, *fakeTwilioAPI) {
+	api := &fakeTwilioAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client := twilio.NewClient("AC123", testAuthToken, nil)
+	client.Base = server.URL
+
+	validator := &twilioValidator{testWebhookHost, testAuthToken}
+	return &TwilioBot{client, validator, newTestBot(t), mode, nil, nil, nil, nil, nil}, api
+}
+
+func whatsappForm(body string) url.Values {
+	return url.Values{
+		"To":   {"whatsapp:+14155238886"},
+		"From": {"whatsapp:+15005550006"},
+		"Body": {body},
+	}
+}
+
+func TestTwilioBotRESTResponse(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+
+	rec := httptest.NewRecorder()
+	twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", whatsappForm("DEATHS SG")))
+	if rec.Code != 200 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+	}
+
+	sent := api.sent()
+	if len(sent) != 1 {
+		t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", 1, len(sent))
+	}
+	if sent[0].Get("Body") != "[SG] Singapore Deaths: 1,822" {
+		t.Errorf("Sent body mismatch. Got=%s", sent[0].Get("Body"))
+	}
+	if sent[0].Get("To") != "whatsapp:+15005550006" || sent[0].Get("From") != "whatsapp:+14155238886" {
+		t.Errorf("Sent addresses mismatch. Got To=%s From=%s", sent[0].Get("To"), sent[0].Get("From"))
+	}
+}
+
+func TestTwilioBotTwiMLResponse(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, twimlResponse)
+
+	rec := httptest.NewRecorder()
+	twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", whatsappForm("cases <b>")))
+	if rec.Code != 200 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "text/xml" {
+		t.Errorf("Content-Type mismatch. Expected=%s Got=%s", "text/xml", rec.Header().Get("Content-Type"))
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Response><Message>Sorry, I&#39;m not sure how to respond to that.</Message></Response>`
+	if rec.Body.String() != expected {
+		t.Errorf("TwiML mismatch.\nExpected=%s\nGot=%s", expected, rec.Body.String())
+	}
+	if len(api.sent()) != 0 {
+		t.Error("TwiML replies should not call the messages API")
+	}
+}
+
+func TestTwilioBotRejectsUnsignedRequests(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+
+	req := newSignedTwilioRequest("/whatsapp", whatsappForm("DEATHS SG"))
+	req.Header.Set("X-Twilio-Signature", "forged")
+	rec := httptest.NewRecorder()
+	twilioBot.handler(whatsappTransport).ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+	}
+	if len(api.sent()) != 0 {
+		t.Error("Unsigned requests should not be answered")
+	}
+}
+
+func TestTwilioBotRejectsMalformedRequests(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+
+	for _, key := range []string{"To", "From", "Body"} {
+		form := whatsappForm("DEATHS SG")
+		form.Del(key)
+		rec := httptest.NewRecorder()
+		twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", form))
+		if rec.Code != 400 {
+			t.Errorf("Status without %s mismatch. Expected=%d Got=%d", key, 400, rec.Code)
+		}
+	}
+	if len(api.sent()) != 0 {
+		t.Error("Malformed requests should not be answered")
+	}
+}
+
+func TestTwilioBotSMS(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+	form := url.Values{
+		"To":   {"+14155238886"},
+		"From": {"+15005550006"},
+		"Body": {"cases <b>"},
+	}
+
+	rec := httptest.NewRecorder()
+	twilioBot.handler(smsTransport).ServeHTTP(rec, newSignedTwilioRequest("/sms", form))
+	if rec.Code != 200 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+	}
+	sent := api.sent()
+	if len(sent) != 1 {
+		t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", 1, len(sent))
+	}
+	if sent[0].Get("Body") != "Sorry, I'm not sure how to respond to that." {
+		t.Errorf("Sent body mismatch. Got=%s", sent[0].Get("Body"))
+	}
+	if sent[0].Get("To") != "+15005550006" || sent[0].Get("From") != "+14155238886" {
+		t.Errorf("Sent addresses mismatch. Got To=%s From=%s", sent[0].Get("To"), sent[0].Get("From"))
+	}
+}
+
+func TestTwilioChannelFormat(t *testing.T) {
+	twilioBot, _ := newTestTwilioBot(t, restResponse)
+	reply := "Oops, I’ve got myself confused 😵"
+	if formatted := fitReply(&twilioChannel{twilioBot, whatsappTransport}, reply); formatted != reply {
+		t.Errorf("WhatsApp replies should be unchanged. Got=%s", formatted)
+	}
+	expected := "Oops, I've got myself confused"
+	if formatted := fitReply(&twilioChannel{twilioBot, smsTransport}, reply); formatted != expected {
+		t.Errorf("SMS format mismatch. Expected=%s Got=%s", expected, formatted)
+	}
+}
+
+func TestTwilioOptOut(t *testing.T) {
+	send := func(twilioBot *TwilioBot, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", whatsappForm(body)))
+		return rec
+	}
+
+	tests := map[string]func(t *testing.T){
+		"STOP unsubscribes until START": func(t *testing.T) {
+			twilioBot, api := newTestTwilioBot(t, restResponse)
+			suppressions := durcov.NewMemorySuppressionList()
+			twilioBot.suppressions = suppressions
+
+			rec := send(twilioBot, " stop ")
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			optedOut, _ := suppressions.IsSuppressed("+15005550006", durcov.SuppressedOptOut)
+			if !optedOut {
+				t.Fatal("Expected sender to be opted out")
+			}
+
+			send(twilioBot, "DEATHS SG")
+			send(twilioBot, "START")
+			send(twilioBot, "DEATHS SG")
+
+			sent := api.sent()
+			expected := []string{optOutReply, optInReply, "[SG] Singapore Deaths: 1,822"}
+			if len(sent) != len(expected) {
+				t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", len(expected), len(sent))
+			}
+			for i, body := range expected {
+				if sent[i].Get("Body") != body {
+					t.Errorf("Sent body %d mismatch. Expected=%s Got=%s", i, body, sent[i].Get("Body"))
+				}
+			}
+		},
+		"Opt-outs apply across channels": func(t *testing.T) {
+			twilioBot, api := newTestTwilioBot(t, restResponse)
+			suppressions := durcov.NewMemorySuppressionList()
+			twilioBot.suppressions = suppressions
+
+			rec := httptest.NewRecorder()
+			twilioBot.handler(smsTransport).ServeHTTP(rec, newSignedTwilioRequest("/sms", url.Values{"To": {"+14155238886"}, "From": {"+15005550006"}, "Body": {"UNSUBSCRIBE"}}))
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			send(twilioBot, "DEATHS SG")
+			if len(api.sent()) != 0 {
+				t.Errorf("Opted out senders should not get replies. Got=%v", api.sent())
+			}
+		},
+		"Blocked senders are ignored": func(t *testing.T) {
+			twilioBot, api := newTestTwilioBot(t, twimlResponse)
+			suppressions := durcov.NewMemorySuppressionList()
+			suppressions.Suppress("+15005550006", durcov.SuppressedBlocked, "spam")
+			twilioBot.suppressions = suppressions
+
+			for _, body := range []string{"DEATHS SG", "START"} {
+				rec := send(twilioBot, body)
+				expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Response></Response>`
+				if rec.Body.String() != expected {
+					t.Errorf("TwiML mismatch.\nExpected=%s\nGot=%s", expected, rec.Body.String())
+				}
+			}
+			if len(api.sent()) != 0 {
+				t.Error("Blocked senders should not get replies")
+			}
+			blocked, _ := suppressions.IsSuppressed("+15005550006", durcov.SuppressedBlocked)
+			if !blocked {
+				t.Error("START should not unblock a blocked sender")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestTwilioRateLimit(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+	twilioBot.limiter = durcov.NewMemoryRateLimiter(durcov.RateLimit{Rate: 0.001, Burst: 2})
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", whatsappForm("DEATHS SG")))
+		if rec.Code != 200 {
+			t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+		}
+	}
+
+	sent := api.sent()
+	expected := []string{"[SG] Singapore Deaths: 1,822", "[SG] Singapore Deaths: 1,822", slowDownReply}
+	if len(sent) != len(expected) {
+		t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", len(expected), len(sent))
+	}
+	for i, body := range expected {
+		if sent[i].Get("Body") != body {
+			t.Errorf("Sent body %d mismatch. Expected=%s Got=%s", i, body, sent[i].Get("Body"))
+		}
+	}
+
+	form := whatsappForm("DEATHS SG")
+	form.Set("From", "whatsapp:+15005550007")
+	rec := httptest.NewRecorder()
+	twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", form))
+	if len(api.sent()) != 4 {
+		t.Error("Other senders should not be limited")
+	}
+}
+
+func TestTwilioChannelSend(t *testing.T) {
+	twilioBot, api := newTestTwilioBot(t, restResponse)
+	twilioBot.senders = map[twilioTransport]string{whatsappTransport: "whatsapp:+14155238886"}
+	suppressions := durcov.NewMemorySuppressionList()
+	suppressions.Suppress("+15005550007", durcov.SuppressedOptOut, "STOP")
+	twilioBot.suppressions = suppressions
+
+	whatsapp := &twilioChannel{twilioBot, whatsappTransport}
+	err := whatsapp.Send("+15005550006", "Daily digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = whatsapp.Send("+15005550007", "Daily digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := api.sent()
+	if len(sent) != 1 {
+		t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", 1, len(sent))
+	}
+	if sent[0].Get("To") != "whatsapp:+15005550006" || sent[0].Get("From") != "whatsapp:+14155238886" {
+		t.Errorf("Sent addresses mismatch. Got To=%s From=%s", sent[0].Get("To"), sent[0].Get("From"))
+	}
+
+	sms := &twilioChannel{twilioBot, smsTransport}
+	if sms.Send("+15005550006", "Daily digest") == nil {
+		t.Error("Expected an error without an SMS sender number")
+	}
+}
+
+func TestTwilioStatusCallback(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Replies ask for delivery statuses of the inbound message": func(t *testing.T) {
+			twilioBot, api := newTestTwilioBot(t, restResponse)
+			twilioBot.statuses = durcov.NewMemoryDeliveryStatusStore()
+
+			form := whatsappForm("DEATHS SG")
+			form.Set("MessageSid", "SMinbound")
+			rec := httptest.NewRecorder()
+			twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", form))
+			if rec.Code != 200 {
+				t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+			}
+			sent := api.sent()
+			if len(sent) != 1 {
+				t.Fatalf("Sent message count mismatch. Expected=%d Got=%d", 1, len(sent))
+			}
+			expected := testWebhookHost + "/twilio/status?inbound=SMinbound"
+			if sent[0].Get("StatusCallback") != expected {
+				t.Errorf("Status callback mismatch. Expected=%s Got=%s", expected, sent[0].Get("StatusCallback"))
+			}
+		},
+		"TwiML replies ask for delivery statuses": func(t *testing.T) {
+			twilioBot, _ := newTestTwilioBot(t, twimlResponse)
+			twilioBot.statuses = durcov.NewMemoryDeliveryStatusStore()
+
+			form := whatsappForm("DEATHS SG")
+			form.Set("MessageSid", "SMinbound")
+			rec := httptest.NewRecorder()
+			twilioBot.handler(whatsappTransport).ServeHTTP(rec, newSignedTwilioRequest("/whatsapp", form))
+			expected := `<Message action="https://durcov.example.com/twilio/status?inbound=SMinbound" method="POST">`
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("TwiML mismatch.\nExpected to contain=%s\nGot=%s", expected, rec.Body.String())
+			}
+		},
+		"Records status transitions linked to the inbound message": func(t *testing.T) {
+			twilioBot, _ := newTestTwilioBot(t, restResponse)
+			statuses := durcov.NewMemoryDeliveryStatusStore()
+			twilioBot.statuses = statuses
+
+			for _, status := range []string{"queued", "sent", "undelivered"} {
+				form := url.Values{"MessageSid": {"SMreply"}, "MessageStatus": {status}}
+				if status == "undelivered" {
+					form.Set("ErrorCode", "30003")
+				}
+				rec := httptest.NewRecorder()
+				twilioBot.handleStatus(rec, newSignedTwilioRequest("/twilio/status?inbound=SMinbound", form))
+				if rec.Code != 200 {
+					t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, rec.Code)
+				}
+			}
+
+			updates, err := statuses.MessageStatuses("SMreply")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(updates) != 3 {
+				t.Fatalf("Recorded status count mismatch. Expected=%d Got=%d", 3, len(updates))
+			}
+			last := updates[2]
+			if last.Status != "undelivered" || last.ErrorCode != "30003" || last.InboundSid != "SMinbound" {
+				t.Errorf("Recorded status mismatch. Got=%+v", last)
+			}
+		},
+		"Rejects unsigned callbacks": func(t *testing.T) {
+			twilioBot, _ := newTestTwilioBot(t, restResponse)
+			statuses := durcov.NewMemoryDeliveryStatusStore()
+			twilioBot.statuses = statuses
+
+			req := newSignedTwilioRequest("/twilio/status", url.Values{"MessageSid": {"SMreply"}, "MessageStatus": {"delivered"}})
+			req.Header.Set("X-Twilio-Signature", "forged")
+			rec := httptest.NewRecorder()
+			twilioBot.handleStatus(rec, req)
+			if rec.Code != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, rec.Code)
+			}
+			updates, _ := statuses.MessageStatuses("SMreply")
+			if len(updates) != 0 {
+				t.Error("Unsigned callbacks should not be recorded")
+			}
+		},
+		"Rejects callbacks without a status": func(t *testing.T) {
+			twilioBot, _ := newTestTwilioBot(t, restResponse)
+			twilioBot.statuses = durcov.NewMemoryDeliveryStatusStore()
+
+			rec := httptest.NewRecorder()
+			twilioBot.handleStatus(rec, newSignedTwilioRequest("/twilio/status", url.Values{"MessageSid": {"SMreply"}}))
+			if rec.Code != 400 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 400, rec.Code)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestParseResponseMode(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    responseMode
+		expectError bool
+	}{
+		{"", restResponse, false},
+		{"rest", restResponse, false},
+		{"twiml", twimlResponse, false},
+		{"carrier-pigeon", restResponse, true},
+	}
+	for _, test := range tests {
+		mode, err := parseResponseMode(test.input)
+		if (err != nil) != test.expectError {
+			t.Errorf("Error mismatch. Input: %s Error: %v", test.input, err)
+		}
+		if mode != test.expected {
+			t.Errorf("Mode mismatch. Input: %s Expected=%d Got=%d", test.input, test.expected, mode)
+		}
+	}
+}
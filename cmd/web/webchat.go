@@ -0,0 +1,591 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TuhinNair/durcov"
+)
+
+const (
+	webChatCookie     = "durcov_chat"
+	webChatSessionTTL = 24 * time.Hour
+	// webChatMaxSessions caps the sessions held in memory. New visitors are turned away once it's reached.
+	webChatMaxSessions = 10000
+	// webChatSessionBurst is how many sessions an address can start at once. It can start another every webChatSessionInterval.
+	webChatSessionBurst    = 10
+	webChatSessionInterval = time.Minute
+	// webChatHistoryLength is how many messages of each conversation are kept
+	webChatHistoryLength = 50
+	// webChatMaxMessageSize caps request bodies and socket messages
+	webChatMaxMessageSize = 4096
+)
+
+// WebChat represents the browser chat. Conversations are kept per session in memory so they aren't shared between processes.
+type WebChat struct {
+	mu       sync.Mutex
+	sessions map[string]*webChatSession
+	// senders holds the same sessions by sender id
+	senders    map[string]*webChatSession
+	closed     bool
+	lastPruned time.Time
+	now        func() time.Time
+	upgrader   websocket.Upgrader
+	// sessionLimiter limits how often each address starts a session so the sessions can't be used up by one visitor
+	sessionLimiter durcov.RateLimiter
+}
+
+type webChatSession struct {
+	id string
+	// sender identifies the session's messages to the bot, which may store it. Unlike id it isn't a secret.
+	sender string
+	// csrfToken is embedded in the chat page and must accompany every message sent with the session cookie
+	csrfToken string
+	history   []*webChatEntry
+	lastSeen  time.Time
+	sockets   map[*webChatSocket]struct{}
+}
+
+// webChatEntry represents a message in a conversation. From is either "user" or "bot".
+type webChatEntry struct {
+	From   string    `json:"from"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// webChatDetails represents who sent a message: the session and the address it was sent from
+type webChatDetails struct {
+	session  *webChatSession
+	clientIP string
+}
+
+type webChatRequest struct {
+	Text string `json:"text"`
+}
+
+// webChatSocket represents an open websocket. Writes are serialized because a connection allows one writer at a time.
+type webChatSocket struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (s *webChatSocket) write(entry *webChatEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return s.conn.WriteJSON(entry)
+}
+
+func (s *webChatSocket) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+}
+
+func newWebChat() *WebChat {
+	return &WebChat{
+		sessions: map[string]*webChatSession{},
+		senders:  map[string]*webChatSession{},
+		now:      time.Now,
+		// The default origin check refuses sockets opened by other sites, which would otherwise be sent the session cookie
+		upgrader:       websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		sessionLimiter: durcov.NewMemoryRateLimiter(durcov.RateLimit{Rate: 1 / webChatSessionInterval.Seconds(), Burst: webChatSessionBurst}),
+	}
+}
+
+// clientIP returns the address the request was sent from. Behind the Heroku router that's the last address in
+// X-Forwarded-For since the router appends the address it received the request from.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lookup returns the request's session. Returns nil when there's no cookie or the session expired.
+func (wc *WebChat) lookup(r *http.Request) *webChatSession {
+	cookie, err := r.Cookie(webChatCookie)
+	if err != nil {
+		return nil
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	session, ok := wc.sessions[cookie.Value]
+	if !ok || wc.now().Sub(session.lastSeen) > webChatSessionTTL {
+		return nil
+	}
+	session.lastSeen = wc.now()
+	return session
+}
+
+// allowSession reports whether the request's address may start another session.
+// Sessions are allowed if the limit can't be checked.
+func (wc *WebChat) allowSession(r *http.Request) bool {
+	decision, err := wc.sessionLimiter.Allow(clientIP(r))
+	if err != nil {
+		log.Printf("Unable to check web chat session limit: %v", err)
+		return true
+	}
+	return decision.Allowed
+}
+
+// start starts a new session and sets its cookie.
+func (wc *WebChat) start(w http.ResponseWriter, r *http.Request) (*webChatSession, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	sender, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.closed {
+		return nil, errors.New("Web chat closed")
+	}
+	wc.prune()
+	if len(wc.sessions) >= webChatMaxSessions {
+		return nil, errors.New("Too many web chat sessions")
+	}
+	session := &webChatSession{id: id, sender: sender, csrfToken: csrfToken, lastSeen: wc.now(), sockets: map[*webChatSocket]struct{}{}}
+	wc.sessions[id] = session
+	wc.senders[sender] = session
+
+	cookie := &http.Cookie{
+		Name:     webChatCookie,
+		Value:    id,
+		Path:     "/webchat",
+		MaxAge:   int(webChatSessionTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	// Pages embedded on other sites are only sent the cookie when it allows cross-site use, which needs https
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+	return session, nil
+}
+
+// prune forgets expired sessions without open sockets (at most once a minute). Must be called with the lock held.
+func (wc *WebChat) prune() {
+	now := wc.now()
+	if now.Sub(wc.lastPruned) < time.Minute {
+		return
+	}
+	wc.lastPruned = now
+	for id, session := range wc.sessions {
+		if now.Sub(session.lastSeen) > webChatSessionTTL && len(session.sockets) == 0 {
+			delete(wc.sessions, id)
+			delete(wc.senders, session.sender)
+		}
+	}
+}
+
+// record adds an entry to the session's conversation, returning it for delivery.
+func (wc *WebChat) record(session *webChatSession, from string, text string) *webChatEntry {
+	entry := &webChatEntry{From: from, Text: text, SentAt: wc.now().UTC()}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	session.history = append(session.history, entry)
+	if len(session.history) > webChatHistoryLength {
+		session.history = session.history[len(session.history)-webChatHistoryLength:]
+	}
+	return entry
+}
+
+// history returns a copy of the session's conversation
+func (wc *WebChat) history(session *webChatSession) []*webChatEntry {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return append([]*webChatEntry{}, session.history...)
+}
+
+// deliver records a bot message and pushes it to every socket the session has open.
+func (wc *WebChat) deliver(session *webChatSession, text string) *webChatEntry {
+	entry := wc.record(session, "bot", text)
+	wc.mu.Lock()
+	sockets := []*webChatSocket{}
+	for socket := range session.sockets {
+		sockets = append(sockets, socket)
+	}
+	wc.mu.Unlock()
+
+	for _, socket := range sockets {
+		err := socket.write(entry)
+		if err != nil {
+			log.Printf("Unable to write to web chat socket: %v", err)
+		}
+	}
+	return entry
+}
+
+func (wc *WebChat) Name() string {
+	return "webchat"
+}
+
+// Capabilities describes the chat page. Messages are shown as plain text.
+func (wc *WebChat) Capabilities() Capabilities {
+	return Capabilities{Media: false, Markdown: false, MaxLength: 0}
+}
+
+// ValidateRequest checks the request has a session and carries the session's CSRF token.
+func (wc *WebChat) ValidateRequest(r *http.Request) error {
+	session := wc.lookup(r)
+	if session == nil {
+		return errors.New("No web chat session")
+	}
+	if !validCSRFToken(session, r.Header.Get("X-CSRF-Token")) {
+		return errors.New("Web chat CSRF token mismatch")
+	}
+	return nil
+}
+
+func validCSRFToken(session *webChatSession, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(session.csrfToken)) == 1
+}
+
+func (wc *WebChat) ParseRequest(r *http.Request) (*InboundMessage, error) {
+	session := wc.lookup(r)
+	if session == nil {
+		return nil, errors.New("No web chat session")
+	}
+	return decodeWebChatRequest(&webChatDetails{session, clientIP(r)}, io.LimitReader(r.Body, webChatMaxMessageSize))
+}
+
+func decodeWebChatRequest(details *webChatDetails, body io.Reader) (*InboundMessage, error) {
+	request := &webChatRequest{}
+	err := json.NewDecoder(body).Decode(request)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(request.Text)
+	if text == "" {
+		return nil, errors.New("Empty web chat message")
+	}
+	return &InboundMessage{From: details.session.sender, Text: text, Platform: details}, nil
+}
+
+func (wc *WebChat) FormatReply(reply string) string {
+	return reply
+}
+
+// Reply answers with the bot's message as JSON, or no content when there's no reply.
+func (wc *WebChat) Reply(w http.ResponseWriter, msg *InboundMessage, reply *Reply) error {
+	details, ok := msg.Platform.(*webChatDetails)
+	if !ok {
+		return errors.New("Web chat message without a session")
+	}
+	if reply.Text == "" {
+		w.WriteHeader(204)
+		return nil
+	}
+	body, err := json.Marshal(wc.deliver(details.session, reply.Text))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	return err
+}
+
+// Send adds a message to the conversation of the session with the given sender id.
+func (wc *WebChat) Send(to string, text string) error {
+	wc.mu.Lock()
+	session, ok := wc.senders[to]
+	wc.mu.Unlock()
+	if !ok {
+		return errors.New("No web chat session " + to)
+	}
+	wc.deliver(session, fitReply(wc, text))
+	return nil
+}
+
+// recordScreen adds the user's message to the conversation. It never answers the message.
+func (wc *WebChat) recordScreen(msg *InboundMessage) (*Reply, error) {
+	if details, ok := msg.Platform.(*webChatDetails); ok {
+		wc.record(details.session, "user", msg.Text)
+	}
+	return nil, nil
+}
+
+// handler returns the channel's message endpoint. Senders are rate limited per address rather than per session
+// since anyone can start a new session.
+func (wc *WebChat) handler(bot *durcov.Bot, limiter durcov.RateLimiter) *ChannelHandler {
+	screens := []screen{wc.recordScreen}
+	if limiter != nil {
+		screens = append(screens, throttleScreen(wc.Name(), limiter, func(msg *InboundMessage) string {
+			return "webchat:" + msg.Platform.(*webChatDetails).clientIP
+		}))
+	}
+	return newChannelHandler(wc, bot, screens...)
+}
+
+// handlePage serves the chat page with the session's conversation so far. The page can be embedded in an iframe.
+func (wc *WebChat) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		log.Println("Method Not Allowed")
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	session := wc.lookup(r)
+	if session == nil {
+		if !wc.allowSession(r) {
+			log.Println("Too many web chat sessions started from the address")
+			http.Error(w, http.StatusText(429), 429)
+			return
+		}
+		var err error
+		session, err = wc.start(w, r)
+		if err != nil {
+			log.Printf("Unable to start web chat session: %v", err)
+			http.Error(w, http.StatusText(503), 503)
+			return
+		}
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		log.Printf("Unable to render web chat: %v", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; connect-src 'self'; style-src 'nonce-"+nonce+"'; script-src 'nonce-"+nonce+"'")
+	err = webChatPage.Execute(w, map[string]interface{}{
+		"CSRFToken": session.csrfToken,
+		"Nonce":     nonce,
+		"History":   wc.history(session),
+	})
+	if err != nil {
+		log.Printf("Unable to render web chat: %v", err)
+	}
+}
+
+// handleSocket answers messages sent over a websocket. The CSRF token is passed as the csrf query parameter
+// because browsers can't set headers on websocket requests.
+func (wc *WebChat) handleSocket(handler *ChannelHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := wc.lookup(r)
+		if session == nil || !validCSRFToken(session, r.URL.Query().Get("csrf")) {
+			log.Println("Web chat socket not authenticated")
+			http.Error(w, http.StatusText(401), 401)
+			return
+		}
+		conn, err := wc.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already responded
+			log.Printf("Unable to open web chat socket: %v", err)
+			return
+		}
+		details := &webChatDetails{session, clientIP(r)}
+		socket := &webChatSocket{conn: conn}
+		if !wc.attach(session, socket) {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			conn.Close()
+			return
+		}
+		defer wc.detach(session, socket)
+
+		done := make(chan struct{})
+		defer close(done)
+		go keepSocketAlive(socket, done)
+
+		conn.SetReadLimit(webChatMaxMessageSize)
+		for {
+			_, body, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			msg, err := decodeWebChatRequest(details, body)
+			if err != nil {
+				log.Printf("Malformed web chat message: %v", err)
+				continue
+			}
+			reply, err := handler.answer(msg)
+			if err != nil {
+				log.Printf("Unable to screen web chat message: %v", err)
+				reply = &Reply{Text: unavailableReply}
+			}
+			if reply.Text != "" {
+				wc.deliver(session, reply.Text)
+			}
+		}
+	}
+}
+
+// keepSocketAlive pings the socket so idle connections aren't closed by the router.
+func keepSocketAlive(socket *webChatSocket, done <-chan struct{}) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if socket.ping() != nil {
+				return
+			}
+		}
+	}
+}
+
+// attach registers the socket with the session. Returns false once the chat is closed.
+func (wc *WebChat) attach(session *webChatSession, socket *webChatSocket) bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.closed {
+		return false
+	}
+	session.sockets[socket] = struct{}{}
+	return true
+}
+
+func (wc *WebChat) detach(session *webChatSession, socket *webChatSocket) {
+	wc.mu.Lock()
+	delete(session.sockets, socket)
+	wc.mu.Unlock()
+	socket.conn.Close()
+}
+
+// close tells every open socket the server is going away and refuses new sessions and sockets.
+// Sockets aren't tracked by the server so they'd otherwise be cut off without notice on shutdown.
+func (wc *WebChat) close() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.closed = true
+	for _, session := range wc.sessions {
+		for socket := range session.sockets {
+			socket.mu.Lock()
+			socket.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			socket.mu.Unlock()
+			socket.conn.Close()
+		}
+	}
+}
+
+var webChatPage = template.Must(template.New("webchat").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>DurCov</title>
+<style nonce="{{.Nonce}}">
+body { margin: 0; font-family: sans-serif; display: flex; flex-direction: column; height: 100vh; }
+#log { flex: 1; overflow-y: auto; padding: 1em; }
+.message { margin: 0.4em 0; padding: 0.5em 0.8em; border-radius: 1em; max-width: 80%; white-space: pre-wrap; }
+.user { background: #dcf8c6; margin-left: auto; }
+.bot { background: #eee; }
+form { display: flex; border-top: 1px solid #ccc; }
+input { flex: 1; padding: 0.8em; border: none; font-size: 1em; }
+button { padding: 0 1.2em; }
+</style>
+</head>
+<body>
+<div id="log" aria-live="polite"><div class="message bot">Send CASES or DEATHS followed by a two letter country code (e.g. CASES SG) or TOTAL.</div></div>
+<form id="chat">
+<input id="text" autocomplete="off" maxlength="100" placeholder="CASES TOTAL" aria-label="Message">
+<button type="submit">Send</button>
+</form>
+<script type="application/json" id="history">{{.History}}</script>
+<script nonce="{{.Nonce}}">
+(function () {
+	var token = "{{.CSRFToken}}";
+	var log = document.getElementById("log");
+	var input = document.getElementById("text");
+	var socket = null;
+
+	function show(entry) {
+		var message = document.createElement("div");
+		message.className = "message " + (entry.from === "user" ? "user" : "bot");
+		message.textContent = entry.text;
+		log.appendChild(message);
+		log.scrollTop = log.scrollHeight;
+	}
+
+	function connect() {
+		var scheme = location.protocol === "https:" ? "wss:" : "ws:";
+		var ws = new WebSocket(scheme + "//" + location.host + "/webchat/ws?csrf=" + encodeURIComponent(token));
+		ws.onopen = function () { socket = ws; };
+		ws.onmessage = function (event) { show(JSON.parse(event.data)); };
+		ws.onclose = function () {
+			socket = null;
+			setTimeout(connect, 5000);
+		};
+	}
+
+	// Messages are sent over the socket when it's open and posted otherwise
+	function post(text) {
+		fetch("/webchat/messages", {
+			method: "POST",
+			credentials: "same-origin",
+			headers: {"Content-Type": "application/json", "X-CSRF-Token": token},
+			body: JSON.stringify({text: text})
+		}).then(function (resp) {
+			if (resp.status === 200) {
+				return resp.json().then(show);
+			}
+			if (resp.status !== 204) {
+				show({from: "bot", text: "Sorry, something went wrong. Please try again."});
+			}
+		});
+	}
+
+	document.getElementById("chat").addEventListener("submit", function (event) {
+		event.preventDefault();
+		var text = input.value.trim();
+		if (!text) {
+			return;
+		}
+		input.value = "";
+		show({from: "user", text: text});
+		if (socket) {
+			socket.send(JSON.stringify({text: text}));
+		} else {
+			post(text);
+		}
+	});
+
+	(JSON.parse(document.getElementById("history").textContent) || []).forEach(show);
+	connect();
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TuhinNair/durcov"
+)
+
+var csrfTokenPattern = regexp.MustCompile(`var token = "([0-9a-f]+)"`)
+
+// startWebChat serves the web chat's routes the way main does
+func startWebChat(t *testing.T, limiter durcov.RateLimiter) (*WebChat, *httptest.Server) {
+	webChat := newWebChat()
+	handler := webChat.handler(newTestBot(t), limiter)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webchat", webChat.handlePage)
+	mux.HandleFunc("/webchat/messages", handler.ServeHTTP)
+	mux.HandleFunc("/webchat/ws", webChat.handleSocket(handler))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return webChat, server
+}
+
+// openWebChat loads the chat page, returning the session cookie and CSRF token it hands out
+func openWebChat(t *testing.T, server *httptest.Server) (*http.Cookie, string) {
+	resp, err := http.Get(server.URL + "/webchat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Status mismatch. Expected=%d Got=%d", 200, resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Security-Policy"), "script-src 'nonce-") {
+		t.Errorf("Expected a content security policy. Got=%s", resp.Header.Get("Content-Security-Policy"))
+	}
+
+	page := &bytes.Buffer{}
+	_, err = page.ReadFrom(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := csrfTokenPattern.FindStringSubmatch(page.String())
+	if matches == nil || len(resp.Cookies()) != 1 {
+		t.Fatalf("Expected a session cookie and CSRF token. Got cookies=%v", resp.Cookies())
+	}
+	return resp.Cookies()[0], matches[1]
+}
+
+func postWebChat(t *testing.T, server *httptest.Server, cookie *http.Cookie, token string, text string) (int, *webChatEntry) {
+	req, err := http.NewRequest("POST", server.URL+"/webchat/messages", strings.NewReader(`{"text": "`+text+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", token)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	entry := &webChatEntry{}
+	if resp.StatusCode == 200 {
+		err = json.NewDecoder(resp.Body).Decode(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, entry
+}
+
+func dialWebChat(server *httptest.Server, cookie *http.Cookie, token string, origin string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	header.Set("Cookie", cookie.String())
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/webchat/ws?csrf=" + token
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+func TestWebChatMessages(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers messages and keeps the conversation": func(t *testing.T) {
+			webChat, server := startWebChat(t, nil)
+			cookie, token := openWebChat(t, server)
+
+			status, entry := postWebChat(t, server, cookie, token, "DEATHS SG")
+			if status != 200 || entry.From != "bot" || entry.Text != "[SG] Singapore Deaths: 1,822" {
+				t.Fatalf("Reply mismatch. Status=%d Got=%+v", status, entry)
+			}
+			session := webChat.sessions[cookie.Value]
+			history := webChat.history(session)
+			if len(history) != 2 || history[0].From != "user" || history[0].Text != "DEATHS SG" || history[1].Text != entry.Text {
+				t.Errorf("History mismatch. Got=%+v", history)
+			}
+			// The bot may store who sent a message, so that mustn't be the secret in the cookie
+			msg, err := decodeWebChatRequest(&webChatDetails{session, "127.0.0.1"}, strings.NewReader(`{"text":"DEATHS SG"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.From == cookie.Value || msg.From != session.sender {
+				t.Errorf("Sender mismatch. Expected=%s Got=%s", session.sender, msg.From)
+			}
+		},
+		"Rejects messages without the CSRF token": func(t *testing.T) {
+			_, server := startWebChat(t, nil)
+			cookie, _ := openWebChat(t, server)
+			if status, _ := postWebChat(t, server, cookie, "forged", "DEATHS SG"); status != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, status)
+			}
+		},
+		"Rejects messages without a session": func(t *testing.T) {
+			_, server := startWebChat(t, nil)
+			_, token := openWebChat(t, server)
+			if status, _ := postWebChat(t, server, nil, token, "DEATHS SG"); status != 401 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 401, status)
+			}
+		},
+		"Rate limits each address": func(t *testing.T) {
+			_, server := startWebChat(t, durcov.NewMemoryRateLimiter(durcov.RateLimit{Rate: 0.001, Burst: 1}))
+			cookie, token := openWebChat(t, server)
+
+			postWebChat(t, server, cookie, token, "DEATHS SG")
+			status, entry := postWebChat(t, server, cookie, token, "DEATHS SG")
+			if status != 200 || entry.Text != slowDownReply {
+				t.Errorf("Expected a warning. Status=%d Got=%+v", status, entry)
+			}
+			if status, _ := postWebChat(t, server, cookie, token, "DEATHS SG"); status != 204 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 204, status)
+			}
+
+			// A new session from the same address doesn't get around the limit
+			otherCookie, otherToken := openWebChat(t, server)
+			if status, _ := postWebChat(t, server, otherCookie, otherToken, "DEATHS SG"); status != 204 {
+				t.Errorf("New sessions should be limited. Status=%d", status)
+			}
+
+			req, err := http.NewRequest("POST", server.URL+"/webchat/messages", strings.NewReader(`{"text": "DEATHS SG"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-CSRF-Token", otherToken)
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			req.AddCookie(otherCookie)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("Other addresses should not be limited. Status=%d", resp.StatusCode)
+			}
+		},
+		"Limits the sessions each address starts": func(t *testing.T) {
+			webChat, server := startWebChat(t, nil)
+			webChat.sessionLimiter = durcov.NewMemoryRateLimiter(durcov.RateLimit{Rate: 0.001, Burst: 1})
+			cookie, _ := openWebChat(t, server)
+
+			resp, err := http.Get(server.URL + "/webchat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 429 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 429, resp.StatusCode)
+			}
+
+			// The address's existing session can still be loaded
+			req, err := http.NewRequest("GET", server.URL+"/webchat", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.AddCookie(cookie)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("Status mismatch. Expected=%d Got=%d", 200, resp.StatusCode)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestWebChatSocket(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Answers messages": func(t *testing.T) {
+			_, server := startWebChat(t, nil)
+			cookie, token := openWebChat(t, server)
+			conn, _, err := dialWebChat(server, cookie, token, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			err = conn.WriteJSON(&webChatRequest{"CASES TOTAL"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			entry := &webChatEntry{}
+			err = conn.ReadJSON(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.From != "bot" || entry.Text != "Total Active Cases: 9,000,000" {
+				t.Errorf("Reply mismatch. Got=%+v", entry)
+			}
+		},
+		"Pushes messages sent to the session": func(t *testing.T) {
+			webChat, server := startWebChat(t, nil)
+			cookie, token := openWebChat(t, server)
+			conn, _, err := dialWebChat(server, cookie, token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// The socket is attached once the handler has upgraded the connection
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				webChat.mu.Lock()
+				attached := len(webChat.sessions[cookie.Value].sockets)
+				webChat.mu.Unlock()
+				if attached == 1 {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := webChat.Send(cookie.Value, "Heads up"); err == nil {
+				t.Error("Expected the session cookie not to address the session")
+			}
+			webChat.mu.Lock()
+			sender := webChat.sessions[cookie.Value].sender
+			webChat.mu.Unlock()
+			err = webChat.Send(sender, "Heads up")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			entry := &webChatEntry{}
+			err = conn.ReadJSON(entry)
+			if err != nil || entry.Text != "Heads up" {
+				t.Errorf("Pushed message mismatch. Err=%v Got=%+v", err, entry)
+			}
+
+			webChat.close()
+			_, _, err = conn.ReadMessage()
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("Expected the socket to be closed on shutdown. Got=%v", err)
+			}
+		},
+		"Rejects sockets without the CSRF token": func(t *testing.T) {
+			_, server := startWebChat(t, nil)
+			cookie, _ := openWebChat(t, server)
+			_, resp, err := dialWebChat(server, cookie, "forged", "")
+			if err == nil || resp.StatusCode != 401 {
+				t.Errorf("Expected the socket to be refused. Err=%v", err)
+			}
+		},
+		"Rejects sockets opened by other sites": func(t *testing.T) {
+			_, server := startWebChat(t, nil)
+			cookie, token := openWebChat(t, server)
+			_, resp, err := dialWebChat(server, cookie, token, "https://evil.example")
+			if err == nil || resp.StatusCode != 403 {
+				t.Errorf("Expected the socket to be refused. Err=%v", err)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		// Clients can send their own X-Forwarded-For. The router appends the address it saw.
+		{"10.0.0.1:1234", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/webchat", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := clientIP(req); ip != test.expected {
+			t.Errorf("Client IP mismatch. Expected=%s Got=%s", test.expected, ip)
+		}
+	}
+}
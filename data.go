@@ -2,13 +2,20 @@ package durcov
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
-// Data represents a combination of global and country based statistics
+// Data represents a combination of global and country based statistics.
+// Regional statistics (states, provinces and counties) along with vaccination, testing and hospital occupancy figures
+// are optional.
 type Data struct {
-	global    *global
-	countries []*country
+	global       *global
+	countries    []*country
+	regions      []*region
+	vaccinations []*vaccinationFigures
+	tests        []*testingFigures
+	hospitals    []*occupancyFigure
 }
 
 type country struct {
@@ -86,3 +93,134 @@ func (d *Data) UnmarshalJSON(data []byte) error {
 	d.countries = countries
 	return nil
 }
+
+// RowCount returns the number of rows the data occupies once stored.
+// (Global plus one per country and region, one per country with vaccination or testing figures for each,
+// and one per hospital occupancy figure)
+func (d *Data) RowCount() int {
+	return len(d.countries) + len(d.regions) + len(d.vaccinations) + len(d.tests) + len(d.hospitals) + 1
+}
+
+// Validate returns an error describing the first problem that would make the data unfit to store.
+func (d *Data) Validate() error {
+	if d.global == nil || d.global.stats == nil {
+		return fmt.Errorf("Missing global statistics")
+	}
+	if err := d.global.stats.validate(); err != nil {
+		return fmt.Errorf("Invalid global statistics: %v", err)
+	}
+	if len(d.countries) == 0 {
+		return fmt.Errorf("Missing country statistics")
+	}
+
+	seen := map[string]bool{}
+	for _, country := range d.countries {
+		if country.code == "" {
+			return fmt.Errorf("Missing country code for %q", country.name)
+		}
+		if country.code == globalID {
+			return fmt.Errorf("Country code %s is reserved", country.code)
+		}
+		if seen[country.code] {
+			return fmt.Errorf("Duplicate country code %s", country.code)
+		}
+		seen[country.code] = true
+		if country.stats == nil {
+			return fmt.Errorf("Missing statistics for country %s", country.code)
+		}
+		if err := country.stats.validate(); err != nil {
+			return fmt.Errorf("Invalid statistics for country %s: %v", country.code, err)
+		}
+	}
+	if err := d.validateVaccinationsAndTests(seen); err != nil {
+		return err
+	}
+	if err := d.validateHospitalOccupancy(seen); err != nil {
+		return err
+	}
+	return d.validateRegions(seen)
+}
+
+// validateVaccinationsAndTests checks the figures are for the world or one of the countries, and at most once for each
+func (d *Data) validateVaccinationsAndTests(countries map[string]bool) error {
+	vaccinated := map[string]bool{}
+	for _, figures := range d.vaccinations {
+		if figures.code != globalID && !countries[figures.code] {
+			return fmt.Errorf("Unknown country %s for vaccination figures", figures.code)
+		}
+		if vaccinated[figures.code] {
+			return fmt.Errorf("Duplicate vaccination figures for %s", figures.code)
+		}
+		vaccinated[figures.code] = true
+		if figures.doses < 0 || figures.peopleVaccinated < 0 {
+			return fmt.Errorf("Invalid vaccination figures for %s: negative totals", figures.code)
+		}
+		if figures.date.IsZero() {
+			return fmt.Errorf("Invalid vaccination figures for %s: missing collection date", figures.code)
+		}
+	}
+
+	tested := map[string]bool{}
+	for _, figures := range d.tests {
+		if figures.code != globalID && !countries[figures.code] {
+			return fmt.Errorf("Unknown country %s for testing figures", figures.code)
+		}
+		if tested[figures.code] {
+			return fmt.Errorf("Duplicate testing figures for %s", figures.code)
+		}
+		tested[figures.code] = true
+		if figures.tests < 0 {
+			return fmt.Errorf("Invalid testing figures for %s: negative totals", figures.code)
+		}
+		if figures.positiveRate < 0 || figures.positiveRate > 1 {
+			return fmt.Errorf("Invalid testing figures for %s: positive rate %v not between 0 and 1", figures.code, figures.positiveRate)
+		}
+		if figures.date.IsZero() {
+			return fmt.Errorf("Invalid testing figures for %s: missing collection date", figures.code)
+		}
+	}
+	return nil
+}
+
+// validateRegions checks every region's parent comes before it, so countries is the set of country codes.
+func (d *Data) validateRegions(countries map[string]bool) error {
+	levels := map[string]RegionLevel{}
+	for _, region := range d.regions {
+		if !IsRegionCode(region.code) {
+			return fmt.Errorf("Invalid region code %q for %q", region.code, region.name)
+		}
+		if _, ok := levels[region.code]; ok || countries[region.code] {
+			return fmt.Errorf("Duplicate region code %s", region.code)
+		}
+		switch region.level {
+		case Admin1:
+			if !countries[region.parent] {
+				return fmt.Errorf("Unknown country %s for region %s", region.parent, region.code)
+			}
+		case Admin2:
+			if levels[region.parent] != Admin1 {
+				return fmt.Errorf("Unknown admin1 region %s for region %s", region.parent, region.code)
+			}
+		default:
+			return fmt.Errorf("Invalid level %d for region %s", region.level, region.code)
+		}
+		levels[region.code] = region.level
+		if region.stats == nil {
+			return fmt.Errorf("Missing statistics for region %s", region.code)
+		}
+		if err := region.stats.validate(); err != nil {
+			return fmt.Errorf("Invalid statistics for region %s: %v", region.code, err)
+		}
+	}
+	return nil
+}
+
+func (s *statistics) validate() error {
+	if s.totalConfirmed < 0 || s.totalDeaths < 0 || s.totalRecovered < 0 {
+		return fmt.Errorf("negative totals")
+	}
+	if s.date.IsZero() {
+		return fmt.Errorf("missing collection date")
+	}
+	return nil
+}
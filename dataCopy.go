@@ -0,0 +1,135 @@
+package durcov
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LoadMemoryStore returns a memory store holding the data in a database laid out like the Postgres schema, e.g. a
+// SQLite copy of it. The database's driver is up to the caller. Group members are read as comma separated codes since
+// SQLite has no arrays.
+func LoadMemoryStore(db *sql.DB) (*MemoryStore, error) {
+	data, err := readDataTables(db)
+	if err != nil {
+		return nil, err
+	}
+	err = data.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	memoryStore := NewMemoryStore()
+	err = queryRows(db, "SELECT id, confirmed, deaths, recovered, collected_at FROM covid_stats_history ORDER BY collected_at;", func(rows *sql.Rows) error {
+		var id string
+		stats := &statistics{}
+		err := rows.Scan(&id, &stats.totalConfirmed, &stats.totalDeaths, &stats.totalRecovered, &stats.date)
+		if err == nil {
+			memoryStore.storeSnapshot(id, stats)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = memoryStore.StoreData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, "SELECT code, name, members FROM country_groups;", func(rows *sql.Rows) error {
+		group := &GroupInfo{}
+		var members string
+		err := rows.Scan(&group.Code, &group.Name, &members)
+		if err != nil {
+			return err
+		}
+		group.Members = strings.Split(strings.Trim(members, "{}"), ",")
+		return memoryStore.SaveGroup(group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memoryStore, nil
+}
+
+// readDataTables reads the latest data from the database's tables
+func readDataTables(db *sql.DB) (*Data, error) {
+	data := &Data{}
+	err := queryRows(db, "SELECT id, name, slug, confirmed, deaths, recovered, collected_at FROM covid_stats;", func(rows *sql.Rows) error {
+		c := &country{stats: &statistics{}}
+		err := rows.Scan(&c.code, &c.name, &c.slug, &c.stats.totalConfirmed, &c.stats.totalDeaths, &c.stats.totalRecovered, &c.stats.date)
+		if c.code == globalID {
+			data.global = &global{c.stats}
+		} else {
+			data.countries = append(data.countries, c)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data.global == nil {
+		return nil, fmt.Errorf("No %s row in covid_stats", globalID)
+	}
+
+	err = queryRows(db, "SELECT id, parent, level, name, confirmed, deaths, recovered, collected_at FROM covid_regions;", func(rows *sql.Rows) error {
+		r := &region{stats: &statistics{}}
+		data.regions = append(data.regions, r)
+		return rows.Scan(&r.code, &r.parent, &r.level, &r.name, &r.stats.totalConfirmed, &r.stats.totalDeaths, &r.stats.totalRecovered, &r.stats.date)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, "SELECT id, doses, people_vaccinated, collected_at FROM covid_vaccinations;", func(rows *sql.Rows) error {
+		figures := &vaccinationFigures{}
+		data.vaccinations = append(data.vaccinations, figures)
+		return rows.Scan(&figures.code, &figures.doses, &figures.peopleVaccinated, &figures.date)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, "SELECT id, tests, positive_rate, collected_at FROM covid_testing;", func(rows *sql.Rows) error {
+		figures := &testingFigures{}
+		data.tests = append(data.tests, figures)
+		return rows.Scan(&figures.code, &figures.tests, &figures.positiveRate, &figures.date)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, "SELECT id, datum, patients, collected_at FROM covid_hospital_occupancy;", func(rows *sql.Rows) error {
+		figure := &occupancyFigure{}
+		var datumName string
+		err := rows.Scan(&figure.code, &datumName, &figure.patients, &figure.date)
+		for datum, storedName := range hospitalDatumNames {
+			if storedName == datumName {
+				figure.datum = datum
+			}
+		}
+		data.hospitals = append(data.hospitals, figure)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// queryRows calls scan for every row the query returns
+func queryRows(db *sql.DB, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
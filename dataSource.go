@@ -3,6 +3,7 @@ package durcov
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -48,6 +49,15 @@ func (c *CovidAPI) FetchData() (*Data, error) {
 	return unmarshalData(body)
 }
 
+// ReadData decodes data in the source's format, e.g. a saved response
+func ReadData(r io.Reader) (*Data, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalData(body)
+}
+
 func unmarshalData(rawData []byte) (*Data, error) {
 	data := Data{}
 
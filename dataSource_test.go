@@ -121,3 +121,44 @@ func TestJSONDecoding(t *testing.T) {
 	}
 
 }
+
+func TestDataValidation(t *testing.T) {
+	validData, err := unmarshalData(exampleJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		mutate      func(d *Data)
+		expectError bool
+	}{
+		{"Decoded data is valid", func(d *Data) {}, false},
+		{"Missing global", func(d *Data) { d.global = nil }, true},
+		{"No countries", func(d *Data) { d.countries = nil }, true},
+		{"Missing country code", func(d *Data) { d.countries[0].code = "" }, true},
+		{"Duplicate country code", func(d *Data) { d.countries[1].code = d.countries[0].code }, true},
+		{"Reserved country code", func(d *Data) { d.countries[0].code = "GLOBAL" }, true},
+		{"Negative totals", func(d *Data) { d.countries[0].stats.totalDeaths = -1 }, true},
+		{"Missing date", func(d *Data) { d.global.stats.date = time.Time{} }, true},
+	}
+
+	for _, test := range tests {
+		data, err := unmarshalData(exampleJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.mutate(data)
+		err = data.Validate()
+		if err != nil && !test.expectError {
+			t.Errorf("%s: Didn't expect error. Got=%v", test.name, err)
+		}
+		if err == nil && test.expectError {
+			t.Errorf("%s: Expected error", test.name)
+		}
+	}
+
+	if validData.RowCount() != 4 {
+		t.Errorf("Row count mismatch. Expected=%d Got=%d", 4, validData.RowCount())
+	}
+}
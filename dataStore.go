@@ -24,7 +24,11 @@ func (c *CovidDataStore) SetDBConnection(pgxpool *pgx.ConnPool) {
 }
 
 // StoreData stores given data in the database,
-// Note: StoreData overwrites existing data in the database.
+// Note: StoreData overwrites the latest data in the database.
+// Every stored snapshot is also kept in the history table.
+// Regions are only overwritten when the data has some, so a poll without regional data keeps the last regions stored.
+// Vaccination, testing and hospital occupancy figures are kept the same way.
+// Listeners are notified of the new data once it is committed.
 func (c *CovidDataStore) StoreData(data *Data) error {
 	if c.pgxpool == nil {
 		return errors.New("Database connection not set on data store")
@@ -45,6 +49,44 @@ func (c *CovidDataStore) StoreData(data *Data) error {
 		return err
 	}
 
+	_, err = tx.Exec("INSERT INTO covid_stats_history SELECT id, confirmed, deaths, recovered, collected_at FROM covid_stats ON CONFLICT DO NOTHING")
+	if err != nil {
+		return err
+	}
+
+	if len(data.regions) > 0 {
+		err = storeRegionData(tx, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(data.vaccinations) > 0 {
+		err = storeVaccinationData(tx, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(data.tests) > 0 {
+		err = storeTestingData(tx, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(data.hospitals) > 0 {
+		err = storeHospitalData(tx, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("SELECT pg_notify($1, $2)", updatesChannel, formatUpdatePayload(data.global.stats.date))
+	if err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -99,3 +141,101 @@ func storeBatchCovidData(tx *pgx.Tx, data *Data) error {
 	}
 	return nil
 }
+
+// storeRegionData replaces the stored regions and keeps their snapshots in the history table alongside the countries'
+func storeRegionData(tx *pgx.Tx, data *Data) error {
+	_, err := tx.Exec("TRUNCATE covid_regions")
+	if err != nil {
+		return err
+	}
+
+	source := [][]interface{}{}
+	for _, region := range data.regions {
+		regionData := []interface{}{
+			region.code,
+			region.parent,
+			int32(region.level),
+			region.name,
+			region.stats.totalConfirmed,
+			region.stats.totalDeaths,
+			region.stats.totalRecovered,
+			region.stats.date,
+		}
+		source = append(source, regionData)
+	}
+
+	tableName := pgx.Identifier{"covid_regions"}
+	columns := []string{
+		"id",
+		"parent",
+		"level",
+		"name",
+		"confirmed",
+		"deaths",
+		"recovered",
+		"collected_at",
+	}
+
+	_, err = tx.CopyFrom(tableName, columns, pgx.CopyFromRows(source))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO covid_stats_history SELECT id, confirmed, deaths, recovered, collected_at FROM covid_regions ON CONFLICT DO NOTHING")
+	return err
+}
+
+// storeVaccinationData replaces the stored vaccination figures
+func storeVaccinationData(tx *pgx.Tx, data *Data) error {
+	_, err := tx.Exec("TRUNCATE covid_vaccinations")
+	if err != nil {
+		return err
+	}
+
+	source := [][]interface{}{}
+	for _, figures := range data.vaccinations {
+		source = append(source, []interface{}{figures.code, figures.doses, figures.peopleVaccinated, figures.date})
+	}
+
+	tableName := pgx.Identifier{"covid_vaccinations"}
+	columns := []string{"id", "doses", "people_vaccinated", "collected_at"}
+	_, err = tx.CopyFrom(tableName, columns, pgx.CopyFromRows(source))
+	return err
+}
+
+// storeTestingData replaces the stored testing figures
+func storeTestingData(tx *pgx.Tx, data *Data) error {
+	_, err := tx.Exec("TRUNCATE covid_testing")
+	if err != nil {
+		return err
+	}
+
+	source := [][]interface{}{}
+	for _, figures := range data.tests {
+		source = append(source, []interface{}{figures.code, figures.tests, figures.positiveRate, figures.date})
+	}
+
+	tableName := pgx.Identifier{"covid_testing"}
+	columns := []string{"id", "tests", "positive_rate", "collected_at"}
+	_, err = tx.CopyFrom(tableName, columns, pgx.CopyFromRows(source))
+	return err
+}
+
+// storeHospitalData replaces the stored hospital occupancy figures. Each figure is a row of its own since places
+// publish them on different days.
+func storeHospitalData(tx *pgx.Tx, data *Data) error {
+	_, err := tx.Exec("TRUNCATE covid_hospital_occupancy")
+	if err != nil {
+		return err
+	}
+
+	source := [][]interface{}{}
+	for _, figure := range data.hospitals {
+		source = append(source, []interface{}{figure.code, hospitalDatumNames[figure.datum], figure.patients, figure.date})
+	}
+
+	tableName := pgx.Identifier{"covid_hospital_occupancy"}
+	columns := []string{"id", "datum", "patients", "collected_at"}
+	_, err = tx.CopyFrom(tableName, columns, pgx.CopyFromRows(source))
+	return err
+}
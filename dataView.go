@@ -2,6 +2,8 @@ package durcov
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx"
 	"gopkg.in/errgo.v2/fmt/errors"
@@ -16,13 +18,66 @@ const (
 	Deaths
 	Recovered
 	Active
+	// Doses and Vaccinated are read from vaccination figures, see VaccinationSnapshot
+	Doses
+	Vaccinated
+	// Tests and Positivity are read from testing figures, see TestingSnapshot
+	Tests
+	Positivity
+	// Hospitalized, ICU and Ventilators are read from hospital occupancy figures, see HospitalSnapshot
+	Hospitalized
+	ICU
+	Ventilators
 )
 
+// snapshotWindow is how long a snapshot gives the figures for. Figures are collected about daily, so a snapshot collected
+// up to a day before a time is what the figures were at it even when a later one is closer.
+const snapshotWindow = 24 * time.Hour
+
 // DataView describes functions for obtaining view data
 type DataView interface {
 	SetDBConnection(pgxpool *pgx.ConnPool)
 	LatestGlobalView(datapoint Datum) (int64, error)
 	LatestCountryView(countryCode string, datapoint Datum) (name string, count int64, err error)
+	Countries() ([]*CountryInfo, error)
+	LatestGlobalStats() (*StatsSnapshot, error)
+	LatestCountryStats(countryCode string) (*CountryInfo, *StatsSnapshot, error)
+	GlobalHistory(from time.Time, to time.Time) ([]*StatsSnapshot, error)
+	CountryHistory(countryCode string, from time.Time, to time.Time) ([]*StatsSnapshot, error)
+	GlobalStatsAt(at time.Time) (*StatsSnapshot, error)
+	CountryStatsAt(countryCode string, at time.Time) (*CountryInfo, *StatsSnapshot, error)
+	Regions(parentCode string) ([]*RegionInfo, error)
+	LatestRegionStats(regionCode string) (*RegionInfo, *StatsSnapshot, error)
+	RegionStatsAt(regionCode string, at time.Time) (*RegionInfo, *StatsSnapshot, error)
+	Groups() ([]*GroupInfo, error)
+	LatestGroupStats(groupCode string) (*GroupInfo, *StatsSnapshot, error)
+	GroupStatsAt(groupCode string, at time.Time) (*GroupInfo, *StatsSnapshot, error)
+	LatestGlobalVaccinations() (*VaccinationSnapshot, error)
+	LatestCountryVaccinations(countryCode string) (*CountryInfo, *VaccinationSnapshot, error)
+	LatestGroupVaccinations(groupCode string) (*GroupInfo, *VaccinationSnapshot, error)
+	LatestCountryTests(countryCode string) (*CountryInfo, *TestingSnapshot, error)
+	LatestGroupTests(groupCode string) (*GroupInfo, *TestingSnapshot, error)
+	LatestHospitalOccupancy(code string) (name string, figures *HospitalSnapshot, err error)
+}
+
+// CountryInfo represents the identifying details of a country
+type CountryInfo struct {
+	Name string
+	Slug string
+	Code string
+}
+
+// StatsSnapshot represents covid statistics as collected at a point in time
+type StatsSnapshot struct {
+	Confirmed   int64
+	Deaths      int64
+	Recovered   int64
+	CollectedAt time.Time
+}
+
+// Active returns the number of active cases in the snapshot
+func (s *StatsSnapshot) Active() int64 {
+	return calculateActive(s.Confirmed, s.Deaths, s.Recovered)
 }
 
 // NoCountryMatchedError when data for a given country code is not found in the database
@@ -35,6 +90,15 @@ func (n *NoCountryMatchedError) Error() string {
 	return errMsg
 }
 
+// NoSnapshotError when no statistics or figures have been stored for the requested place and time
+type NoSnapshotError struct {
+	id string
+}
+
+func (n *NoSnapshotError) Error() string {
+	return fmt.Sprintf("No snapshot stored for %s", n.id)
+}
+
 // CovidBotView represents a view for covid data
 type CovidBotView struct {
 	pgxpool *pgx.ConnPool
@@ -130,6 +194,487 @@ func (c *CovidBotView) latestCountryDeaths(countryCode string) (string, int64, e
 	return name, deaths, nil
 }
 
+// Countries returns the identifying details of every country with latest data, ordered by name.
+func (c *CovidBotView) Countries() ([]*CountryInfo, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	rows, err := c.pgxpool.Query("SELECT id, name, slug FROM covid_stats WHERE id<>'GLOBAL' ORDER BY name;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countries := []*CountryInfo{}
+	for rows.Next() {
+		info := &CountryInfo{}
+		err = rows.Scan(&info.Code, &info.Name, &info.Slug)
+		if err != nil {
+			return nil, err
+		}
+		countries = append(countries, info)
+	}
+	return countries, rows.Err()
+}
+
+// LatestGlobalStats returns the latest (available) global statistics.
+func (c *CovidBotView) LatestGlobalStats() (*StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	stats := &StatsSnapshot{}
+	err := c.pgxpool.QueryRow("SELECT confirmed, deaths, recovered, collected_at FROM covid_stats WHERE id='GLOBAL';").Scan(&stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// LatestCountryStats returns the latest (available) statistics for the given country code.
+// Returns err if no match found for the country code.
+func (c *CovidBotView) LatestCountryStats(countryCode string) (*CountryInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info := &CountryInfo{Code: countryCode}
+	stats := &StatsSnapshot{}
+	err := c.pgxpool.QueryRow("SELECT name, slug, confirmed, deaths, recovered, collected_at FROM covid_stats WHERE id=$1;", countryCode).Scan(&info.Name, &info.Slug, &stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil, &NoCountryMatchedError{countryCode}
+		}
+		return nil, nil, err
+	}
+	return info, stats, nil
+}
+
+// GlobalHistory returns the stored global statistics collected between from and to (inclusive), oldest first.
+func (c *CovidBotView) GlobalHistory(from time.Time, to time.Time) ([]*StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	return c.history("GLOBAL", from, to)
+}
+
+// CountryHistory returns the stored statistics for the given country code collected between from and to (inclusive), oldest first.
+// Returns err if no match found for the country code.
+func (c *CovidBotView) CountryHistory(countryCode string, from time.Time, to time.Time) ([]*StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	var exists bool
+	err := c.pgxpool.QueryRow("SELECT EXISTS(SELECT 1 FROM covid_stats WHERE id=$1);", countryCode).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &NoCountryMatchedError{countryCode}
+	}
+	return c.history(countryCode, from, to)
+}
+
+// GlobalStatsAt returns the global statistics as they were at the given time. That's the latest snapshot collected
+// within snapshotWindow before it, or else the snapshot collected nearest to it. Returns a *NoSnapshotError when no
+// history is stored.
+func (c *CovidBotView) GlobalStatsAt(at time.Time) (*StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	return c.snapshotAt("GLOBAL", at)
+}
+
+// CountryStatsAt returns the statistics for the given country code as they were at the given time. The snapshot is
+// picked like GlobalStatsAt picks it.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when no history is stored.
+func (c *CovidBotView) CountryStatsAt(countryCode string, at time.Time) (*CountryInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info := &CountryInfo{Code: countryCode}
+	err := c.pgxpool.QueryRow("SELECT name, slug FROM covid_stats WHERE id=$1;", countryCode).Scan(&info.Name, &info.Slug)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil, &NoCountryMatchedError{countryCode}
+		}
+		return nil, nil, err
+	}
+	stats, err := c.snapshotAt(countryCode, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, stats, nil
+}
+
+// Regions returns the identifying details of the regions directly within the given country or admin1 region, ordered by name.
+// Every region is returned, ordered by code, when parentCode is empty.
+func (c *CovidBotView) Regions(parentCode string) ([]*RegionInfo, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	var rows *pgx.Rows
+	var err error
+	if parentCode == "" {
+		rows, err = c.pgxpool.Query("SELECT id, name, parent, level FROM covid_regions ORDER BY id;")
+	} else {
+		rows, err = c.pgxpool.Query("SELECT id, name, parent, level FROM covid_regions WHERE parent=$1 ORDER BY name;", parentCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regions := []*RegionInfo{}
+	for rows.Next() {
+		info := &RegionInfo{}
+		var level int32
+		err = rows.Scan(&info.Code, &info.Name, &info.Parent, &level)
+		if err != nil {
+			return nil, err
+		}
+		info.Level = RegionLevel(level)
+		regions = append(regions, info)
+	}
+	return regions, rows.Err()
+}
+
+// LatestRegionStats returns the latest (available) statistics for the given region code.
+// Returns err if no match found for the region code.
+func (c *CovidBotView) LatestRegionStats(regionCode string) (*RegionInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info := &RegionInfo{Code: regionCode}
+	stats := &StatsSnapshot{}
+	var level int32
+	err := c.pgxpool.QueryRow("SELECT name, parent, level, confirmed, deaths, recovered, collected_at FROM covid_regions WHERE id=$1;", regionCode).Scan(&info.Name, &info.Parent, &level, &stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil, &NoRegionMatchedError{regionCode}
+		}
+		return nil, nil, err
+	}
+	info.Level = RegionLevel(level)
+	return info, stats, nil
+}
+
+// RegionStatsAt returns the statistics for the given region code as they were at the given time, like CountryStatsAt.
+// Returns err if no match found for the region code (or) a *NoSnapshotError when no history is stored.
+func (c *CovidBotView) RegionStatsAt(regionCode string, at time.Time) (*RegionInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info := &RegionInfo{Code: regionCode}
+	var level int32
+	err := c.pgxpool.QueryRow("SELECT name, parent, level FROM covid_regions WHERE id=$1;", regionCode).Scan(&info.Name, &info.Parent, &level)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil, &NoRegionMatchedError{regionCode}
+		}
+		return nil, nil, err
+	}
+	info.Level = RegionLevel(level)
+	stats, err := c.snapshotAt(regionCode, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, stats, nil
+}
+
+// Groups returns the built in groups and the custom groups stored, ordered by name.
+func (c *CovidBotView) Groups() ([]*GroupInfo, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	rows, err := c.pgxpool.Query("SELECT code, name, members FROM country_groups;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := builtInGroupList()
+	for rows.Next() {
+		group := &GroupInfo{}
+		err = rows.Scan(&group.Code, &group.Name, &group.Members)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	sortGroups(groups)
+	return groups, rows.Err()
+}
+
+// LatestGroupStats returns the latest (available) statistics of the group's members added up.
+// Returns err if no match found for the group code (or) a *NoSnapshotError when none of its members have statistics.
+func (c *CovidBotView) LatestGroupStats(groupCode string) (*GroupInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	group, err := c.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := &StatsSnapshot{}
+	err = c.pgxpool.QueryRow("SELECT SUM(confirmed), SUM(deaths), SUM(recovered), MAX(collected_at) FROM covid_stats WHERE id = ANY($1) HAVING COUNT(*) > 0;", group.Members).Scan(&stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, stats, nil
+}
+
+// GroupStatsAt returns the statistics of the group's members as they were at the given time added up. Each member's
+// snapshot is picked like CountryStatsAt picks it. Returns err if no match found for the group code (or) a
+// *NoSnapshotError when no history is stored for any of its members.
+func (c *CovidBotView) GroupStatsAt(groupCode string, at time.Time) (*GroupInfo, *StatsSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	group, err := c.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := &StatsSnapshot{}
+	err = c.pgxpool.QueryRow(`SELECT SUM(confirmed), SUM(deaths), SUM(recovered), MAX(collected_at) FROM (
+		SELECT DISTINCT ON (id) id, confirmed, deaths, recovered, collected_at FROM covid_stats_history WHERE id = ANY($1)
+		ORDER BY id, `+nearestSnapshotOrder+`
+	) AS nearest HAVING COUNT(*) > 0;`, group.Members, at, at.Add(-snapshotWindow)).Scan(&stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, stats, nil
+}
+
+func (c *CovidBotView) group(code string) (*GroupInfo, error) {
+	if group, ok := builtInGroup(code); ok {
+		return group, nil
+	}
+	group := &GroupInfo{Code: code}
+	err := c.pgxpool.QueryRow("SELECT name, members FROM country_groups WHERE code=$1;", code).Scan(&group.Name, &group.Members)
+	if err == pgx.ErrNoRows {
+		return nil, &NoGroupMatchedError{code}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// LatestGlobalVaccinations returns the latest (available) vaccination figures for the world.
+// Returns a *NoSnapshotError when none are stored.
+func (c *CovidBotView) LatestGlobalVaccinations() (*VaccinationSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("DB Connection not set in data view")
+	}
+	return c.vaccinations(globalID)
+}
+
+// LatestCountryVaccinations returns the latest (available) vaccination figures for the given country code.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when none are stored for the country.
+func (c *CovidBotView) LatestCountryVaccinations(countryCode string) (*CountryInfo, *VaccinationSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info, err := c.country(countryCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	figures, err := c.vaccinations(countryCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, figures, nil
+}
+
+// LatestGroupVaccinations returns the latest (available) vaccination figures of the group's members added up.
+// Returns err if no match found for the group code (or) a *NoSnapshotError when none are stored for its members.
+func (c *CovidBotView) LatestGroupVaccinations(groupCode string) (*GroupInfo, *VaccinationSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	group, err := c.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	figures := &VaccinationSnapshot{}
+	err = c.pgxpool.QueryRow("SELECT SUM(doses), SUM(people_vaccinated), MAX(collected_at) FROM covid_vaccinations WHERE id = ANY($1) HAVING COUNT(*) > 0;", group.Members).Scan(&figures.Doses, &figures.PeopleVaccinated, &figures.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, figures, nil
+}
+
+// LatestCountryTests returns the latest (available) testing figures for the given country code.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when none are stored for the country.
+func (c *CovidBotView) LatestCountryTests(countryCode string) (*CountryInfo, *TestingSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	info, err := c.country(countryCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	figures := &TestingSnapshot{}
+	err = c.pgxpool.QueryRow("SELECT tests, positive_rate, collected_at FROM covid_testing WHERE id=$1;", countryCode).Scan(&figures.Tests, &figures.PositiveRate, &figures.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, &NoSnapshotError{countryCode}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, figures, nil
+}
+
+// LatestGroupTests returns the latest (available) testing figures of the group's members added up, with the positive
+// rate weighted by each member's tests. Returns err if no match found for the group code (or) a *NoSnapshotError when
+// none are stored for its members.
+func (c *CovidBotView) LatestGroupTests(groupCode string) (*GroupInfo, *TestingSnapshot, error) {
+	if c.pgxpool == nil {
+		return nil, nil, errors.New("DB Connection not set in data view")
+	}
+	group, err := c.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	figures := &TestingSnapshot{}
+	err = c.pgxpool.QueryRow("SELECT SUM(tests), COALESCE(SUM(positive_rate * tests) / NULLIF(SUM(tests), 0), 0), MAX(collected_at) FROM covid_testing WHERE id = ANY($1) HAVING COUNT(*) > 0;", group.Members).Scan(&figures.Tests, &figures.PositiveRate, &figures.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, figures, nil
+}
+
+// LatestHospitalOccupancy returns the name of the country or region with the given code and the latest (available)
+// hospital occupancy figures it publishes. Figures it doesn't publish are nil. A region the regional report doesn't have
+// is named after its country when figures are published for it. Returns err if no match found for the country or region code.
+func (c *CovidBotView) LatestHospitalOccupancy(code string) (string, *HospitalSnapshot, error) {
+	if c.pgxpool == nil {
+		return "", nil, errors.New("DB Connection not set in data view")
+	}
+	var name string
+	if IsRegionCode(code) {
+		err := c.pgxpool.QueryRow("SELECT name FROM covid_regions WHERE id=$1;", code).Scan(&name)
+		if err == pgx.ErrNoRows {
+			name, err = c.hospitalRegionName(code)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		info, err := c.country(code)
+		if err != nil {
+			return "", nil, err
+		}
+		name = info.Name
+	}
+
+	rows, err := c.pgxpool.Query("SELECT datum, patients, collected_at FROM covid_hospital_occupancy WHERE id=$1;", code)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	figures := &HospitalSnapshot{}
+	for rows.Next() {
+		var datumName string
+		figure := &OccupancyFigure{}
+		err = rows.Scan(&datumName, &figure.Patients, &figure.CollectedAt)
+		if err != nil {
+			return "", nil, err
+		}
+		for datum, storedName := range hospitalDatumNames {
+			if storedName == datumName {
+				figures.set(datum, figure)
+			}
+		}
+	}
+	return name, figures, rows.Err()
+}
+
+func (c *CovidBotView) country(countryCode string) (*CountryInfo, error) {
+	info := &CountryInfo{Code: countryCode}
+	err := c.pgxpool.QueryRow("SELECT name, slug FROM covid_stats WHERE id=$1;", countryCode).Scan(&info.Name, &info.Slug)
+	if err == pgx.ErrNoRows {
+		return nil, &NoCountryMatchedError{countryCode}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *CovidBotView) vaccinations(id string) (*VaccinationSnapshot, error) {
+	figures := &VaccinationSnapshot{}
+	err := c.pgxpool.QueryRow("SELECT doses, people_vaccinated, collected_at FROM covid_vaccinations WHERE id=$1;", id).Scan(&figures.Doses, &figures.PeopleVaccinated, &figures.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, &NoSnapshotError{id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return figures, nil
+}
+
+// hospitalRegionName names a region the regional report doesn't have when hospital occupancy figures are stored for it.
+// Returns a *NoRegionMatchedError when there are none.
+func (c *CovidBotView) hospitalRegionName(code string) (string, error) {
+	var countryName string
+	err := c.pgxpool.QueryRow("SELECT name FROM covid_stats WHERE id=$1 AND EXISTS (SELECT 1 FROM covid_hospital_occupancy WHERE id=$2);", strings.SplitN(code, "-", 2)[0], code).Scan(&countryName)
+	if err == pgx.ErrNoRows {
+		return "", &NoRegionMatchedError{code}
+	}
+	if err != nil {
+		return "", err
+	}
+	return hospitalRegionName(code, countryName), nil
+}
+
+// nearestSnapshotOrder orders snapshots so the one for the time in $2 comes first: snapshots collected within
+// snapshotWindow before it ($3), then the rest, nearest first. Ties go to the earlier snapshot.
+const nearestSnapshotOrder = "collected_at BETWEEN $3 AND $2 DESC, ABS(EXTRACT(EPOCH FROM collected_at - $2)), collected_at"
+
+func (c *CovidBotView) snapshotAt(id string, at time.Time) (*StatsSnapshot, error) {
+	stats := &StatsSnapshot{}
+	err := c.pgxpool.QueryRow("SELECT confirmed, deaths, recovered, collected_at FROM covid_stats_history WHERE id=$1 ORDER BY "+nearestSnapshotOrder+" LIMIT 1;", id, at, at.Add(-snapshotWindow)).Scan(&stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+	if err == pgx.ErrNoRows {
+		return nil, &NoSnapshotError{id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (c *CovidBotView) history(id string, from time.Time, to time.Time) ([]*StatsSnapshot, error) {
+	rows, err := c.pgxpool.Query("SELECT confirmed, deaths, recovered, collected_at FROM covid_stats_history WHERE id=$1 AND collected_at BETWEEN $2 AND $3 ORDER BY collected_at;", id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*StatsSnapshot{}
+	for rows.Next() {
+		stats := &StatsSnapshot{}
+		err = rows.Scan(&stats.Confirmed, &stats.Deaths, &stats.Recovered, &stats.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, stats)
+	}
+	return history, rows.Err()
+}
+
 func calculateActive(confirmed int64, deaths int64, recovered int64) int64 {
 	return confirmed - (deaths + recovered)
 }
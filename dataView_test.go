@@ -3,6 +3,7 @@ package durcov
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDataView(t *testing.T) {
@@ -86,6 +87,21 @@ func TestDataView(t *testing.T) {
 				}
 			}
 		},
+		"Country stats at a time": func(t *testing.T) {
+			for _, country := range exampleData.countries {
+				info, stats, err := dataView.CountryStatsAt(country.code, country.stats.date)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Name != country.name || stats.Deaths != country.stats.totalDeaths || !stats.CollectedAt.Equal(country.stats.date) {
+					t.Errorf("country snapshot mismatch for country=%s. Got=%+v", country.name, stats)
+				}
+			}
+			_, _, err := dataView.CountryStatsAt("--", time.Now())
+			if err, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Unexpected error. Expected=*NoCountryMatchedError Got=%T", err)
+			}
+		},
 		"Unmathced country code returns specific error": func(t *testing.T) {
 			_, _, err := dataView.LatestCountryView("--", Active)
 			if err, ok := err.(*NoCountryMatchedError); !ok {
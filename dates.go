@@ -0,0 +1,180 @@
+package durcov
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateRange represents when a question is about. From is zero for questions about a point in time.
+type dateRange struct {
+	from time.Time
+	to   time.Time
+}
+
+func (d *dateRange) isRange() bool {
+	return !d.from.IsZero()
+}
+
+// datePattern matches the dates a question can name, e.g. 2020-11-01, 1 Nov 2020 or 1 November 2020
+const datePattern = `(\d{4}-\d{2}-\d{2}|\d{1,2}\s+[A-Z]+\s+\d{4})`
+
+var dateLayouts = []string{"2006-01-02", "2 Jan 2006", "2 January 2006"}
+
+// dateQualifier represents a way of saying when at the end of a question, e.g. "ON 2020-11-01" or "LAST WEEK"
+type dateQualifier struct {
+	pattern *regexp.Regexp
+	// resolve returns when the matched qualifier is about
+	resolve func(matches []string, now time.Time) (*dateRange, error)
+}
+
+func newDateQualifier(pattern string, resolve func(matches []string, now time.Time) (*dateRange, error)) *dateQualifier {
+	return &dateQualifier{regexp.MustCompile(`(?i)(?:^|\s)` + pattern + `$`), resolve}
+}
+
+// dateQualifiers are tried in order. Days are counted in UTC, like the collected data.
+var dateQualifiers = []*dateQualifier{
+	newDateQualifier(`(?:FROM|BETWEEN)\s+`+datePattern+`\s+(?:TO|AND|UNTIL)\s+`+datePattern, func(matches []string, now time.Time) (*dateRange, error) {
+		from, err := parseDay(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseDay(matches[2])
+		if err != nil {
+			return nil, err
+		}
+		if to.Before(from) {
+			from, to = to, from
+		}
+		return &dateRange{endOfDay(from), endOfDay(to)}, nil
+	}),
+	newDateQualifier(`SINCE\s+`+datePattern, func(matches []string, now time.Time) (*dateRange, error) {
+		from, err := parseDay(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		return &dateRange{endOfDay(from), now}, nil
+	}),
+	newDateQualifier(`(?:ON\s+)?`+datePattern, func(matches []string, now time.Time) (*dateRange, error) {
+		day, err := parseDay(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		return &dateRange{to: endOfDay(day)}, nil
+	}),
+	newDateQualifier(`TODAY`, func(matches []string, now time.Time) (*dateRange, error) {
+		return &dateRange{to: now}, nil
+	}),
+	newDateQualifier(`YESTERDAY`, func(matches []string, now time.Time) (*dateRange, error) {
+		return &dateRange{to: endOfDay(now.AddDate(0, 0, -1))}, nil
+	}),
+	newDateQualifier(`(\d+|A|ONE)\s+(DAY|DAYS|WEEK|WEEKS)\s+AGO`, func(matches []string, now time.Time) (*dateRange, error) {
+		days, err := countDays(matches[1], matches[2])
+		if err != nil {
+			return nil, err
+		}
+		return &dateRange{to: endOfDay(now.AddDate(0, 0, -days))}, nil
+	}),
+	newDateQualifier(`(?:LAST|PAST|THIS)\s+(WEEK|MONTH)`, func(matches []string, now time.Time) (*dateRange, error) {
+		days := 7
+		if strings.EqualFold(matches[1], "MONTH") {
+			days = 30
+		}
+		return &dateRange{now.AddDate(0, 0, -days), now}, nil
+	}),
+	newDateQualifier(`(?:LAST|PAST)\s+(\d+)\s+(DAYS|WEEKS)`, func(matches []string, now time.Time) (*dateRange, error) {
+		days, err := countDays(matches[1], matches[2])
+		if err != nil {
+			return nil, err
+		}
+		return &dateRange{now.AddDate(0, 0, -days), now}, nil
+	}),
+}
+
+// trailingPrepositions are left over when a qualifier is cut from e.g. "deaths in the last week"
+var trailingPrepositions = map[string]bool{"IN": true, "OVER": true, "DURING": true, "FOR": true, "OF": true}
+
+// splitDateQualifier cuts a date qualifier from the end of the message, e.g. "CASES IN ON 2020-11-01" is "CASES IN" on 1 Nov 2020.
+// Returns a nil range when the message doesn't say when. Dates that can't be read or are in the future are errors.
+func splitDateQualifier(message string, now time.Time) (string, *dateRange, error) {
+	trimmed := strings.TrimRight(message, "?!. ")
+	for _, qualifier := range dateQualifiers {
+		loc := qualifier.pattern.FindStringSubmatchIndex(trimmed)
+		if loc == nil {
+			continue
+		}
+		matches := []string{}
+		for i := 0; i < len(loc); i += 2 {
+			if loc[i] < 0 {
+				matches = append(matches, "")
+				continue
+			}
+			matches = append(matches, trimmed[loc[i]:loc[i+1]])
+		}
+		when, err := qualifier.resolve(matches, now)
+		if err != nil {
+			return "", nil, err
+		}
+		if when.to.After(endOfDay(now)) {
+			return "", nil, fmt.Errorf("%s is in the future", when.to.Format("2 Jan 2006"))
+		}
+		return trimQualifierPrepositions(trimmed[:loc[0]]), when, nil
+	}
+	return message, nil, nil
+}
+
+// trimQualifierPrepositions drops "in the" and the like left at the end of the message once the qualifier is cut.
+// A lone preposition is kept because IN is also India's code.
+func trimQualifierPrepositions(message string) string {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || !strings.EqualFold(fields[len(fields)-1], "THE") {
+		return strings.Join(fields, " ")
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields) > 0 && trailingPrepositions[strings.ToUpper(fields[len(fields)-1])] {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseDay(text string) (time.Time, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, layout := range dateLayouts {
+		day, err := time.Parse(layout, text)
+		if err == nil {
+			return day, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unable to read date %q", text)
+}
+
+// countDays returns the days in e.g. "3 days" or "a week"
+func countDays(count string, unit string) (int, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		if !strings.EqualFold(count, "A") && !strings.EqualFold(count, "ONE") {
+			return 0, fmt.Errorf("Unable to read count %q", count)
+		}
+		n = 1
+	}
+	if strings.HasPrefix(strings.ToUpper(unit), "WEEK") {
+		n *= 7
+	}
+	if n > 3660 {
+		return 0, fmt.Errorf("%d days is too far back", n)
+	}
+	return n, nil
+}
+
+// endOfDay returns the last moment of the UTC day so a day's figures include everything collected on it
+func endOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+}
+
+// sameDay reports whether both times fall on the same UTC day
+func sameDay(a time.Time, b time.Time) bool {
+	return endOfDay(a).Equal(endOfDay(b))
+}
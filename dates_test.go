@@ -0,0 +1,123 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitDateQualifier(t *testing.T) {
+	now := time.Date(2020, 12, 4, 3, 49, 29, 0, time.UTC)
+	day := func(year int, month time.Month, day int) time.Time {
+		return endOfDay(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+	}
+
+	tests := []struct {
+		input    string
+		query    string
+		expected *dateRange
+	}{
+		{"CASES IN ON 2020-11-01", "CASES IN", &dateRange{to: day(2020, 11, 1)}},
+		{"cases in on 2020-11-01", "cases in", &dateRange{to: day(2020, 11, 1)}},
+		{"DEATHS SG 2020-11-01", "DEATHS SG", &dateRange{to: day(2020, 11, 1)}},
+		{"DEATHS SG on 1 Nov 2020", "DEATHS SG", &dateRange{to: day(2020, 11, 1)}},
+		{"DEATHS SG on 1 November 2020?", "DEATHS SG", &dateRange{to: day(2020, 11, 1)}},
+		{"DEATHS SG YESTERDAY", "DEATHS SG", &dateRange{to: day(2020, 12, 3)}},
+		{"deaths sg today", "deaths sg", &dateRange{to: now}},
+		{"CASES TOTAL 3 DAYS AGO", "CASES TOTAL", &dateRange{to: day(2020, 12, 1)}},
+		{"CASES TOTAL a week ago", "CASES TOTAL", &dateRange{to: day(2020, 11, 27)}},
+		{"CASES TOTAL 2 weeks ago", "CASES TOTAL", &dateRange{to: day(2020, 11, 20)}},
+		{"DEATHS SG LAST WEEK", "DEATHS SG", &dateRange{now.AddDate(0, 0, -7), now}},
+		{"DEATHS SG past month", "DEATHS SG", &dateRange{now.AddDate(0, 0, -30), now}},
+		{"DEATHS SG LAST 3 DAYS", "DEATHS SG", &dateRange{now.AddDate(0, 0, -3), now}},
+		{"DEATHS SG FROM 2020-11-01 TO 2020-11-08", "DEATHS SG", &dateRange{day(2020, 11, 1), day(2020, 11, 8)}},
+		{"DEATHS SG between 8 Nov 2020 and 1 Nov 2020", "DEATHS SG", &dateRange{day(2020, 11, 1), day(2020, 11, 8)}},
+		{"DEATHS SG since 2020-11-01", "DEATHS SG", &dateRange{day(2020, 11, 1), now}},
+		{"how many people died in brazil in the last week?", "how many people died in brazil", &dateRange{now.AddDate(0, 0, -7), now}},
+		{"deaths in brazil over the past month", "deaths in brazil", &dateRange{now.AddDate(0, 0, -30), now}},
+		{"and yesterday?", "and", &dateRange{to: day(2020, 12, 3)}},
+		{"yesterday", "", &dateRange{to: day(2020, 12, 3)}},
+		{"CASES SG", "CASES SG", nil},
+		{"CASES TOTAL", "CASES TOTAL", nil},
+		{"how many people died?", "how many people died?", nil},
+		{"DEATHS SG ON 2020-11-01X", "DEATHS SG ON 2020-11-01X", nil},
+	}
+
+	for _, test := range tests {
+		query, when, err := splitDateQualifier(test.input, now)
+		if err != nil {
+			t.Errorf("Unexpected error. Input: %s Error: %v", test.input, err)
+			continue
+		}
+		if query != test.query {
+			t.Errorf("Query mismatch. Input: %s Expected=%q Got=%q", test.input, test.query, query)
+		}
+		if test.expected == nil {
+			if when != nil {
+				t.Errorf("Expected no date. Input: %s Got=%+v", test.input, when)
+			}
+		} else if when == nil || !when.from.Equal(test.expected.from) || !when.to.Equal(test.expected.to) {
+			t.Errorf("Date mismatch. Input: %s Expected=%+v Got=%+v", test.input, test.expected, when)
+		}
+	}
+
+	for _, input := range []string{"CASES SG ON 2020-13-01", "CASES SG ON 31 Foo 2020", "CASES SG ON 2021-01-01", "CASES SG 100000 DAYS AGO"} {
+		if _, _, err := splitDateQualifier(input, now); err == nil {
+			t.Errorf("Expected an error. Input: %s", input)
+		}
+	}
+}
+
+func TestBotDatedQueries(t *testing.T) {
+	first := time.Date(2020, 11, 1, 3, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 11, 8, 3, 0, 0, 0, time.UTC)
+	memoryStore := NewMemoryStore()
+	memoryStore.StoreData(ExampleTestDataAt(first))
+	laterData := ExampleTestDataAt(second)
+	laterData.global.stats.totalDeaths = 510000
+	laterData.countries[1].stats.totalDeaths = 1900
+	memoryStore.StoreData(laterData)
+
+	// Answers about the past never carry the stale notice latest answers do
+	testBot := NewBot(memoryStore, 24*time.Hour)
+	testBot.now = func() time.Time { return time.Date(2020, 11, 9, 12, 0, 0, 0, time.UTC) }
+	testBot.SetSessions(NewMemorySessionStore(time.Hour))
+
+	tests := []struct {
+		sender   string
+		input    string
+		expected string
+	}{
+		{"", "DEATHS SG ON 2020-11-01", "[SG] Singapore Deaths on 1 Nov 2020: 1,822"},
+		{"", "DEATHS SG ON 2020-11-08", "[SG] Singapore Deaths on 8 Nov 2020: 1,900"},
+		{"", "DEATHS TOTAL ON 2020-11-03", "Total Deaths on 1 Nov 2020: 500,000\n(I don't have data for 3 Nov 2020. This is the nearest day I have.)"},
+		// The later snapshot is closer
+		{"", "DEATHS TOTAL ON 2020-11-05", "Total Deaths on 8 Nov 2020: 510,000\n(I don't have data for 5 Nov 2020. This is the nearest day I have.)"},
+		{"", "DEATHS SG ON 2020-10-29", "[SG] Singapore Deaths on 1 Nov 2020: 1,822\n(I don't have data for 29 Oct 2020. This is the nearest day I have.)"},
+		{"", "DEATHS SG ON 2020-10-01", "Sorry, I don't have data near 1 Oct 2020. The nearest day I have is 1 Nov 2020."},
+		{"", "DEATHS SG FROM 2020-10-01 TO 2020-11-08", "Sorry, I don't have data near 1 Oct 2020. The nearest day I have is 1 Nov 2020."},
+		{"", "CASES SG YESTERDAY", "[SG] Singapore Active Cases on 8 Nov 2020: 8,054"},
+		{"", "DEATHS SG FROM 2020-11-01 TO 2020-11-08", "[SG] Singapore Deaths went from 1,822 on 1 Nov 2020 to 1,900 on 8 Nov 2020 (+78)"},
+		{"", "CASES SG FROM 2020-11-01 TO 2020-11-08", "[SG] Singapore Active Cases went from 8,132 on 1 Nov 2020 to 8,054 on 8 Nov 2020 (-78)"},
+		{"", "deaths total last week", "Total Deaths went from 500,000 on 1 Nov 2020 to 510,000 on 8 Nov 2020 (+10,000)"},
+		{"", "DEATHS SG FROM 2020-11-02 TO 2020-11-03", "Sorry, I don't have enough history for that. The nearest day I have is 1 Nov 2020."},
+		{"", "how many people died in singapore on 1 Nov 2020", "[SG] Singapore Deaths on 1 Nov 2020: 1,822"},
+		{"", "DEATHS SG ON 2020-12-01", "Sorry, I couldn't use that date. Try e.g. CASES SG ON 2020-11-01 or DEATHS SG LAST WEEK."},
+		{"", "DEATHS IN ON 2020-11-01", "Sorry, that code doesn't match any countries I know."},
+		// Follow-ups can be dated too
+		{"telegram:1", "DEATHS SG", "[SG] Singapore Deaths: 1,900\n(Heads up: this data was last updated 8 Nov 2020 and may be out of date.)"},
+		{"telegram:1", "and on 2020-11-01?", "[SG] Singapore Deaths on 1 Nov 2020: 1,822"},
+		{"telegram:1", "what about cases yesterday", "[SG] Singapore Active Cases on 8 Nov 2020: 8,054"},
+	}
+
+	for _, test := range tests {
+		response, _ := testBot.RespondTo(test.sender, test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+
+	_, command := testBot.RespondTo("", "DEATHS SG YESTERDAY")
+	if command != nil {
+		t.Errorf("Expected no command for answers about the past. Got=%+v", command)
+	}
+}
@@ -0,0 +1,201 @@
+package durcov
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Delivery statuses reported by twilio that count as failures
+const (
+	DeliveryFailed      = "failed"
+	DeliveryUndelivered = "undelivered"
+)
+
+// deliveryStatusRanks orders twilio's statuses by how far along a message is. Callbacks can arrive out of order so
+// the furthest status is a message's latest, e.g. sent reported after delivered doesn't undo the delivery.
+// Delivered, undelivered and failed are final. WhatsApp reports read after delivered. Unknown statuses rank lowest.
+var deliveryStatusRanks = map[string]int{
+	"accepted":          1,
+	"scheduled":         1,
+	"queued":            2,
+	"sending":           3,
+	"sent":              4,
+	"delivered":         5,
+	DeliveryUndelivered: 5,
+	DeliveryFailed:      5,
+	"read":              6,
+}
+
+// StatusUpdate represents a delivery status reported for an outbound message
+type StatusUpdate struct {
+	MessageSid string
+	InboundSid string
+	Status     string
+	ErrorCode  string
+	RecordedAt time.Time
+}
+
+// DeliveryStats represents a summary of the latest status of every outbound message
+type DeliveryStats struct {
+	Total    int
+	ByStatus map[string]int
+}
+
+// FailureRate returns the fraction of messages whose latest status is failed or undelivered
+func (d *DeliveryStats) FailureRate() float64 {
+	if d.Total == 0 {
+		return 0
+	}
+	return float64(d.ByStatus[DeliveryFailed]+d.ByStatus[DeliveryUndelivered]) / float64(d.Total)
+}
+
+// DeliveryStatusStore describes an API to record and summarise delivery statuses of outbound messages
+type DeliveryStatusStore interface {
+	RecordStatus(update *StatusUpdate) error
+	MessageStatuses(messageSid string) ([]*StatusUpdate, error)
+	DeliveryStats(since time.Time) (*DeliveryStats, error)
+}
+
+// CovidDeliveryStatusStore represents a postgres backed store of delivery statuses
+type CovidDeliveryStatusStore struct {
+	pgxpool *pgx.ConnPool
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the store.
+func (c *CovidDeliveryStatusStore) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// RecordStatus appends the status to the message's transitions. RecordedAt is set when zero.
+func (c *CovidDeliveryStatusStore) RecordStatus(update *StatusUpdate) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on delivery status store")
+	}
+	if update.RecordedAt.IsZero() {
+		update.RecordedAt = time.Now().UTC()
+	}
+	_, err := c.pgxpool.Exec("INSERT INTO message_statuses (message_sid, inbound_sid, status, error_code, recorded_at) VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5);", update.MessageSid, update.InboundSid, update.Status, update.ErrorCode, update.RecordedAt)
+	return err
+}
+
+// MessageStatuses returns every status recorded for the message, oldest first.
+func (c *CovidDeliveryStatusStore) MessageStatuses(messageSid string) ([]*StatusUpdate, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on delivery status store")
+	}
+	rows, err := c.pgxpool.Query("SELECT message_sid, COALESCE(inbound_sid, ''), status, COALESCE(error_code, ''), recorded_at FROM message_statuses WHERE message_sid=$1 ORDER BY recorded_at, id;", messageSid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	updates := []*StatusUpdate{}
+	for rows.Next() {
+		update := &StatusUpdate{}
+		err = rows.Scan(&update.MessageSid, &update.InboundSid, &update.Status, &update.ErrorCode, &update.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+// DeliveryStats summarises the furthest status of every message with a status recorded since the given time.
+// Statuses are ranked like deliveryStatusRanks, the latest recorded winning ties.
+func (c *CovidDeliveryStatusStore) DeliveryStats(since time.Time) (*DeliveryStats, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on delivery status store")
+	}
+	rows, err := c.pgxpool.Query(`SELECT status, COUNT(*) FROM (
+			SELECT DISTINCT ON (message_sid) status FROM message_statuses
+			WHERE recorded_at >= $1
+			ORDER BY message_sid, CASE status
+				WHEN 'accepted' THEN 1 WHEN 'scheduled' THEN 1 WHEN 'queued' THEN 2 WHEN 'sending' THEN 3 WHEN 'sent' THEN 4
+				WHEN 'delivered' THEN 5 WHEN 'undelivered' THEN 5 WHEN 'failed' THEN 5 WHEN 'read' THEN 6 ELSE 0
+			END DESC, recorded_at DESC, id DESC
+		) latest GROUP BY status;`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &DeliveryStats{ByStatus: map[string]int{}}
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+		stats.ByStatus[status] = count
+		stats.Total += count
+	}
+	return stats, rows.Err()
+}
+
+// MemoryDeliveryStatusStore represents an in-memory store of delivery statuses
+type MemoryDeliveryStatusStore struct {
+	mu      sync.Mutex
+	updates map[string][]*StatusUpdate
+}
+
+// NewMemoryDeliveryStatusStore returns an empty MemoryDeliveryStatusStore
+func NewMemoryDeliveryStatusStore() *MemoryDeliveryStatusStore {
+	return &MemoryDeliveryStatusStore{updates: map[string][]*StatusUpdate{}}
+}
+
+// RecordStatus appends the status to the message's transitions. RecordedAt is set when zero.
+func (m *MemoryDeliveryStatusStore) RecordStatus(update *StatusUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if update.RecordedAt.IsZero() {
+		update.RecordedAt = time.Now().UTC()
+	}
+	recorded := *update
+	m.updates[update.MessageSid] = append(m.updates[update.MessageSid], &recorded)
+	sort.SliceStable(m.updates[update.MessageSid], func(i, j int) bool {
+		return m.updates[update.MessageSid][i].RecordedAt.Before(m.updates[update.MessageSid][j].RecordedAt)
+	})
+	return nil
+}
+
+// MessageStatuses returns every status recorded for the message, oldest first.
+func (m *MemoryDeliveryStatusStore) MessageStatuses(messageSid string) ([]*StatusUpdate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*StatusUpdate{}, m.updates[messageSid]...), nil
+}
+
+// DeliveryStats summarises the furthest status of every message with a status recorded since the given time.
+// Statuses are ranked by deliveryStatusRanks, the latest recorded winning ties.
+func (m *MemoryDeliveryStatusStore) DeliveryStats(since time.Time) (*DeliveryStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &DeliveryStats{ByStatus: map[string]int{}}
+	for _, updates := range m.updates {
+		var latest *StatusUpdate
+		for _, update := range updates {
+			if update.RecordedAt.Before(since) {
+				continue
+			}
+			if latest == nil || deliveryStatusRanks[update.Status] >= deliveryStatusRanks[latest.Status] {
+				latest = update
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		stats.ByStatus[latest.Status]++
+		stats.Total++
+	}
+	return stats, nil
+}
@@ -0,0 +1,87 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryDeliveryStatusStore(t *testing.T) {
+	now := time.Date(2020, 12, 7, 12, 0, 0, 0, time.UTC)
+	tests := map[string]func(t *testing.T){
+		"Keeps status transitions in order": func(t *testing.T) {
+			store := NewMemoryDeliveryStatusStore()
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", InboundSid: "SM0", Status: "delivered", RecordedAt: now.Add(2 * time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", InboundSid: "SM0", Status: "sent", RecordedAt: now.Add(time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", InboundSid: "SM0", Status: "queued", RecordedAt: now})
+
+			updates, err := store.MessageStatuses("SM1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(updates) != 3 {
+				t.Fatalf("Status count mismatch. Expected=%d Got=%d", 3, len(updates))
+			}
+			for i, expected := range []string{"queued", "sent", "delivered"} {
+				if updates[i].Status != expected {
+					t.Errorf("Status %d mismatch. Expected=%s Got=%s", i, expected, updates[i].Status)
+				}
+				if updates[i].InboundSid != "SM0" {
+					t.Errorf("Inbound sid mismatch. Expected=%s Got=%s", "SM0", updates[i].InboundSid)
+				}
+			}
+		},
+		"Summarises the latest status of each message": func(t *testing.T) {
+			store := NewMemoryDeliveryStatusStore()
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", Status: "sent", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", Status: "delivered", RecordedAt: now.Add(time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM2", Status: "sent", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM2", Status: "undelivered", RecordedAt: now.Add(time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM3", Status: "failed", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM4", Status: "delivered", RecordedAt: now.Add(-time.Hour)})
+
+			stats, err := store.DeliveryStats(now.Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Total != 3 {
+				t.Errorf("Total mismatch. Expected=%d Got=%d", 3, stats.Total)
+			}
+			if stats.ByStatus["delivered"] != 1 || stats.ByStatus["undelivered"] != 1 || stats.ByStatus["failed"] != 1 || stats.ByStatus["sent"] != 0 {
+				t.Errorf("Status counts mismatch. Got=%v", stats.ByStatus)
+			}
+			if stats.FailureRate() != 2.0/3.0 {
+				t.Errorf("Failure rate mismatch. Expected=%f Got=%f", 2.0/3.0, stats.FailureRate())
+			}
+		},
+		"Callbacks arriving out of order don't undo final statuses": func(t *testing.T) {
+			store := NewMemoryDeliveryStatusStore()
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", Status: "delivered", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM1", Status: "sent", RecordedAt: now.Add(time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM2", Status: "failed", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM2", Status: "queued", RecordedAt: now.Add(time.Second)})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM3", Status: "sent", RecordedAt: now})
+			store.RecordStatus(&StatusUpdate{MessageSid: "SM3", Status: "sending", RecordedAt: now.Add(time.Second)})
+
+			stats, err := store.DeliveryStats(now.Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.ByStatus["delivered"] != 1 || stats.ByStatus["failed"] != 1 || stats.ByStatus["sent"] != 1 || stats.Total != 3 {
+				t.Errorf("Status counts mismatch. Got=%v", stats.ByStatus)
+			}
+		},
+		"No messages have no failures": func(t *testing.T) {
+			stats, err := NewMemoryDeliveryStatusStore().DeliveryStats(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.FailureRate() != 0 {
+				t.Errorf("Failure rate mismatch. Expected=%f Got=%f", 0.0, stats.FailureRate())
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,4 @@
+// Package durcovpb contains the protobuf definition and generated grpc bindings for serving durcov data.
+package durcovpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative durcov.proto
@@ -0,0 +1,241 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package durcovpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// DurCovClient is the client API for DurCov service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DurCovClient interface {
+	// GetLatest returns the latest global statistics and statistics for the requested countries.
+	GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Data, error)
+	// GetHistory returns every stored snapshot for a country (or global) between two times.
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+	// ListCountries returns every country with latest statistics.
+	ListCountries(ctx context.Context, in *ListCountriesRequest, opts ...grpc.CallOption) (*ListCountriesResponse, error)
+	// WatchUpdates streams the latest data every time a new snapshot is ingested.
+	WatchUpdates(ctx context.Context, in *WatchUpdatesRequest, opts ...grpc.CallOption) (DurCov_WatchUpdatesClient, error)
+}
+
+type durCovClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDurCovClient(cc grpc.ClientConnInterface) DurCovClient {
+	return &durCovClient{cc}
+}
+
+func (c *durCovClient) GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Data, error) {
+	out := new(Data)
+	err := c.cc.Invoke(ctx, "/durcov.DurCov/GetLatest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *durCovClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, "/durcov.DurCov/GetHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *durCovClient) ListCountries(ctx context.Context, in *ListCountriesRequest, opts ...grpc.CallOption) (*ListCountriesResponse, error) {
+	out := new(ListCountriesResponse)
+	err := c.cc.Invoke(ctx, "/durcov.DurCov/ListCountries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *durCovClient) WatchUpdates(ctx context.Context, in *WatchUpdatesRequest, opts ...grpc.CallOption) (DurCov_WatchUpdatesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_DurCov_serviceDesc.Streams[0], "/durcov.DurCov/WatchUpdates", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &durCovWatchUpdatesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DurCov_WatchUpdatesClient interface {
+	Recv() (*Data, error)
+	grpc.ClientStream
+}
+
+type durCovWatchUpdatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *durCovWatchUpdatesClient) Recv() (*Data, error) {
+	m := new(Data)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DurCovServer is the server API for DurCov service.
+// All implementations must embed UnimplementedDurCovServer
+// for forward compatibility
+type DurCovServer interface {
+	// GetLatest returns the latest global statistics and statistics for the requested countries.
+	GetLatest(context.Context, *GetLatestRequest) (*Data, error)
+	// GetHistory returns every stored snapshot for a country (or global) between two times.
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	// ListCountries returns every country with latest statistics.
+	ListCountries(context.Context, *ListCountriesRequest) (*ListCountriesResponse, error)
+	// WatchUpdates streams the latest data every time a new snapshot is ingested.
+	WatchUpdates(*WatchUpdatesRequest, DurCov_WatchUpdatesServer) error
+	mustEmbedUnimplementedDurCovServer()
+}
+
+// UnimplementedDurCovServer must be embedded to have forward compatible implementations.
+type UnimplementedDurCovServer struct {
+}
+
+func (UnimplementedDurCovServer) GetLatest(context.Context, *GetLatestRequest) (*Data, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLatest not implemented")
+}
+func (UnimplementedDurCovServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedDurCovServer) ListCountries(context.Context, *ListCountriesRequest) (*ListCountriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCountries not implemented")
+}
+func (UnimplementedDurCovServer) WatchUpdates(*WatchUpdatesRequest, DurCov_WatchUpdatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchUpdates not implemented")
+}
+func (UnimplementedDurCovServer) mustEmbedUnimplementedDurCovServer() {}
+
+// UnsafeDurCovServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DurCovServer will
+// result in compilation errors.
+type UnsafeDurCovServer interface {
+	mustEmbedUnimplementedDurCovServer()
+}
+
+func RegisterDurCovServer(s grpc.ServiceRegistrar, srv DurCovServer) {
+	s.RegisterService(&_DurCov_serviceDesc, srv)
+}
+
+func _DurCov_GetLatest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLatestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DurCovServer).GetLatest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/durcov.DurCov/GetLatest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DurCovServer).GetLatest(ctx, req.(*GetLatestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DurCov_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DurCovServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/durcov.DurCov/GetHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DurCovServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DurCov_ListCountries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCountriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DurCovServer).ListCountries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/durcov.DurCov/ListCountries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DurCovServer).ListCountries(ctx, req.(*ListCountriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DurCov_WatchUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchUpdatesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DurCovServer).WatchUpdates(m, &durCovWatchUpdatesServer{stream})
+}
+
+type DurCov_WatchUpdatesServer interface {
+	Send(*Data) error
+	grpc.ServerStream
+}
+
+type durCovWatchUpdatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *durCovWatchUpdatesServer) Send(m *Data) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _DurCov_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "durcov.DurCov",
+	HandlerType: (*DurCovServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetLatest",
+			Handler:    _DurCov_GetLatest_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _DurCov_GetHistory_Handler,
+		},
+		{
+			MethodName: "ListCountries",
+			Handler:    _DurCov_ListCountries_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchUpdates",
+			Handler:       _DurCov_WatchUpdates_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "durcov.proto",
+}
@@ -0,0 +1,213 @@
+package durcov
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// GroupInfo represents a named group of countries whose statistics are totalled, e.g. Europe or the EU
+type GroupInfo struct {
+	Code string
+	Name string
+	// Members are the codes of the countries in the group. Members without stored statistics are left out of totals.
+	Members []string
+	// BuiltIn is set for the continents and unions every deployment knows. They can't be changed.
+	BuiltIn bool
+}
+
+// NoGroupMatchedError when no group has a given code
+type NoGroupMatchedError struct {
+	attemptedCode string
+}
+
+func (n *NoGroupMatchedError) Error() string {
+	return fmt.Sprintf("No group matched with code %s", n.attemptedCode)
+}
+
+// groupCodePattern matches the codes of custom groups. They're longer than country codes so they can't hide a country.
+var groupCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{2,19}$`)
+
+var countryCodeFormat = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Validate returns an error describing the first problem that would make a custom group unfit to store.
+// Codes and members are upper cased and duplicate members dropped first.
+func (g *GroupInfo) Validate() error {
+	g.Code = strings.ToUpper(strings.TrimSpace(g.Code))
+	g.Name = strings.TrimSpace(g.Name)
+	if !groupCodePattern.MatchString(g.Code) {
+		return fmt.Errorf("Invalid group code %q. Expected 3 to 20 letters or digits starting with a letter", g.Code)
+	}
+	if g.Code == "TOTAL" || g.Code == globalID {
+		return fmt.Errorf("Group code %s is reserved", g.Code)
+	}
+	if _, ok := builtInGroups[g.Code]; ok {
+		return fmt.Errorf("Group code %s is built in", g.Code)
+	}
+	if g.Name == "" {
+		return fmt.Errorf("Missing name for group %s", g.Code)
+	}
+
+	seen := map[string]bool{}
+	members := []string{}
+	for _, member := range g.Members {
+		member = strings.ToUpper(strings.TrimSpace(member))
+		if !countryCodeFormat.MatchString(member) {
+			return fmt.Errorf("Invalid country code %q in group %s", member, g.Code)
+		}
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("Missing members for group %s", g.Code)
+	}
+	g.Members = members
+	return nil
+}
+
+// GroupStore describes an API for managing custom groups. Groups are read through a DataView.
+type GroupStore interface {
+	// SaveGroup creates the group or replaces the group with the same code. The group must validate.
+	SaveGroup(group *GroupInfo) error
+	// DeleteGroup removes a custom group. Returns a *NoGroupMatchedError when no custom group has the code.
+	DeleteGroup(code string) error
+}
+
+// CovidGroupStore represents a group store with custom groups kept in postgres
+type CovidGroupStore struct {
+	pgxpool *pgx.ConnPool
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the store.
+func (c *CovidGroupStore) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// SaveGroup creates the group or replaces the group with the same code. The group must validate.
+func (c *CovidGroupStore) SaveGroup(group *GroupInfo) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on group store")
+	}
+	if err := group.Validate(); err != nil {
+		return err
+	}
+	_, err := c.pgxpool.Exec("INSERT INTO country_groups (code, name, members) VALUES ($1, $2, $3) ON CONFLICT (code) DO UPDATE SET name=$2, members=$3;", group.Code, group.Name, group.Members)
+	return err
+}
+
+// DeleteGroup removes a custom group. Returns a *NoGroupMatchedError when no custom group has the code.
+func (c *CovidGroupStore) DeleteGroup(code string) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on group store")
+	}
+	tag, err := c.pgxpool.Exec("DELETE FROM country_groups WHERE code=$1;", code)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return &NoGroupMatchedError{code}
+	}
+	return nil
+}
+
+// sortGroups orders groups by name
+func sortGroups(groups []*GroupInfo) {
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+}
+
+// builtInGroupList returns copies of the built in groups so callers can't change them
+func builtInGroupList() []*GroupInfo {
+	groups := []*GroupInfo{}
+	for _, group := range builtInGroups {
+		copied := *group
+		copied.Members = append([]string{}, group.Members...)
+		groups = append(groups, &copied)
+	}
+	return groups
+}
+
+func builtInGroup(code string) (*GroupInfo, bool) {
+	group, ok := builtInGroups[code]
+	if !ok {
+		return nil, false
+	}
+	copied := *group
+	copied.Members = append([]string{}, group.Members...)
+	return &copied, true
+}
+
+func newBuiltInGroup(code string, name string, members string) *GroupInfo {
+	return &GroupInfo{Code: code, Name: name, Members: strings.Fields(members), BuiltIn: true}
+}
+
+// builtInGroups are the continents (following the UN geoscheme, with Central America and the Caribbean in North America)
+// along with the EU and ASEAN
+var builtInGroups = map[string]*GroupInfo{
+	"AFRICA": newBuiltInGroup("AFRICA", "Africa", `DZ AO BJ BW BF BI CV CM CF TD KM CG CD CI DJ EG GQ ER SZ ET GA GM GH GN GW KE
+		LS LR LY MG MW ML MR MU YT MA MZ NA NE NG RE RW SH ST SN SC SL SO ZA SS SD TZ TG TN UG EH ZM ZW`),
+	"ASIA": newBuiltInGroup("ASIA", "Asia", `AF AM AZ BH BD BT BN KH CN CY GE HK IN ID IR IQ IL JP JO KZ KW KG LA LB MO MY MV
+		MN MM NP KP OM PK PS PH QA SA SG KR LK SY TW TJ TH TL TR TM AE UZ VN YE`),
+	"EUROPE": newBuiltInGroup("EUROPE", "Europe", `AL AD AT BY BE BA BG HR CZ DK EE FO FI FR DE GI GR GG VA HU IS IE IM IT JE
+		XK LV LI LT LU MT MD MC ME NL MK NO PL PT RO RU SM RS SK SI ES SE CH UA GB`),
+	"NORTHAMERICA": newBuiltInGroup("NORTHAMERICA", "North America", `AI AG AW BS BB BZ BM BQ VG CA KY CR CU CW DM DO SV GL
+		GD GP GT HT HN JM MQ MX MS NI PA PR BL KN LC MF PM VC SX TT TC US VI`),
+	"SOUTHAMERICA": newBuiltInGroup("SOUTHAMERICA", "South America", `AR BO BR CL CO EC FK GF GY PY PE SR UY VE`),
+	"OCEANIA":      newBuiltInGroup("OCEANIA", "Oceania", `AS AU CK FJ PF GU KI MH FM NR NC NZ NU MP PW PG WS SB TO TV VU WF`),
+	"EU": newBuiltInGroup("EU", "European Union", `AT BE BG HR CY CZ DK EE FI FR DE GR HU IE IT LV LT LU MT NL PL PT RO SK SI
+		ES SE`),
+	"ASEAN": newBuiltInGroup("ASEAN", "ASEAN", `BN KH ID LA MY MM PH SG TH VN`),
+}
+
+// totalSnapshots adds up the members' snapshots. CollectedAt is the latest of theirs.
+func totalSnapshots(snapshots []*StatsSnapshot) *StatsSnapshot {
+	total := &StatsSnapshot{}
+	for _, stats := range snapshots {
+		total.Confirmed += stats.Confirmed
+		total.Deaths += stats.Deaths
+		total.Recovered += stats.Recovered
+		if stats.CollectedAt.After(total.CollectedAt) {
+			total.CollectedAt = stats.CollectedAt
+		}
+	}
+	return total
+}
+
+// totalVaccinations adds up the members' vaccination figures. CollectedAt is the latest of theirs.
+func totalVaccinations(members []*VaccinationSnapshot) *VaccinationSnapshot {
+	total := &VaccinationSnapshot{}
+	for _, figures := range members {
+		total.Doses += figures.Doses
+		total.PeopleVaccinated += figures.PeopleVaccinated
+		if figures.CollectedAt.After(total.CollectedAt) {
+			total.CollectedAt = figures.CollectedAt
+		}
+	}
+	return total
+}
+
+// totalTests adds up the members' testing figures, weighting their positive rates by their tests. CollectedAt is the
+// latest of theirs.
+func totalTests(members []*TestingSnapshot) *TestingSnapshot {
+	total := &TestingSnapshot{}
+	positive := 0.0
+	for _, figures := range members {
+		total.Tests += figures.Tests
+		positive += figures.PositiveRate * float64(figures.Tests)
+		if figures.CollectedAt.After(total.CollectedAt) {
+			total.CollectedAt = figures.CollectedAt
+		}
+	}
+	if total.Tests > 0 {
+		total.PositiveRate = positive / float64(total.Tests)
+	}
+	return total
+}
@@ -0,0 +1,185 @@
+package durcov
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		group       *GroupInfo
+		expectError bool
+	}{
+		{"Valid group", &GroupInfo{Code: "nordics", Name: "Nordic countries", Members: []string{"dk", " FI", "SE", "SE"}}, false},
+		{"Country sized code", &GroupInfo{Code: "NC", Name: "Nordic countries", Members: []string{"DK"}}, true},
+		{"Region like code", &GroupInfo{Code: "US-WEST", Name: "West coast", Members: []string{"US"}}, true},
+		{"Reserved code", &GroupInfo{Code: "TOTAL", Name: "Everywhere", Members: []string{"DK"}}, true},
+		{"Built in code", &GroupInfo{Code: "ASEAN", Name: "ASEAN", Members: []string{"SG"}}, true},
+		{"Missing name", &GroupInfo{Code: "NORDICS", Members: []string{"DK"}}, true},
+		{"Missing members", &GroupInfo{Code: "NORDICS", Name: "Nordic countries"}, true},
+		{"Invalid member", &GroupInfo{Code: "NORDICS", Name: "Nordic countries", Members: []string{"DENMARK"}}, true},
+	}
+
+	for _, test := range tests {
+		err := test.group.Validate()
+		if err != nil && !test.expectError {
+			t.Errorf("%s: Didn't expect error. Got=%v", test.name, err)
+		}
+		if err == nil && test.expectError {
+			t.Errorf("%s: Expected error", test.name)
+		}
+	}
+
+	valid := tests[0].group
+	if valid.Code != "NORDICS" || strings.Join(valid.Members, " ") != "DK FI SE" {
+		t.Errorf("Normalized group mismatch. Got=%+v", valid)
+	}
+}
+
+func TestMemoryStoreGroups(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	firstDay := ExampleTestDataAt(time.Date(2020, 12, 3, 3, 0, 0, 0, time.UTC))
+	firstDay.countries[0].stats.totalDeaths = 1800
+	err := memoryStore.StoreData(firstDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Members are added up": func(t *testing.T) {
+			group, stats, err := memoryStore.LatestGroupStats("ASIA")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if group.Name != "Asia" || !group.BuiltIn {
+				t.Errorf("Group mismatch. Got=%+v", group)
+			}
+			if stats.Deaths != 3644 || stats.Active() != 16264 {
+				t.Errorf("Stats mismatch. Expected deaths=3644 active=16264 Got=%+v", stats)
+			}
+			if !stats.CollectedAt.Equal(data.global.stats.date) {
+				t.Errorf("Collected at mismatch. Expected=%v Got=%v", data.global.stats.date, stats.CollectedAt)
+			}
+		},
+		"Members are added up at a time": func(t *testing.T) {
+			_, stats, err := memoryStore.GroupStatsAt("ASIA", time.Date(2020, 12, 3, 23, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Deaths != 3622 {
+				t.Errorf("Deaths mismatch. Expected=%d Got=%d", 3622, stats.Deaths)
+			}
+		},
+		"Groups without data": func(t *testing.T) {
+			_, _, err := memoryStore.LatestGroupStats("EUROPE")
+			if _, ok := err.(*NoSnapshotError); !ok {
+				t.Errorf("Expected *NoSnapshotError. Got=%T", err)
+			}
+			_, _, err = memoryStore.LatestGroupStats("NARNIA")
+			if _, ok := err.(*NoGroupMatchedError); !ok {
+				t.Errorf("Expected *NoGroupMatchedError. Got=%T", err)
+			}
+		},
+		"Custom groups": func(t *testing.T) {
+			err := memoryStore.SaveGroup(&GroupInfo{Code: "team", Name: "Team countries", Members: []string{"sg", "in"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, stats, err := memoryStore.LatestGroupStats("TEAM")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Deaths != 1822 {
+				t.Errorf("Deaths mismatch. Expected=%d Got=%d", 1822, stats.Deaths)
+			}
+			groups, err := memoryStore.Groups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != len(builtInGroups)+1 || groups[len(groups)-1].Code != "TEAM" {
+				t.Errorf("Expected the custom group to be listed last by name. Got=%d groups", len(groups))
+			}
+
+			err = memoryStore.DeleteGroup("TEAM")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := memoryStore.DeleteGroup("TEAM").(*NoGroupMatchedError); !ok {
+				t.Error("Expected *NoGroupMatchedError deleting a deleted group")
+			}
+			if _, ok := memoryStore.DeleteGroup("ASIA").(*NoGroupMatchedError); !ok {
+				t.Error("Expected *NoGroupMatchedError deleting a built in group")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestGroupTestTotals(t *testing.T) {
+	day := time.Date(2021, 2, 27, 0, 0, 0, 0, time.UTC)
+	total := totalTests([]*TestingSnapshot{
+		{Tests: 1000, PositiveRate: 0.1, CollectedAt: day},
+		{Tests: 3000, PositiveRate: 0.02, CollectedAt: day.AddDate(0, 0, 1)},
+		{Tests: 0, PositiveRate: 0.5, CollectedAt: day},
+	})
+	if total.Tests != 4000 || total.PositiveRate < 0.0399 || total.PositiveRate > 0.0401 || !total.CollectedAt.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("Totals mismatch. Expected=4000 tests at 4%% on 28 Feb Got=%+v", total)
+	}
+	if total := totalTests([]*TestingSnapshot{{CollectedAt: day}}); total.PositiveRate != 0 {
+		t.Errorf("Positive rate mismatch. Expected=0 Got=%v", total.PositiveRate)
+	}
+}
+
+func TestBotGroups(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot := NewBot(memoryStore, 0)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"CASES ASEAN", "[ASEAN] ASEAN Active Cases: 8,132"},
+		{"deaths in asia", "[ASIA] Asia Deaths: 3,644"},
+		{"how many people died in the EU", "Sorry, I don't have any figures for that yet."},
+		{"DEATHS EU", "Sorry, I don't have any figures for that yet."},
+		{"DEATHS ASIA ON 2020-12-04", "[ASIA] Asia Deaths on 4 Dec 2020: 3,644"},
+		{"DEATHS IN", "Sorry, that code doesn't match any countries I know."},
+	}
+
+	for _, test := range tests {
+		response := testBot.Respond(test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+	// Commands find groups saved since the bot last read the places it knows
+	err = memoryStore.SaveGroup(&GroupInfo{Code: "TEAM", Name: "Team countries", Members: []string{"SG", "AF"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[TEAM] Team countries Deaths: 3,644"
+	if response := testBot.Respond("DEATHS TEAM"); response != expected {
+		t.Errorf("Response mismatch. Expected=%s Got=%s", expected, response)
+	}
+}
@@ -0,0 +1,269 @@
+package durcov
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HospitalSnapshot represents the hospital occupancy figures published for a country or region.
+// A figure is nil when the place doesn't publish it, which isn't the same as no patients.
+type HospitalSnapshot struct {
+	Hospitalized *OccupancyFigure
+	ICU          *OccupancyFigure
+	Ventilators  *OccupancyFigure
+}
+
+// OccupancyFigure represents the number of covid patients in hospital, in intensive care or on a ventilator as published
+// for a day. Each figure has its own collection time since places don't publish them all on the same days.
+type OccupancyFigure struct {
+	Patients    int64
+	CollectedAt time.Time
+}
+
+type occupancyFigure struct {
+	// code is a country's or region's code
+	code     string
+	datum    Datum
+	patients int64
+	date     time.Time
+}
+
+// HospitalSource represents a DataSource adding hospital occupancy figures from a report to the data another source fetches.
+// The report is a CSV with code and date (YYYY-MM-DD) columns, and any of the hospitalized, icu and ventilators columns,
+// e.g. "US-CA,2021-02-28,5000,1500,". Figures are left empty on days they aren't published.
+type HospitalSource struct {
+	// Source fetches the data the figures are added to. Its URL must be set separately.
+	Source DataSource
+	url    *url.URL
+}
+
+// HospitalReportError when the source's data was fetched but the hospital occupancy report couldn't be added to it.
+// FetchData still returns the data, so the figures stored last time are kept once it's stored.
+type HospitalReportError struct {
+	err error
+}
+
+func (h *HospitalReportError) Error() string {
+	return fmt.Sprintf("Unable to add hospital occupancy figures: %v", h.err)
+}
+
+// UseURL sets the hospital occupancy report to fetch.
+// Must be set before calling FetchData
+func (h *HospitalSource) UseURL(reportURL string) error {
+	u, err := url.ParseRequestURI(reportURL)
+	if err != nil {
+		return err
+	}
+	h.url = u
+	return nil
+}
+
+// FetchData fetches the data from the source, then the report, and adds the report's figures to the data.
+// Returns the data along with a *HospitalReportError when only the report couldn't be added.
+func (h *HospitalSource) FetchData() (*Data, error) {
+	if h.Source == nil {
+		return nil, errors.New("No source set to fetch data")
+	}
+	if h.url == nil {
+		return nil, errors.New("No URL set to fetch hospital occupancy")
+	}
+	data, err := h.Source.FetchData()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Get(h.url.String())
+	if err != nil {
+		return data, &HospitalReportError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return data, &HospitalReportError{fmt.Errorf("Unexpected status fetching hospital occupancy: %s", resp.Status)}
+	}
+	err = data.ReadHospitalOccupancy(resp.Body)
+	if err != nil {
+		return data, &HospitalReportError{err}
+	}
+	return data, nil
+}
+
+// ReadHospitalOccupancy reads hospital occupancy figures from a report in the HospitalSource format into the data,
+// replacing any figures it has. Each place's latest published value of each figure is kept, so a place's figures can be
+// from different days. Rows are for a country or a region, e.g. US-CA, and rows of countries the data doesn't have are
+// skipped. Region rows only need their country in the data since regions are read from a report of their own.
+// The data's countries must be set first. The data is left as it was when the report can't be read or its figures
+// don't validate.
+func (d *Data) ReadHospitalOccupancy(r io.Reader) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "\ufeff"))] = i
+	}
+	for _, name := range []string{"code", "date"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("Missing column %s in hospital occupancy report", name)
+		}
+	}
+	figureColumns := map[string]Datum{}
+	for datum, name := range hospitalDatumNames {
+		if _, ok := columns[name]; ok {
+			figureColumns[name] = datum
+		}
+	}
+	if len(figureColumns) == 0 {
+		return errors.New("No hospitalized, icu or ventilators columns in hospital occupancy report")
+	}
+	field := func(record []string, name string) string {
+		i := columns[name]
+		if i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	countries := map[string]bool{}
+	for _, country := range d.countries {
+		countries[country.code] = true
+	}
+	latest := map[string]map[Datum]*occupancyFigure{}
+	// order keeps the figures in the order their place was first seen
+	order := []string{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return err
+		}
+
+		code := strings.ToUpper(field(record, "code"))
+		if !countries[strings.SplitN(code, "-", 2)[0]] {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", field(record, "date"))
+		if err != nil {
+			return fmt.Errorf("Invalid hospital occupancy report line %d: unreadable date %q", line, field(record, "date"))
+		}
+		if _, ok := latest[code]; !ok {
+			latest[code] = map[Datum]*occupancyFigure{}
+			order = append(order, code)
+		}
+		for name, datum := range figureColumns {
+			value := field(record, name)
+			if value == "" {
+				continue
+			}
+			// Some publishers write counts as decimals, e.g. 1500.0
+			patients, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid hospital occupancy report line %d: unreadable %s %q", line, name, value)
+			}
+			figure, ok := latest[code][datum]
+			if !ok || date.After(figure.date) {
+				latest[code][datum] = &occupancyFigure{code, datum, int64(patients), date}
+			}
+		}
+	}
+
+	previous := d.hospitals
+	d.hospitals = []*occupancyFigure{}
+	for _, code := range order {
+		for _, datum := range []Datum{Hospitalized, ICU, Ventilators} {
+			if figure, ok := latest[code][datum]; ok {
+				d.hospitals = append(d.hospitals, figure)
+			}
+		}
+	}
+	if err := d.Validate(); err != nil {
+		d.hospitals = previous
+		return err
+	}
+	return nil
+}
+
+// hospitalRegionName names a region only hospital occupancy figures are published for after its country, e.g.
+// "CA, United States" for US-CA, since region names are read from the regional report
+func hospitalRegionName(code string, countryName string) string {
+	return strings.SplitN(code, "-", 2)[1] + ", " + countryName
+}
+
+// validateHospitalOccupancy checks the figures are for one of the countries or a region in one, and at most once for
+// each place and datapoint
+func (d *Data) validateHospitalOccupancy(countries map[string]bool) error {
+	seen := map[string]map[Datum]bool{}
+	for _, figure := range d.hospitals {
+		if !countries[strings.SplitN(figure.code, "-", 2)[0]] {
+			return fmt.Errorf("Unknown country or region %s for hospital occupancy figures", figure.code)
+		}
+		if !figure.datum.isHospitalFigure() {
+			return fmt.Errorf("Invalid hospital occupancy figures for %s: unsupported datum %d", figure.code, figure.datum)
+		}
+		if seen[figure.code] == nil {
+			seen[figure.code] = map[Datum]bool{}
+		}
+		if seen[figure.code][figure.datum] {
+			return fmt.Errorf("Duplicate %s figures for %s", hospitalDatumNames[figure.datum], figure.code)
+		}
+		seen[figure.code][figure.datum] = true
+		if figure.patients < 0 {
+			return fmt.Errorf("Invalid %s figures for %s: negative patients", hospitalDatumNames[figure.datum], figure.code)
+		}
+		if figure.date.IsZero() {
+			return fmt.Errorf("Invalid %s figures for %s: missing collection date", hospitalDatumNames[figure.datum], figure.code)
+		}
+	}
+	return nil
+}
+
+// hospitalDatumNames are the names hospital occupancy figures are stored under, also the report's columns
+var hospitalDatumNames = map[Datum]string{
+	Hospitalized: "hospitalized",
+	ICU:          "icu",
+	Ventilators:  "ventilators",
+}
+
+// set sets the snapshot's figure for the datapoint
+func (s *HospitalSnapshot) set(datum Datum, figure *OccupancyFigure) {
+	switch datum {
+	case Hospitalized:
+		s.Hospitalized = figure
+	case ICU:
+		s.ICU = figure
+	case Ventilators:
+		s.Ventilators = figure
+	}
+}
+
+// Figure returns the snapshot's figure for the datapoint. Returns nil when it isn't published or isn't a hospital figure.
+func (s *HospitalSnapshot) Figure(datum Datum) *OccupancyFigure {
+	switch datum {
+	case Hospitalized:
+		return s.Hospitalized
+	case ICU:
+		return s.ICU
+	case Ventilators:
+		return s.Ventilators
+	}
+	return nil
+}
+
+// isHospitalFigure reports whether the datapoint is read from hospital occupancy figures
+func (d Datum) isHospitalFigure() bool {
+	_, ok := hospitalDatumNames[d]
+	return ok
+}
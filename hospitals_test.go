@@ -0,0 +1,295 @@
+package durcov
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubSource is a DataSource returning the example test data
+type stubSource struct{}
+
+func (s *stubSource) UseURL(url string) error {
+	return nil
+}
+
+func (s *stubSource) FetchData() (*Data, error) {
+	return ExampleTestData()
+}
+
+// exampleHospitalReport has Singapore's figures published on different days, Kabul's hospitalized count only, Herat's,
+// which the regional report doesn't have, and Atlantis, which is skipped since the example data doesn't have it
+const exampleHospitalReport = `code,date,hospitalized,icu,ventilators
+SG,2021-02-27,130,16,4
+SG,2021-02-28,120,15,
+AF-KABUL,2021-02-28,300,,
+AF-HERAT,2021-02-28,45,,
+ATL,2021-02-28,1,1,1
+`
+
+// exampleHospitalData returns the example test data with Kabul as a region and the example hospital report read
+func exampleHospitalData(t *testing.T) *Data {
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	regions := "FIPS,Admin2,Province_State,Country_Region,Last_Update,Confirmed,Deaths,Recovered\n" +
+		",,Kabul,Afghanistan,2020-12-04 05:27:50,17000,650,13000\n"
+	err = data.ReadRegions(strings.NewReader(regions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.ReadHospitalOccupancy(strings.NewReader(exampleHospitalReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadHospitalOccupancy(t *testing.T) {
+	data := exampleHospitalData(t)
+	if err := data.Validate(); err != nil {
+		t.Fatalf("Didn't expect validation error. Got=%v", err)
+	}
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 2, d, 0, 0, 0, 0, time.UTC)
+	}
+	// Singapore's latest ventilator count is from the day before its other figures
+	expected := []occupancyFigure{
+		{"SG", Hospitalized, 120, day(28)},
+		{"SG", ICU, 15, day(28)},
+		{"SG", Ventilators, 4, day(27)},
+		{"AF-KABUL", Hospitalized, 300, day(28)},
+		{"AF-HERAT", Hospitalized, 45, day(28)},
+	}
+	if len(data.hospitals) != len(expected) {
+		t.Fatalf("Hospital figures count mismatch. Expected=%d Got=%d", len(expected), len(data.hospitals))
+	}
+	for i, figure := range data.hospitals {
+		if *figure != expected[i] {
+			t.Errorf("Hospital figure mismatch. Expected=%+v Got=%+v", expected[i], figure)
+		}
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Some figure columns": func(t *testing.T) {
+			data := exampleHospitalData(t)
+			err := data.ReadHospitalOccupancy(strings.NewReader("Date,Code,ICU\n2021-03-01,sg,12\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data.hospitals) != 1 || *data.hospitals[0] != (occupancyFigure{"SG", ICU, 12, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}) {
+				t.Errorf("Hospital figures mismatch. Got=%+v", data.hospitals)
+			}
+		},
+		"Missing figures": func(t *testing.T) {
+			data := exampleHospitalData(t)
+			err := data.ReadHospitalOccupancy(strings.NewReader("code,date,cases\n"))
+			if err == nil {
+				t.Error("Expected error for a report without hospital figures")
+			}
+		},
+		"Unreadable figures": func(t *testing.T) {
+			data := exampleHospitalData(t)
+			report := strings.Replace(exampleHospitalReport, "300", "many", 1)
+			err := data.ReadHospitalOccupancy(strings.NewReader(report))
+			if err == nil {
+				t.Error("Expected error for unreadable figures")
+			}
+			if len(data.hospitals) != 5 {
+				t.Errorf("Expected the data's figures to be kept. Got=%+v", data.hospitals)
+			}
+		},
+		"Fetched with the source's data": func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/hospitals.csv" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(exampleHospitalReport))
+			}))
+			defer server.Close()
+
+			source := &HospitalSource{Source: &stubSource{}}
+			err := source.UseURL(server.URL + "/hospitals.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := source.FetchData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Kabul's and Herat's figures are kept even though the source's data has no regions since regions are added separately
+			if len(data.hospitals) != 5 {
+				t.Errorf("Hospital figures mismatch. Got=%+v", data.hospitals)
+			}
+
+			source.UseURL(server.URL + "/missing.csv")
+			data, err = source.FetchData()
+			var reportErr *HospitalReportError
+			if !errors.As(err, &reportErr) {
+				t.Fatalf("Expected *HospitalReportError. Got=%v", err)
+			}
+			if data == nil || len(data.countries) != 2 {
+				t.Errorf("Expected the source's data along with the error. Got=%+v", data)
+			}
+		},
+		"Invalid figures": func(t *testing.T) {
+			mutations := map[string]func(d *Data){
+				"Unknown country":   func(d *Data) { d.hospitals[0].code = "ZZ" },
+				"Duplicate figures": func(d *Data) { d.hospitals[1].datum = Hospitalized },
+				"Unsupported datum": func(d *Data) { d.hospitals[0].datum = Deaths },
+				"Negative patients": func(d *Data) { d.hospitals[0].patients = -1 },
+				"Missing date":      func(d *Data) { d.hospitals[0].date = time.Time{} },
+			}
+			for name, mutate := range mutations {
+				data := exampleHospitalData(t)
+				mutate(data)
+				if err := data.Validate(); err == nil {
+					t.Errorf("%s: Expected error", name)
+				}
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestMemoryStoreHospitals(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleHospitalData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Country figures": func(t *testing.T) {
+			name, figures, err := memoryStore.LatestHospitalOccupancy("SG")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "Singapore" || figures.ICU == nil || figures.ICU.Patients != 15 {
+				t.Errorf("Hospital figures mismatch. Got=%s %+v", name, figures)
+			}
+			if figures.Ventilators == nil || !figures.Ventilators.CollectedAt.Equal(time.Date(2021, 2, 27, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("Ventilators mismatch. Got=%+v", figures.Ventilators)
+			}
+		},
+		"Region figures": func(t *testing.T) {
+			name, figures, err := memoryStore.LatestHospitalOccupancy("AF-KABUL")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "Kabul" || figures.Hospitalized == nil || figures.Hospitalized.Patients != 300 {
+				t.Errorf("Hospital figures mismatch. Got=%s %+v", name, figures)
+			}
+			if figures.ICU != nil || figures.Ventilators != nil {
+				t.Errorf("Expected unpublished figures to be nil. Got=%+v", figures)
+			}
+		},
+		"Regions only hospital figures have": func(t *testing.T) {
+			name, figures, err := memoryStore.LatestHospitalOccupancy("AF-HERAT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "HERAT, Afghanistan" || figures.Hospitalized == nil || figures.Hospitalized.Patients != 45 {
+				t.Errorf("Hospital figures mismatch. Got=%s %+v", name, figures)
+			}
+		},
+		"Unpublished figures": func(t *testing.T) {
+			_, figures, err := memoryStore.LatestHospitalOccupancy("AF")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *figures != (HospitalSnapshot{}) {
+				t.Errorf("Expected no figures. Got=%+v", figures)
+			}
+		},
+		"Unknown places": func(t *testing.T) {
+			_, _, err := memoryStore.LatestHospitalOccupancy("IN")
+			if _, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Expected *NoCountryMatchedError. Got=%T", err)
+			}
+			_, _, err = memoryStore.LatestHospitalOccupancy("US-CA")
+			if _, ok := err.(*NoRegionMatchedError); !ok {
+				t.Errorf("Expected *NoRegionMatchedError. Got=%T", err)
+			}
+		},
+		"Figures are kept when data has none": func(t *testing.T) {
+			err := memoryStore.StoreData(ExampleTestDataAt(time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, figures, err := memoryStore.LatestHospitalOccupancy("SG")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if figures.Hospitalized == nil || figures.Hospitalized.Patients != 120 {
+				t.Errorf("Hospitalized mismatch. Got=%+v", figures.Hospitalized)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestBotHospitals(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleHospitalData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot := NewBot(memoryStore, 0)
+	testBot.SetSessions(NewMemorySessionStore(time.Hour))
+
+	tests := []struct {
+		sender   string
+		input    string
+		expected string
+	}{
+		{"", "HOSPITALIZED SG", "[SG] Singapore Hospitalized: 120 (as of 28 Feb 2021)"},
+		{"", "icu sg", "[SG] Singapore ICU Patients: 15 (as of 28 Feb 2021)"},
+		{"", "VENTILATORS SG", "[SG] Singapore Patients on Ventilators: 4 (as of 27 Feb 2021)"},
+		{"", "HOSPITALIZED AF-KABUL", "[AF-KABUL] Kabul Hospitalized: 300 (as of 28 Feb 2021)"},
+		{"", "ICU AF-KABUL", "Sorry, ICU figures aren't available for [AF-KABUL] Kabul."},
+		{"", "HOSPITALIZED AF-HERAT", "[AF-HERAT] HERAT, Afghanistan Hospitalized: 45 (as of 28 Feb 2021)"},
+		{"", "HOSPITALIZED AF-KANDAHAR", "Sorry, that code doesn't match any regions I know."},
+		{"", "HOSPITALIZED AF", "Sorry, hospitalization figures aren't available for [AF] Afghanistan."},
+		{"", "VENTILATORS TOTAL", "Sorry, I only have hospital figures for countries and regions."},
+		{"", "ICU ASIA", "Sorry, I only have hospital figures for countries and regions."},
+		{"", "ICU IN", "Sorry, that code doesn't match any countries I know."},
+		{"", "ICU SG YESTERDAY", "Sorry, I only have the latest hospital figures."},
+		{"", "how many people are in intensive care in singapore", "[SG] Singapore ICU Patients: 15 (as of 28 Feb 2021)"},
+		{"", "how many hospital patients are on ventilators in singapore", "[SG] Singapore Patients on Ventilators: 4 (as of 27 Feb 2021)"},
+		{"sms:1", "HOSPITALIZED SG", "[SG] Singapore Hospitalized: 120 (as of 28 Feb 2021)"},
+		{"sms:1", "what about AF-KABUL", "[AF-KABUL] Kabul Hospitalized: 300 (as of 28 Feb 2021)"},
+	}
+
+	for _, test := range tests {
+		response, _ := testBot.RespondTo(test.sender, test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+
+	var outcome string
+	testBot.SetObserver(func(command string, o string) {
+		outcome = o
+	})
+	testBot.RespondTo("", "ICU AF")
+	if outcome != OutcomeNotPublished {
+		t.Errorf("Outcome mismatch. Expected=%s Got=%s", OutcomeNotPublished, outcome)
+	}
+	testBot.RespondTo("", "ICU ASIA")
+	if outcome != OutcomeUnsupportedPlace {
+		t.Errorf("Outcome mismatch. Expected=%s Got=%s", OutcomeUnsupportedPlace, outcome)
+	}
+}
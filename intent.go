@@ -0,0 +1,391 @@
+package durcov
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Keywords classifying a free-form question. Words are compared after normalizeWords.
+var (
+	deathsKeywords = map[string]bool{
+		"die": true, "died": true, "dies": true, "dying": true, "dead": true, "death": true, "deaths": true,
+		"deceased": true, "fatal": true, "fatalities": true, "fatality": true, "kill": true, "killed": true,
+		"kills": true, "mortality": true, "toll": true, "perished": true, "lives": true,
+	}
+	casesKeywords = map[string]bool{
+		"case": true, "cases": true, "infected": true, "infection": true, "infections": true, "infect": true,
+		"active": true, "sick": true, "ill": true, "positive": true, "positives": true, "confirmed": true,
+		"carriers": true,
+	}
+	dosesKeywords = map[string]bool{
+		"dose": true, "doses": true, "vaccinations": true, "vaccines": true, "vaccine": true, "jabs": true, "shots": true,
+	}
+	vaccinatedKeywords = map[string]bool{"vaccinated": true, "jabbed": true, "immunized": true, "immunised": true}
+	// testsKeywords don't include "test" since it's also a verb, e.g. "test positivity"
+	testsKeywords      = map[string]bool{"tests": true, "tested": true, "testing": true}
+	positivityKeywords = map[string]bool{"positivity": true}
+	hospitalKeywords   = map[string]bool{
+		"hospital": true, "hospitals": true, "hospitalized": true, "hospitalised": true, "hospitalizations": true,
+		"hospitalisations": true, "admitted": true,
+	}
+	icuKeywords        = map[string]bool{"icu": true, "icus": true, "intensive": true}
+	ventilatorKeywords = map[string]bool{"ventilator": true, "ventilators": true, "ventilated": true, "intubated": true}
+	// diseaseKeywords ask about cases when nothing else names a datapoint, e.g. "covid in france" or "patients in italy"
+	diseaseKeywords = map[string]bool{
+		"covid": true, "covid19": true, "corona": true, "coronavirus": true, "virus": true, "sars": true, "patients": true,
+	}
+	globalKeywords = map[string]bool{
+		"world": true, "worldwide": true, "global": true, "globally": true, "total": true, "everywhere": true,
+		"overall": true, "planet": true, "earth": true, "international": true, "internationally": true,
+	}
+)
+
+// countryAliases are common names for countries that don't match the names the covid API uses
+var countryAliases = map[string][]string{
+	"AE": {"uae", "emirates"},
+	"BO": {"bolivia"},
+	"CD": {"drc", "dr congo", "congo kinshasa"},
+	"CG": {"congo brazzaville"},
+	"CI": {"ivory coast", "cote divoire"},
+	"CZ": {"czechia", "czech republic"},
+	"GB": {"uk", "britain", "great britain", "england", "scotland", "wales"},
+	"IR": {"iran"},
+	"KR": {"south korea", "korea"},
+	"LA": {"laos"},
+	"MD": {"moldova"},
+	"MK": {"north macedonia", "macedonia"},
+	"RU": {"russia"},
+	"SY": {"syria"},
+	"TW": {"taiwan"},
+	"TZ": {"tanzania"},
+	"US": {"usa", "america", "united states", "the states"},
+	"VE": {"venezuela"},
+	"VN": {"vietnam"},
+}
+
+// removeMarks strips accents so "Côte d'Ivoire" and "cote divoire" are the same
+var removeMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizeWords lowercases the text, strips accents and apostrophes and splits it into words.
+// e.g. "What's the toll in Côte d'Ivoire?" is [whats the toll in cote divoire]
+func normalizeWords(text string) []string {
+	text, _, err := transform.String(removeMarks, text)
+	if err != nil {
+		return nil
+	}
+	text = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// intentParser represents an offline parser for free-form questions like "how many people died in brazil".
+// Questions are classified by keyword and places are found by matching the longest known country, group or region name.
+type intentParser struct {
+	// places maps a normalized country, group or region name to its code
+	places map[string]string
+	// longestPlace is the most words in a country name
+	longestPlace int
+	codes        map[string]bool
+}
+
+// newIntentParser returns a parser knowing the countries' names and slugs along with some common aliases, then the groups'
+// names and codes (e.g. "europe" and "eu") and the regions' names.
+// Countries keep names regions share, e.g. Georgia is the country rather than the US state.
+func newIntentParser(countries []*CountryInfo, regions []*RegionInfo, groups []*GroupInfo) *intentParser {
+	p := &intentParser{places: map[string]string{}, codes: map[string]bool{}}
+	for _, country := range countries {
+		p.codes[country.Code] = true
+		p.addPlace(country.Name, country.Code, false)
+		p.addPlace(strings.ReplaceAll(country.Slug, "-", " "), country.Code, false)
+		// e.g. "Iran" for "Iran, Islamic Republic of" and "Korea" for "Korea (South)"
+		if i := strings.IndexAny(country.Name, ",("); i > 0 {
+			p.addPlace(country.Name[:i], country.Code, false)
+		}
+	}
+	for code, aliases := range countryAliases {
+		for _, alias := range aliases {
+			p.addPlace(alias, code, true)
+		}
+	}
+	for _, group := range groups {
+		p.addPlace(group.Name, group.Code, false)
+		p.addPlace(group.Code, group.Code, false)
+	}
+	p.addRegions(regions)
+	return p
+}
+
+// addRegions adds the names of admin1 regions, then of admin2 regions along with their admin1 region's name,
+// e.g. "los angeles california". An admin2 region's name is only added on its own when no other region has it, and only when
+// it's more than a word since counties like Day and Story would match ordinary questions. "story county" still matches.
+func (p *intentParser) addRegions(regions []*RegionInfo) {
+	parents := map[string]*RegionInfo{}
+	counties := map[string]int{}
+	for _, region := range regions {
+		p.codes[region.Code] = true
+		switch region.Level {
+		case Admin1:
+			parents[region.Code] = region
+			p.addPlace(region.Name, region.Code, false)
+		case Admin2:
+			counties[strings.Join(normalizeWords(region.Name), " ")]++
+		}
+	}
+	for _, region := range regions {
+		parent, ok := parents[region.Parent]
+		if region.Level != Admin2 || !ok {
+			continue
+		}
+		p.addPlace(region.Name+" "+parent.Name, region.Code, false)
+		p.addPlace(region.Name+" county "+parent.Name, region.Code, false)
+		words := normalizeWords(region.Name)
+		if counties[strings.Join(words, " ")] != 1 {
+			continue
+		}
+		p.addPlace(region.Name+" county", region.Code, false)
+		if len(words) > 1 {
+			p.addPlace(region.Name, region.Code, false)
+		}
+	}
+}
+
+// addPlace adds a name for the country, group or region. Names already taken by another country are kept unless override is set.
+func (p *intentParser) addPlace(name string, code string, override bool) {
+	words := normalizeWords(name)
+	if len(words) == 0 {
+		return
+	}
+	place := strings.Join(words, " ")
+	if _, taken := p.places[place]; taken && !override {
+		return
+	}
+	p.places[place] = code
+	if len(words) > p.longestPlace {
+		p.longestPlace = len(words)
+	}
+}
+
+// parse classifies the question as asking about cases, deaths, vaccinations, tests or hospital patients and finds the
+// country it's about.
+// Code is TOTAL for questions about the world and empty when no place is named.
+// Returns nil when the question doesn't name exactly one datapoint and at most one place, or names a place or code it doesn't know.
+func (p *intentParser) parse(text string) *parsedRequest {
+	words := normalizeWords(text)
+	request := &parsedRequest{}
+	mentionsDisease := false
+	mentionsWorld := false
+	for i, word := range words {
+		var requestType requestType
+		switch {
+		case deathsKeywords[word]:
+			requestType = _Deaths
+		case word == "passed" && i+1 < len(words) && words[i+1] == "away":
+			requestType = _Deaths
+		case word == "tests" && i > 0 && words[i-1] == "positive":
+			// e.g. "positive tests" asks about cases
+		case casesKeywords[word]:
+			requestType = _Cases
+		case dosesKeywords[word]:
+			requestType = _Doses
+		case vaccinatedKeywords[word]:
+			requestType = _Vaccinated
+		case testsKeywords[word]:
+			requestType = _Tests
+		case positivityKeywords[word]:
+			requestType = _Positivity
+		case hospitalKeywords[word]:
+			requestType = _Hospitalized
+		case icuKeywords[word]:
+			requestType = _ICU
+		case ventilatorKeywords[word]:
+			requestType = _Ventilators
+		case diseaseKeywords[word]:
+			mentionsDisease = true
+		case globalKeywords[word]:
+			mentionsWorld = true
+		}
+		if requestType == 0 {
+			continue
+		}
+		if request.Type != 0 && request.Type != requestType {
+			// e.g. "cases and deaths in brazil" can't be answered with one reply
+			narrowest, ok := narrower(request.Type, requestType)
+			if !ok {
+				return nil
+			}
+			request.Type = narrowest
+			continue
+		}
+		request.Type = requestType
+	}
+	if request.Type == 0 {
+		if !mentionsDisease {
+			return nil
+		}
+		request.Type = _Cases
+	}
+
+	code, ok := p.findPlace(words)
+	if !ok {
+		return nil
+	}
+	if code == "" {
+		code = p.findCode(text)
+	}
+	if code == "" && mentionsWorld {
+		code = "TOTAL"
+	}
+	if code == "" && (namesPlace(words) || p.hasUnknownCode(text) || startsCommand(words)) {
+		// e.g. "deaths in atlantis", "deaths in SGP" or "DEATHS TOT" shouldn't be answered for the world
+		return nil
+	}
+	request.Code = code
+	return request
+}
+
+// hasUnknownCode reports whether a word in the text is typed like a code (e.g. SGP in "how many deaths SGP") but
+// isn't one. Every word looks like a code when the whole text is in capitals, so nothing is reported then.
+func (p *intentParser) hasUnknownCode(text string) bool {
+	if strings.ToUpper(text) == text {
+		return false
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+	for _, field := range fields {
+		field = strings.Trim(field, "-")
+		if len(field) < 2 || strings.ToUpper(field) != field || strings.ToLower(field) == field {
+			continue
+		}
+		word := strings.ToLower(field)
+		if !p.codes[field] && p.places[word] == "" && !isKeyword(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// startsCommand reports whether the words are a command with more than its command word, e.g. "CASES SGP".
+// Commands with a code that isn't known are answered with the help rather than for the world.
+func startsCommand(words []string) bool {
+	if len(words) < 2 || commandRequestTypes[strings.ToUpper(words[0])] == 0 {
+		return false
+	}
+	for _, word := range words[1:] {
+		if !isKeyword(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// narrowingTypes are request types that narrow the ones before them, e.g. "people vaccinated" asks about people
+// vaccinated rather than doses and "hospital patients in intensive care" about ICU patients
+var narrowingTypes = [][]requestType{{_Doses, _Vaccinated}, {_Hospitalized, _ICU, _Ventilators}}
+
+// narrower returns the narrower of two request types when one narrows the other
+func narrower(a requestType, b requestType) (requestType, bool) {
+	for _, types := range narrowingTypes {
+		i, j := -1, -1
+		for k, rt := range types {
+			if rt == a {
+				i = k
+			}
+			if rt == b {
+				j = k
+			}
+		}
+		if i >= 0 && j >= 0 {
+			if j > i {
+				return b, true
+			}
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// placePrepositions come before a place, e.g. "in brazil"
+var placePrepositions = map[string]bool{"in": true, "for": true, "from": true, "across": true}
+
+// namesPlace reports whether the words name a place, e.g. "in atlantis". Used when no known place was found.
+func namesPlace(words []string) bool {
+	for i := 0; i+1 < len(words); i++ {
+		if !placePrepositions[words[i]] {
+			continue
+		}
+		next := words[i+1]
+		if next == "the" && i+2 < len(words) {
+			next = words[i+2]
+		}
+		if !isKeyword(next) && next != "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// isKeyword reports whether the word is one of the keywords classifying a question
+func isKeyword(word string) bool {
+	for _, keywords := range []map[string]bool{
+		deathsKeywords, casesKeywords, dosesKeywords, vaccinatedKeywords, testsKeywords, positivityKeywords, hospitalKeywords,
+		icuKeywords, ventilatorKeywords, diseaseKeywords, globalKeywords,
+	} {
+		if keywords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// findPlace returns the code of the country, group or region named in the words, preferring the longest names.
+// e.g. "united states" rather than "states". Fails when more than one country is named.
+func (p *intentParser) findPlace(words []string) (string, bool) {
+	found := ""
+	for i := 0; i < len(words); {
+		matched := 0
+		for n := p.longestPlace; n > 0; n-- {
+			if i+n > len(words) {
+				continue
+			}
+			code, ok := p.places[strings.Join(words[i:i+n], " ")]
+			if !ok {
+				continue
+			}
+			if found != "" && found != code {
+				return "", false
+			}
+			found = code
+			matched = n
+			break
+		}
+		if matched == 0 {
+			matched = 1
+		}
+		i += matched
+	}
+	return found, true
+}
+
+// findCode returns a country or region code written in capitals, e.g. "deaths in the US" or "cases in US-CA".
+// Codes are ignored when the whole question is in capitals because common words like IN and ME would match.
+func (p *intentParser) findCode(text string) string {
+	if strings.ToUpper(text) == text {
+		return ""
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+	for _, field := range fields {
+		field = strings.Trim(field, "-")
+		if (len(field) == 2 || IsRegionCode(field)) && strings.ToUpper(field) == field && p.codes[field] {
+			return field
+		}
+	}
+	return ""
+}
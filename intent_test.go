@@ -0,0 +1,243 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+// intentTestCountries are named the way the covid API names them
+var intentTestCountries = []*CountryInfo{
+	{"Brazil", "brazil", "BR"},
+	{"Congo (Brazzaville)", "congo-brazzaville", "CG"},
+	{"Congo (Kinshasa)", "congo-kinshasa", "CD"},
+	{"Côte d'Ivoire", "cote-divoire", "CI"},
+	{"Dominica", "dominica", "DM"},
+	{"Dominican Republic", "dominican-republic", "DO"},
+	{"France", "france", "FR"},
+	{"Georgia", "georgia", "GE"},
+	{"Germany", "germany", "DE"},
+	{"Guinea", "guinea", "GN"},
+	{"India", "india", "IN"},
+	{"Iran, Islamic Republic of", "iran", "IR"},
+	{"Korea (South)", "korea-south", "KR"},
+	{"Niger", "niger", "NE"},
+	{"Nigeria", "nigeria", "NG"},
+	{"Papua New Guinea", "papua-new-guinea", "PG"},
+	{"Russian Federation", "russia", "RU"},
+	{"Singapore", "singapore", "SG"},
+	{"South Africa", "south-africa", "ZA"},
+	{"South Sudan", "south-sudan", "SS"},
+	{"Sudan", "sudan", "SD"},
+	{"Taiwan, Republic of China", "taiwan", "TW"},
+	{"United Kingdom", "united-kingdom", "GB"},
+	{"United States of America", "united-states", "US"},
+	{"Viet Nam", "vietnam", "VN"},
+}
+
+// intentTestRegions are named the way the JHU daily reports name them
+var intentTestRegions = []*RegionInfo{
+	{"US-CA", "California", "US", Admin1},
+	{"US-FL", "Florida", "US", Admin1},
+	{"US-GA", "Georgia", "US", Admin1},
+	{"US-IA", "Iowa", "US", Admin1},
+	{"US-CA-037", "Los Angeles", "US-CA", Admin2},
+	{"US-CA-059", "Orange", "US-CA", Admin2},
+	{"US-FL-095", "Orange", "US-FL", Admin2},
+	{"US-IA-169", "Story", "US-IA", Admin2},
+}
+
+func TestIntentParser(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *parsedRequest
+	}{
+		// Deaths
+		{"how many people died in brazil", &parsedRequest{_Deaths, "BR"}},
+		{"How many people have died in Brazil?", &parsedRequest{_Deaths, "BR"}},
+		{"brazil deaths", &parsedRequest{_Deaths, "BR"}},
+		{"death toll in france", &parsedRequest{_Deaths, "FR"}},
+		{"What's the death toll in the UK?", &parsedRequest{_Deaths, "GB"}},
+		{"how many dead in germany", &parsedRequest{_Deaths, "DE"}},
+		{"number of fatalities in india", &parsedRequest{_Deaths, "IN"}},
+		{"how many were killed by covid in nigeria", &parsedRequest{_Deaths, "NG"}},
+		{"how many people passed away in niger", &parsedRequest{_Deaths, "NE"}},
+		{"covid mortality in south africa", &parsedRequest{_Deaths, "ZA"}},
+		{"how many lives has corona claimed in russia", &parsedRequest{_Deaths, "RU"}},
+		{"deceased in singapore", &parsedRequest{_Deaths, "SG"}},
+		{"is anyone dying in taiwan", &parsedRequest{_Deaths, "TW"}},
+		{"how many deaths in the united states", &parsedRequest{_Deaths, "US"}},
+		{"how many deaths in the united states of america", &parsedRequest{_Deaths, "US"}},
+		{"deaths in the USA", &parsedRequest{_Deaths, "US"}},
+		{"deaths in america", &parsedRequest{_Deaths, "US"}},
+		{"how many have died in the states", &parsedRequest{_Deaths, "US"}},
+		{"how many people died worldwide", &parsedRequest{_Deaths, "TOTAL"}},
+		{"global death toll", &parsedRequest{_Deaths, "TOTAL"}},
+		{"total deaths", &parsedRequest{_Deaths, "TOTAL"}},
+		{"how many people died around the world", &parsedRequest{_Deaths, "TOTAL"}},
+		{"total deaths in france", &parsedRequest{_Deaths, "FR"}},
+		{"deaths in the world", &parsedRequest{_Deaths, "TOTAL"}},
+		{"how many people died", &parsedRequest{_Deaths, ""}},
+		{"how many died from covid", &parsedRequest{_Deaths, ""}},
+		{"and deaths?", &parsedRequest{_Deaths, ""}},
+
+		// Cases
+		{"how many cases in singapore", &parsedRequest{_Cases, "SG"}},
+		{"How many active cases are there in Germany right now?", &parsedRequest{_Cases, "DE"}},
+		{"how many people are infected in india", &parsedRequest{_Cases, "IN"}},
+		{"infections in viet nam", &parsedRequest{_Cases, "VN"}},
+		{"infections in vietnam", &parsedRequest{_Cases, "VN"}},
+		{"how many are sick in iran", &parsedRequest{_Cases, "IR"}},
+		{"positive tests in the Islamic Republic of Iran", &parsedRequest{_Cases, "IR"}},
+		{"confirmed cases in south korea", &parsedRequest{_Cases, "KR"}},
+		{"cases in korea", &parsedRequest{_Cases, "KR"}},
+		{"covid patients in russia", &parsedRequest{_Cases, "RU"}},
+		{"covid in france", &parsedRequest{_Cases, "FR"}},
+		{"coronavirus britain", &parsedRequest{_Cases, "GB"}},
+		{"how bad is corona in england", &parsedRequest{_Cases, "GB"}},
+		{"cases worldwide", &parsedRequest{_Cases, "TOTAL"}},
+		{"How many people have covid globally?", &parsedRequest{_Cases, "TOTAL"}},
+		{"how many cases in total", &parsedRequest{_Cases, "TOTAL"}},
+		{"how many cases are there", &parsedRequest{_Cases, ""}},
+
+		// Names sharing words with other countries
+		{"cases in papua new guinea", &parsedRequest{_Cases, "PG"}},
+		{"cases in guinea", &parsedRequest{_Cases, "GN"}},
+		{"deaths in south sudan", &parsedRequest{_Deaths, "SS"}},
+		{"deaths in sudan", &parsedRequest{_Deaths, "SD"}},
+		{"cases in dominica", &parsedRequest{_Cases, "DM"}},
+		{"cases in the dominican republic", &parsedRequest{_Cases, "DO"}},
+		{"deaths in niger", &parsedRequest{_Deaths, "NE"}},
+		{"deaths in nigeria", &parsedRequest{_Deaths, "NG"}},
+		{"cases in congo kinshasa", &parsedRequest{_Cases, "CD"}},
+		{"cases in the drc", &parsedRequest{_Cases, "CD"}},
+		{"cases in congo (brazzaville)", &parsedRequest{_Cases, "CG"}},
+
+		// Accents, punctuation and capitals
+		{"deaths in Côte d'Ivoire", &parsedRequest{_Deaths, "CI"}},
+		{"deaths in cote divoire", &parsedRequest{_Deaths, "CI"}},
+		{"deaths in the ivory coast", &parsedRequest{_Deaths, "CI"}},
+		{"HOW MANY PEOPLE DIED IN BRAZIL", &parsedRequest{_Deaths, "BR"}},
+		{"cases... in... FRANCE!!!", &parsedRequest{_Cases, "FR"}},
+		{"deaths,brazil", &parsedRequest{_Deaths, "BR"}},
+
+		// Country codes
+		{"how many died in the US", &parsedRequest{_Deaths, "US"}},
+		{"Deaths in IN", &parsedRequest{_Deaths, "IN"}},
+		{"cases for SG please", &parsedRequest{_Cases, "SG"}},
+		{"cases in ZZ", nil},
+		{"HOW MANY DIED IN US", nil},
+		{"tell us how many died", &parsedRequest{_Deaths, ""}},
+
+		// Not understood
+		{"hello", nil},
+		{"how are you", nil},
+		{"what's the weather in france", nil},
+		{"how many people live in brazil", nil},
+		{"cases and deaths in brazil", nil},
+		{"how many people died in atlantis", nil},
+		{"deaths in the last week", nil},
+		{"deaths in brazil and france", nil},
+		{"", nil},
+		// Regions
+		{"how many cases in california", &parsedRequest{_Cases, "US-CA"}},
+		{"deaths in los angeles", &parsedRequest{_Deaths, "US-CA-037"}},
+		{"deaths in Los Angeles, California", &parsedRequest{_Deaths, "US-CA-037"}},
+		{"covid in orange county, california", &parsedRequest{_Cases, "US-CA-059"}},
+		{"covid in orange county florida", &parsedRequest{_Cases, "US-FL-095"}},
+		{"covid in orange county", nil},
+		{"cases in story county", &parsedRequest{_Cases, "US-IA-169"}},
+		{"the story of deaths in france", &parsedRequest{_Deaths, "FR"}},
+		{"cases in georgia", &parsedRequest{_Cases, "GE"}},
+		{"how many died in US-CA?", &parsedRequest{_Deaths, "US-CA"}},
+		{"cases in california and brazil", nil},
+		// Groups
+		{"deaths in europe", &parsedRequest{_Deaths, "EUROPE"}},
+		{"cases in the EU", &parsedRequest{_Cases, "EU"}},
+		{"how many cases in the european union", &parsedRequest{_Cases, "EU"}},
+		{"cases in africa", &parsedRequest{_Cases, "AFRICA"}},
+		{"died in south africa", &parsedRequest{_Deaths, "ZA"}},
+		{"covid in north america", &parsedRequest{_Cases, "NORTHAMERICA"}},
+		{"deaths in asean", &parsedRequest{_Deaths, "ASEAN"}},
+		// Vaccinations and tests
+		{"how many vaccine doses in france", &parsedRequest{_Doses, "FR"}},
+		{"how many people are vaccinated in the uk", &parsedRequest{_Vaccinated, "GB"}},
+		{"how many people got a vaccine dose and are vaccinated in brazil", &parsedRequest{_Vaccinated, "BR"}},
+		{"how many tests in india", &parsedRequest{_Tests, "IN"}},
+		{"how many people have been tested in germany", &parsedRequest{_Tests, "DE"}},
+		{"test positivity in singapore", &parsedRequest{_Positivity, "SG"}},
+		{"positive tests in singapore", &parsedRequest{_Cases, "SG"}},
+		{"vaccinations worldwide", &parsedRequest{_Doses, "TOTAL"}},
+		{"tests and deaths in france", nil},
+		// Hospitals
+		{"how many people are in hospital in france", &parsedRequest{_Hospitalized, "FR"}},
+		{"hospitalizations in the uk", &parsedRequest{_Hospitalized, "GB"}},
+		{"how many patients are in icu in germany", &parsedRequest{_ICU, "DE"}},
+		{"hospital patients in intensive care in brazil", &parsedRequest{_ICU, "BR"}},
+		{"how many people are on ventilators in california", &parsedRequest{_Ventilators, "US-CA"}},
+		{"patients in italy", nil},
+		{"patients in india", &parsedRequest{_Cases, "IN"}},
+		{"icu patients and deaths in france", nil},
+		{"vaccinated people in hospital in france", nil},
+	}
+
+	parser := newIntentParser(intentTestCountries, intentTestRegions, builtInGroupList())
+	for _, test := range tests {
+		parsedReq := parser.parse(test.input)
+		if test.expected == nil {
+			if parsedReq != nil {
+				t.Errorf("Expected no request. Input: %s Got=%+v", test.input, parsedReq)
+			}
+		} else if parsedReq == nil || *parsedReq != *test.expected {
+			t.Errorf("Request mismatch. Input: %s Expected=%+v Got=%+v", test.input, test.expected, parsedReq)
+		}
+	}
+}
+
+func TestBotIntents(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot := NewBot(memoryStore, 0)
+	testBot.SetSessions(NewMemorySessionStore(time.Hour))
+
+	tests := []struct {
+		sender   string
+		input    string
+		expected string
+	}{
+		{"", "how many people died in singapore", "[SG] Singapore Deaths: 1,822"},
+		{"", "How many active cases are there in Afghanistan?", "[AF] Afghanistan Active Cases: 8,132"},
+		{"", "how many people died worldwide", "Total Deaths: 500,000"},
+		{"", "how many people died", "Total Deaths: 500,000"},
+		{"", "how many people died in brazil", "Sorry, I'm not sure how to respond to that."},
+		{"", "DEATHS SG please", "[SG] Singapore Deaths: 1,822"},
+		{"", "what's the weather like", "Sorry, I'm not sure how to respond to that."},
+		// Codes that aren't known aren't answered for the world
+		{"", "DEATHS TOT", "Sorry, that doesn't match any groups of countries I know."},
+		{"", "CASES SGP", "Sorry, that doesn't match any groups of countries I know."},
+		{"", "cases <b>", "Sorry, I'm not sure how to respond to that."},
+		{"", "how many people died in SGP", "Sorry, I'm not sure how to respond to that."},
+		{"", "how many people died SGP", "Sorry, I'm not sure how to respond to that."},
+		{"", "how many COVID deaths", "Total Deaths: 500,000"},
+		// Commands naming a place rather than a code are read as questions
+		{"", "deaths singapore", "[SG] Singapore Deaths: 1,822"},
+		{"", "DEATHS WORLDWIDE", "Total Deaths: 500,000"},
+		// Questions naming no place are about the country in the conversation
+		{"telegram:1", "cases in singapore", "[SG] Singapore Active Cases: 8,132"},
+		{"telegram:1", "how many people died there?", "[SG] Singapore Deaths: 1,822"},
+		{"telegram:1", "DEATHS TOT", "Sorry, that doesn't match any groups of countries I know."},
+	}
+
+	for _, test := range tests {
+		response, _ := testBot.RespondTo(test.sender, test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+}
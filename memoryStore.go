@@ -0,0 +1,579 @@
+package durcov
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+	"gopkg.in/errgo.v2/fmt/errors"
+)
+
+// MemoryStore represents an in-memory store and view for covid data.
+// It satisfies DataStore, DataView, GroupStore and UpdateListener and is safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	countries map[string]*CountryInfo
+	regions   map[string]*RegionInfo
+	groups    map[string]*GroupInfo
+	latest    map[string]*StatsSnapshot
+	history   map[string][]*StatsSnapshot
+	// vaccinations and tests hold the latest figures by country code, or GLOBAL for the world
+	vaccinations map[string]*VaccinationSnapshot
+	tests        map[string]*TestingSnapshot
+	// hospitals holds the latest hospital occupancy figures by country or region code
+	hospitals map[string]*HospitalSnapshot
+	listeners map[chan time.Time]struct{}
+}
+
+const globalID = "GLOBAL"
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		countries:    map[string]*CountryInfo{},
+		regions:      map[string]*RegionInfo{},
+		groups:       map[string]*GroupInfo{},
+		latest:       map[string]*StatsSnapshot{},
+		history:      map[string][]*StatsSnapshot{},
+		vaccinations: map[string]*VaccinationSnapshot{},
+		tests:        map[string]*TestingSnapshot{},
+		hospitals:    map[string]*HospitalSnapshot{},
+		listeners:    map[chan time.Time]struct{}{},
+	}
+}
+
+// SetDBConnection is a no-op. The memory store has no backing database.
+func (m *MemoryStore) SetDBConnection(pgxpool *pgx.ConnPool) {}
+
+// StoreData replaces the latest data held in memory.
+// Every stored snapshot is also kept in the history and listeners are notified of the new data.
+// Regions are only replaced when the data has some, so data without regions keeps the last regions stored.
+// Vaccination, testing and hospital occupancy figures are kept the same way.
+func (m *MemoryStore) StoreData(data *Data) error {
+	if data == nil || data.global == nil {
+		return errors.New("No data to store")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := map[string]*StatsSnapshot{}
+	if len(data.regions) == 0 {
+		for code := range m.regions {
+			latest[code] = m.latest[code]
+		}
+	}
+	m.countries = map[string]*CountryInfo{}
+	m.latest = latest
+
+	m.storeSnapshot(globalID, data.global.stats)
+	for _, country := range data.countries {
+		m.countries[country.code] = &CountryInfo{Name: country.name, Slug: country.slug, Code: country.code}
+		m.storeSnapshot(country.code, country.stats)
+	}
+	if len(data.regions) > 0 {
+		m.regions = map[string]*RegionInfo{}
+		for _, region := range data.regions {
+			m.regions[region.code] = &RegionInfo{Code: region.code, Name: region.name, Parent: region.parent, Level: region.level}
+			m.storeSnapshot(region.code, region.stats)
+		}
+	}
+	if len(data.vaccinations) > 0 {
+		m.vaccinations = map[string]*VaccinationSnapshot{}
+		for _, figures := range data.vaccinations {
+			m.vaccinations[figures.code] = &VaccinationSnapshot{Doses: figures.doses, PeopleVaccinated: figures.peopleVaccinated, CollectedAt: figures.date}
+		}
+	}
+	if len(data.tests) > 0 {
+		m.tests = map[string]*TestingSnapshot{}
+		for _, figures := range data.tests {
+			m.tests[figures.code] = &TestingSnapshot{Tests: figures.tests, PositiveRate: figures.positiveRate, CollectedAt: figures.date}
+		}
+	}
+	if len(data.hospitals) > 0 {
+		m.hospitals = map[string]*HospitalSnapshot{}
+		for _, figure := range data.hospitals {
+			if _, ok := m.hospitals[figure.code]; !ok {
+				m.hospitals[figure.code] = &HospitalSnapshot{}
+			}
+			m.hospitals[figure.code].set(figure.datum, &OccupancyFigure{Patients: figure.patients, CollectedAt: figure.date})
+		}
+	}
+
+	for listener := range m.listeners {
+		select {
+		case listener <- data.global.stats.date:
+		default:
+			// The listener hasn't consumed an earlier update yet. It'll see this data when it does.
+		}
+	}
+	return nil
+}
+
+// ListenForUpdates subscribes to data stored in memory from now on.
+// The returned channel is closed once ctx is done.
+func (m *MemoryStore) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	listener := make(chan time.Time, 1)
+	m.mu.Lock()
+	m.listeners[listener] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.listeners, listener)
+		close(listener)
+		m.mu.Unlock()
+	}()
+	return listener, nil
+}
+
+func (m *MemoryStore) storeSnapshot(id string, stats *statistics) {
+	snapshot := &StatsSnapshot{
+		Confirmed:   stats.totalConfirmed,
+		Deaths:      stats.totalDeaths,
+		Recovered:   stats.totalRecovered,
+		CollectedAt: stats.date,
+	}
+	m.latest[id] = snapshot
+
+	history := m.history[id]
+	for _, stored := range history {
+		if stored.CollectedAt.Equal(snapshot.CollectedAt) {
+			return
+		}
+	}
+	history = append(history, snapshot)
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].CollectedAt.Before(history[j].CollectedAt)
+	})
+	m.history[id] = history
+}
+
+// LatestGlobalView returns the latest (available) global data for the given datapoint
+// Returns error if the given datapoint does not have a view implemented.
+func (m *MemoryStore) LatestGlobalView(datapoint Datum) (int64, error) {
+	stats, err := m.LatestGlobalStats()
+	if err != nil {
+		return 0, err
+	}
+	switch datapoint {
+	case Active:
+		return stats.Active(), nil
+	case Deaths:
+		return stats.Deaths, nil
+	default:
+		return 0, errors.Newf("Unsupported Op for Global View. Datum Enum %d", datapoint)
+	}
+}
+
+// LatestCountryView returns the latest (available) covid data for the given country code and datapoint.
+// Returns err if no match found for the country code (or) if no view implemented for the datapoint.
+func (m *MemoryStore) LatestCountryView(countryCode string, datapoint Datum) (string, int64, error) {
+	info, stats, err := m.LatestCountryStats(countryCode)
+	if err != nil {
+		return "", 0, err
+	}
+	switch datapoint {
+	case Active:
+		return info.Name, stats.Active(), nil
+	case Deaths:
+		return info.Name, stats.Deaths, nil
+	default:
+		return "", 0, errors.Newf("Unsupported Op for Country View. Datum Enum %d", datapoint)
+	}
+}
+
+// Countries returns the identifying details of every country with latest data, ordered by name.
+func (m *MemoryStore) Countries() ([]*CountryInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	countries := []*CountryInfo{}
+	for _, info := range m.countries {
+		countries = append(countries, info)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		return countries[i].Name < countries[j].Name
+	})
+	return countries, nil
+}
+
+// LatestGlobalStats returns the latest (available) global statistics.
+func (m *MemoryStore) LatestGlobalStats() (*StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats, ok := m.latest[globalID]
+	if !ok {
+		return nil, errors.New("No global data stored")
+	}
+	return stats, nil
+}
+
+// LatestCountryStats returns the latest (available) statistics for the given country code.
+// Returns err if no match found for the country code.
+func (m *MemoryStore) LatestCountryStats(countryCode string) (*CountryInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.countries[countryCode]
+	if !ok {
+		return nil, nil, &NoCountryMatchedError{countryCode}
+	}
+	return info, m.latest[countryCode], nil
+}
+
+// GlobalHistory returns the stored global statistics collected between from and to (inclusive), oldest first.
+func (m *MemoryStore) GlobalHistory(from time.Time, to time.Time) ([]*StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.historyBetween(globalID, from, to), nil
+}
+
+// CountryHistory returns the stored statistics for the given country code collected between from and to (inclusive), oldest first.
+// Returns err if no match found for the country code.
+func (m *MemoryStore) CountryHistory(countryCode string, from time.Time, to time.Time) ([]*StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.countries[countryCode]; !ok {
+		return nil, &NoCountryMatchedError{countryCode}
+	}
+	return m.historyBetween(countryCode, from, to), nil
+}
+
+// GlobalStatsAt returns the global statistics as they were at the given time. That's the latest snapshot collected
+// within snapshotWindow before it, or else the snapshot collected nearest to it. Returns a *NoSnapshotError when no
+// history is stored.
+func (m *MemoryStore) GlobalStatsAt(at time.Time) (*StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.snapshotAt(globalID, at)
+}
+
+// CountryStatsAt returns the statistics for the given country code as they were at the given time. The snapshot is
+// picked like GlobalStatsAt picks it.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when no history is stored.
+func (m *MemoryStore) CountryStatsAt(countryCode string, at time.Time) (*CountryInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.countries[countryCode]
+	if !ok {
+		return nil, nil, &NoCountryMatchedError{countryCode}
+	}
+	stats, err := m.snapshotAt(countryCode, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, stats, nil
+}
+
+// Regions returns the identifying details of the regions directly within the given country or admin1 region, ordered by name.
+// Every region is returned, ordered by code, when parentCode is empty.
+func (m *MemoryStore) Regions(parentCode string) ([]*RegionInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	regions := []*RegionInfo{}
+	for _, info := range m.regions {
+		if parentCode == "" || info.Parent == parentCode {
+			regions = append(regions, info)
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if parentCode == "" {
+			return regions[i].Code < regions[j].Code
+		}
+		return regions[i].Name < regions[j].Name
+	})
+	return regions, nil
+}
+
+// LatestRegionStats returns the latest (available) statistics for the given region code.
+// Returns err if no match found for the region code.
+func (m *MemoryStore) LatestRegionStats(regionCode string) (*RegionInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.regions[regionCode]
+	if !ok {
+		return nil, nil, &NoRegionMatchedError{regionCode}
+	}
+	return info, m.latest[regionCode], nil
+}
+
+// RegionStatsAt returns the statistics for the given region code as they were at the given time, like CountryStatsAt.
+// Returns err if no match found for the region code (or) a *NoSnapshotError when no history is stored.
+func (m *MemoryStore) RegionStatsAt(regionCode string, at time.Time) (*RegionInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.regions[regionCode]
+	if !ok {
+		return nil, nil, &NoRegionMatchedError{regionCode}
+	}
+	stats, err := m.snapshotAt(regionCode, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, stats, nil
+}
+
+// Groups returns the built in groups and the custom groups saved, ordered by name.
+func (m *MemoryStore) Groups() ([]*GroupInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := builtInGroupList()
+	for _, group := range m.groups {
+		groups = append(groups, group)
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+// LatestGroupStats returns the latest (available) statistics of the group's members added up.
+// Returns err if no match found for the group code (or) a *NoSnapshotError when none of its members have statistics.
+func (m *MemoryStore) LatestGroupStats(groupCode string) (*GroupInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, err := m.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshots := []*StatsSnapshot{}
+	for _, member := range group.Members {
+		if _, ok := m.countries[member]; ok {
+			snapshots = append(snapshots, m.latest[member])
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	return group, totalSnapshots(snapshots), nil
+}
+
+// GroupStatsAt returns the statistics of the group's members as they were at the given time added up. Each member's
+// snapshot is picked like CountryStatsAt picks it. Returns err if no match found for the group code (or) a
+// *NoSnapshotError when no history is stored for any of its members.
+func (m *MemoryStore) GroupStatsAt(groupCode string, at time.Time) (*GroupInfo, *StatsSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, err := m.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshots := []*StatsSnapshot{}
+	for _, member := range group.Members {
+		if stats, err := m.snapshotAt(member, at); err == nil {
+			snapshots = append(snapshots, stats)
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	return group, totalSnapshots(snapshots), nil
+}
+
+// SaveGroup creates the custom group or replaces the one with the same code. The group must validate.
+func (m *MemoryStore) SaveGroup(group *GroupInfo) error {
+	if err := group.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *group
+	saved.Members = append([]string{}, group.Members...)
+	saved.BuiltIn = false
+	m.groups[group.Code] = &saved
+	return nil
+}
+
+// DeleteGroup removes a custom group. Returns a *NoGroupMatchedError when no custom group has the code.
+func (m *MemoryStore) DeleteGroup(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[code]; !ok {
+		return &NoGroupMatchedError{code}
+	}
+	delete(m.groups, code)
+	return nil
+}
+
+func (m *MemoryStore) group(code string) (*GroupInfo, error) {
+	if group, ok := builtInGroup(code); ok {
+		return group, nil
+	}
+	group, ok := m.groups[code]
+	if !ok {
+		return nil, &NoGroupMatchedError{code}
+	}
+	return group, nil
+}
+
+// LatestGlobalVaccinations returns the latest (available) vaccination figures for the world.
+// Returns a *NoSnapshotError when none are stored.
+func (m *MemoryStore) LatestGlobalVaccinations() (*VaccinationSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	figures, ok := m.vaccinations[globalID]
+	if !ok {
+		return nil, &NoSnapshotError{globalID}
+	}
+	return figures, nil
+}
+
+// LatestCountryVaccinations returns the latest (available) vaccination figures for the given country code.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when none are stored for the country.
+func (m *MemoryStore) LatestCountryVaccinations(countryCode string) (*CountryInfo, *VaccinationSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.countries[countryCode]
+	if !ok {
+		return nil, nil, &NoCountryMatchedError{countryCode}
+	}
+	figures, ok := m.vaccinations[countryCode]
+	if !ok {
+		return nil, nil, &NoSnapshotError{countryCode}
+	}
+	return info, figures, nil
+}
+
+// LatestGroupVaccinations returns the latest (available) vaccination figures of the group's members added up.
+// Returns err if no match found for the group code (or) a *NoSnapshotError when none are stored for its members.
+func (m *MemoryStore) LatestGroupVaccinations(groupCode string) (*GroupInfo, *VaccinationSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, err := m.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := []*VaccinationSnapshot{}
+	for _, member := range group.Members {
+		if figures, ok := m.vaccinations[member]; ok {
+			members = append(members, figures)
+		}
+	}
+	if len(members) == 0 {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	return group, totalVaccinations(members), nil
+}
+
+// LatestCountryTests returns the latest (available) testing figures for the given country code.
+// Returns err if no match found for the country code (or) a *NoSnapshotError when none are stored for the country.
+func (m *MemoryStore) LatestCountryTests(countryCode string) (*CountryInfo, *TestingSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.countries[countryCode]
+	if !ok {
+		return nil, nil, &NoCountryMatchedError{countryCode}
+	}
+	figures, ok := m.tests[countryCode]
+	if !ok {
+		return nil, nil, &NoSnapshotError{countryCode}
+	}
+	return info, figures, nil
+}
+
+// LatestGroupTests returns the latest (available) testing figures of the group's members added up, with the positive
+// rate weighted by each member's tests. Returns err if no match found for the group code (or) a *NoSnapshotError when
+// none are stored for its members.
+func (m *MemoryStore) LatestGroupTests(groupCode string) (*GroupInfo, *TestingSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, err := m.group(groupCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := []*TestingSnapshot{}
+	for _, member := range group.Members {
+		if figures, ok := m.tests[member]; ok {
+			members = append(members, figures)
+		}
+	}
+	if len(members) == 0 {
+		return nil, nil, &NoSnapshotError{groupCode}
+	}
+	return group, totalTests(members), nil
+}
+
+// LatestHospitalOccupancy returns the name of the country or region with the given code and the latest (available)
+// hospital occupancy figures it publishes. Figures it doesn't publish are nil. A region the regional report doesn't have
+// is named after its country when figures are published for it. Returns err if no match found for the country or region code.
+func (m *MemoryStore) LatestHospitalOccupancy(code string) (string, *HospitalSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var name string
+	if IsRegionCode(code) {
+		info, ok := m.regions[code]
+		country, hasCountry := m.countries[strings.SplitN(code, "-", 2)[0]]
+		if ok {
+			name = info.Name
+		} else if hasCountry && m.hospitals[code] != nil {
+			name = hospitalRegionName(code, country.Name)
+		} else {
+			return "", nil, &NoRegionMatchedError{code}
+		}
+	} else {
+		info, ok := m.countries[code]
+		if !ok {
+			return "", nil, &NoCountryMatchedError{code}
+		}
+		name = info.Name
+	}
+	figures, ok := m.hospitals[code]
+	if !ok {
+		return name, &HospitalSnapshot{}, nil
+	}
+	return name, figures, nil
+}
+
+// snapshotAt relies on the history being kept oldest first
+func (m *MemoryStore) snapshotAt(id string, at time.Time) (*StatsSnapshot, error) {
+	history := m.history[id]
+	if len(history) == 0 {
+		return nil, &NoSnapshotError{id}
+	}
+	after := sort.Search(len(history), func(i int) bool {
+		return history[i].CollectedAt.After(at)
+	})
+	if after == 0 {
+		return history[0], nil
+	}
+	before := history[after-1]
+	if after == len(history) || at.Sub(before.CollectedAt) <= snapshotWindow {
+		return before, nil
+	}
+	if next := history[after]; next.CollectedAt.Sub(at) < at.Sub(before.CollectedAt) {
+		return next, nil
+	}
+	return before, nil
+}
+
+func (m *MemoryStore) historyBetween(id string, from time.Time, to time.Time) []*StatsSnapshot {
+	history := []*StatsSnapshot{}
+	for _, stats := range m.history[id] {
+		if stats.CollectedAt.Before(from) || stats.CollectedAt.After(to) {
+			continue
+		}
+		history = append(history, stats)
+	}
+	return history
+}
@@ -0,0 +1,182 @@
+package durcov
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	memoryStore := NewMemoryStore()
+
+	exampleData, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memoryStore.StoreData(exampleData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Listeners are notified of stored data": func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			updates, err := memoryStore.ListenForUpdates(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = memoryStore.StoreData(exampleData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			collectedAt := <-updates
+			if !collectedAt.Equal(exampleData.global.stats.date) {
+				t.Errorf("update time mismatch. Expected=%v Got=%v", exampleData.global.stats.date, collectedAt)
+			}
+
+			cancel()
+			if _, ok := <-updates; ok {
+				t.Error("Expected updates to be closed once the context is done")
+			}
+		},
+		"Total global deaths view": func(t *testing.T) {
+			globalDeaths, err := memoryStore.LatestGlobalView(Deaths)
+			if err != nil {
+				t.Error(err)
+			}
+			if globalDeaths != exampleData.global.stats.totalDeaths {
+				t.Errorf("Total global deaths mismatch. Expected=%d Got=%d", exampleData.global.stats.totalDeaths, globalDeaths)
+			}
+		},
+		"Countries active view": func(t *testing.T) {
+			for _, country := range exampleData.countries {
+				countryName, countryActive, err := memoryStore.LatestCountryView(country.code, Active)
+				if err != nil {
+					t.Error(err)
+				}
+				expectedActive := calculateActive(
+					country.stats.totalConfirmed,
+					country.stats.totalDeaths,
+					country.stats.totalRecovered,
+				)
+				if countryActive != expectedActive {
+					t.Errorf("country active mismatch for country=%s. Expected=%d Got=%d", country.name, expectedActive, countryActive)
+				}
+				if countryName != country.name {
+					t.Errorf("country name mismatch. Expected=%s Got=%s", country.name, countryName)
+				}
+			}
+		},
+		"Countries are ordered by name": func(t *testing.T) {
+			countries, err := memoryStore.Countries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(countries) != len(exampleData.countries) {
+				t.Fatalf("countries length mismatch. Expected=%d Got=%d", len(exampleData.countries), len(countries))
+			}
+			if countries[0].Code != "AF" || countries[1].Code != "SG" {
+				t.Errorf("countries order mismatch. Got=%s,%s", countries[0].Code, countries[1].Code)
+			}
+		},
+		"History keeps every stored snapshot": func(t *testing.T) {
+			laterTime := exampleData.global.stats.date.Add(24 * time.Hour)
+			err = memoryStore.StoreData(ExampleTestDataAt(laterTime))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			history, err := memoryStore.CountryHistory("SG", time.Time{}, laterTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 2 {
+				t.Fatalf("history length mismatch. Expected=%d Got=%d", 2, len(history))
+			}
+			if !history[1].CollectedAt.Equal(laterTime) {
+				t.Errorf("history order mismatch. Expected=%v Got=%v", laterTime, history[1].CollectedAt)
+			}
+
+			history, err = memoryStore.GlobalHistory(laterTime, laterTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 1 {
+				t.Errorf("history range mismatch. Expected=%d Got=%d", 1, len(history))
+			}
+		},
+		"Unmatched country code returns specific error": func(t *testing.T) {
+			_, _, err := memoryStore.LatestCountryView("--", Active)
+			if err, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Unexpected error. Expected=*NoCountryMatchedError Got=%T", err)
+			}
+			_, err = memoryStore.CountryHistory("--", time.Time{}, time.Now())
+			if err, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Unexpected error. Expected=*NoCountryMatchedError Got=%T", err)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestMemoryStoreStatsAt(t *testing.T) {
+	first := time.Date(2020, 11, 1, 3, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 11, 8, 3, 0, 0, 0, time.UTC)
+	memoryStore := NewMemoryStore()
+	memoryStore.StoreData(ExampleTestDataAt(first))
+	laterData := ExampleTestDataAt(second)
+	laterData.countries[1].stats.totalDeaths = 1900
+	memoryStore.StoreData(laterData)
+
+	tests := []struct {
+		at       time.Time
+		expected time.Time
+	}{
+		{first, first},
+		{first.Add(-time.Hour), first},
+		{first.Add(3 * 24 * time.Hour), first},
+		// The later snapshot is closer
+		{first.Add(4 * 24 * time.Hour), second},
+		{second.Add(-time.Nanosecond), second},
+		{second, second},
+		{second.Add(48 * time.Hour), second},
+	}
+	for _, test := range tests {
+		info, stats, err := memoryStore.CountryStatsAt("SG", test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != "Singapore" || !stats.CollectedAt.Equal(test.expected) {
+			t.Errorf("Snapshot mismatch. At=%v Expected=%v Got=%v", test.at, test.expected, stats.CollectedAt)
+		}
+	}
+
+	// A day's snapshot is kept for the end of the day even when the next day's is closer
+	nextDay := ExampleTestDataAt(second.Add(22 * time.Hour))
+	memoryStore.StoreData(nextDay)
+	endOfSecondDay := time.Date(2020, 11, 8, 23, 59, 59, 0, time.UTC)
+	if _, stats, _ := memoryStore.CountryStatsAt("SG", endOfSecondDay); !stats.CollectedAt.Equal(second) {
+		t.Errorf("Snapshot mismatch. At=%v Expected=%v Got=%v", endOfSecondDay, second, stats.CollectedAt)
+	}
+
+	_, stats, _ := memoryStore.CountryStatsAt("SG", second)
+	if stats.Deaths != 1900 {
+		t.Errorf("Deaths mismatch. Expected=%d Got=%d", 1900, stats.Deaths)
+	}
+	stats, err := memoryStore.GlobalStatsAt(first)
+	if err != nil || !stats.CollectedAt.Equal(first) {
+		t.Errorf("Global snapshot mismatch. Expected=%v Got=%+v Err=%v", first, stats, err)
+	}
+	_, _, err = memoryStore.CountryStatsAt("--", first)
+	if err, ok := err.(*NoCountryMatchedError); !ok {
+		t.Errorf("Unexpected error. Expected=*NoCountryMatchedError Got=%T", err)
+	}
+	_, err = NewMemoryStore().GlobalStatsAt(first)
+	if err, ok := err.(*NoSnapshotError); !ok {
+		t.Errorf("Unexpected error. Expected=*NoSnapshotError Got=%T", err)
+	}
+}
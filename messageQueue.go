@@ -0,0 +1,349 @@
+package durcov
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Statuses of an outbound message
+const (
+	MessagePending      = "pending"
+	MessageSending      = "sending"
+	MessageSent         = "sent"
+	MessageDeadLettered = "dead_lettered"
+)
+
+// OutboundMessage represents a reply queued for delivery
+type OutboundMessage struct {
+	ID         int64
+	To         string
+	From       string
+	Body       string
+	Status     string
+	Attempts   int
+	LastError  string
+	ProviderID string
+	// StatusCallback is the URL twilio reports delivery statuses to. Optional.
+	StatusCallback string
+}
+
+// DeliveryAttempt represents a single attempt at sending an outbound message
+type DeliveryAttempt struct {
+	MessageID   int64
+	AttemptedAt time.Time
+	Error       string
+}
+
+// MessageQueue describes an API for a durable queue of outbound messages.
+// Claimed messages are leased to the claimer. If the lease runs out before the message is marked
+// (e.g. the claimer restarted) the message becomes claimable again.
+type MessageQueue interface {
+	Enqueue(msg *OutboundMessage) error
+	Claim(limit int, lease time.Duration) ([]*OutboundMessage, error)
+	MarkSent(id int64, providerID string) error
+	MarkFailed(id int64, errMsg string, retryAfter time.Duration) error
+	DeadLetter(id int64, errMsg string) error
+	Release(id int64, retryAfter time.Duration) error
+	Drop(id int64, reason string) error
+	Attempts(id int64) ([]*DeliveryAttempt, error)
+}
+
+// CovidMessageQueue represents a postgres backed queue of outbound messages
+type CovidMessageQueue struct {
+	pgxpool *pgx.ConnPool
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the queue.
+func (c *CovidMessageQueue) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// Enqueue stores the message as pending and sets its ID.
+func (c *CovidMessageQueue) Enqueue(msg *OutboundMessage) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on message queue")
+	}
+	msg.Status = MessagePending
+	return c.pgxpool.QueryRow("INSERT INTO outbound_messages (to_address, from_address, body, status_callback) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id;", msg.To, msg.From, msg.Body, msg.StatusCallback).Scan(&msg.ID)
+}
+
+// Claim leases up to limit messages that are due, oldest first.
+// Rows locked by other claimers are skipped so multiple dynos can share the queue.
+func (c *CovidMessageQueue) Claim(limit int, lease time.Duration) ([]*OutboundMessage, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on message queue")
+	}
+	rows, err := c.pgxpool.Query(`UPDATE outbound_messages SET status='sending', locked_until=now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbound_messages
+			WHERE (status='pending' AND next_attempt_at <= now()) OR (status='sending' AND locked_until < now())
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, to_address, from_address, body, status, attempts, COALESCE(last_error, ''), COALESCE(status_callback, '');`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := []*OutboundMessage{}
+	for rows.Next() {
+		msg := &OutboundMessage{}
+		err = rows.Scan(&msg.ID, &msg.To, &msg.From, &msg.Body, &msg.Status, &msg.Attempts, &msg.LastError, &msg.StatusCallback)
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, msg)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed, nil
+}
+
+// MarkSent records a successful attempt and the id the provider assigned to the message.
+func (c *CovidMessageQueue) MarkSent(id int64, providerID string) error {
+	return c.recordAttempt(id, "", "UPDATE outbound_messages SET status='sent', attempts=attempts+1, provider_id=$2, locked_until=NULL WHERE id=$1;", id, providerID)
+}
+
+// MarkFailed records a failed attempt and makes the message claimable again after retryAfter.
+func (c *CovidMessageQueue) MarkFailed(id int64, errMsg string, retryAfter time.Duration) error {
+	return c.recordAttempt(id, errMsg, "UPDATE outbound_messages SET status='pending', attempts=attempts+1, last_error=$2, next_attempt_at=now() + $3 * interval '1 millisecond', locked_until=NULL WHERE id=$1;", id, errMsg, retryAfter.Milliseconds())
+}
+
+// DeadLetter records a failed attempt and gives up on the message.
+func (c *CovidMessageQueue) DeadLetter(id int64, errMsg string) error {
+	return c.recordAttempt(id, errMsg, "UPDATE outbound_messages SET status='dead_lettered', attempts=attempts+1, last_error=$2, locked_until=NULL WHERE id=$1;", id, errMsg)
+}
+
+// Release makes the message claimable again after retryAfter without recording an attempt, for when it wasn't sent.
+func (c *CovidMessageQueue) Release(id int64, retryAfter time.Duration) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on message queue")
+	}
+	_, err := c.pgxpool.Exec("UPDATE outbound_messages SET status='pending', next_attempt_at=now() + $2 * interval '1 millisecond', locked_until=NULL WHERE id=$1;", id, retryAfter.Milliseconds())
+	return err
+}
+
+// Drop gives up on the message without recording an attempt, for when it mustn't be sent.
+func (c *CovidMessageQueue) Drop(id int64, reason string) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on message queue")
+	}
+	_, err := c.pgxpool.Exec("UPDATE outbound_messages SET status='dead_lettered', last_error=$2, locked_until=NULL WHERE id=$1;", id, reason)
+	return err
+}
+
+// Attempts returns every recorded delivery attempt for the message, oldest first.
+func (c *CovidMessageQueue) Attempts(id int64) ([]*DeliveryAttempt, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on message queue")
+	}
+	rows, err := c.pgxpool.Query("SELECT message_id, attempted_at, COALESCE(error, '') FROM outbound_message_attempts WHERE message_id=$1 ORDER BY attempted_at;", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*DeliveryAttempt{}
+	for rows.Next() {
+		attempt := &DeliveryAttempt{}
+		err = rows.Scan(&attempt.MessageID, &attempt.AttemptedAt, &attempt.Error)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (c *CovidMessageQueue) recordAttempt(id int64, errMsg string, update string, args ...interface{}) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on message queue")
+	}
+	tx, err := c.pgxpool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(update, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO outbound_message_attempts (message_id, attempted_at, error) VALUES ($1, now(), NULLIF($2, ''));", id, errMsg)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MemoryMessageQueue represents an in-memory queue of outbound messages.
+// Messages don't survive restarts so it's meant for local development and tests.
+// Sent messages are pruned once they're older than sentRetention so the queue doesn't grow forever.
+type MemoryMessageQueue struct {
+	mu            sync.Mutex
+	nextID        int64
+	messages      map[int64]*memoryQueuedMessage
+	attempts      map[int64][]*DeliveryAttempt
+	sentRetention time.Duration
+}
+
+type memoryQueuedMessage struct {
+	msg           OutboundMessage
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+	sentAt        time.Time
+}
+
+// NewMemoryMessageQueue returns an empty MemoryMessageQueue
+func NewMemoryMessageQueue() *MemoryMessageQueue {
+	return &MemoryMessageQueue{
+		messages:      map[int64]*memoryQueuedMessage{},
+		attempts:      map[int64][]*DeliveryAttempt{},
+		sentRetention: time.Hour,
+	}
+}
+
+// Enqueue stores the message as pending and sets its ID.
+func (m *MemoryMessageQueue) Enqueue(msg *OutboundMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneSent()
+	m.nextID++
+	msg.ID = m.nextID
+	msg.Status = MessagePending
+	m.messages[msg.ID] = &memoryQueuedMessage{msg: *msg, nextAttemptAt: time.Now()}
+	return nil
+}
+
+// pruneSent forgets sent messages, and their attempts, older than the retention. Must be called with the lock held.
+func (m *MemoryMessageQueue) pruneSent() {
+	cutoff := time.Now().Add(-m.sentRetention)
+	for id, queued := range m.messages {
+		if queued.msg.Status == MessageSent && queued.sentAt.Before(cutoff) {
+			delete(m.messages, id)
+			delete(m.attempts, id)
+		}
+	}
+}
+
+// Claim leases up to limit messages that are due, oldest first.
+func (m *MemoryMessageQueue) Claim(limit int, lease time.Duration) ([]*OutboundMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	due := []*memoryQueuedMessage{}
+	for _, queued := range m.messages {
+		pendingDue := queued.msg.Status == MessagePending && !queued.nextAttemptAt.After(now)
+		leaseExpired := queued.msg.Status == MessageSending && queued.lockedUntil.Before(now)
+		if pendingDue || leaseExpired {
+			due = append(due, queued)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].msg.ID < due[j].msg.ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := []*OutboundMessage{}
+	for _, queued := range due {
+		queued.msg.Status = MessageSending
+		queued.lockedUntil = now.Add(lease)
+		msg := queued.msg
+		claimed = append(claimed, &msg)
+	}
+	return claimed, nil
+}
+
+// MarkSent records a successful attempt and the id the provider assigned to the message.
+func (m *MemoryMessageQueue) MarkSent(id int64, providerID string) error {
+	return m.recordAttempt(id, "", func(queued *memoryQueuedMessage) {
+		queued.msg.Status = MessageSent
+		queued.msg.ProviderID = providerID
+		queued.sentAt = time.Now()
+	})
+}
+
+// MarkFailed records a failed attempt and makes the message claimable again after retryAfter.
+func (m *MemoryMessageQueue) MarkFailed(id int64, errMsg string, retryAfter time.Duration) error {
+	return m.recordAttempt(id, errMsg, func(queued *memoryQueuedMessage) {
+		queued.msg.Status = MessagePending
+		queued.msg.LastError = errMsg
+		queued.nextAttemptAt = time.Now().Add(retryAfter)
+	})
+}
+
+// DeadLetter records a failed attempt and gives up on the message.
+func (m *MemoryMessageQueue) DeadLetter(id int64, errMsg string) error {
+	return m.recordAttempt(id, errMsg, func(queued *memoryQueuedMessage) {
+		queued.msg.Status = MessageDeadLettered
+		queued.msg.LastError = errMsg
+	})
+}
+
+// Release makes the message claimable again after retryAfter without recording an attempt, for when it wasn't sent.
+func (m *MemoryMessageQueue) Release(id int64, retryAfter time.Duration) error {
+	return m.update(id, func(queued *memoryQueuedMessage) {
+		queued.msg.Status = MessagePending
+		queued.nextAttemptAt = time.Now().Add(retryAfter)
+	})
+}
+
+// Drop gives up on the message without recording an attempt, for when it mustn't be sent.
+func (m *MemoryMessageQueue) Drop(id int64, reason string) error {
+	return m.update(id, func(queued *memoryQueuedMessage) {
+		queued.msg.Status = MessageDeadLettered
+		queued.msg.LastError = reason
+	})
+}
+
+// Attempts returns every recorded delivery attempt for the message, oldest first.
+func (m *MemoryMessageQueue) Attempts(id int64) ([]*DeliveryAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*DeliveryAttempt{}, m.attempts[id]...), nil
+}
+
+// Message returns a copy of the queued message with the given id.
+func (m *MemoryMessageQueue) Message(id int64) (*OutboundMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queued, ok := m.messages[id]
+	if !ok {
+		return nil, false
+	}
+	msg := queued.msg
+	return &msg, true
+}
+
+func (m *MemoryMessageQueue) recordAttempt(id int64, errMsg string, update func(queued *memoryQueuedMessage)) error {
+	return m.update(id, func(queued *memoryQueuedMessage) {
+		update(queued)
+		queued.msg.Attempts++
+		m.attempts[id] = append(m.attempts[id], &DeliveryAttempt{id, time.Now(), errMsg})
+	})
+}
+
+// update applies the change to the message and ends its lease
+func (m *MemoryMessageQueue) update(id int64, change func(queued *memoryQueuedMessage)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queued, ok := m.messages[id]
+	if !ok {
+		return errors.New("No queued message with that id")
+	}
+	change(queued)
+	queued.lockedUntil = time.Time{}
+	return nil
+}
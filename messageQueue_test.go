@@ -0,0 +1,168 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryMessageQueue(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Claims due messages oldest first": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			for _, body := range []string{"first", "second", "third"} {
+				err := queue.Enqueue(&OutboundMessage{To: "to", From: "from", Body: body})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			claimed, err := queue.Claim(2, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claimed) != 2 || claimed[0].Body != "first" || claimed[1].Body != "second" {
+				t.Fatalf("claimed mismatch. Got=%+v", claimed)
+			}
+			claimed, err = queue.Claim(2, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claimed) != 1 || claimed[0].Body != "third" {
+				t.Errorf("Leased messages should not be claimed twice. Got=%+v", claimed)
+			}
+		},
+		"Expired leases are claimable again": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			err := queue.Enqueue(&OutboundMessage{Body: "lost"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = queue.Claim(1, -time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claimed, err := queue.Claim(1, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claimed) != 1 {
+				t.Errorf("Expected message with an expired lease to be reclaimed. Got=%d", len(claimed))
+			}
+		},
+		"Failed messages wait before retrying": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			msg := &OutboundMessage{Body: "retry"}
+			err := queue.Enqueue(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queue.Claim(1, time.Minute)
+			err = queue.MarkFailed(msg.ID, "unreachable", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claimed, _ := queue.Claim(1, time.Minute)
+			if len(claimed) != 0 {
+				t.Errorf("Expected no claimable messages during backoff. Got=%d", len(claimed))
+			}
+			err = queue.MarkFailed(msg.ID, "unreachable", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claimed, _ = queue.Claim(1, time.Minute)
+			if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].LastError != "unreachable" {
+				t.Errorf("retried message mismatch. Got=%+v", claimed)
+			}
+		},
+		"Sent and dead lettered messages are never claimed and attempts are recorded": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			sent := &OutboundMessage{Body: "sent"}
+			dead := &OutboundMessage{Body: "dead"}
+			queue.Enqueue(sent)
+			queue.Enqueue(dead)
+			queue.Claim(2, time.Minute)
+			err := queue.MarkSent(sent.ID, "SM123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = queue.DeadLetter(dead.ID, "invalid number")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claimed, _ := queue.Claim(2, -time.Second)
+			if len(claimed) != 0 {
+				t.Errorf("Expected no claimable messages. Got=%d", len(claimed))
+			}
+			stored, _ := queue.Message(sent.ID)
+			if stored.Status != MessageSent || stored.ProviderID != "SM123" {
+				t.Errorf("sent message mismatch. Got=%+v", stored)
+			}
+			stored, _ = queue.Message(dead.ID)
+			if stored.Status != MessageDeadLettered {
+				t.Errorf("dead lettered message mismatch. Got=%+v", stored)
+			}
+			attempts, err := queue.Attempts(dead.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(attempts) != 1 || attempts[0].Error != "invalid number" {
+				t.Errorf("attempts mismatch. Got=%+v", attempts)
+			}
+		},
+		"Released and dropped messages have no attempts": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			released := &OutboundMessage{Body: "released"}
+			dropped := &OutboundMessage{Body: "dropped"}
+			queue.Enqueue(released)
+			queue.Enqueue(dropped)
+			queue.Claim(2, time.Minute)
+			err := queue.Release(released.ID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = queue.Drop(dropped.ID, "Recipient suppressed")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claimed, _ := queue.Claim(2, time.Minute)
+			if len(claimed) != 1 || claimed[0].ID != released.ID || claimed[0].Attempts != 0 {
+				t.Errorf("claimed mismatch. Got=%+v", claimed)
+			}
+			stored, _ := queue.Message(dropped.ID)
+			if stored.Status != MessageDeadLettered || stored.Attempts != 0 || stored.LastError != "Recipient suppressed" {
+				t.Errorf("dropped message mismatch. Got=%+v", stored)
+			}
+			attempts, _ := queue.Attempts(dropped.ID)
+			if len(attempts) != 0 {
+				t.Errorf("attempts mismatch. Got=%+v", attempts)
+			}
+		},
+		"Sent messages are pruned after the retention": func(t *testing.T) {
+			queue := NewMemoryMessageQueue()
+			queue.sentRetention = 0
+			sent := &OutboundMessage{Body: "sent"}
+			dead := &OutboundMessage{Body: "dead"}
+			queue.Enqueue(sent)
+			queue.Enqueue(dead)
+			queue.Claim(2, time.Minute)
+			queue.MarkSent(sent.ID, "SM123")
+			queue.DeadLetter(dead.ID, "invalid number")
+
+			queue.Enqueue(&OutboundMessage{Body: "next"})
+			if _, ok := queue.Message(sent.ID); ok {
+				t.Error("Expected the sent message to be pruned")
+			}
+			if attempts, _ := queue.Attempts(sent.ID); len(attempts) != 0 {
+				t.Errorf("Expected the sent message's attempts to be pruned. Got=%+v", attempts)
+			}
+			if _, ok := queue.Message(dead.ID); !ok {
+				t.Error("Expected the dead lettered message to be kept")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,147 @@
+package durcov
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// RateLimit represents a token bucket. Each key starts with Burst tokens and regains Rate tokens per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateDecision represents the outcome of taking a token
+type RateDecision struct {
+	Allowed bool
+	// FirstRefusal is set on the first refusal since the key was last allowed so callers can warn once per window
+	FirstRefusal bool
+}
+
+// RateLimiter describes an API to limit how often each key may act
+type RateLimiter interface {
+	Allow(key string) (*RateDecision, error)
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	refused   bool
+}
+
+// take refills the bucket for the time elapsed since it was last updated and takes a token if there is one.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) *RateDecision {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.updatedAt = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.refused = false
+		return &RateDecision{Allowed: true}
+	}
+	decision := &RateDecision{Allowed: false, FirstRefusal: !b.refused}
+	b.refused = true
+	return decision
+}
+
+// full reports whether the bucket would be back to Burst tokens by now, i.e. it holds no state worth keeping.
+func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.updatedAt).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+// CovidRateLimiter represents a rate limiter with buckets stored in postgres so every dyno shares them
+type CovidRateLimiter struct {
+	pgxpool *pgx.ConnPool
+	limit   RateLimit
+}
+
+// NewCovidRateLimiter returns a postgres backed rate limiter. The DB connection must be set before use.
+func NewCovidRateLimiter(limit RateLimit) *CovidRateLimiter {
+	return &CovidRateLimiter{limit: limit}
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the limiter.
+func (c *CovidRateLimiter) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// Allow takes a token from the key's bucket. The bucket row is locked while it's updated.
+func (c *CovidRateLimiter) Allow(key string) (*RateDecision, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on rate limiter")
+	}
+	tx, err := c.pgxpool.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec("INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;", key, float64(c.limit.Burst), now)
+	if err != nil {
+		return nil, err
+	}
+	bucket := &tokenBucket{}
+	err = tx.QueryRow("SELECT tokens, updated_at, refused FROM rate_limit_buckets WHERE key=$1 FOR UPDATE;", key).Scan(&bucket.tokens, &bucket.updatedAt, &bucket.refused)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := bucket.take(c.limit, now)
+	_, err = tx.Exec("UPDATE rate_limit_buckets SET tokens=$2, updated_at=$3, refused=$4 WHERE key=$1;", key, bucket.tokens, bucket.updatedAt, bucket.refused)
+	if err != nil {
+		return nil, err
+	}
+	return decision, tx.Commit()
+}
+
+// MemoryRateLimiter represents a rate limiter with buckets held in memory.
+// Buckets aren't shared between processes.
+type MemoryRateLimiter struct {
+	mu         sync.Mutex
+	limit      RateLimit
+	buckets    map[string]*tokenBucket
+	now        func() time.Time
+	lastPruned time.Time
+}
+
+// NewMemoryRateLimiter returns a MemoryRateLimiter with every bucket full
+func NewMemoryRateLimiter(limit RateLimit) *MemoryRateLimiter {
+	return &MemoryRateLimiter{limit: limit, buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// Allow takes a token from the key's bucket.
+func (m *MemoryRateLimiter) Allow(key string) (*RateDecision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(m.limit.Burst), updatedAt: now}
+		m.buckets[key] = bucket
+	}
+	return bucket.take(m.limit, now), nil
+}
+
+// prune forgets full buckets (at most once a minute) so memory doesn't grow with every sender ever seen.
+func (m *MemoryRateLimiter) prune(now time.Time) {
+	if now.Sub(m.lastPruned) < time.Minute {
+		return
+	}
+	m.lastPruned = now
+	for key, bucket := range m.buckets {
+		if bucket.full(m.limit, now) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	start := time.Date(2020, 12, 7, 12, 0, 0, 0, time.UTC)
+	newLimiter := func(now *time.Time) *MemoryRateLimiter {
+		limiter := NewMemoryRateLimiter(RateLimit{Rate: 1, Burst: 2})
+		limiter.now = func() time.Time { return *now }
+		return limiter
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Allows a burst then refuses": func(t *testing.T) {
+			now := start
+			limiter := newLimiter(&now)
+			expected := []RateDecision{{true, false}, {true, false}, {false, true}, {false, false}}
+			for i, want := range expected {
+				decision, err := limiter.Allow("+15005550006")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *decision != want {
+					t.Errorf("Decision %d mismatch. Expected=%+v Got=%+v", i, want, *decision)
+				}
+			}
+		},
+		"Refills over time": func(t *testing.T) {
+			now := start
+			limiter := newLimiter(&now)
+			limiter.Allow("+15005550006")
+			limiter.Allow("+15005550006")
+			limiter.Allow("+15005550006")
+
+			now = now.Add(time.Second)
+			decision, _ := limiter.Allow("+15005550006")
+			if !decision.Allowed {
+				t.Fatal("Expected a token after a second")
+			}
+			decision, _ = limiter.Allow("+15005550006")
+			if decision.Allowed || !decision.FirstRefusal {
+				t.Errorf("Expected a new window of refusals. Got=%+v", *decision)
+			}
+		},
+		"Keys have separate buckets": func(t *testing.T) {
+			now := start
+			limiter := newLimiter(&now)
+			limiter.Allow("+15005550006")
+			limiter.Allow("+15005550006")
+			decision, _ := limiter.Allow("+15005550007")
+			if !decision.Allowed {
+				t.Error("Expected another key to be allowed")
+			}
+		},
+		"Forgets full buckets": func(t *testing.T) {
+			now := start
+			limiter := newLimiter(&now)
+			limiter.Allow("+15005550006")
+			now = now.Add(2 * time.Minute)
+			limiter.Allow("+15005550007")
+			if _, ok := limiter.buckets["+15005550006"]; ok {
+				t.Error("Expected full bucket to be pruned")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
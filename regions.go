@@ -0,0 +1,352 @@
+package durcov
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/transform"
+)
+
+// RegionLevel represents how far below its country a region is
+type RegionLevel int
+
+// Levels of regions below a country
+const (
+	// Admin1 regions are states, provinces and the like. Their parent is a country.
+	Admin1 RegionLevel = iota + 1
+	// Admin2 regions are counties and the like. Their parent is an admin1 region.
+	Admin2
+)
+
+// RegionInfo represents the identifying details of a region, e.g. the state of California (US-CA)
+// or Los Angeles county (US-CA-037)
+type RegionInfo struct {
+	Code string
+	Name string
+	// Parent is the code of the country (for admin1 regions) or admin1 region (for admin2 regions) the region is in
+	Parent string
+	Level  RegionLevel
+}
+
+// NoRegionMatchedError when data for a given region code is not found in the database
+type NoRegionMatchedError struct {
+	attemptedCode string
+}
+
+func (n *NoRegionMatchedError) Error() string {
+	return fmt.Sprintf("No region matched with code %s", n.attemptedCode)
+}
+
+// IsRegionCode reports whether the code is a region's rather than a country's, e.g. US-CA or US-CA-037
+func IsRegionCode(code string) bool {
+	return strings.Contains(code, "-")
+}
+
+type region struct {
+	code   string
+	name   string
+	parent string
+	level  RegionLevel
+	stats  *statistics
+}
+
+// JHUReport represents the daily report CSV Johns Hopkins CSSE publishes with state, province and county figures
+type JHUReport struct {
+	url *url.URL
+}
+
+// UseURL sets the report to fetch.
+// Must be set before calling AddRegions
+func (j *JHUReport) UseURL(reportURL string) error {
+	u, err := url.ParseRequestURI(reportURL)
+	if err != nil {
+		return err
+	}
+	j.url = u
+	return nil
+}
+
+// AddRegions fetches the report and adds its regions to the data. See Data.ReadRegions.
+func (j *JHUReport) AddRegions(data *Data) error {
+	if j.url == nil {
+		return errors.New("No URL set to fetch regions")
+	}
+	resp, err := http.Get(j.url.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status fetching regions: %s", resp.Status)
+	}
+	return data.ReadRegions(resp.Body)
+}
+
+// jhuColumns are the daily report columns read. FIPS is optional.
+var jhuColumns = []string{"Admin2", "Province_State", "Country_Region", "Last_Update", "Confirmed", "Deaths", "Recovered"}
+
+// jhuLayouts are the formats Last_Update has been published in
+var jhuLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339, "1/2/06 15:04"}
+
+// Rows that aren't places, e.g. Canada's "Recovered" province and US counties like "Out of NY" or "Unassigned".
+// They still count towards their admin1 region's totals.
+var (
+	ignoredProvinces = map[string]bool{"recovered": true, "unknown": true}
+	ignoredCounties  = map[string]bool{"unassigned": true, "unknown": true}
+)
+
+// ReadRegions reads regions from a JHU CSSE daily report CSV into the data, replacing any it already has.
+// Rows are matched to the data's countries by name and rows of countries it doesn't have are skipped. The data's
+// countries must be set first. Admin1 regions without a row of their own (e.g. US states) are totalled from their counties.
+// The data is left as it was when the report can't be read or its regions don't validate.
+func (d *Data) ReadRegions(r io.Reader) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, name := range jhuColumns {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("Missing column %s in regional report", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	countryCodes := d.countryNames()
+	regions := []*region{}
+	admin1 := map[string]*region{}
+	// totalled holds the sum of each admin1 region's rows. It's used unless the region has a row of its own.
+	totalled := map[string]*statistics{}
+	ownRow := map[string]bool{}
+	seen := map[string]bool{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return err
+		}
+
+		countryCode, ok := countryCodes[strings.Join(normalizeWords(field(record, "Country_Region")), " ")]
+		province := field(record, "Province_State")
+		if !ok || province == "" || ignoredProvinces[strings.ToLower(province)] {
+			continue
+		}
+		stats, err := readJHUStatistics(record, field)
+		if err != nil {
+			return fmt.Errorf("Invalid regional report line %d: %v", line, err)
+		}
+
+		stateCode := admin1Code(countryCode, province)
+		if stateCode == "" {
+			continue
+		}
+		state, ok := admin1[stateCode]
+		if !ok {
+			state = &region{code: stateCode, name: province, parent: countryCode, level: Admin1}
+			admin1[stateCode] = state
+			totalled[stateCode] = &statistics{}
+			regions = append(regions, state)
+		}
+
+		county := field(record, "Admin2")
+		if county == "" {
+			state.stats = stats
+			ownRow[stateCode] = true
+			continue
+		}
+		total := totalled[stateCode]
+		total.totalConfirmed += stats.totalConfirmed
+		total.totalDeaths += stats.totalDeaths
+		total.totalRecovered += stats.totalRecovered
+		if stats.date.After(total.date) {
+			total.date = stats.date
+		}
+
+		if ignoredCounties[strings.ToLower(county)] || strings.HasPrefix(strings.ToLower(county), "out of ") {
+			continue
+		}
+		countyCode := admin2Code(stateCode, county, field(record, "FIPS"))
+		if countyCode == "" || seen[countyCode] {
+			continue
+		}
+		seen[countyCode] = true
+		regions = append(regions, &region{code: countyCode, name: county, parent: stateCode, level: Admin2, stats: stats})
+	}
+
+	for code, state := range admin1 {
+		if !ownRow[code] {
+			state.stats = totalled[code]
+		}
+	}
+	previous := d.regions
+	d.regions = regions
+	if err := d.Validate(); err != nil {
+		d.regions = previous
+		return err
+	}
+	return nil
+}
+
+func readJHUStatistics(record []string, field func(record []string, name string) string) (*statistics, error) {
+	stats := &statistics{}
+	counts := []*int64{&stats.totalConfirmed, &stats.totalDeaths, &stats.totalRecovered}
+	for i, name := range []string{"Confirmed", "Deaths", "Recovered"} {
+		value := field(record, name)
+		if value == "" {
+			continue
+		}
+		count, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unreadable %s %q", name, value)
+		}
+		*counts[i] = int64(count)
+	}
+
+	lastUpdate := field(record, "Last_Update")
+	for _, layout := range jhuLayouts {
+		date, err := time.Parse(layout, lastUpdate)
+		if err == nil {
+			stats.date = date.UTC()
+			return stats, nil
+		}
+	}
+	return nil, fmt.Errorf("unreadable Last_Update %q", lastUpdate)
+}
+
+// jhuCountryNames are names the daily reports use for countries that the covid API names differently
+var jhuCountryNames = map[string]string{
+	"us":                 "US",
+	"korea south":        "KR",
+	"burma":              "MM",
+	"west bank and gaza": "PS",
+	"holy see":           "VA",
+	"cabo verde":         "CV",
+	"timor leste":        "TL",
+	"eswatini":           "SZ",
+}
+
+// countryNames maps the normalized names of the data's countries to their codes
+func (d *Data) countryNames() map[string]string {
+	known := map[string]bool{}
+	names := map[string]string{}
+	add := func(name string, code string) {
+		if words := normalizeWords(name); len(words) > 0 {
+			names[strings.Join(words, " ")] = code
+		}
+	}
+	for _, country := range d.countries {
+		known[country.code] = true
+		add(country.name, country.code)
+		add(strings.ReplaceAll(country.slug, "-", " "), country.code)
+		if i := strings.IndexAny(country.name, ",("); i > 0 {
+			add(country.name[:i], country.code)
+		}
+	}
+	for code, aliases := range countryAliases {
+		for _, alias := range aliases {
+			if known[code] {
+				add(alias, code)
+			}
+		}
+	}
+	for name, code := range jhuCountryNames {
+		if known[code] {
+			add(name, code)
+		}
+	}
+	return names
+}
+
+// admin1Code returns the ISO 3166-2 code of a state or province where it's known, e.g. US-CA for California.
+// Other regions are coded from their name, e.g. CN-HUBEI.
+func admin1Code(countryCode string, name string) string {
+	if codes, ok := subdivisionCodes[countryCode]; ok {
+		if code, ok := codes[strings.Join(normalizeWords(name), " ")]; ok {
+			return countryCode + "-" + code
+		}
+	}
+	suffix := codeFromName(name)
+	if suffix == "" {
+		return ""
+	}
+	return countryCode + "-" + suffix
+}
+
+// admin2Code returns the code of a county. US counties are coded by the last three digits of their FIPS code,
+// e.g. US-CA-037 for Los Angeles. Other counties are coded from their name.
+func admin2Code(stateCode string, name string, fips string) string {
+	if strings.HasPrefix(stateCode, "US-") {
+		if n, err := strconv.ParseFloat(fips, 64); err == nil && n >= 1000 {
+			return fmt.Sprintf("%s-%03d", stateCode, int(n)%1000)
+		}
+	}
+	suffix := codeFromName(name)
+	if suffix == "" {
+		return ""
+	}
+	return stateCode + "-" + suffix
+}
+
+// codeFromName keeps the letters and digits of the name in capitals, e.g. "Hubei" is HUBEI
+func codeFromName(name string) string {
+	name, _, err := transform.String(removeMarks, name)
+	if err != nil {
+		return ""
+	}
+	var code strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			code.WriteRune(r)
+		}
+	}
+	return code.String()
+}
+
+// subdivisionCodes maps the normalized names of states and provinces to their ISO 3166-2 subdivision codes by country
+var subdivisionCodes = map[string]map[string]string{
+	"US": {
+		"alabama": "AL", "alaska": "AK", "arizona": "AZ", "arkansas": "AR", "california": "CA", "colorado": "CO",
+		"connecticut": "CT", "delaware": "DE", "district of columbia": "DC", "florida": "FL", "georgia": "GA",
+		"hawaii": "HI", "idaho": "ID", "illinois": "IL", "indiana": "IN", "iowa": "IA", "kansas": "KS",
+		"kentucky": "KY", "louisiana": "LA", "maine": "ME", "maryland": "MD", "massachusetts": "MA",
+		"michigan": "MI", "minnesota": "MN", "mississippi": "MS", "missouri": "MO", "montana": "MT",
+		"nebraska": "NE", "nevada": "NV", "new hampshire": "NH", "new jersey": "NJ", "new mexico": "NM",
+		"new york": "NY", "north carolina": "NC", "north dakota": "ND", "ohio": "OH", "oklahoma": "OK",
+		"oregon": "OR", "pennsylvania": "PA", "rhode island": "RI", "south carolina": "SC", "south dakota": "SD",
+		"tennessee": "TN", "texas": "TX", "utah": "UT", "vermont": "VT", "virginia": "VA", "washington": "WA",
+		"west virginia": "WV", "wisconsin": "WI", "wyoming": "WY", "puerto rico": "PR", "guam": "GU",
+		"virgin islands": "VI", "northern mariana islands": "MP", "american samoa": "AS",
+	},
+	"CA": {
+		"alberta": "AB", "british columbia": "BC", "manitoba": "MB", "new brunswick": "NB",
+		"newfoundland and labrador": "NL", "northwest territories": "NT", "nova scotia": "NS", "nunavut": "NU",
+		"ontario": "ON", "prince edward island": "PE", "quebec": "QC", "saskatchewan": "SK", "yukon": "YT",
+	},
+	"AU": {
+		"australian capital territory": "ACT", "new south wales": "NSW", "northern territory": "NT",
+		"queensland": "QLD", "south australia": "SA", "tasmania": "TAS", "victoria": "VIC",
+		"western australia": "WA",
+	},
+}
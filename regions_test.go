@@ -0,0 +1,228 @@
+package durcov
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// exampleRegionalReport is in the format of the JHU CSSE daily reports. Singapore's country row and China's province
+// are skipped since the example data has no regions for Singapore and no China.
+const exampleRegionalReport = `FIPS,Admin2,Province_State,Country_Region,Last_Update,Lat,Long_,Confirmed,Deaths,Recovered,Active,Combined_Key,Incident_Rate,Case_Fatality_Ratio
+6037,Los Angeles,California,US,2020-12-04 05:27:50,34.30828379,-118.2282411,408396,7604,0,400792,"Los Angeles, California, US",4067.02,1.86
+6059,Orange,California,US,2020-12-04 05:27:50,33.70147516,-117.7645998,81064,1604,0,79460,"Orange, California, US",2561.98,1.98
+,Unassigned,California,US,2020-12-04 05:27:50,,,100,5,0,95,"Unassigned, California, US",,5.0
+80006,Out of CA,California,US,2020-12-04 05:27:50,,,10,1,0,9,"Out of CA, California, US",,10.0
+,,Ontario,Canada,2020-12-04 05:27:50,51.2538,-85.3232,125360,3698,106489,15173,"Ontario, Canada",860.37,2.95
+,,Recovered,Canada,2020-12-04 05:27:50,,,0,0,292313,0,"Recovered, Canada",,
+,,,Singapore,2020-12-04 05:27:50,1.2833,103.8333,58228,29,58134,65,Singapore,995.26,0.05
+,,Hubei,China,2020-12-04 05:27:50,30.9756,112.2707,68149,4512,63637,0,"Hubei, China",115.18,6.62
+`
+
+// exampleRegionalData returns the example test data with the US and Canada added and the example regional report read
+func exampleRegionalData(t *testing.T) *Data {
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := data.global.stats.date
+	data.countries = append(data.countries,
+		&country{name: "United States of America", slug: "united-states", code: "US", stats: &statistics{14000000, 275000, 5300000, date}},
+		&country{name: "Canada", slug: "canada", code: "CA", stats: &statistics{390000, 12500, 310000, date}},
+	)
+	err = data.ReadRegions(strings.NewReader(exampleRegionalReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadRegions(t *testing.T) {
+	data := exampleRegionalData(t)
+	if err := data.Validate(); err != nil {
+		t.Fatalf("Didn't expect validation error. Got=%v", err)
+	}
+
+	date, err := time.Parse("2006-01-02 15:04:05", "2020-12-04 05:27:50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*region{
+		{"US-CA", "California", "US", Admin1, &statistics{489570, 9214, 0, date}},
+		{"US-CA-037", "Los Angeles", "US-CA", Admin2, &statistics{408396, 7604, 0, date}},
+		{"US-CA-059", "Orange", "US-CA", Admin2, &statistics{81064, 1604, 0, date}},
+		{"CA-ON", "Ontario", "CA", Admin1, &statistics{125360, 3698, 106489, date}},
+	}
+	if len(data.regions) != len(expected) {
+		t.Fatalf("Region count mismatch. Expected=%d Got=%d", len(expected), len(data.regions))
+	}
+	for i, region := range data.regions {
+		want := expected[i]
+		if region.code != want.code || region.name != want.name || region.parent != want.parent || region.level != want.level {
+			t.Errorf("Region mismatch. Expected=%+v Got=%+v", want, region)
+		}
+		if *region.stats != *want.stats {
+			t.Errorf("Statistics mismatch for %s. Expected=%+v Got=%+v", want.code, want.stats, region.stats)
+		}
+	}
+	if data.RowCount() != 9 {
+		t.Errorf("Row count mismatch. Expected=%d Got=%d", 9, data.RowCount())
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Missing column": func(t *testing.T) {
+			data := exampleRegionalData(t)
+			err := data.ReadRegions(strings.NewReader("Admin2,Province_State,Country_Region\n"))
+			if err == nil {
+				t.Error("Expected error for a report without figures")
+			}
+		},
+		"Unreadable figures": func(t *testing.T) {
+			data := exampleRegionalData(t)
+			report := strings.Replace(exampleRegionalReport, "408396", "many", 1)
+			err := data.ReadRegions(strings.NewReader(report))
+			if err == nil {
+				t.Error("Expected error for unreadable figures")
+			}
+		},
+		"Invalid regions": func(t *testing.T) {
+			mutations := map[string]func(d *Data){
+				"Region code without a country": func(d *Data) { d.regions[0].code = "CALIFORNIA" },
+				"Duplicate region code":         func(d *Data) { d.regions[3].code = "US-CA" },
+				"Unknown country":               func(d *Data) { d.regions[3].parent = "ZZ" },
+				"Admin2 under a country":        func(d *Data) { d.regions[1].parent = "US" },
+				"Negative totals":               func(d *Data) { d.regions[2].stats.totalDeaths = -1 },
+			}
+			for name, mutate := range mutations {
+				data := exampleRegionalData(t)
+				mutate(data)
+				if err := data.Validate(); err == nil {
+					t.Errorf("%s: Expected error", name)
+				}
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestMemoryStoreRegions(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleRegionalData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Regions within a parent": func(t *testing.T) {
+			regions, err := memoryStore.Regions("US-CA")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(regions) != 2 || regions[0].Name != "Los Angeles" || regions[1].Name != "Orange" {
+				t.Errorf("Regions mismatch. Expected=[Los Angeles Orange] Got=%+v", regions)
+			}
+		},
+		"Every region": func(t *testing.T) {
+			regions, err := memoryStore.Regions("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes := []string{}
+			for _, region := range regions {
+				codes = append(codes, region.Code)
+			}
+			if strings.Join(codes, " ") != "CA-ON US-CA US-CA-037 US-CA-059" {
+				t.Errorf("Region codes mismatch. Expected=CA-ON US-CA US-CA-037 US-CA-059 Got=%v", codes)
+			}
+		},
+		"Latest region stats": func(t *testing.T) {
+			info, stats, err := memoryStore.LatestRegionStats("US-CA-037")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name != "Los Angeles" || info.Parent != "US-CA" || info.Level != Admin2 {
+				t.Errorf("Region mismatch. Got=%+v", info)
+			}
+			if stats.Deaths != 7604 {
+				t.Errorf("Deaths mismatch. Expected=%d Got=%d", 7604, stats.Deaths)
+			}
+		},
+		"Countries aren't regions": func(t *testing.T) {
+			_, _, err := memoryStore.LatestRegionStats("US")
+			if _, ok := err.(*NoRegionMatchedError); !ok {
+				t.Errorf("Expected *NoRegionMatchedError. Got=%T", err)
+			}
+			_, _, err = memoryStore.LatestCountryStats("US-CA")
+			if _, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Expected *NoCountryMatchedError. Got=%T", err)
+			}
+		},
+		"Regions are kept when data has none": func(t *testing.T) {
+			later := ExampleTestDataAt(time.Date(2020, 12, 5, 3, 0, 0, 0, time.UTC))
+			err := memoryStore.StoreData(later)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, stats, err := memoryStore.LatestRegionStats("CA-ON")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Recovered != 106489 {
+				t.Errorf("Recovered mismatch. Expected=%d Got=%d", 106489, stats.Recovered)
+			}
+			_, stats, err = memoryStore.RegionStatsAt("CA-ON", later.global.stats.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Confirmed != 125360 {
+				t.Errorf("Confirmed mismatch. Expected=%d Got=%d", 125360, stats.Confirmed)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestBotRegions(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleRegionalData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot := NewBot(memoryStore, 0)
+	testBot.SetSessions(NewMemorySessionStore(time.Hour))
+
+	tests := []struct {
+		sender   string
+		input    string
+		expected string
+	}{
+		{"", "CASES US-CA", "[US-CA] California Active Cases: 480,356"},
+		{"", "deaths us-ca-037", "[US-CA-037] Los Angeles Deaths: 7,604"},
+		{"", "CASES CA-ON", "[CA-ON] Ontario Active Cases: 15,173"},
+		{"", "CASES US-ZZ", "Sorry, that code doesn't match any regions I know."},
+		{"", "how many people died in los angeles", "[US-CA-037] Los Angeles Deaths: 7,604"},
+		{"", "covid in orange county, california", "[US-CA-059] Orange Active Cases: 79,460"},
+		{"", "DEATHS US-CA ON 2020-12-04", "[US-CA] California Deaths on 4 Dec 2020: 9,214"},
+		{"sms:1", "CASES SG", "[SG] Singapore Active Cases: 8,132"},
+		{"sms:1", "what about US-CA", "[US-CA] California Active Cases: 480,356"},
+		{"sms:1", "and deaths?", "[US-CA] California Deaths: 9,214"},
+	}
+
+	for _, test := range tests {
+		response, _ := testBot.RespondTo(test.sender, test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+
+	command := MatchCommand("CASES us-ca-037")
+	if command == nil || *command != (BotCommand{Active, "US-CA-037"}) {
+		t.Errorf("Command mismatch. Expected=%+v Got=%+v", &BotCommand{Active, "US-CA-037"}, command)
+	}
+}
@@ -6,4 +6,112 @@ CREATE TABLE IF NOT EXISTS covid_stats (
     deaths INT,
     recovered INT,
     collected_at TIMESTAMP
-)
+);
+
+CREATE TABLE IF NOT EXISTS covid_stats_history (
+    id TEXT,
+    confirmed INT,
+    deaths INT,
+    recovered INT,
+    collected_at TIMESTAMP,
+    PRIMARY KEY (id, collected_at)
+);
+
+CREATE TABLE IF NOT EXISTS outbound_messages (
+    id BIGSERIAL PRIMARY KEY,
+    to_address TEXT NOT NULL,
+    from_address TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    provider_id TEXT,
+    status_callback TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS outbound_messages_due ON outbound_messages (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS outbound_message_attempts (
+    message_id BIGINT NOT NULL REFERENCES outbound_messages (id),
+    attempted_at TIMESTAMP NOT NULL,
+    error TEXT
+);
+
+CREATE TABLE IF NOT EXISTS message_statuses (
+    id BIGSERIAL PRIMARY KEY,
+    message_sid TEXT NOT NULL,
+    inbound_sid TEXT,
+    status TEXT NOT NULL,
+    error_code TEXT,
+    recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_statuses_message ON message_statuses (message_sid, recorded_at);
+
+CREATE TABLE IF NOT EXISTS suppressed_addresses (
+    address TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (address, reason)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    refused BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS conversation_sessions (
+    sender TEXT PRIMARY KEY,
+    datum INT NOT NULL,
+    code TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS conversation_sessions_updated ON conversation_sessions (updated_at);
+
+CREATE TABLE IF NOT EXISTS covid_regions (
+    id TEXT PRIMARY KEY,
+    parent TEXT NOT NULL,
+    level INT NOT NULL,
+    name TEXT,
+    confirmed INT,
+    deaths INT,
+    recovered INT,
+    collected_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS covid_regions_parent ON covid_regions (parent);
+
+CREATE TABLE IF NOT EXISTS country_groups (
+    code TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    members TEXT[] NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS covid_vaccinations (
+    id TEXT PRIMARY KEY,
+    doses BIGINT NOT NULL,
+    people_vaccinated BIGINT NOT NULL,
+    collected_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS covid_testing (
+    id TEXT PRIMARY KEY,
+    tests BIGINT NOT NULL,
+    positive_rate DOUBLE PRECISION NOT NULL,
+    collected_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS covid_hospital_occupancy (
+    id TEXT NOT NULL,
+    datum TEXT NOT NULL,
+    patients BIGINT NOT NULL,
+    collected_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id, datum)
+);
\ No newline at end of file
@@ -0,0 +1,162 @@
+package durcov
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Conversation represents what a sender last asked the bot, so follow-ups like "and deaths?" can be answered
+type Conversation struct {
+	Datum     Datum
+	Code      string
+	UpdatedAt time.Time
+}
+
+// SessionStore describes an API for remembering each sender's conversation.
+// Conversations expire once they haven't been updated for the store's TTL.
+type SessionStore interface {
+	// Conversation returns the sender's conversation, or nil when there isn't one or it has expired
+	Conversation(sender string) (*Conversation, error)
+	// Remember saves the sender's conversation. UpdatedAt is set by the store.
+	Remember(sender string, conversation *Conversation) error
+	// Forget ends the sender's conversation
+	Forget(sender string) error
+}
+
+// CovidSessionStore represents a session store with conversations kept in postgres so every dyno shares them
+type CovidSessionStore struct {
+	pgxpool *pgx.ConnPool
+	ttl     time.Duration
+}
+
+// NewCovidSessionStore returns a postgres backed session store. The DB connection must be set before use.
+func NewCovidSessionStore(ttl time.Duration) *CovidSessionStore {
+	return &CovidSessionStore{ttl: ttl}
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the store.
+func (c *CovidSessionStore) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// Conversation returns the sender's conversation, or nil when there isn't one or it has expired
+func (c *CovidSessionStore) Conversation(sender string) (*Conversation, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on session store")
+	}
+	expiredAt := time.Now().UTC().Add(-c.ttl)
+	conversation := &Conversation{}
+	var datum int32
+	err := c.pgxpool.QueryRow("SELECT datum, code, updated_at FROM conversation_sessions WHERE sender=$1 AND updated_at > $2;", sender, expiredAt).Scan(&datum, &conversation.Code, &conversation.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	conversation.Datum = Datum(datum)
+	return conversation, nil
+}
+
+// Remember saves the sender's conversation.
+func (c *CovidSessionStore) Remember(sender string, conversation *Conversation) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on session store")
+	}
+	now := time.Now().UTC()
+	_, err := c.pgxpool.Exec("INSERT INTO conversation_sessions (sender, datum, code, updated_at) VALUES ($1, $2, $3, $4) ON CONFLICT (sender) DO UPDATE SET datum=$2, code=$3, updated_at=$4;", sender, int32(conversation.Datum), conversation.Code, now)
+	return err
+}
+
+// DeleteExpired deletes expired conversations so the table doesn't grow with every sender ever seen.
+// Expired conversations are never read so this only needs to run now and then. Returns the number deleted.
+func (c *CovidSessionStore) DeleteExpired() (int64, error) {
+	if c.pgxpool == nil {
+		return 0, errors.New("Database connection not set on session store")
+	}
+	tag, err := c.pgxpool.Exec("DELETE FROM conversation_sessions WHERE updated_at <= $1;", time.Now().UTC().Add(-c.ttl))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Forget ends the sender's conversation
+func (c *CovidSessionStore) Forget(sender string) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on session store")
+	}
+	_, err := c.pgxpool.Exec("DELETE FROM conversation_sessions WHERE sender=$1;", sender)
+	return err
+}
+
+// MemorySessionStore represents a session store with conversations held in memory.
+// Conversations aren't shared between processes.
+type MemorySessionStore struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	conversations map[string]*Conversation
+	now           func() time.Time
+	lastPruned    time.Time
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{ttl: ttl, conversations: map[string]*Conversation{}, now: time.Now}
+}
+
+// Conversation returns the sender's conversation, or nil when there isn't one or it has expired
+func (m *MemorySessionStore) Conversation(sender string) (*Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+	conversation, ok := m.conversations[sender]
+	if !ok || m.expired(conversation, now) {
+		return nil, nil
+	}
+	copied := *conversation
+	return &copied, nil
+}
+
+// Remember saves the sender's conversation.
+func (m *MemorySessionStore) Remember(sender string, conversation *Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+	m.conversations[sender] = &Conversation{conversation.Datum, conversation.Code, now.UTC()}
+	return nil
+}
+
+// Forget ends the sender's conversation
+func (m *MemorySessionStore) Forget(sender string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.conversations, sender)
+	return nil
+}
+
+func (m *MemorySessionStore) expired(conversation *Conversation, now time.Time) bool {
+	return now.Sub(conversation.UpdatedAt) >= m.ttl
+}
+
+// prune forgets expired conversations (at most once a minute) so memory doesn't grow with every sender ever seen.
+func (m *MemorySessionStore) prune(now time.Time) {
+	if now.Sub(m.lastPruned) < time.Minute {
+		return
+	}
+	m.lastPruned = now
+	for sender, conversation := range m.conversations {
+		if m.expired(conversation, now) {
+			delete(m.conversations, sender)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package durcov
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Remembers each sender's conversation": func(t *testing.T) {
+			store := NewMemorySessionStore(time.Minute)
+			store.Remember("telegram:1", &Conversation{Datum: Deaths, Code: "SG"})
+			store.Remember("telegram:2", &Conversation{Datum: Active, Code: "AF"})
+
+			conversation, err := store.Conversation("telegram:1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conversation == nil || conversation.Datum != Deaths || conversation.Code != "SG" {
+				t.Errorf("Conversation mismatch. Got=%+v", conversation)
+			}
+			conversation, _ = store.Conversation("telegram:3")
+			if conversation != nil {
+				t.Errorf("Expected no conversation. Got=%+v", conversation)
+			}
+		},
+		"Expires conversations after the TTL": func(t *testing.T) {
+			now := time.Date(2020, 12, 4, 3, 49, 29, 0, time.UTC)
+			store := NewMemorySessionStore(time.Minute)
+			store.now = func() time.Time { return now }
+			store.Remember("telegram:1", &Conversation{Datum: Deaths, Code: "SG"})
+
+			now = now.Add(59 * time.Second)
+			if conversation, _ := store.Conversation("telegram:1"); conversation == nil {
+				t.Error("Expected the conversation before the TTL")
+			}
+			now = now.Add(time.Second)
+			if conversation, _ := store.Conversation("telegram:1"); conversation != nil {
+				t.Errorf("Expected the conversation to expire. Got=%+v", conversation)
+			}
+			if len(store.conversations) != 0 {
+				t.Errorf("Expired conversations mismatch. Expected=%d Got=%d", 0, len(store.conversations))
+			}
+		},
+		"Remembering renews the TTL": func(t *testing.T) {
+			now := time.Date(2020, 12, 4, 3, 49, 29, 0, time.UTC)
+			store := NewMemorySessionStore(time.Minute)
+			store.now = func() time.Time { return now }
+			store.Remember("telegram:1", &Conversation{Datum: Deaths, Code: "SG"})
+			now = now.Add(50 * time.Second)
+			store.Remember("telegram:1", &Conversation{Datum: Active, Code: "SG"})
+
+			now = now.Add(50 * time.Second)
+			conversation, _ := store.Conversation("telegram:1")
+			if conversation == nil || conversation.Datum != Active || !conversation.UpdatedAt.Equal(now.Add(-50*time.Second)) {
+				t.Errorf("Conversation mismatch. Got=%+v", conversation)
+			}
+		},
+		"Forgets conversations": func(t *testing.T) {
+			store := NewMemorySessionStore(time.Minute)
+			store.Remember("telegram:1", &Conversation{Datum: Deaths, Code: "SG"})
+			store.Forget("telegram:1")
+			if conversation, _ := store.Conversation("telegram:1"); conversation != nil {
+				t.Errorf("Expected no conversation. Got=%+v", conversation)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,193 @@
+package durcov
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Reasons an address is suppressed
+const (
+	// SuppressedOptOut marks an address that asked not to be messaged (e.g. by sending STOP)
+	SuppressedOptOut = "opt_out"
+	// SuppressedBlocked marks an address an admin blocked for abuse
+	SuppressedBlocked = "blocked"
+)
+
+// Suppression represents an address that must not be messaged
+type Suppression struct {
+	Address   string
+	Reason    string
+	Note      string
+	CreatedAt time.Time
+}
+
+// SuppressionList describes an API for the addresses that must not be messaged.
+// An address can be suppressed for more than one reason at a time.
+type SuppressionList interface {
+	Suppress(address string, reason string, note string) error
+	Unsuppress(address string, reason string) error
+	// IsSuppressed reports whether the address is suppressed for any of the given reasons, or for any reason when none are given.
+	IsSuppressed(address string, reasons ...string) (bool, error)
+	Suppressions() ([]*Suppression, error)
+}
+
+// NormalizeAddress returns the phone number of a twilio address so the channel prefix doesn't matter.
+// e.g "whatsapp:+15005550006" and "+15005550006" are the same number.
+func NormalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if i := strings.Index(address, ":"); i >= 0 {
+		address = address[i+1:]
+	}
+	return strings.ReplaceAll(address, " ", "")
+}
+
+// CovidSuppressionList represents a postgres backed list of suppressed addresses
+type CovidSuppressionList struct {
+	pgxpool *pgx.ConnPool
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before using the list.
+func (c *CovidSuppressionList) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// Suppress adds the address for the reason. Suppressing an address twice for the same reason keeps the first note.
+func (c *CovidSuppressionList) Suppress(address string, reason string, note string) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on suppression list")
+	}
+	_, err := c.pgxpool.Exec("INSERT INTO suppressed_addresses (address, reason, note) VALUES ($1, $2, NULLIF($3, '')) ON CONFLICT DO NOTHING;", NormalizeAddress(address), reason, note)
+	return err
+}
+
+// Unsuppress removes the address for the reason. Suppressions for other reasons are kept.
+func (c *CovidSuppressionList) Unsuppress(address string, reason string) error {
+	if c.pgxpool == nil {
+		return errors.New("Database connection not set on suppression list")
+	}
+	_, err := c.pgxpool.Exec("DELETE FROM suppressed_addresses WHERE address=$1 AND reason=$2;", NormalizeAddress(address), reason)
+	return err
+}
+
+// IsSuppressed reports whether the address is suppressed for any of the given reasons, or for any reason when none are given.
+func (c *CovidSuppressionList) IsSuppressed(address string, reasons ...string) (bool, error) {
+	if c.pgxpool == nil {
+		return false, errors.New("Database connection not set on suppression list")
+	}
+	var suppressed bool
+	var err error
+	if len(reasons) == 0 {
+		err = c.pgxpool.QueryRow("SELECT EXISTS (SELECT 1 FROM suppressed_addresses WHERE address=$1);", NormalizeAddress(address)).Scan(&suppressed)
+	} else {
+		err = c.pgxpool.QueryRow("SELECT EXISTS (SELECT 1 FROM suppressed_addresses WHERE address=$1 AND reason = ANY($2));", NormalizeAddress(address), reasons).Scan(&suppressed)
+	}
+	return suppressed, err
+}
+
+// Suppressions returns every suppressed address, newest first.
+func (c *CovidSuppressionList) Suppressions() ([]*Suppression, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on suppression list")
+	}
+	rows, err := c.pgxpool.Query("SELECT address, reason, COALESCE(note, ''), created_at FROM suppressed_addresses ORDER BY created_at DESC, address;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []*Suppression{}
+	for rows.Next() {
+		suppression := &Suppression{}
+		err = rows.Scan(&suppression.Address, &suppression.Reason, &suppression.Note, &suppression.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, suppression)
+	}
+	return suppressions, rows.Err()
+}
+
+// MemorySuppressionList represents an in-memory list of suppressed addresses
+type MemorySuppressionList struct {
+	mu           sync.Mutex
+	suppressions map[string]map[string]*Suppression
+}
+
+// NewMemorySuppressionList returns an empty MemorySuppressionList
+func NewMemorySuppressionList() *MemorySuppressionList {
+	return &MemorySuppressionList{suppressions: map[string]map[string]*Suppression{}}
+}
+
+// Suppress adds the address for the reason. Suppressing an address twice for the same reason keeps the first note.
+func (m *MemorySuppressionList) Suppress(address string, reason string, note string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	address = NormalizeAddress(address)
+	reasons, ok := m.suppressions[address]
+	if !ok {
+		reasons = map[string]*Suppression{}
+		m.suppressions[address] = reasons
+	}
+	if _, ok := reasons[reason]; !ok {
+		reasons[reason] = &Suppression{address, reason, note, time.Now().UTC()}
+	}
+	return nil
+}
+
+// Unsuppress removes the address for the reason. Suppressions for other reasons are kept.
+func (m *MemorySuppressionList) Unsuppress(address string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	address = NormalizeAddress(address)
+	delete(m.suppressions[address], reason)
+	if len(m.suppressions[address]) == 0 {
+		delete(m.suppressions, address)
+	}
+	return nil
+}
+
+// IsSuppressed reports whether the address is suppressed for any of the given reasons, or for any reason when none are given.
+func (m *MemorySuppressionList) IsSuppressed(address string, reasons ...string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	suppressed := m.suppressions[NormalizeAddress(address)]
+	if len(reasons) == 0 {
+		return len(suppressed) > 0, nil
+	}
+	for _, reason := range reasons {
+		if _, ok := suppressed[reason]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Suppressions returns every suppressed address, newest first.
+func (m *MemorySuppressionList) Suppressions() ([]*Suppression, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	suppressions := []*Suppression{}
+	for _, reasons := range m.suppressions {
+		for _, suppression := range reasons {
+			copied := *suppression
+			suppressions = append(suppressions, &copied)
+		}
+	}
+	sort.Slice(suppressions, func(i, j int) bool {
+		if suppressions[i].CreatedAt.Equal(suppressions[j].CreatedAt) {
+			return suppressions[i].Address < suppressions[j].Address
+		}
+		return suppressions[i].CreatedAt.After(suppressions[j].CreatedAt)
+	})
+	return suppressions, nil
+}
@@ -0,0 +1,74 @@
+package durcov
+
+import (
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"+15005550006", "+15005550006"},
+		{"whatsapp:+15005550006", "+15005550006"},
+		{" sms:+1 500 555 0006 ", "+15005550006"},
+	}
+	for _, test := range tests {
+		if got := NormalizeAddress(test.input); got != test.expected {
+			t.Errorf("Address mismatch. Input=%s Expected=%s Got=%s", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestMemorySuppressionList(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"Suppresses a number on every channel": func(t *testing.T) {
+			list := NewMemorySuppressionList()
+			list.Suppress("whatsapp:+15005550006", SuppressedOptOut, "STOP")
+
+			for _, address := range []string{"+15005550006", "whatsapp:+15005550006"} {
+				suppressed, err := list.IsSuppressed(address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !suppressed {
+					t.Errorf("Expected %s to be suppressed", address)
+				}
+			}
+		},
+		"Matches on reason": func(t *testing.T) {
+			list := NewMemorySuppressionList()
+			list.Suppress("+15005550006", SuppressedBlocked, "spam")
+
+			optedOut, _ := list.IsSuppressed("+15005550006", SuppressedOptOut)
+			blocked, _ := list.IsSuppressed("+15005550006", SuppressedOptOut, SuppressedBlocked)
+			if optedOut || !blocked {
+				t.Errorf("Reason mismatch. OptedOut=%v Blocked=%v", optedOut, blocked)
+			}
+		},
+		"Unsuppressing keeps other reasons": func(t *testing.T) {
+			list := NewMemorySuppressionList()
+			list.Suppress("+15005550006", SuppressedBlocked, "spam")
+			list.Suppress("+15005550006", SuppressedOptOut, "STOP")
+			list.Unsuppress("+15005550006", SuppressedOptOut)
+
+			suppressions, err := list.Suppressions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(suppressions) != 1 || suppressions[0].Reason != SuppressedBlocked || suppressions[0].Note != "spam" {
+				t.Errorf("Suppressions mismatch. Got=%+v", suppressions)
+			}
+
+			list.Unsuppress("+15005550006", SuppressedBlocked)
+			suppressed, _ := list.IsSuppressed("+15005550006")
+			if suppressed {
+				t.Error("Expected no suppressions left")
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -9,7 +9,12 @@ func ExampleTestData() (*Data, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ExampleTestDataAt(exampleTime), nil
+}
 
+// ExampleTestDataAt returns the same mock Data as ExampleTestData collected at the given time.
+// Inteneded as a testing utility for history.
+func ExampleTestDataAt(exampleTime time.Time) *Data {
 	exampleData := Data{
 		&global{
 			&statistics{
@@ -43,7 +48,11 @@ func ExampleTestData() (*Data, error) {
 				},
 			},
 		},
+		nil,
+		nil,
+		nil,
+		nil,
 	}
 
-	return &exampleData, nil
+	return &exampleData
 }
@@ -0,0 +1,135 @@
+package durcov
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// UpdateListener describes an API to listen for newly stored data.
+// Each received value is the collection time of the newly stored global data.
+type UpdateListener interface {
+	ListenForUpdates(ctx context.Context) (<-chan time.Time, error)
+}
+
+// updatesChannel is the postgres notification channel StoreData notifies on
+const updatesChannel = "covid_stats_updates"
+
+// CovidUpdateListener represents a postgres LISTEN/NOTIFY based listener for newly stored covid data
+type CovidUpdateListener struct {
+	pgxpool *pgx.ConnPool
+}
+
+// SetDBConnection sets the connection to the backing database.
+// Must be set before calling ListenForUpdates
+func (c *CovidUpdateListener) SetDBConnection(pgxpool *pgx.ConnPool) {
+	c.pgxpool = pgxpool
+}
+
+// ListenForUpdates holds a connection from the pool listening for notifications sent by CovidDataStore.StoreData.
+// The returned channel is closed and the connection released once ctx is done or the connection fails.
+func (c *CovidUpdateListener) ListenForUpdates(ctx context.Context) (<-chan time.Time, error) {
+	if c.pgxpool == nil {
+		return nil, errors.New("Database connection not set on update listener")
+	}
+	conn, err := c.pgxpool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Listen(updatesChannel)
+	if err != nil {
+		c.pgxpool.Release(conn)
+		return nil, err
+	}
+
+	updates := make(chan time.Time)
+	go func() {
+		defer close(updates)
+		defer c.pgxpool.Release(conn)
+		for {
+			notification, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Stopped listening for updates: %v", err)
+				}
+				if conn.IsAlive() {
+					conn.Unlisten(updatesChannel)
+				}
+				return
+			}
+			collectedAt, err := time.Parse(time.RFC3339Nano, notification.Payload)
+			if err != nil {
+				log.Printf("Malformed update notification %q: %v", notification.Payload, err)
+				continue
+			}
+			select {
+			case updates <- collectedAt:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return updates, nil
+}
+
+func formatUpdatePayload(collectedAt time.Time) string {
+	return collectedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// ListenRetry configures how ListenWithRetry listens again once listening stops
+type ListenRetry struct {
+	// MinDelay is the wait before the first attempt to listen again. It doubles with every failed attempt up to MaxDelay.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// Attempts is how many attempts in a row may fail before giving up
+	Attempts int
+}
+
+// DefaultListenRetry gives up after trying to listen again for about 5 minutes
+var DefaultListenRetry = ListenRetry{MinDelay: time.Second, MaxDelay: time.Minute, Attempts: 10}
+
+// ListenWithRetry calls changed once listening starts and again for every update received until ctx is done.
+// When listening stops, e.g. because the LISTEN connection dropped, it listens again with backoff and calls changed
+// once it's listening since updates may have been missed in between.
+// Returns ctx's error once it's done, or an error once every attempt to listen again has failed.
+func ListenWithRetry(ctx context.Context, listener UpdateListener, retry ListenRetry, changed func()) error {
+	delay := retry.MinDelay
+	failures := 0
+	for {
+		updates, err := listener.ListenForUpdates(ctx)
+		if err == nil {
+			failures = 0
+			delay = retry.MinDelay
+			changed()
+			for range updates {
+				changed()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Stopped receiving updates, listening again in %v", delay)
+		} else {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			if failures >= retry.Attempts {
+				return fmt.Errorf("Unable to listen for updates after %d attempts: %v", failures, err)
+			}
+			log.Printf("Unable to listen for updates, retrying in %v: %v", delay, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > retry.MaxDelay {
+			delay = retry.MaxDelay
+		}
+	}
+}
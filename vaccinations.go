@@ -0,0 +1,227 @@
+package durcov
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VaccinationSnapshot represents vaccination figures as published for a day
+type VaccinationSnapshot struct {
+	// Doses is the number of vaccine doses given in total
+	Doses int64
+	// PeopleVaccinated is the number of people given at least one dose
+	PeopleVaccinated int64
+	CollectedAt      time.Time
+}
+
+// TestingSnapshot represents testing figures as published for a day
+type TestingSnapshot struct {
+	// Tests is the number of tests performed in total
+	Tests int64
+	// PositiveRate is the share of recent tests that were positive, from 0 to 1
+	PositiveRate float64
+	CollectedAt  time.Time
+}
+
+type vaccinationFigures struct {
+	// code is a country's code or GLOBAL for the world
+	code             string
+	doses            int64
+	peopleVaccinated int64
+	date             time.Time
+}
+
+type testingFigures struct {
+	code         string
+	tests        int64
+	positiveRate float64
+	date         time.Time
+}
+
+// OWIDReport represents a CSV in the format Our World in Data publishes vaccination and testing figures in,
+// e.g. owid-covid-data.csv or vaccinations.csv
+type OWIDReport struct {
+	url *url.URL
+}
+
+// UseURL sets the report to fetch.
+// Must be set before calling AddVaccinationsAndTests
+func (o *OWIDReport) UseURL(reportURL string) error {
+	u, err := url.ParseRequestURI(reportURL)
+	if err != nil {
+		return err
+	}
+	o.url = u
+	return nil
+}
+
+// AddVaccinationsAndTests fetches the report and adds its figures to the data. See Data.ReadVaccinationsAndTests.
+func (o *OWIDReport) AddVaccinationsAndTests(data *Data) error {
+	if o.url == nil {
+		return errors.New("No URL set to fetch vaccinations and tests")
+	}
+	resp, err := http.Get(o.url.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status fetching vaccinations and tests: %s", resp.Status)
+	}
+	return data.ReadVaccinationsAndTests(resp.Body)
+}
+
+// Columns of the OWID reports. A dataset is only read when the report has all of its columns.
+var (
+	owidColumns            = []string{"iso_code", "location", "date"}
+	owidVaccinationColumns = []string{"total_vaccinations", "people_vaccinated"}
+	owidTestingColumns     = []string{"total_tests", "positive_rate"}
+)
+
+// owidWorldCode is the iso_code OWID reports the world's figures under
+const owidWorldCode = "OWID_WRL"
+
+// ReadVaccinationsAndTests reads vaccination and testing figures from an OWID style CSV into the data, replacing any of
+// the datasets the report has. Each country's latest row with all of a dataset's figures is kept, so a country's
+// vaccination and testing figures can be from different days. Rows are matched to the data's countries by name and rows
+// of countries it doesn't have are skipped, apart from the world's. The data's countries must be set first.
+// The data is left as it was when the report can't be read or its figures don't validate.
+func (d *Data) ReadVaccinationsAndTests(r io.Reader) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, name := range owidColumns {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("Missing column %s in vaccination and testing report", name)
+		}
+	}
+	hasColumns := func(names []string) bool {
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	readVaccinations := hasColumns(owidVaccinationColumns)
+	readTests := hasColumns(owidTestingColumns)
+	if !readVaccinations && !readTests {
+		return errors.New("No vaccination or testing columns in report")
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	countryCodes := d.countryNames()
+	vaccinations := map[string]*vaccinationFigures{}
+	tests := map[string]*testingFigures{}
+	// order keeps the figures in the order their country was first seen
+	order := []string{}
+	seen := map[string]bool{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return err
+		}
+
+		code := globalID
+		if field(record, "iso_code") != owidWorldCode {
+			var ok bool
+			code, ok = countryCodes[strings.Join(normalizeWords(field(record, "location")), " ")]
+			if !ok {
+				continue
+			}
+		}
+		if !seen[code] {
+			seen[code] = true
+			order = append(order, code)
+		}
+		date, err := time.Parse("2006-01-02", field(record, "date"))
+		if err != nil {
+			return fmt.Errorf("Invalid vaccination and testing report line %d: unreadable date %q", line, field(record, "date"))
+		}
+
+		if readVaccinations {
+			figures, err := readOWIDFigures(record, field, owidVaccinationColumns)
+			if err != nil {
+				return fmt.Errorf("Invalid vaccination and testing report line %d: %v", line, err)
+			}
+			latest, ok := vaccinations[code]
+			if figures != nil && (!ok || date.After(latest.date)) {
+				vaccinations[code] = &vaccinationFigures{code, int64(figures[0]), int64(figures[1]), date}
+			}
+		}
+		if readTests {
+			figures, err := readOWIDFigures(record, field, owidTestingColumns)
+			if err != nil {
+				return fmt.Errorf("Invalid vaccination and testing report line %d: %v", line, err)
+			}
+			latest, ok := tests[code]
+			if figures != nil && (!ok || date.After(latest.date)) {
+				tests[code] = &testingFigures{code, int64(figures[0]), figures[1], date}
+			}
+		}
+	}
+
+	previousVaccinations, previousTests := d.vaccinations, d.tests
+	if readVaccinations {
+		d.vaccinations = []*vaccinationFigures{}
+	}
+	if readTests {
+		d.tests = []*testingFigures{}
+	}
+	for _, code := range order {
+		if figures, ok := vaccinations[code]; ok {
+			d.vaccinations = append(d.vaccinations, figures)
+		}
+		if figures, ok := tests[code]; ok {
+			d.tests = append(d.tests, figures)
+		}
+	}
+	if err := d.Validate(); err != nil {
+		d.vaccinations, d.tests = previousVaccinations, previousTests
+		return err
+	}
+	return nil
+}
+
+// readOWIDFigures returns the row's figures in the columns. Returns nil when any of them are missing
+// since OWID leaves days without figures empty.
+func readOWIDFigures(record []string, field func(record []string, name string) string, names []string) ([]float64, error) {
+	figures := []float64{}
+	for _, name := range names {
+		value := field(record, name)
+		if value == "" {
+			return nil, nil
+		}
+		figure, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unreadable %s %q", name, value)
+		}
+		figures = append(figures, figure)
+	}
+	return figures, nil
+}
@@ -0,0 +1,260 @@
+package durcov
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// exampleOWIDReport is in the format of Our World in Data's owid-covid-data.csv. Figures are left empty on days they
+// weren't published and Atlantis is skipped since the example data doesn't have it.
+const exampleOWIDReport = `iso_code,continent,location,date,total_cases,total_vaccinations,people_vaccinated,total_tests,positive_rate
+AFG,Asia,Afghanistan,2021-02-27,55714,,,,
+AFG,Asia,Afghanistan,2021-02-28,55733,8200,8200,,
+SGP,Asia,Singapore,2021-02-27,59936,480000,300000,7800000.0,0.001
+SGP,Asia,Singapore,2021-02-28,59979,500000,320000,,
+ATL,,Atlantis,2021-02-28,1,1,1,1,0.5
+OWID_WRL,,World,2021-02-28,114000000,244000000,160000000,,
+`
+
+// exampleVaccinationData returns the example test data with the example OWID report read
+func exampleVaccinationData(t *testing.T) *Data {
+	data, err := ExampleTestData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.ReadVaccinationsAndTests(strings.NewReader(exampleOWIDReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadVaccinationsAndTests(t *testing.T) {
+	data := exampleVaccinationData(t)
+	if err := data.Validate(); err != nil {
+		t.Fatalf("Didn't expect validation error. Got=%v", err)
+	}
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 2, d, 0, 0, 0, 0, time.UTC)
+	}
+	expectedVaccinations := []vaccinationFigures{
+		{"AF", 8200, 8200, day(28)},
+		{"SG", 500000, 320000, day(28)},
+		{globalID, 244000000, 160000000, day(28)},
+	}
+	if len(data.vaccinations) != len(expectedVaccinations) {
+		t.Fatalf("Vaccination figures count mismatch. Expected=%d Got=%d", len(expectedVaccinations), len(data.vaccinations))
+	}
+	for i, figures := range data.vaccinations {
+		if *figures != expectedVaccinations[i] {
+			t.Errorf("Vaccination figures mismatch. Expected=%+v Got=%+v", expectedVaccinations[i], figures)
+		}
+	}
+	// Singapore's latest testing figures are from the day before its latest vaccination figures
+	if len(data.tests) != 1 || *data.tests[0] != (testingFigures{"SG", 7800000, 0.001, day(27)}) {
+		t.Errorf("Testing figures mismatch. Got=%+v", data.tests)
+	}
+	if data.RowCount() != 7 {
+		t.Errorf("Row count mismatch. Expected=%d Got=%d", 7, data.RowCount())
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Vaccinations only": func(t *testing.T) {
+			data := exampleVaccinationData(t)
+			report := "location,iso_code,date,total_vaccinations,people_vaccinated\nSingapore,SGP,2021-03-01,600000,400000\n"
+			err := data.ReadVaccinationsAndTests(strings.NewReader(report))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data.vaccinations) != 1 || data.vaccinations[0].doses != 600000 {
+				t.Errorf("Vaccination figures mismatch. Got=%+v", data.vaccinations)
+			}
+			if len(data.tests) != 1 {
+				t.Errorf("Expected testing figures to be kept. Got=%+v", data.tests)
+			}
+		},
+		"Missing figures": func(t *testing.T) {
+			data := exampleVaccinationData(t)
+			err := data.ReadVaccinationsAndTests(strings.NewReader("iso_code,location,date,total_cases\n"))
+			if err == nil {
+				t.Error("Expected error for a report without vaccination or testing figures")
+			}
+		},
+		"Unreadable figures": func(t *testing.T) {
+			data := exampleVaccinationData(t)
+			report := strings.Replace(exampleOWIDReport, "480000", "many", 1)
+			err := data.ReadVaccinationsAndTests(strings.NewReader(report))
+			if err == nil {
+				t.Error("Expected error for unreadable figures")
+			}
+			if len(data.vaccinations) != 3 {
+				t.Errorf("Expected the data's figures to be kept. Got=%+v", data.vaccinations)
+			}
+		},
+		"Invalid figures": func(t *testing.T) {
+			mutations := map[string]func(d *Data){
+				"Unknown country":         func(d *Data) { d.vaccinations[0].code = "ZZ" },
+				"Duplicate figures":       func(d *Data) { d.vaccinations[1].code = "AF" },
+				"Negative doses":          func(d *Data) { d.vaccinations[0].doses = -1 },
+				"Positive rate above one": func(d *Data) { d.tests[0].positiveRate = 1.5 },
+				"Missing date":            func(d *Data) { d.tests[0].date = time.Time{} },
+			}
+			for name, mutate := range mutations {
+				data := exampleVaccinationData(t)
+				mutate(data)
+				if err := data.Validate(); err == nil {
+					t.Errorf("%s: Expected error", name)
+				}
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestMemoryStoreVaccinations(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleVaccinationData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"Country figures": func(t *testing.T) {
+			info, figures, err := memoryStore.LatestCountryVaccinations("SG")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name != "Singapore" || figures.Doses != 500000 || figures.PeopleVaccinated != 320000 {
+				t.Errorf("Vaccinations mismatch. Got=%+v %+v", info, figures)
+			}
+			_, tests, err := memoryStore.LatestCountryTests("SG")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tests.Tests != 7800000 || tests.PositiveRate != 0.001 {
+				t.Errorf("Tests mismatch. Got=%+v", tests)
+			}
+		},
+		"Global figures": func(t *testing.T) {
+			figures, err := memoryStore.LatestGlobalVaccinations()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if figures.Doses != 244000000 {
+				t.Errorf("Doses mismatch. Expected=%d Got=%d", 244000000, figures.Doses)
+			}
+		},
+		"Missing figures": func(t *testing.T) {
+			_, _, err := memoryStore.LatestCountryTests("AF")
+			if _, ok := err.(*NoSnapshotError); !ok {
+				t.Errorf("Expected *NoSnapshotError. Got=%T", err)
+			}
+			_, _, err = memoryStore.LatestCountryVaccinations("IN")
+			if _, ok := err.(*NoCountryMatchedError); !ok {
+				t.Errorf("Expected *NoCountryMatchedError. Got=%T", err)
+			}
+		},
+		"Group figures": func(t *testing.T) {
+			group, figures, err := memoryStore.LatestGroupVaccinations("ASIA")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if group.Name != "Asia" || figures.Doses != 508200 || figures.PeopleVaccinated != 328200 {
+				t.Errorf("Vaccinations mismatch. Got=%+v %+v", group, figures)
+			}
+			_, tests, err := memoryStore.LatestGroupTests("ASIA")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tests.Tests != 7800000 || tests.PositiveRate != 0.001 {
+				t.Errorf("Tests mismatch. Got=%+v", tests)
+			}
+			_, _, err = memoryStore.LatestGroupTests("EU")
+			if _, ok := err.(*NoSnapshotError); !ok {
+				t.Errorf("Expected *NoSnapshotError. Got=%T", err)
+			}
+		},
+		"Figures are kept when data has none": func(t *testing.T) {
+			err := memoryStore.StoreData(ExampleTestDataAt(time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, figures, err := memoryStore.LatestCountryVaccinations("AF")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if figures.Doses != 8200 {
+				t.Errorf("Doses mismatch. Expected=%d Got=%d", 8200, figures.Doses)
+			}
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestBotVaccinations(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	err := memoryStore.StoreData(exampleVaccinationData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot := NewBot(memoryStore, 0)
+	testBot.SetSessions(NewMemorySessionStore(time.Hour))
+
+	tests := []struct {
+		sender   string
+		input    string
+		expected string
+	}{
+		{"", "DOSES SG", "[SG] Singapore Vaccine Doses: 500,000 (as of 28 Feb 2021)"},
+		{"", "vaccinated sg", "[SG] Singapore People Vaccinated: 320,000 (as of 28 Feb 2021)"},
+		{"", "DOSES TOTAL", "Total Vaccine Doses: 244,000,000 (as of 28 Feb 2021)"},
+		{"", "TESTS SG", "[SG] Singapore Tests: 7,800,000 (as of 27 Feb 2021)"},
+		{"", "POSITIVITY SG", "[SG] Singapore Test Positivity: 0.1% (as of 27 Feb 2021)"},
+		{"", "TESTS AF", "Sorry, I don't have testing figures for AF yet."},
+		{"", "TESTS TOTAL", "Sorry, I only have testing figures for countries and groups."},
+		{"", "DOSES IN", "Sorry, that code doesn't match any countries I know."},
+		{"", "DOSES ASIA", "[ASIA] Asia Vaccine Doses: 508,200 (as of 28 Feb 2021)"},
+		{"", "positivity asia", "[ASIA] Asia Test Positivity: 0.1% (as of 27 Feb 2021)"},
+		{"", "DOSES EU", "Sorry, I don't have vaccination figures for EU yet."},
+		{"", "TESTS EU", "Sorry, I don't have testing figures for EU yet."},
+		{"", "DOSES ZZZ", "Sorry, that doesn't match any groups of countries I know."},
+		{"", "DOSES US-CA", "Sorry, I only have vaccination figures for countries and groups."},
+		{"", "DOSES SG YESTERDAY", "Sorry, I only have the latest vaccination and testing figures."},
+		{"", "how many people have been vaccinated in singapore", "[SG] Singapore People Vaccinated: 320,000 (as of 28 Feb 2021)"},
+		{"", "CASES SG", "[SG] Singapore Active Cases: 8,132"},
+		{"sms:1", "DOSES AF", "[AF] Afghanistan Vaccine Doses: 8,200 (as of 28 Feb 2021)"},
+		{"sms:1", "what about SG", "[SG] Singapore Vaccine Doses: 500,000 (as of 28 Feb 2021)"},
+		{"sms:1", "and tests?", "[SG] Singapore Tests: 7,800,000 (as of 27 Feb 2021)"},
+	}
+
+	for _, test := range tests {
+		response, _ := testBot.RespondTo(test.sender, test.input)
+		if response != test.expected {
+			t.Errorf("Response mismatch. Input: %s Expected=%s Got=%s", test.input, test.expected, response)
+		}
+	}
+
+	var outcome string
+	testBot.SetObserver(func(command string, o string) {
+		outcome = o
+	})
+	for _, input := range []string{"TESTS TOTAL", "DOSES US-CA"} {
+		testBot.RespondTo("", input)
+		if outcome != OutcomeUnsupportedPlace {
+			t.Errorf("Outcome mismatch. Input: %s Expected=%s Got=%s", input, OutcomeUnsupportedPlace, outcome)
+		}
+	}
+
+	command := MatchCommand("positivity sg")
+	if command == nil || *command != (BotCommand{Positivity, "SG"}) {
+		t.Errorf("Command mismatch. Expected=%+v Got=%+v", &BotCommand{Positivity, "SG"}, command)
+	}
+}